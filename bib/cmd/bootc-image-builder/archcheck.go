@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/container"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+var imageArch = fetchImageArch
+
+// imageArchReference returns the reference of the given resolved
+// image, pinned to the image ID for the containers storage and to the
+// digest for registries so that the image that is built is inspected
+func imageArchReference(spec container.Spec) (string, error) {
+	if spec.ContainersTransport != nil && *spec.ContainersTransport == osbuild.ContainersStorageTransport {
+		id, err := digest.Parse(spec.ImageID)
+		if err != nil {
+			return "", err
+		}
+		return containersStorageImageName("@"+id.Encoded(), spec.StoragePath), nil
+	}
+	named, err := reference.ParseNormalizedNamed(spec.Source)
+	if err != nil {
+		return "", err
+	}
+	canonical, err := reference.WithDigest(reference.TrimNamed(named), digest.Digest(spec.Digest))
+	if err != nil {
+		return "", err
+	}
+	return "docker://" + canonical.String(), nil
+}
+
+// fetchImageArch returns the architecture of the config of the given
+// resolved image, e.g. "amd64"
+func fetchImageArch(spec container.Spec) (string, error) {
+	name, err := imageArchReference(spec)
+	if err != nil {
+		return "", err
+	}
+	ref, err := alltransports.ParseImageName(name)
+	if err != nil {
+		return "", err
+	}
+
+	tlsVerify := spec.TLSVerify == nil || *spec.TLSVerify
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.NewOptionalBool(!tlsVerify),
+	}
+	ctx := context.Background()
+	img, err := ref.NewImage(ctx, sys)
+	if err != nil {
+		return "", err
+	}
+	defer img.Close()
+	info, err := img.Inspect(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.Architecture, nil
+}
+
+// imageArchs maps the architectures of container images to ours
+var imageArchs = map[string]arch.Arch{
+	"amd64":   arch.ARCH_X86_64,
+	"x86_64":  arch.ARCH_X86_64,
+	"arm64":   arch.ARCH_AARCH64,
+	"aarch64": arch.ARCH_AARCH64,
+	"ppc64le": arch.ARCH_PPC64LE,
+	"s390x":   arch.ARCH_S390X,
+}
+
+// checkBaseImageArch ensures that the base image resolved for the
+// target architecture is built for it. Images of a manifest list were
+// chosen for the target architecture by the resolver, only images
+// without a manifest list are inspected as they are resolved for any
+// architecture.
+func (c *ManifestConfig) checkBaseImageArch(containerSpecs map[string][]container.Spec) error {
+	spec, ok := c.baseImageSpec(containerSpecs)
+	if !ok || spec.ListDigest != "" {
+		return nil
+	}
+	archName, err := imageArch(spec)
+	if err != nil {
+		return fmt.Errorf("cannot get the architecture of %s: %w", c.Imgref, err)
+	}
+	a, ok := imageArchs[archName]
+	if !ok {
+		return fmt.Errorf("base image %s is for the unsupported architecture %q", c.Imgref, archName)
+	}
+	if a != c.Architecture {
+		return fmt.Errorf("base image %s is for %s but the target architecture is %s, pass --no-arch-check to build it anyway", c.Imgref, a, c.Architecture)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/container"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestBaseImageArchCheck(t *testing.T) {
+	calls := 0
+	restore := main.MockNewContainerResolver(func(string) main.ContainerResolver {
+		return &fakeResolver{calls: &calls}
+	})
+	defer restore()
+
+	for name, tc := range map[string]struct {
+		targetArch  arch.Arch
+		imageArch   string
+		noArchCheck bool
+		err         string
+	}{
+		"match-x86_64":  {arch.ARCH_X86_64, "amd64", false, ""},
+		"match-aarch64": {arch.ARCH_AARCH64, "arm64", false, ""},
+		"mismatch": {
+			arch.ARCH_AARCH64, "amd64", false,
+			"base image quay.io/example/bootc:latest is for x86_64 but the target architecture is aarch64, pass --no-arch-check to build it anyway",
+		},
+		"mismatch-no-check": {arch.ARCH_AARCH64, "amd64", true, ""},
+		"unsupported": {
+			arch.ARCH_X86_64, "riscv64", false,
+			`base image quay.io/example/bootc:latest is for the unsupported architecture "riscv64"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var inspected []container.Spec
+			restore := main.MockImageArch(func(spec container.Spec) (string, error) {
+				inspected = append(inspected, spec)
+				return tc.imageArch, nil
+			})
+			defer restore()
+
+			c := &main.ManifestConfig{
+				Imgref:       "quay.io/example/bootc:latest",
+				ImgType:      "qcow2",
+				Architecture: tc.targetArch,
+				NoArchCheck:  tc.noArchCheck,
+			}
+			_, err := main.MakeManifest(c, "")
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+			}
+			if tc.noArchCheck {
+				assert.Empty(t, inspected)
+			} else {
+				// only the image of the target is inspected
+				require.Len(t, inspected, 1)
+				assert.Equal(t, testContainerSpec.Digest, inspected[0].Digest)
+			}
+		})
+	}
+}
+
+func TestBaseImageArchCheckError(t *testing.T) {
+	calls := 0
+	restore := main.MockNewContainerResolver(func(string) main.ContainerResolver {
+		return &fakeResolver{calls: &calls}
+	})
+	defer restore()
+	restore = main.MockImageArch(func(container.Spec) (string, error) {
+		return "", fmt.Errorf("manifest unknown")
+	})
+	defer restore()
+
+	c := &main.ManifestConfig{
+		Imgref:       "quay.io/example/bootc:latest",
+		ImgType:      "qcow2",
+		Architecture: arch.ARCH_X86_64,
+	}
+	_, err := main.MakeManifest(c, "")
+	assert.EqualError(t, err, "cannot get the architecture of quay.io/example/bootc:latest: manifest unknown")
+}
+
+func TestBaseImageArchCheckManifestList(t *testing.T) {
+	calls := 0
+	restore := main.MockNewContainerResolver(func(string) main.ContainerResolver {
+		return &fakeResolver{calls: &calls, listDigest: "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"}
+	})
+	defer restore()
+	inspected := 0
+	restore = main.MockImageArch(func(container.Spec) (string, error) {
+		inspected++
+		return "arm64", nil
+	})
+	defer restore()
+
+	c := &main.ManifestConfig{
+		Imgref:       "quay.io/example/bootc:latest",
+		ImgType:      "qcow2",
+		Architecture: arch.ARCH_X86_64,
+	}
+	_, err := main.MakeManifest(c, "")
+	require.NoError(t, err)
+	// the resolver chose the image of the list for the target
+	assert.Equal(t, 0, inspected)
+}
+
+func TestImageArchReference(t *testing.T) {
+	transport := "containers-storage"
+	storage := "/var/lib/containers/storage"
+	local := testContainerSpec
+	local.Source = "localhost/bootc"
+	local.ContainersTransport = &transport
+	local.StoragePath = &storage
+	ref, err := main.ImageArchReference(local)
+	require.NoError(t, err)
+	assert.Equal(t, "containers-storage:[overlay@/var/lib/containers/storage]@1111111111111111111111111111111111111111111111111111111111111111", ref)
+
+	remote := testContainerSpec
+	remote.Source = "quay.io/example/bootc"
+	ref, err = main.ImageArchReference(remote)
+	require.NoError(t, err)
+	assert.Equal(t, "docker://quay.io/example/bootc@sha256:dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd", ref)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	autoUpdateTimer         = "bootc-fetch-apply-updates.timer"
+	autoUpdateTimerDropIn   = "/etc/systemd/system/bootc-fetch-apply-updates.timer.d/90-bootc-image-builder.conf"
+	autoUpdateServiceDropIn = "/etc/systemd/system/bootc-fetch-apply-updates.service.d/90-bootc-image-builder.conf"
+)
+
+// AutoUpdate configures the automatic updates of bootc via
+// bootc-fetch-apply-updates.timer of the container image
+type AutoUpdate struct {
+	// Enabled set to false disables the automatic updates
+	Enabled *bool `json:"enabled,omitempty"`
+	// Schedule is an OnCalendar expression, e.g. "Sun 03:00", that
+	// replaces the default schedule of the timer
+	Schedule string `json:"schedule,omitempty"`
+	// RandomizedDelay spreads the updates of a fleet, e.g. "30m"
+	RandomizedDelay string `json:"randomized_delay,omitempty"`
+	// StageOnly downloads and stages the update without applying it,
+	// it is applied on the next reboot
+	StageOnly bool `json:"stage_only,omitempty"`
+}
+
+var (
+	// a single component of a calendar event like "*", "5", "1..5",
+	// "*/15" or "0/2"
+	calendarComponent = `(\*|[0-9]+(\.\.[0-9]+)?)(/[0-9]+)?`
+	calendarList      = calendarComponent + `(,` + calendarComponent + `)*`
+	calendarDateRegex = regexp.MustCompile(`^(` + calendarList + `-)?` + calendarList + `[-~]` + calendarList + `$`)
+	calendarTimeRegex = regexp.MustCompile(`^` + calendarList + `:` + calendarList + `(:` + calendarList + `)?$`)
+
+	weekday              = `(mon|tue|wed|thu|fri|sat|sun)[a-z]*`
+	weekdayItem          = weekday + `(\.\.` + weekday + `)?`
+	calendarWeekdayRegex = regexp.MustCompile(`^` + weekdayItem + `(,` + weekdayItem + `)*$`)
+
+	timeSpanRegex = regexp.MustCompile(`^([0-9]+ ?(usec|us|msec|ms|seconds?|sec|s|minutes?|min|m|hours?|hr|h|days?|d|weeks?|w)? ?)+$`)
+)
+
+// the shorthands of systemd.time(7)
+var calendarShorthands = []string{
+	"minutely", "hourly", "daily", "monthly", "weekly", "yearly",
+	"quarterly", "semiannually", "annually",
+}
+
+// validateCalendar checks that the expression has the structure of a
+// calendar event of systemd.time(7): "[weekdays] [date] [time]
+// [timezone]" or a shorthand like "daily". The values themselves are
+// checked by systemd.
+func validateCalendar(expr string) error {
+	invalid := fmt.Errorf("invalid auto_update schedule %q, expected an OnCalendar expression like \"Sun *-*-* 03:00\" or \"daily\"", expr)
+
+	if strings.ContainsAny(expr, "\n\r") {
+		return invalid
+	}
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		for _, shorthand := range calendarShorthands {
+			if strings.EqualFold(fields[0], shorthand) {
+				return nil
+			}
+		}
+	}
+	matched := false
+	if len(fields) > 0 && calendarWeekdayRegex.MatchString(strings.ToLower(fields[0])) {
+		fields, matched = fields[1:], true
+	}
+	if len(fields) > 0 && calendarDateRegex.MatchString(fields[0]) {
+		fields, matched = fields[1:], true
+	}
+	hasTime := false
+	if len(fields) > 0 && calendarTimeRegex.MatchString(fields[0]) {
+		fields, matched, hasTime = fields[1:], true, true
+	}
+	// a timezone can follow the time
+	if hasTime && len(fields) == 1 && timezoneRegex.MatchString(fields[0]) {
+		fields = fields[1:]
+	}
+	if !matched || len(fields) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// autoUpdateFiles returns the drop-ins of the timer and the service of
+// the automatic updates and the units to enable or disable
+func autoUpdateFiles(au *AutoUpdate) (files []*fsnode.File, enabled, disabled []string, err error) {
+	if au.Enabled != nil && !*au.Enabled {
+		if au.Schedule != "" || au.RandomizedDelay != "" || au.StageOnly {
+			return nil, nil, nil, fmt.Errorf("auto_update schedule, randomized_delay and stage_only have no effect when the updates are disabled")
+		}
+		return nil, nil, []string{autoUpdateTimer}, nil
+	}
+
+	if au.Schedule != "" || au.RandomizedDelay != "" {
+		var content strings.Builder
+		content.WriteString("[Timer]\n")
+		if au.Schedule != "" {
+			if err := validateCalendar(au.Schedule); err != nil {
+				return nil, nil, nil, err
+			}
+			// the empty assignments reset the default schedule of the
+			// timer
+			fmt.Fprintf(&content, "OnBootSec=\nOnUnitInactiveSec=\nOnCalendar=\nOnCalendar=%s\nPersistent=true\n", au.Schedule)
+		}
+		if au.RandomizedDelay != "" {
+			if !timeSpanRegex.MatchString(au.RandomizedDelay) {
+				return nil, nil, nil, fmt.Errorf("invalid auto_update randomized_delay %q, expected a time span like \"30m\" or \"1h 30min\"", au.RandomizedDelay)
+			}
+			fmt.Fprintf(&content, "RandomizedDelaySec=%s\n", au.RandomizedDelay)
+		}
+		f, err := fsnode.NewFile(autoUpdateTimerDropIn, nil, nil, nil, []byte(content.String()))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, f)
+	}
+	if au.StageOnly {
+		// without --apply the update is staged and applied on the next
+		// reboot
+		f, err := fsnode.NewFile(autoUpdateServiceDropIn, nil, nil, nil, []byte("[Service]\nExecStart=\nExecStart=/usr/bin/bootc upgrade --quiet\n"))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, f)
+	}
+	return files, []string{autoUpdateTimer}, nil, nil
+}
@@ -0,0 +1,138 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/manifest"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+// systemdServices returns the services that the systemd stage of the
+// deployment enables and disables
+func systemdServices(t *testing.T, manifestJson manifest.OSBuildManifest) (enabled, disabled []string) {
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.systemd")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var opts struct {
+		EnabledServices  []string `json:"enabled_services"`
+		DisabledServices []string `json:"disabled_services"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+	return opts.EnabledServices, opts.DisabledServices
+}
+
+func TestManifestAutoUpdate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		autoUpdate main.AutoUpdate
+		timer      string
+		service    string
+	}{
+		"default-schedule": {
+			autoUpdate: main.AutoUpdate{},
+		},
+		"schedule": {
+			autoUpdate: main.AutoUpdate{Schedule: "Sun *-*-* 03:00:00", RandomizedDelay: "30m"},
+			timer:      "[Timer]\nOnBootSec=\nOnUnitInactiveSec=\nOnCalendar=\nOnCalendar=Sun *-*-* 03:00:00\nPersistent=true\nRandomizedDelaySec=30m\n",
+		},
+		"stage-only": {
+			autoUpdate: main.AutoUpdate{Schedule: "daily", StageOnly: true},
+			timer:      "[Timer]\nOnBootSec=\nOnUnitInactiveSec=\nOnCalendar=\nOnCalendar=daily\nPersistent=true\n",
+			service:    "[Service]\nExecStart=\nExecStart=/usr/bin/bootc upgrade --quiet\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{AutoUpdate: &tc.autoUpdate}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			enabled, disabled := systemdServices(t, manifestJson)
+			assert.Equal(t, []string{"bootc-fetch-apply-updates.timer"}, enabled)
+			assert.Empty(t, disabled)
+
+			for path, expected := range map[string]string{
+				"/etc/systemd/system/bootc-fetch-apply-updates.timer.d/90-bootc-image-builder.conf":   tc.timer,
+				"/etc/systemd/system/bootc-fetch-apply-updates.service.d/90-bootc-image-builder.conf": tc.service,
+			} {
+				content, err := findFileContent(manifestJson, "ostree-deployment", path)
+				if expected == "" {
+					assert.Error(t, err, path)
+					continue
+				}
+				require.NoError(t, err)
+				assert.Equal(t, expected, content)
+			}
+		})
+	}
+}
+
+func TestManifestAutoUpdateDisabled(t *testing.T) {
+	disabled := false
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{AutoUpdate: &main.AutoUpdate{Enabled: &disabled}}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	enabled, disabledServices := systemdServices(t, manifestJson)
+	assert.Empty(t, enabled)
+	assert.Equal(t, []string{"bootc-fetch-apply-updates.timer"}, disabledServices)
+}
+
+func TestAutoUpdateSchedules(t *testing.T) {
+	for _, schedule := range []string{
+		"daily",
+		"Weekly",
+		"Sun 03:00",
+		"Mon..Fri *-*-* 02:30:00",
+		"Sat,Sun 04:00 UTC",
+		"*-*-01 00:00:00",
+		"2026-*-* 12:00",
+		"*-02~03 06:00",
+		"*:0/15",
+		"Mon",
+		"03:00 Europe/Berlin",
+	} {
+		t.Run(schedule, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{AutoUpdate: &main.AutoUpdate{Schedule: schedule}}
+			_, err := main.Manifest(config)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestManifestAutoUpdateErrors(t *testing.T) {
+	disabled := false
+	for name, tc := range map[string]struct {
+		imgType    string
+		autoUpdate main.AutoUpdate
+		err        string
+	}{
+		"bad-schedule":   {"qcow2", main.AutoUpdate{Schedule: "every sunday"}, `invalid auto_update schedule "every sunday", expected an OnCalendar expression like "Sun *-*-* 03:00" or "daily"`},
+		"two-times":      {"qcow2", main.AutoUpdate{Schedule: "03:00 04:00"}, `invalid auto_update schedule "03:00 04:00", expected an OnCalendar expression like "Sun *-*-* 03:00" or "daily"`},
+		"newline":        {"qcow2", main.AutoUpdate{Schedule: "daily\nOnBootSec=1"}, "invalid auto_update schedule \"daily\\nOnBootSec=1\", expected an OnCalendar expression like \"Sun *-*-* 03:00\" or \"daily\""},
+		"bad-delay":      {"qcow2", main.AutoUpdate{RandomizedDelay: "soon"}, `invalid auto_update randomized_delay "soon", expected a time span like "30m" or "1h 30min"`},
+		"disabled-extra": {"qcow2", main.AutoUpdate{Enabled: &disabled, Schedule: "daily"}, "auto_update schedule, randomized_delay and stage_only have no effect when the updates are disabled"},
+		"iso":            {"iso", main.AutoUpdate{Schedule: "daily"}, "auto_update not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{AutoUpdate: &tc.autoUpdate}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
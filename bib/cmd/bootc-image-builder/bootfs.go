@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/osbuild/images/pkg/disk"
+)
+
+// bootFSTypes are the filesystems for /boot that grub2 can read and
+// that have the hardlinks and symlinks ostree needs
+var bootFSTypes = []string{"ext4", "xfs"}
+
+// applyBootFSType sets the filesystem of the /boot partition, the root
+// filesystem is not changed
+func applyBootFSType(pt *disk.PartitionTable, fstype string) error {
+	if fstype == "" {
+		return nil
+	}
+	valid := false
+	for _, t := range bootFSTypes {
+		if fstype == t {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("unsupported boot_fstype %q, valid values are %q", fstype, bootFSTypes)
+	}
+	bootfs, ok := pt.FindMountable("/boot").(*disk.Filesystem)
+	if !ok {
+		return fmt.Errorf("cannot set the /boot filesystem type: no /boot filesystem in the partition table")
+	}
+	if maxLen := filesystemLabelMaxLen[fstype]; len(bootfs.Label) > maxLen {
+		return fmt.Errorf("/boot filesystem label %q is too long for %s, the maximum is %d characters", bootfs.Label, fstype, maxLen)
+	}
+	bootfs.Type = fstype
+	return nil
+}
@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func mkfsLabels(t *testing.T, manifestJson []byte, stageType string) []string {
+	stages, err := findStages(manifestJson, "image", stageType)
+	require.NoError(t, err)
+	var labels []string
+	for _, st := range stages {
+		var opts struct {
+			Label string `json:"label"`
+		}
+		require.NoError(t, json.Unmarshal(st.Options, &opts))
+		labels = append(labels, opts.Label)
+	}
+	return labels
+}
+
+func TestManifestBootFSType(t *testing.T) {
+	for _, architecture := range []arch.Arch{arch.ARCH_X86_64, arch.ARCH_AARCH64} {
+		t.Run(architecture.String(), func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Architecture = architecture
+			config.Config = &main.BuildConfig{BootFSType: "xfs"}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			// only /boot changes, root stays ext4
+			assert.Equal(t, []string{"boot"}, mkfsLabels(t, manifestJson, "org.osbuild.mkfs.xfs"))
+			assert.Equal(t, []string{"root"}, mkfsLabels(t, manifestJson, "org.osbuild.mkfs.ext4"))
+
+			fstabStages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.fstab")
+			require.NoError(t, err)
+			require.Len(t, fstabStages, 1)
+			var fstab struct {
+				Filesystems []struct {
+					Path    string `json:"path"`
+					VFSType string `json:"vfs_type"`
+				} `json:"filesystems"`
+			}
+			require.NoError(t, json.Unmarshal(fstabStages[0].Options, &fstab))
+			types := make(map[string]string)
+			for _, fs := range fstab.Filesystems {
+				types[fs.Path] = fs.VFSType
+			}
+			assert.Equal(t, "xfs", types["/boot"])
+			assert.Equal(t, "ext4", types["/"])
+		})
+	}
+}
+
+func TestManifestBootFSTypeDefault(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Architecture = arch.ARCH_X86_64
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"boot", "root"}, mkfsLabels(t, manifestJson, "org.osbuild.mkfs.ext4"))
+	assert.Len(t, mkfsLabels(t, manifestJson, "org.osbuild.mkfs.xfs"), 0)
+}
+
+func TestManifestBootFSTypeErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		fstype  string
+		err     string
+	}{
+		"vfat":  {"qcow2", "vfat", `unsupported boot_fstype "vfat", valid values are ["ext4" "xfs"]`},
+		"btrfs": {"qcow2", "btrfs", `unsupported boot_fstype "btrfs", valid values are ["ext4" "xfs"]`},
+		"iso":   {"iso", "xfs", "boot_fstype not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Architecture = arch.ARCH_X86_64
+			config.Config = &main.BuildConfig{BootFSType: tc.fstype}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/osbuild/images/pkg/arch"
+)
+
+// bootloaderNone builds disk images without a bootloader, for clouds
+// and hypervisors that boot the kernel of the image from outside, e.g.
+// with pvgrub or direct kernel boot. By default bootupd installs the
+// bootloader.
+const bootloaderNone = "none"
+
+var bootloaderValues = []string{bootloaderNone}
+
+// bootupdStageType installs the bootloader in the image pipeline of
+// disk images
+const bootupdStageType = "org.osbuild.bootupd"
+
+// validateBootloader checks that the bootloader setting can be applied
+// to the image and that no option configures the bootloader that is
+// not installed
+func (c *ManifestConfig) validateBootloader() error {
+	if c.Config.Bootloader != bootloaderNone {
+		return fmt.Errorf("unsupported bootloader %q, valid values are %q", c.Config.Bootloader, bootloaderValues)
+	}
+	// vagrant boxes boot from their disk, the other image types are
+	// no disk images
+	switch c.ImgType {
+	case "ami", "qcow2", "raw":
+	default:
+		return fmt.Errorf("bootloader %q is not supported for the %s image type", c.Config.Bootloader, c.ImgType)
+	}
+	switch c.Architecture {
+	case arch.ARCH_X86_64, arch.ARCH_AARCH64:
+	default:
+		return fmt.Errorf("bootloader %q is not supported on %s", c.Config.Bootloader, c.Architecture)
+	}
+
+	var opts []string
+	if c.Config.Bootupd != "" {
+		opts = append(opts, "bootupd")
+	}
+	if c.Config.EFIFallback || c.Config.EFIVendor != "" {
+		opts = append(opts, "efi_fallback")
+	}
+	if len(c.Config.GrubUserConfig) > 0 {
+		opts = append(opts, "grub_user_config")
+	}
+	if c.Config.GrubMenu != "" {
+		opts = append(opts, "grub_menu")
+	}
+	if len(opts) > 0 {
+		return fmt.Errorf("%s cannot be used with bootloader %q", strings.Join(opts, ", "), c.Config.Bootloader)
+	}
+	return nil
+}
+
+// removeBootloader drops the bootupd stage that installs grub (and
+// shim on UEFI) to the disk. The partitions of the ESP and the BIOS
+// boot partition stay empty, the boot entries of the deployment with
+// their kernel arguments are still written to /boot so bootloaders
+// outside of the image, like pvgrub2, find the kernel and the
+// deployment.
+//
+// TODO: osbuild/images always installs the bootloader of bootc images
+func removeBootloader(m *serializedManifest) error {
+	if err := m.removeStages(espDiskPipelineName, bootupdStageType); err != nil {
+		return fmt.Errorf("cannot remove the bootloader: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/osbuild/images/pkg/arch"
+)
+
+// bootupdService updates the bootloader from the one of the booted
+// deployment, the bootloader of a disk image is always installed by
+// bootupd when the image is built
+const bootupdService = "bootloader-update.service"
+
+var bootupdValues = []string{"enabled", "disabled"}
+
+// bootupdServices returns the services to enable and disable for the
+// given bootupd setting
+func bootupdServices(value string, a arch.Arch) (enabled, disabled []string, err error) {
+	switch a {
+	case arch.ARCH_X86_64, arch.ARCH_AARCH64:
+	default:
+		return nil, nil, fmt.Errorf("bootupd cannot be configured on %s, it manages the bootloader on x86_64 and aarch64 only", a)
+	}
+	switch value {
+	case "enabled":
+		return []string{bootupdService}, nil, nil
+	case "disabled":
+		return nil, []string{bootupdService}, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported bootupd %q, valid values are %q", value, bootupdValues)
+}
@@ -0,0 +1,63 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/arch"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestBootupd(t *testing.T) {
+	for name, tc := range map[string]struct {
+		arch     arch.Arch
+		bootupd  string
+		enabled  []string
+		disabled []string
+	}{
+		"enabled-x86_64":   {arch.ARCH_X86_64, "enabled", []string{"bootloader-update.service"}, nil},
+		"disabled-x86_64":  {arch.ARCH_X86_64, "disabled", nil, []string{"bootloader-update.service"}},
+		"enabled-aarch64":  {arch.ARCH_AARCH64, "enabled", []string{"bootloader-update.service"}, nil},
+		"disabled-aarch64": {arch.ARCH_AARCH64, "disabled", nil, []string{"bootloader-update.service"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Architecture = tc.arch
+			config.Config = &main.BuildConfig{Bootupd: tc.bootupd}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			enabled, disabled := systemdServices(t, manifestJson)
+			assert.Equal(t, tc.enabled, enabled)
+			assert.Equal(t, tc.disabled, disabled)
+		})
+	}
+}
+
+func TestManifestBootupdErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		arch    arch.Arch
+		bootupd string
+		err     string
+	}{
+		"invalid": {"qcow2", arch.ARCH_X86_64, "off", `unsupported bootupd "off", valid values are ["enabled" "disabled"]`},
+		"s390x":   {"qcow2", arch.ARCH_S390X, "disabled", "bootupd cannot be configured on s390x, it manages the bootloader on x86_64 and aarch64 only"},
+		"iso":     {"anaconda-iso", arch.ARCH_X86_64, "disabled", "bootupd not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Architecture = tc.arch
+			config.Config = &main.BuildConfig{Bootupd: tc.bootupd}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
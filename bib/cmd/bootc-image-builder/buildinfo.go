@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/osbuild/images/pkg/container"
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// bibVersion is set at build time by build.sh via
+// -ldflags "-X main.bibVersion=<version>"
+var bibVersion = "devel"
+
+const buildInfoPath = "/etc/bootc-build-info.json"
+
+// BuildInfo is embedded into the image (with --embed-build-info) to
+// make it possible to find out how an image was built in the field.
+type BuildInfo struct {
+	BaseImage  string `json:"base_image"`
+	BaseDigest string `json:"base_digest"`
+	BibVersion string `json:"bib_version"`
+	BuildTime  string `json:"build_time"`
+	ImageType  string `json:"image_type"`
+}
+
+// buildTime returns the time of the build, SOURCE_DATE_EPOCH is
+// honored to make reproducible builds possible.
+func buildTime() (time.Time, error) {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse SOURCE_DATE_EPOCH %q: %w", epoch, err)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	return time.Now().UTC(), nil
+}
+
+func newBuildInfo(imgref, imgType string) (*BuildInfo, error) {
+	t, err := buildTime()
+	if err != nil {
+		return nil, err
+	}
+	return &BuildInfo{
+		BaseImage:  imgref,
+		BibVersion: bibVersion,
+		BuildTime:  t.Format(time.RFC3339),
+		ImageType:  imgType,
+	}, nil
+}
+
+// baseImageSpec returns the resolved base image of the target
+// architecture from the given resolved containers
+func (c *ManifestConfig) baseImageSpec(containerSpecs map[string][]container.Spec) (container.Spec, bool) {
+	for plName, specs := range containerSpecs {
+		// the build pipeline runs the image of the host
+		if plName == "build" {
+			continue
+		}
+		for _, spec := range specs {
+			if spec.LocalName == c.Imgref {
+				return spec, true
+			}
+		}
+	}
+	return container.Spec{}, false
+}
+
+func (bi *BuildInfo) file() (*fsnode.File, error) {
+	b, err := json.MarshalIndent(bi, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, '\n')
+	return fsnode.NewFile(buildInfoPath, nil, nil, nil, b)
+}
+
+// addBuildInfo writes the build info to the deployment. The digest of
+// the base image is only known once the containers of the manifest are
+// resolved, so the file is added to the serialized manifest instead of
+// creating the manifest again with it.
+//
+// TODO: osbuild/images only adds the files of the deployment that are
+// known when the manifest is created
+func addBuildInfo(m *serializedManifest, bi *BuildInfo) error {
+	f, err := bi.file()
+	if err != nil {
+		return err
+	}
+
+	err = m.updateSource("org.osbuild.inline", func(source json.RawMessage) (json.RawMessage, error) {
+		inline := osbuild.NewInlineSource()
+		if source != nil {
+			if err := json.Unmarshal(source, inline); err != nil {
+				return nil, err
+			}
+		}
+		inline.AddItem(string(f.Data()))
+		return json.Marshal(inline)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add build info: %w", err)
+	}
+
+	err = m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(options json.RawMessage) ([]*osbuild.Stage, error) {
+		var selinuxOpts osbuild.OSTreeSelinuxStageOptions
+		if err := json.Unmarshal(options, &selinuxOpts); err != nil {
+			return nil, err
+		}
+		deployment := selinuxOpts.Deployment
+
+		stages := osbuild.GenFileNodesStages([]*fsnode.File{f})
+		for _, stage := range stages {
+			stage.MountOSTree(deployment.OSName, deployment.Ref, 0)
+		}
+		return stages, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add build info: %w", err)
+	}
+	return nil
+}
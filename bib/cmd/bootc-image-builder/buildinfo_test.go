@@ -0,0 +1,101 @@
+package main_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestNewBuildInfoSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	bi, err := main.NewBuildInfo("quay.io/example/img:latest", "qcow2")
+	require.NoError(t, err)
+	assert.Equal(t, "2023-11-14T22:13:20Z", bi.BuildTime)
+	assert.Equal(t, "quay.io/example/img:latest", bi.BaseImage)
+	assert.Equal(t, "qcow2", bi.ImageType)
+}
+
+func TestNewBuildInfoBadSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+
+	_, err := main.NewBuildInfo("quay.io/example/img:latest", "qcow2")
+	assert.EqualError(t, err, `cannot parse SOURCE_DATE_EPOCH "yesterday": strconv.ParseInt: parsing "yesterday": invalid syntax`)
+}
+
+func TestManifestEmbedBuildInfo(t *testing.T) {
+	for _, imgType := range []string{"ami", "qcow2", "raw"} {
+		t.Run(imgType, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = imgType
+			config.BuildInfo = &main.BuildInfo{
+				BaseImage:  config.Imgref,
+				BaseDigest: testContainerSpec.Digest,
+				BibVersion: "devel",
+				BuildTime:  "2023-11-14T22:13:20Z",
+				ImageType:  imgType,
+			}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+			manifestJson, err = main.AddBuildInfo(manifestJson, config.BuildInfo)
+			require.NoError(t, err)
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/bootc-build-info.json")
+			require.NoError(t, err)
+			var bi main.BuildInfo
+			require.NoError(t, json.Unmarshal([]byte(content), &bi))
+			assert.Equal(t, *config.BuildInfo, bi)
+		})
+	}
+}
+
+func TestManifestEmbedBuildInfoISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.BuildInfo = &main.BuildInfo{}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "embedding build info is not supported for the iso image type")
+}
+
+func TestMakeManifestBuildInfoDigestFromBuild(t *testing.T) {
+	calls := 0
+	restore := main.MockNewContainerResolver(func(string) main.ContainerResolver {
+		return &fakeResolver{calls: &calls}
+	})
+	defer restore()
+
+	_, err := main.MakeManifest(lockTestConfig(), "")
+	require.NoError(t, err)
+	resolved := calls
+
+	// the digest is taken from the containers of the build, the base
+	// image is not resolved a second time
+	calls = 0
+	c := lockTestConfig()
+	c.BuildInfo = &main.BuildInfo{BaseImage: c.Imgref}
+	mf, err := main.MakeManifest(c, "")
+	require.NoError(t, err)
+	assert.Equal(t, resolved, calls)
+	assert.Equal(t, testContainerSpec.Digest, c.BuildInfo.BaseDigest)
+
+	// the file is written once, with the digest
+	stages, err := findStages(mf, "ostree-deployment", "org.osbuild.copy")
+	require.NoError(t, err)
+	written := 0
+	for _, st := range stages {
+		written += strings.Count(string(st.Options), `"tree:///etc/bootc-build-info.json"`)
+	}
+	assert.Equal(t, 1, written)
+	content, err := findFileContent(mf, "ostree-deployment", "/etc/bootc-build-info.json")
+	require.NoError(t, err)
+	var bi main.BuildInfo
+	require.NoError(t, json.Unmarshal([]byte(content), &bi))
+	assert.Equal(t, testContainerSpec.Digest, bi.BaseDigest)
+}
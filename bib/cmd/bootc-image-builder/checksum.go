@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	checksumSuffix  = ".sha256"
+	signatureSuffix = ".asc"
+	// checksumsFilename is the combined checksum file of all artifacts
+	// in the output directory
+	checksumsFilename = "SHA256SUMS"
+)
+
+// writeChecksums writes a checksum file in the format of sha256sum(1)
+// next to every artifact of the given outputs and returns the paths of
+// the checksum files.
+func writeChecksums(outputDir string, outputs []string) ([]string, error) {
+	var checksumFiles []string
+	for _, output := range outputs {
+		err := filepath.WalkDir(filepath.Join(outputDir, output), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() || isSidecar(path) {
+				return nil
+			}
+			sum, err := sha256File(path)
+			if err != nil {
+				return err
+			}
+			checksumFile := path + checksumSuffix
+			content := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+			if err := os.WriteFile(checksumFile, []byte(content), 0644); err != nil {
+				return err
+			}
+			checksumFiles = append(checksumFiles, checksumFile)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return checksumFiles, nil
+}
+
+// isSidecar returns true for the checksum, signature and metadata
+// files that are written next to the artifacts
+func isSidecar(path string) bool {
+	return strings.HasSuffix(path, checksumSuffix) || strings.HasSuffix(path, signatureSuffix) || strings.HasSuffix(path, metadataSuffix)
+}
+
+// writeCombinedChecksums writes the checksums of all files of the
+// given outputs in stagingDir with the paths relative to it to
+// stagingDir/SHA256SUMS and returns its path. Besides the artifacts
+// this covers the files written next to them, like the artifact
+// metadata, so it must run after all of them are written. The
+// checksums of the artifacts are taken from their checksum files
+// instead of reading the artifacts again.
+//
+// The lines of the outputDir/SHA256SUMS of previous builds are kept,
+// except the ones of the given outputs as those are replaced.
+func writeCombinedChecksums(stagingDir, outputDir string, outputs []string) (string, error) {
+	var combined strings.Builder
+	if err := copyPreviousChecksums(&combined, filepath.Join(outputDir, checksumsFilename), outputs); err != nil {
+		return "", err
+	}
+	for _, output := range outputs {
+		err := filepath.WalkDir(filepath.Join(stagingDir, output), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() || strings.HasSuffix(path, checksumSuffix) || strings.HasSuffix(path, signatureSuffix) {
+				return nil
+			}
+			var sum string
+			checksum, err := os.ReadFile(path + checksumSuffix)
+			switch {
+			case err == nil:
+				sum, _, _ = strings.Cut(string(checksum), " ")
+			case errors.Is(err, fs.ErrNotExist):
+				if sum, err = sha256File(path); err != nil {
+					return err
+				}
+			default:
+				return err
+			}
+
+			relPath, err := filepath.Rel(stagingDir, path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&combined, "%s  %s\n", sum, relPath)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	combinedFile := filepath.Join(stagingDir, checksumsFilename)
+	if err := os.WriteFile(combinedFile, []byte(combined.String()), 0644); err != nil {
+		return "", err
+	}
+	return combinedFile, nil
+}
+
+// copyPreviousChecksums copies the lines of the checksum file of a
+// previous build to w, except the ones of files in the given outputs
+// (the outputs are replaced as a whole, so files that are not built
+// again are gone as well)
+func copyPreviousChecksums(w io.Writer, path string, outputs []string) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read the checksums of the previous build: %w", err)
+	}
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if line == "" {
+			continue
+		}
+		_, relPath, _ := strings.Cut(strings.TrimSuffix(line, "\n"), "  ")
+		if isInOutputs(relPath, outputs) {
+			continue
+		}
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isInOutputs returns true when relPath is one of the given outputs or
+// a file inside of one
+func isInOutputs(relPath string, outputs []string) bool {
+	for _, output := range outputs {
+		if relPath == output || strings.HasPrefix(relPath, output+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// signFile creates a detached ASCII armored signature of path in
+// path + ".asc" with the given key
+var signFile = func(keyID, path string) error {
+	output, err := exec.Command("gpg", "--batch", "--yes", "--armor", "--detach-sign", "--local-user", keyID, "--output", path+signatureSuffix, path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cannot sign %q: %w, output:\n%s", path, err, output)
+	}
+	return nil
+}
+
+// checkSignKey ensures that the secret key to sign with is available
+var checkSignKey = func(keyID string) error {
+	output, err := exec.Command("gpg", "--batch", "--list-secret-keys", keyID).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cannot find secret key %q: %w, output:\n%s", keyID, err, output)
+	}
+	return nil
+}
+
+// removeStaleSignature removes the signature of the SHA256SUMS of a
+// previous signed build, it does not match the merged file of an
+// unsigned build
+func removeStaleSignature(outputDir string) error {
+	path := filepath.Join(outputDir, checksumsFilename+signatureSuffix)
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot remove the stale signature %q: %w", path, err)
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: removed %s, it does not match %s of this unsigned build\n", path, checksumsFilename)
+	return nil
+}
+
+// signChecksums creates detached signatures of the given checksum
+// files and returns the paths of the signatures.
+func signChecksums(keyID string, checksumFiles []string) ([]string, error) {
+	var signatures []string
+	for _, path := range checksumFiles {
+		if err := signFile(keyID, path); err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, path+signatureSuffix)
+	}
+	return signatures, nil
+}
@@ -0,0 +1,161 @@
+package main_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestWriteChecksums(t *testing.T) {
+	outputDir := t.TempDir()
+	diskPath := filepath.Join(outputDir, "qcow2", "disk.qcow2")
+	require.NoError(t, os.MkdirAll(filepath.Dir(diskPath), 0755))
+	require.NoError(t, os.WriteFile(diskPath, []byte("hello\n"), 0644))
+
+	checksumFiles, err := main.WriteChecksums(outputDir, []string{"qcow2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{diskPath + ".sha256"}, checksumFiles)
+	content, err := os.ReadFile(diskPath + ".sha256")
+	require.NoError(t, err)
+	assert.Equal(t, "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03  disk.qcow2\n", string(content))
+
+	// checksum files are not checksummed again on a second run
+	checksumFiles, err = main.WriteChecksums(outputDir, []string{"qcow2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{diskPath + ".sha256"}, checksumFiles)
+}
+
+func TestWriteChecksumsCombined(t *testing.T) {
+	outputDir := t.TempDir()
+	diskPath := filepath.Join(outputDir, "qcow2", "disk.qcow2")
+	dataDiskPath := filepath.Join(outputDir, "data-disks", "data-disk-0.raw")
+	for _, path := range []string{diskPath, dataDiskPath} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	}
+	require.NoError(t, os.WriteFile(diskPath, []byte("hello\n"), 0644))
+	require.NoError(t, os.WriteFile(dataDiskPath, []byte("world\n"), 0644))
+
+	_, err := main.WriteChecksums(outputDir, []string{"qcow2", "data-disks"})
+	require.NoError(t, err)
+	// files next to the artifacts are covered by the combined
+	// checksums only
+	require.NoError(t, os.WriteFile(diskPath+".meta.json", []byte("{}\n"), 0644))
+	combined, err := main.WriteCombinedChecksums(outputDir, outputDir, []string{"qcow2", "data-disks"})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(outputDir, "SHA256SUMS"), combined)
+	assert.NoFileExists(t, diskPath+".meta.json.sha256")
+	content, err := os.ReadFile(combined)
+	require.NoError(t, err)
+	assert.Equal(t, `5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03  qcow2/disk.qcow2
+ca3d163bab055381827226140568f3bef7eaac187cebd76878e0b63e9e442356  qcow2/disk.qcow2.meta.json
+e258d248fda94c63753607f7c4494ee0fcbe92f1a76bfdac795c9d84101eb317  data-disks/data-disk-0.raw
+`, string(content))
+
+	// the relative paths can be verified from the output directory
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not available")
+	}
+	cmd := exec.Command("sha256sum", "--check", "--strict", "SHA256SUMS")
+	cmd.Dir = outputDir
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	assert.Equal(t, "qcow2/disk.qcow2: OK\nqcow2/disk.qcow2.meta.json: OK\ndata-disks/data-disk-0.raw: OK\n", string(output))
+}
+
+func TestSignChecksums(t *testing.T) {
+	outputDir := t.TempDir()
+	checksumFile := filepath.Join(outputDir, "disk.qcow2.sha256")
+	require.NoError(t, os.WriteFile(checksumFile, []byte("checksum"), 0644))
+
+	var signed []string
+	restore := main.MockSignFile(func(keyID, path string) error {
+		assert.Equal(t, "0xDEADBEEF", keyID)
+		signed = append(signed, path)
+		return os.WriteFile(path+".asc", []byte("signature"), 0644)
+	})
+	defer restore()
+
+	signatures, err := main.SignChecksums("0xDEADBEEF", []string{checksumFile})
+	require.NoError(t, err)
+	assert.Equal(t, []string{checksumFile}, signed)
+	assert.Equal(t, []string{checksumFile + ".asc"}, signatures)
+	assert.FileExists(t, checksumFile+".asc")
+}
+
+// buildInto does what cmdBuild does with the artifact of a build, the
+// output is written to a staging dir and committed into outputDir
+func buildInto(t *testing.T, outputDir, output, content, signKey string) {
+	t.Helper()
+	staging, err := main.NewStagedOutput(outputDir)
+	require.NoError(t, err)
+	defer staging.Cleanup()
+	require.NoError(t, os.MkdirAll(filepath.Join(staging.Dir, output), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(staging.Dir, output, "disk.img"), []byte(content), 0644))
+
+	checksumFiles, err := main.WriteChecksums(staging.Dir, []string{output})
+	require.NoError(t, err)
+	combined, err := main.WriteCombinedChecksums(staging.Dir, outputDir, []string{output})
+	require.NoError(t, err)
+	committed := []string{output, "SHA256SUMS"}
+	if signKey != "" {
+		_, err = main.SignChecksums(signKey, append(checksumFiles, combined))
+		require.NoError(t, err)
+		committed = append(committed, "SHA256SUMS.asc")
+	}
+	require.NoError(t, staging.Commit(committed))
+	if signKey == "" {
+		require.NoError(t, main.RemoveStaleSignature(outputDir))
+	}
+}
+
+func TestWriteChecksumsCombinedTwoBuilds(t *testing.T) {
+	restore := main.MockSignFile(func(keyID, path string) error {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path+".asc", append([]byte("signature of\n"), content...), 0644)
+	})
+	defer restore()
+
+	outputDir := t.TempDir()
+	buildInto(t, outputDir, "qcow2", "hello\n", "0xDEADBEEF")
+	// the SHA256SUMS of the first build is not a conflict
+	require.NoError(t, main.CheckOutputs(outputDir, []string{"raw"}, false))
+	buildInto(t, outputDir, "raw", "world\n", "0xDEADBEEF")
+
+	// SHA256SUMS has the lines of both builds and is signed again
+	sums, err := os.ReadFile(filepath.Join(outputDir, "SHA256SUMS"))
+	require.NoError(t, err)
+	assert.Equal(t, `5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03  qcow2/disk.img
+e258d248fda94c63753607f7c4494ee0fcbe92f1a76bfdac795c9d84101eb317  raw/disk.img
+`, string(sums))
+	signature, err := os.ReadFile(filepath.Join(outputDir, "SHA256SUMS.asc"))
+	require.NoError(t, err)
+	assert.Equal(t, "signature of\n"+string(sums), string(signature))
+
+	// building qcow2 again replaces its line
+	buildInto(t, outputDir, "qcow2", "hello again\n", "")
+	sums, err = os.ReadFile(filepath.Join(outputDir, "SHA256SUMS"))
+	require.NoError(t, err)
+	assert.Equal(t, `e258d248fda94c63753607f7c4494ee0fcbe92f1a76bfdac795c9d84101eb317  raw/disk.img
+d9a4c6676a62cb3b8ca0b8459ab341837cdba8543316c8574b454ccc24d4c690  qcow2/disk.img
+`, string(sums))
+	// the signature of the previous build does not match anymore
+	assert.NoFileExists(t, filepath.Join(outputDir, "SHA256SUMS.asc"))
+
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum not available")
+	}
+	cmd := exec.Command("sha256sum", "--check", "--strict", "SHA256SUMS")
+	cmd.Dir = outputDir
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	assert.Equal(t, "raw/disk.img: OK\nqcow2/disk.img: OK\n", string(output))
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/osbuild/bootc-image-builder/bib/internal/uploader"
+	"github.com/osbuild/images/pkg/arch"
 	"github.com/osbuild/images/pkg/cloud/awscloud"
 	"github.com/spf13/pflag"
 )
@@ -26,3 +32,53 @@ func uploadAMI(path, targetArch string, flags *pflag.FlagSet) error {
 	}
 	return uploader.UploadAndRegister(client, path, bucketName, imageName, targetArch)
 }
+
+// AMIRegisterParams are the parameters that --aws-region passes to
+// the RegisterImage API besides the name of the AMI and the block
+// device mapping of the imported snapshot, plus SriovNetSupport, which
+// awscloud cannot pass. The names match the API, so the file can be
+// passed to "aws ec2 register-image --cli-input-json" together with
+// --name and --block-device-mappings.
+type AMIRegisterParams struct {
+	Architecture       string `json:"Architecture"`
+	BootMode           string `json:"BootMode"`
+	EnaSupport         bool   `json:"EnaSupport"`
+	SriovNetSupport    string `json:"SriovNetSupport"`
+	RootDeviceName     string `json:"RootDeviceName"`
+	VirtualizationType string `json:"VirtualizationType"`
+}
+
+// amiRegisterParams returns the registration parameters for an AMI
+// built for the given architecture.
+func amiRegisterParams(a arch.Arch) (*AMIRegisterParams, error) {
+	params := &AMIRegisterParams{
+		BootMode:           uploader.BootMode(a),
+		EnaSupport:         true,
+		SriovNetSupport:    "simple",
+		RootDeviceName:     "/dev/sda1",
+		VirtualizationType: ec2.VirtualizationTypeHvm,
+	}
+	switch a {
+	case arch.ARCH_X86_64:
+		params.Architecture = ec2.ArchitectureValuesX8664
+	case arch.ARCH_AARCH64:
+		params.Architecture = ec2.ArchitectureValuesArm64
+	default:
+		return nil, fmt.Errorf("ec2 doesn't support the following arch: %s", a)
+	}
+	return params, nil
+}
+
+// writeAMIRegisterParams writes the registration parameters for an AMI
+// built for the given architecture as JSON to path.
+func writeAMIRegisterParams(path string, a arch.Arch) error {
+	params, err := amiRegisterParams(a)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
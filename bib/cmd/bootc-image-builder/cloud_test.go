@@ -0,0 +1,50 @@
+package main_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestWriteAMIRegisterParams(t *testing.T) {
+	for _, tc := range []struct {
+		arch     arch.Arch
+		ec2Arch  string
+		bootMode string
+	}{
+		{arch.ARCH_AARCH64, "arm64", "uefi"},
+		{arch.ARCH_X86_64, "x86_64", "uefi-preferred"},
+	} {
+		t.Run(tc.arch.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ami-register-params.json")
+			require.NoError(t, main.WriteAMIRegisterParams(path, tc.arch))
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			var params main.AMIRegisterParams
+			require.NoError(t, json.Unmarshal(data, &params))
+			assert.Equal(t, main.AMIRegisterParams{
+				Architecture:       tc.ec2Arch,
+				BootMode:           tc.bootMode,
+				EnaSupport:         true,
+				SriovNetSupport:    "simple",
+				RootDeviceName:     "/dev/sda1",
+				VirtualizationType: "hvm",
+			}, params)
+		})
+	}
+}
+
+func TestWriteAMIRegisterParamsUnsupportedArch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ami-register-params.json")
+	err := main.WriteAMIRegisterParams(path, arch.ARCH_S390X)
+	assert.EqualError(t, err, "ec2 doesn't support the following arch: s390x")
+	assert.NoFileExists(t, path)
+}
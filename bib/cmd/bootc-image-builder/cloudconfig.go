@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/osbuild/images/pkg/blueprint"
+)
+
+const cloudConfigTemplateFilename = "cloud-config.yaml"
+
+// yamlQuote quotes s as a double quoted YAML scalar, JSON strings are
+// valid YAML
+func yamlQuote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// cloudConfigTemplate returns a commented #cloud-config sample for an
+// image built with the given customizations. Users of the build config
+// are listed as they already exist in the image, everything the image
+// does not contain is a commented out example. Passwords are never
+// part of the template.
+func cloudConfigTemplate(customizations *blueprint.Customizations) []byte {
+	var b strings.Builder
+	b.WriteString(`#cloud-config
+# Sample cloud-config for the image built by bootc-image-builder. Review it,
+# fill in the commented out values and pass it as user-data to cloud-init.
+
+# The image does not set a hostname.
+# hostname: my-hostname
+
+`)
+
+	bpUsers := customizations.GetUsers()
+	if len(bpUsers) == 0 {
+		b.WriteString(`# The image has no users, add one to be able to log in.
+# users:
+#   - name: my-user
+#     groups: [wheel]
+#     ssh_authorized_keys:
+#       - ssh-ed25519 AAAA... my-user@example.com
+`)
+		return []byte(b.String())
+	}
+
+	b.WriteString(`# These users are already part of the image. Listing them keeps the
+# default user of cloud-init from being created, more SSH keys can be added.
+users:
+`)
+	for _, user := range bpUsers {
+		fmt.Fprintf(&b, "  - name: %s\n", yamlQuote(user.Name))
+		if len(user.Groups) > 0 {
+			quoted := make([]string, 0, len(user.Groups))
+			for _, group := range user.Groups {
+				quoted = append(quoted, yamlQuote(group))
+			}
+			fmt.Fprintf(&b, "    groups: [%s]\n", strings.Join(quoted, ", "))
+		}
+		var keys []string
+		if user.Key != nil {
+			for _, key := range strings.Split(*user.Key, "\n") {
+				if key = strings.TrimSpace(key); key != "" {
+					keys = append(keys, key)
+				}
+			}
+		}
+		if len(keys) == 0 {
+			b.WriteString("    # ssh_authorized_keys:\n")
+			fmt.Fprintf(&b, "    #   - ssh-ed25519 AAAA... %s@example.com\n", user.Name)
+			continue
+		}
+		b.WriteString("    ssh_authorized_keys:\n")
+		for _, key := range keys {
+			fmt.Fprintf(&b, "      - %s\n", yamlQuote(key))
+		}
+	}
+	return []byte(b.String())
+}
+
+// writeCloudConfigTemplate writes the cloud-config template for the
+// given customizations to path
+func writeCloudConfigTemplate(path string, customizations *blueprint.Customizations) error {
+	return os.WriteFile(path, cloudConfigTemplate(customizations), 0644)
+}
@@ -0,0 +1,59 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestCloudConfigTemplateUsers(t *testing.T) {
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGVx alice@example.com\nssh-rsa AAAAB3NzaC1yc2E alice@laptop\n"
+	password := "$6$secret"
+	customizations := &blueprint.Customizations{
+		User: []blueprint.UserCustomization{
+			{Name: "alice", Key: &key, Groups: []string{"wheel", "adm"}, Password: &password},
+			{Name: "bob"},
+		},
+	}
+	template := string(main.CloudConfigTemplate(customizations))
+	assert.True(t, strings.HasPrefix(template, "#cloud-config\n"))
+	// passwords are never part of the template
+	assert.NotContains(t, template, password)
+
+	var cloudConfig struct {
+		Users []struct {
+			Name              string   `yaml:"name"`
+			Groups            []string `yaml:"groups"`
+			SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
+		} `yaml:"users"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(template), &cloudConfig))
+	require.Len(t, cloudConfig.Users, 2)
+	assert.Equal(t, "alice", cloudConfig.Users[0].Name)
+	assert.Equal(t, []string{"wheel", "adm"}, cloudConfig.Users[0].Groups)
+	assert.Equal(t, []string{
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGVx alice@example.com",
+		"ssh-rsa AAAAB3NzaC1yc2E alice@laptop",
+	}, cloudConfig.Users[0].SSHAuthorizedKeys)
+	assert.Equal(t, "bob", cloudConfig.Users[1].Name)
+	assert.Nil(t, cloudConfig.Users[1].SSHAuthorizedKeys)
+	assert.Contains(t, template, "    #   - ssh-ed25519 AAAA... bob@example.com\n")
+}
+
+func TestCloudConfigTemplateNoUsers(t *testing.T) {
+	template := string(main.CloudConfigTemplate(nil))
+	assert.True(t, strings.HasPrefix(template, "#cloud-config\n"))
+	assert.Contains(t, template, "# users:\n#   - name: my-user\n")
+	assert.Contains(t, template, "# hostname: my-hostname\n")
+
+	// only comments, nothing is configured
+	var cloudConfig map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(template), &cloudConfig))
+	assert.Len(t, cloudConfig, 0)
+}
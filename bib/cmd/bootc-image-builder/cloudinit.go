@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// cloudInitUnits are the units that run the stages of cloud-init,
+// cloud-init-network.service replaces cloud-init.service in newer
+// versions
+var cloudInitUnits = []string{
+	"cloud-init-local.service",
+	"cloud-init.service",
+	"cloud-init-network.service",
+	"cloud-config.service",
+	"cloud-final.service",
+}
+
+// disableCloudInit returns the files that mask the units of cloud-init,
+// see disableFirewall()
+func disableCloudInit() ([]*fsnode.File, error) {
+	var files []*fsnode.File
+	for _, unit := range cloudInitUnits {
+		f, err := fsnode.NewFile(path.Join("/etc/systemd/system", unit), nil, nil, nil, []byte{})
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
@@ -0,0 +1,46 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestDisableCloudInit(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{DisableCloudInit: true}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	for _, unit := range []string{"cloud-init-local.service", "cloud-init.service", "cloud-init-network.service", "cloud-config.service", "cloud-final.service"} {
+		content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/"+unit)
+		require.NoError(t, err, unit)
+		assert.Equal(t, "", content)
+	}
+}
+
+func TestManifestDisableCloudInitUnset(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	_, err = findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/cloud-init.service")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestManifestDisableCloudInitISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{DisableCloudInit: true}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "disable_cloud_init not supported for the iso image type")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	containerProxyConfPath   = "/etc/containers/containers.conf.d/90-bootc-image-builder-proxy.conf"
+	containerProxyDropInName = "90-bootc-image-builder-proxy.conf"
+)
+
+// ContainerProxy configures the proxy that the container runtimes of
+// the installed system use to pull images
+type ContainerProxy struct {
+	HTTPProxy  string   `json:"http_proxy,omitempty"`
+	HTTPSProxy string   `json:"https_proxy,omitempty"`
+	NoProxy    []string `json:"no_proxy,omitempty"`
+	// Services get the proxy via a drop-in, by default the services
+	// that update the system and its containers
+	Services []string `json:"services,omitempty"`
+}
+
+var defaultContainerProxyServices = []string{
+	"bootc-fetch-apply-updates.service",
+	"podman-auto-update.service",
+}
+
+var (
+	containerProxySchemes = []string{"http", "https", "socks5", "socks5h"}
+	serviceNameRegex      = regexp.MustCompile(`^[A-Za-z0-9:_.@-]+\.service$`)
+)
+
+func validateProxyURL(name, value string) error {
+	u, err := url.Parse(value)
+	valid := err == nil && u.Host != "" && !strings.ContainsAny(value, " \t\n\"\\")
+	if valid {
+		valid = false
+		for _, scheme := range containerProxySchemes {
+			if u.Scheme == scheme {
+				valid = true
+				break
+			}
+		}
+	}
+	if !valid {
+		// the URL can contain credentials
+		return fmt.Errorf("invalid container_proxy %s, expected a URL with one of the schemes %q", name, containerProxySchemes)
+	}
+	return nil
+}
+
+// validateNoProxy accepts "*", IP addresses, CIDRs and domains with an
+// optional leading "." and port
+func validateNoProxy(entry string) error {
+	if entry == "*" || net.ParseIP(entry) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(entry); err == nil {
+		return nil
+	}
+	host := entry
+	if h, port, err := net.SplitHostPort(entry); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid container_proxy no_proxy entry %q", entry)
+		}
+		host = h
+	}
+	host = strings.TrimPrefix(host, ".")
+	if net.ParseIP(host) == nil && !hostnameRegex.MatchString(host) {
+		return fmt.Errorf("invalid container_proxy no_proxy entry %q", entry)
+	}
+	return nil
+}
+
+// env returns the proxy variables in upper and lower case, tools differ
+// in the ones they read
+func (cp *ContainerProxy) env() []string {
+	var env []string
+	add := func(name, value string) {
+		if value != "" {
+			env = append(env, name+"="+value, strings.ToLower(name)+"="+value)
+		}
+	}
+	add("HTTP_PROXY", cp.HTTPProxy)
+	add("HTTPS_PROXY", cp.HTTPSProxy)
+	add("NO_PROXY", strings.Join(cp.NoProxy, ","))
+	return env
+}
+
+// containerProxyFiles returns the containers.conf drop-in for podman
+// and the systemd drop-ins of the services
+func containerProxyFiles(cp *ContainerProxy) ([]*fsnode.File, error) {
+	if cp.HTTPProxy == "" && cp.HTTPSProxy == "" {
+		return nil, fmt.Errorf("container_proxy needs http_proxy or https_proxy")
+	}
+	if cp.HTTPProxy != "" {
+		if err := validateProxyURL("http_proxy", cp.HTTPProxy); err != nil {
+			return nil, err
+		}
+	}
+	if cp.HTTPSProxy != "" {
+		if err := validateProxyURL("https_proxy", cp.HTTPSProxy); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range cp.NoProxy {
+		if err := validateNoProxy(entry); err != nil {
+			return nil, err
+		}
+	}
+	services := cp.Services
+	if len(services) == 0 {
+		services = defaultContainerProxyServices
+	}
+	for _, service := range services {
+		if !serviceNameRegex.MatchString(service) {
+			return nil, fmt.Errorf("invalid container_proxy service %q, expected a unit name like \"podman.service\"", service)
+		}
+	}
+
+	env := cp.env()
+	quoted := make([]string, len(env))
+	for i, kv := range env {
+		quoted[i] = strconv.Quote(kv)
+	}
+	// the env of the engine is used for pulling, it is not passed to
+	// the containers
+	conf, err := fsnode.NewFile(containerProxyConfPath, nil, nil, nil, []byte(fmt.Sprintf("[engine]\nenv = [%s]\n", strings.Join(quoted, ", "))))
+	if err != nil {
+		return nil, err
+	}
+	files := []*fsnode.File{conf}
+
+	var dropIn strings.Builder
+	dropIn.WriteString("[Service]\n")
+	for _, kv := range env {
+		// "%" starts a specifier in unit files
+		fmt.Fprintf(&dropIn, "Environment=%q\n", strings.ReplaceAll(kv, "%", "%%"))
+	}
+	for _, service := range services {
+		f, err := fsnode.NewFile(path.Join("/etc/systemd/system", service+".d", containerProxyDropInName), nil, nil, nil, []byte(dropIn.String()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
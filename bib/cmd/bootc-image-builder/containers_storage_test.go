@@ -0,0 +1,172 @@
+package main_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestContainersStorage(t *testing.T) {
+	for _, imgType := range []string{"qcow2", "iso"} {
+		config := getBaseConfig()
+		config.ImgType = imgType
+		config.Architecture = arch.ARCH_X86_64
+		config.ContainersStorage = "/var/lib/containers/storage"
+		mf, err := main.Manifest(config)
+		require.NoError(t, err)
+
+		sources := mf.GetContainerSourceSpecs()
+		require.NotEmpty(t, sources, imgType)
+		for plName, specs := range sources {
+			for _, spec := range specs {
+				assert.Equal(t, "testempty", spec.Source)
+				require.NotNil(t, spec.ContainersTransport, plName)
+				assert.Equal(t, "containers-storage", *spec.ContainersTransport)
+				require.NotNil(t, spec.StoragePath, plName)
+				assert.Equal(t, "/var/lib/containers/storage", *spec.StoragePath)
+			}
+		}
+	}
+}
+
+func TestManifestContainersStorageUnset(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	for _, specs := range mf.GetContainerSourceSpecs() {
+		for _, spec := range specs {
+			assert.Nil(t, spec.ContainersTransport)
+			assert.Nil(t, spec.StoragePath)
+		}
+	}
+}
+
+func TestValidateContainersStorage(t *testing.T) {
+	storage := t.TempDir()
+	assert.NoError(t, main.ValidateContainersStorage(storage))
+
+	file := filepath.Join(storage, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	assert.EqualError(t, main.ValidateContainersStorage(file), `containers storage "`+file+`" is not a directory`)
+	assert.EqualError(t, main.ValidateContainersStorage(filepath.Join(storage, "missing")), "cannot use containers storage: stat "+filepath.Join(storage, "missing")+": no such file or directory")
+	assert.EqualError(t, main.ValidateContainersStorage("storage"), `containers storage "storage" must be an absolute path`)
+}
+
+func TestLocalImageRef(t *testing.T) {
+	for imgref, expected := range map[string]string{
+		"myimg":                           "localhost/myimg",
+		"myimg:latest":                    "localhost/myimg:latest",
+		"team/myimg:latest":               "localhost/team/myimg:latest",
+		"localhost/myimg:latest":          "localhost/myimg:latest",
+		"quay.io/team/myimg:latest":       "quay.io/team/myimg:latest",
+		"registry:5000/myimg:latest":      "registry:5000/myimg:latest",
+		"localhost:5000/team/myimg:1.0.0": "localhost:5000/team/myimg:1.0.0",
+	} {
+		t.Run(imgref, func(t *testing.T) {
+			ref, err := main.LocalImageRef(imgref)
+			require.NoError(t, err)
+			assert.Equal(t, expected, ref)
+		})
+	}
+}
+
+func TestLocalImageRefErrors(t *testing.T) {
+	for _, imgref := range []string{"", "docker://quay.io/team/myimg", "containers-storage:myimg:latest"} {
+		_, err := main.LocalImageRef(imgref)
+		assert.EqualError(t, err, fmt.Sprintf("--local needs the name of a local image, e.g. my-image:latest, not %q", imgref))
+	}
+}
+
+func manifestConfigFromArgs(t *testing.T, args ...string) (*main.ManifestConfig, error) {
+	rootCmd, err := main.NewRootCmd()
+	require.NoError(t, err)
+	manifestCmd, _, err := rootCmd.Find([]string{"manifest"})
+	require.NoError(t, err)
+	require.NoError(t, manifestCmd.ParseFlags(args))
+	return main.ManifestConfigFromCobra(manifestCmd, manifestCmd.Flags().Args())
+}
+
+func TestManifestConfigLocal(t *testing.T) {
+	storage := t.TempDir()
+	config, err := manifestConfigFromArgs(t, "--local", "--containers-storage", storage, "myimg:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost/myimg:latest", config.Imgref)
+	assert.Equal(t, storage, config.ContainersStorage)
+
+	// the image is resolved from the local storage
+	config.Architecture = arch.ARCH_X86_64
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	sources := mf.GetContainerSourceSpecs()
+	require.NotEmpty(t, sources)
+	for plName, specs := range sources {
+		for _, spec := range specs {
+			assert.Equal(t, "localhost/myimg:latest", spec.Source)
+			require.NotNil(t, spec.ContainersTransport, plName)
+			assert.Equal(t, "containers-storage", *spec.ContainersTransport)
+			require.NotNil(t, spec.StoragePath, plName)
+			assert.Equal(t, storage, *spec.StoragePath)
+		}
+	}
+}
+
+func TestManifestConfigLocalTag(t *testing.T) {
+	storage := t.TempDir()
+	config, err := manifestConfigFromArgs(t, "--local", "--containers-storage", storage, "myimg:dev")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost/myimg:dev", config.Imgref)
+
+	// the tag is kept until the image is looked up in the storage
+	config.Architecture = arch.ARCH_X86_64
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	sources := mf.GetContainerSourceSpecs()
+	require.NotEmpty(t, sources)
+	for _, specs := range sources {
+		for _, spec := range specs {
+			assert.Equal(t, "localhost/myimg:dev", spec.Source)
+			name, err := main.StorageSourceName(spec)
+			require.NoError(t, err)
+			assert.Equal(t, "containers-storage:[overlay@"+storage+"]localhost/myimg:dev", main.ContainersStorageImageName(name, spec.StoragePath))
+		}
+	}
+}
+
+func TestStorageSourceName(t *testing.T) {
+	for source, expected := range map[string]string{
+		"localhost/myimg:dev":    "localhost/myimg:dev",
+		"localhost/myimg:latest": "localhost/myimg:latest",
+		"localhost/myimg":        "localhost/myimg:latest",
+		"quay.io/team/myimg:1.0": "quay.io/team/myimg:1.0",
+	} {
+		name, err := main.StorageSourceName(container.SourceSpec{Source: source})
+		require.NoError(t, err)
+		assert.Equal(t, expected, name)
+	}
+}
+
+func TestManifestConfigLocalDefaultStorage(t *testing.T) {
+	config, err := manifestConfigFromArgs(t, "--local", "myimg:latest")
+	if _, statErr := os.Stat("/var/lib/containers/storage"); statErr != nil {
+		assert.EqualError(t, err, "cannot use containers storage: stat /var/lib/containers/storage: no such file or directory")
+		return
+	}
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/containers/storage", config.ContainersStorage)
+}
+
+func TestManifestConfigNotLocal(t *testing.T) {
+	config, err := manifestConfigFromArgs(t, "myimg:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "myimg:latest", config.Imgref)
+	assert.Equal(t, "", config.ContainersStorage)
+}
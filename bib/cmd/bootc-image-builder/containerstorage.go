@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const containerStorageConfPath = "/etc/containers/storage.conf"
+
+// ContainerStorageCustomization configures the storage of the container
+// runtimes (podman, buildah, cri-o) of the installed system
+type ContainerStorageCustomization struct {
+	// Driver is one of "overlay", "vfs", "btrfs" or "zfs"
+	Driver string `json:"driver"`
+	// MountOptions are the default mount options of the driver, e.g.
+	// "nodev" or "metacopy=on"
+	MountOptions []string `json:"mount_options,omitempty"`
+}
+
+var containerStorageDrivers = []string{"overlay", "vfs", "btrfs", "zfs"}
+
+var containerStorageMountOptionRegex = regexp.MustCompile(`^[A-Za-z0-9_.=/-]+$`)
+
+// containerStorageConfig returns the /etc/containers/storage.conf for
+// the given customization. containers-storage.conf(5) does not merge
+// it with /usr/share/containers/storage.conf, so the default paths are
+// set as well.
+func containerStorageConfig(cs *ContainerStorageCustomization) (*fsnode.File, error) {
+	valid := false
+	for _, driver := range containerStorageDrivers {
+		if cs.Driver == driver {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("unsupported container_storage driver %q, valid values are %q", cs.Driver, containerStorageDrivers)
+	}
+	for _, opt := range cs.MountOptions {
+		if !containerStorageMountOptionRegex.MatchString(opt) {
+			return nil, fmt.Errorf("invalid container_storage mount option %q", opt)
+		}
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, `[storage]
+driver = %q
+runroot = "/run/containers/storage"
+graphroot = "/var/lib/containers/storage"
+`, cs.Driver)
+	if len(cs.MountOptions) > 0 {
+		fmt.Fprintf(&content, "\n[storage.options]\nmountopt = %q\n", strings.Join(cs.MountOptions, ","))
+	}
+	return fsnode.NewFile(containerStorageConfPath, nil, nil, nil, []byte(content.String()))
+}
@@ -0,0 +1,63 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestContainerStorage(t *testing.T) {
+	for name, tc := range map[string]struct {
+		storage  main.ContainerStorageCustomization
+		expected string
+	}{
+		"driver": {
+			main.ContainerStorageCustomization{Driver: "overlay"},
+			"[storage]\ndriver = \"overlay\"\nrunroot = \"/run/containers/storage\"\ngraphroot = \"/var/lib/containers/storage\"\n",
+		},
+		"mount-options": {
+			main.ContainerStorageCustomization{Driver: "overlay", MountOptions: []string{"nodev", "metacopy=on"}},
+			"[storage]\ndriver = \"overlay\"\nrunroot = \"/run/containers/storage\"\ngraphroot = \"/var/lib/containers/storage\"\n\n[storage.options]\nmountopt = \"nodev,metacopy=on\"\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			storage := tc.storage
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{ContainerStorage: &storage}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/containers/storage.conf")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, content)
+		})
+	}
+}
+
+func TestManifestContainerStorageErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		storage main.ContainerStorageCustomization
+		err     string
+	}{
+		"no-driver":  {"qcow2", main.ContainerStorageCustomization{}, `unsupported container_storage driver "", valid values are ["overlay" "vfs" "btrfs" "zfs"]`},
+		"bad-driver": {"qcow2", main.ContainerStorageCustomization{Driver: "aufs"}, `unsupported container_storage driver "aufs", valid values are ["overlay" "vfs" "btrfs" "zfs"]`},
+		"bad-option": {"qcow2", main.ContainerStorageCustomization{Driver: "overlay", MountOptions: []string{"nodev,exec"}}, `invalid container_storage mount option "nodev,exec"`},
+		"iso":        {"iso", main.ContainerStorageCustomization{Driver: "overlay"}, "container_storage not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			storage := tc.storage
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{ContainerStorage: &storage}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// cryptoPolicies are the system wide crypto policies of
+// crypto-policies(7)
+var cryptoPolicies = []string{"DEFAULT", "FUTURE", "LEGACY", "FIPS"}
+
+func validateCryptoPolicy(policy string) error {
+	for _, p := range cryptoPolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid crypto_policy %q, valid values are %s", policy, strings.Join(cryptoPolicies, ", "))
+}
+
+// effectiveCryptoPolicy returns the crypto policy that is set in the
+// image. FIPS mode needs the FIPS policy, so it wins over the
+// configured policy.
+func effectiveCryptoPolicy(policy string, customizations *blueprint.Customizations) string {
+	if policy != "" && customizations.GetFIPS() {
+		return "FIPS"
+	}
+	return policy
+}
+
+// addCryptoPolicy sets the crypto policy of the deployment.
+//
+// TODO: osbuild/images only sets a crypto policy for FIPS
+func addCryptoPolicy(m *serializedManifest, policy string) error {
+	err := m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(options json.RawMessage) ([]*osbuild.Stage, error) {
+		var selinuxOpts osbuild.OSTreeSelinuxStageOptions
+		if err := json.Unmarshal(options, &selinuxOpts); err != nil {
+			return nil, err
+		}
+		deployment := selinuxOpts.Deployment
+
+		stage := osbuild.NewUpdateCryptoPoliciesStage(&osbuild.UpdateCryptoPoliciesStageOptions{Policy: policy})
+		stage.MountOSTree(deployment.OSName, deployment.Ref, 0)
+		return []*osbuild.Stage{stage}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set crypto policy: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/blueprint"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestCryptoPolicy(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{CryptoPolicy: "FUTURE"}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddCryptoPolicy(manifestJson, "FUTURE")
+	require.NoError(t, err)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.update-crypto-policies")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var opts struct {
+		Policy string `json:"policy"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+	assert.Equal(t, "FUTURE", opts.Policy)
+}
+
+func TestManifestCryptoPolicyInvalid(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{CryptoPolicy: "future"}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, `invalid crypto_policy "future", valid values are DEFAULT, FUTURE, LEGACY, FIPS`)
+}
+
+func TestManifestCryptoPolicyISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{CryptoPolicy: "FUTURE"}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "crypto_policy not supported for the iso image type")
+}
+
+func TestEffectiveCryptoPolicy(t *testing.T) {
+	fips := true
+	for name, tc := range map[string]struct {
+		policy         string
+		customizations *blueprint.Customizations
+		expected       string
+	}{
+		"unset":          {"", nil, ""},
+		"unset-fips":     {"", &blueprint.Customizations{FIPS: &fips}, ""},
+		"policy":         {"LEGACY", nil, "LEGACY"},
+		"policy-no-fips": {"LEGACY", &blueprint.Customizations{}, "LEGACY"},
+		"fips-wins":      {"LEGACY", &blueprint.Customizations{FIPS: &fips}, "FIPS"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, main.EffectiveCryptoPolicy(tc.policy, tc.customizations))
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const crypttabPath = "/etc/crypttab"
+
+var (
+	// names of the mapped devices below /dev/mapper
+	crypttabNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+	// options are flags like "discard" or key=value pairs like
+	// "keyfile-timeout=10s"
+	crypttabOptionRegex = regexp.MustCompile(`^[a-z][a-z0-9-]*(=[^\s,=]+)?$`)
+)
+
+// CrypttabEntry is a line of /etc/crypttab for a LUKS device that is
+// provisioned outside of the image, e.g. an encrypted data disk
+type CrypttabEntry struct {
+	// Name is the name of the mapped device, e.g. "data" for
+	// /dev/mapper/data
+	Name string `json:"name"`
+	// UUID is the UUID of the LUKS header of the device
+	UUID string `json:"uuid"`
+	// KeyFile is the absolute path of the key file, the passphrase is
+	// asked for when it is not set
+	KeyFile string `json:"key_file,omitempty"`
+	// Options are the crypttab options, e.g. "discard" or "nofail"
+	Options []string `json:"options,omitempty"`
+}
+
+func (e *CrypttabEntry) line() (string, error) {
+	if !crypttabNameRegex.MatchString(e.Name) {
+		return "", fmt.Errorf("invalid crypttab_entries name %q", e.Name)
+	}
+	if _, err := uuid.Parse(e.UUID); err != nil || len(e.UUID) != 36 {
+		return "", fmt.Errorf("invalid crypttab_entries uuid %q for %s", e.UUID, e.Name)
+	}
+	keyFile := "none"
+	if e.KeyFile != "" {
+		if !filepath.IsAbs(e.KeyFile) || strings.ContainsAny(e.KeyFile, " \t\n") {
+			return "", fmt.Errorf("invalid crypttab_entries key_file %q for %s, expected an absolute path without whitespace", e.KeyFile, e.Name)
+		}
+		keyFile = e.KeyFile
+	}
+	options := "-"
+	if len(e.Options) > 0 {
+		for _, opt := range e.Options {
+			if !crypttabOptionRegex.MatchString(opt) {
+				return "", fmt.Errorf("invalid crypttab_entries option %q for %s", opt, e.Name)
+			}
+		}
+		options = strings.Join(e.Options, ",")
+	}
+	return fmt.Sprintf("%s UUID=%s %s %s", e.Name, strings.ToLower(e.UUID), keyFile, options), nil
+}
+
+// crypttabFile returns /etc/crypttab with the given entries, the devices
+// are opened by systemd-cryptsetup-generator on boot
+func crypttabFile(entries []CrypttabEntry) (*fsnode.File, error) {
+	var content strings.Builder
+	content.WriteString("# written by bootc-image-builder\n")
+	names := make(map[string]bool)
+	for _, e := range entries {
+		if names[e.Name] {
+			return nil, fmt.Errorf("duplicate crypttab_entries name %q", e.Name)
+		}
+		names[e.Name] = true
+		line, err := e.line()
+		if err != nil {
+			return nil, err
+		}
+		content.WriteString(line + "\n")
+	}
+	return fsnode.NewFile(crypttabPath, nil, nil, nil, []byte(content.String()))
+}
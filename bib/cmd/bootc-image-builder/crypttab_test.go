@@ -0,0 +1,65 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestCrypttabEntries(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		CrypttabEntries: []main.CrypttabEntry{
+			{
+				Name: "data",
+				UUID: "6E5A1B2C-3D4E-4F50-8A6B-7C8D9E0F1A2B",
+			},
+			{
+				Name:    "scratch",
+				UUID:    "0f1e2d3c-4b5a-4968-8776-a5b4c3d2e1f0",
+				KeyFile: "/etc/cryptsetup-keys.d/scratch.key",
+				Options: []string{"luks", "discard", "nofail", "keyfile-timeout=10s"},
+			},
+		},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/crypttab")
+	require.NoError(t, err)
+	assert.Equal(t, `# written by bootc-image-builder
+data UUID=6e5a1b2c-3d4e-4f50-8a6b-7c8d9e0f1a2b none -
+scratch UUID=0f1e2d3c-4b5a-4968-8776-a5b4c3d2e1f0 /etc/cryptsetup-keys.d/scratch.key luks,discard,nofail,keyfile-timeout=10s
+`, content)
+}
+
+func TestManifestCrypttabEntriesErrors(t *testing.T) {
+	const validUUID = "6e5a1b2c-3d4e-4f50-8a6b-7c8d9e0f1a2b"
+	for name, tc := range map[string]struct {
+		imgType string
+		entries []main.CrypttabEntry
+		err     string
+	}{
+		"bad-name":     {"qcow2", []main.CrypttabEntry{{Name: "my data", UUID: validUUID}}, `invalid crypttab_entries name "my data"`},
+		"bad-uuid":     {"qcow2", []main.CrypttabEntry{{Name: "data", UUID: "/dev/sdb"}}, `invalid crypttab_entries uuid "/dev/sdb" for data`},
+		"relative-key": {"qcow2", []main.CrypttabEntry{{Name: "data", UUID: validUUID, KeyFile: "data.key"}}, `invalid crypttab_entries key_file "data.key" for data, expected an absolute path without whitespace`},
+		"bad-option":   {"qcow2", []main.CrypttabEntry{{Name: "data", UUID: validUUID, Options: []string{"discard,nofail"}}}, `invalid crypttab_entries option "discard,nofail" for data`},
+		"empty-value":  {"qcow2", []main.CrypttabEntry{{Name: "data", UUID: validUUID, Options: []string{"tries="}}}, `invalid crypttab_entries option "tries=" for data`},
+		"duplicate":    {"qcow2", []main.CrypttabEntry{{Name: "data", UUID: validUUID}, {Name: "data", UUID: validUUID}}, `duplicate crypttab_entries name "data"`},
+		"iso":          {"iso", []main.CrypttabEntry{{Name: "data", UUID: validUUID}}, "crypttab_entries not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{CrypttabEntries: tc.entries}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
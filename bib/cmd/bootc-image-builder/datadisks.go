@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/osbuild/images/pkg/disk"
+	"github.com/osbuild/images/pkg/osbuild"
+	"github.com/osbuild/images/pkg/policies"
+)
+
+// DataDisk is an additional empty disk that is built as a separate
+// image file next to the disk image and mounted via /etc/fstab.
+type DataDisk struct {
+	// Size of the disk, e.g. "20GiB"
+	Size string `json:"size"`
+	// Filesystem is "xfs" (default) or "ext4"
+	Filesystem string `json:"filesystem,omitempty"`
+	Mountpoint string `json:"mountpoint"`
+	Label      string `json:"label,omitempty"`
+	// Options are the mount options of the fstab entry, the disk is
+	// not required for booting by default
+	Options string `json:"options,omitempty"`
+}
+
+// dataDisksPipelineName is the name of the pipeline (and the export)
+// that creates the data disk images
+const dataDisksPipelineName = "data-disks"
+
+const dataDiskDefaultOptions = "defaults,nofail"
+
+// maximum label length of the filesystems that bib creates
+var filesystemLabelMaxLen = map[string]int{
+	"xfs":  12,
+	"ext4": 16,
+}
+
+func dataDiskFilename(idx int) string {
+	return fmt.Sprintf("data-disk-%d.raw", idx)
+}
+
+func (d *DataDisk) filesystem() string {
+	if d.Filesystem == "" {
+		return "xfs"
+	}
+	return d.Filesystem
+}
+
+func (d *DataDisk) options() string {
+	if d.Options == "" {
+		return dataDiskDefaultOptions
+	}
+	return d.Options
+}
+
+// validateDataDisks ensures the data disks can be created and mounted
+// without clashing with each other or with the partition table of the
+// disk image.
+func validateDataDisks(disks []DataDisk, pt *disk.PartitionTable) error {
+	seen := make(map[string]bool)
+	for _, d := range disks {
+		if d.Mountpoint == "" || !filepath.IsAbs(d.Mountpoint) || filepath.Clean(d.Mountpoint) != d.Mountpoint {
+			return fmt.Errorf("data disk mountpoint %q must be a clean absolute path", d.Mountpoint)
+		}
+		mountpoints := []blueprint.FilesystemCustomization{{Mountpoint: d.Mountpoint}}
+		if err := blueprint.CheckMountpointsPolicy(mountpoints, policies.OstreeMountpointPolicies); err != nil {
+			return fmt.Errorf("cannot use data disk mountpoint: %w", err)
+		}
+		if err := blueprint.CheckMountpointsPolicy(mountpoints, policies.MountpointPolicies); err != nil {
+			return fmt.Errorf("cannot use data disk mountpoint: %w", err)
+		}
+		if d.Mountpoint == "/" || pt.FindMountable(d.Mountpoint) != nil {
+			return fmt.Errorf("data disk mountpoint %q clashes with a filesystem of the disk image", d.Mountpoint)
+		}
+		if seen[d.Mountpoint] {
+			return fmt.Errorf("duplicate data disk mountpoint %q", d.Mountpoint)
+		}
+		seen[d.Mountpoint] = true
+
+		size, err := parseDiskSize(d.Size)
+		if err != nil {
+			return fmt.Errorf("cannot use data disk for %q: %w", d.Mountpoint, err)
+		}
+		if size == 0 {
+			return fmt.Errorf("data disk for %q must have a size", d.Mountpoint)
+		}
+		maxLen, ok := filesystemLabelMaxLen[d.filesystem()]
+		if !ok {
+			return fmt.Errorf("unsupported data disk filesystem %q, valid values are \"xfs\" and \"ext4\"", d.Filesystem)
+		}
+		if len(d.Label) > maxLen {
+			return fmt.Errorf("data disk label %q is too long for %s, the maximum is %d characters", d.Label, d.filesystem(), maxLen)
+		}
+		if d.Label != "" && !rootfsLabelRegex.MatchString(d.Label) {
+			return fmt.Errorf("data disk label %q must only contain ASCII letters, digits, '-', '.' and '_'", d.Label)
+		}
+		if !mountOptionsRegex.MatchString(d.options()) {
+			return fmt.Errorf("invalid mount options %q for %q", d.Options, d.Mountpoint)
+		}
+	}
+	return nil
+}
+
+// dataDiskUUIDNamespace is the namespace of the filesystem UUIDs of
+// the data disks that are derived from SOURCE_DATE_EPOCH
+var dataDiskUUIDNamespace = uuid.MustParse("0b8e7f4c-2f5d-4c1e-9a77-6d2c1e5f8a93")
+
+// dataDiskUUID returns the filesystem UUID of the data disk for the
+// given mountpoint. With a SOURCE_DATE_EPOCH it is derived from it and
+// the mountpoint, like the partition GUIDs, so rebuilds have the same
+// UUIDs.
+func dataDiskUUID(epoch, mountpoint string) (uuid.UUID, error) {
+	if epoch == "" {
+		return uuid.NewRandom()
+	}
+	return uuid.NewSHA1(dataDiskUUIDNamespace, []byte(fmt.Sprintf("%s:%s", epoch, mountpoint))), nil
+}
+
+// dataDisksPipeline creates one image file with an empty filesystem
+// per data disk and returns the fstab entries that mount them.
+func dataDisksPipeline(disks []DataDisk, epoch string) (*osbuild.Pipeline, []*osbuild.FSTabEntry, error) {
+	pipeline := &osbuild.Pipeline{
+		Name:  dataDisksPipelineName,
+		Build: "name:build",
+	}
+	var entries []*osbuild.FSTabEntry
+	for idx, d := range disks {
+		size, err := parseDiskSize(d.Size)
+		if err != nil {
+			return nil, nil, err
+		}
+		fsUUID, err := dataDiskUUID(epoch, d.Mountpoint)
+		if err != nil {
+			return nil, nil, err
+		}
+		filename := dataDiskFilename(idx)
+
+		pipeline.AddStage(osbuild.NewTruncateStage(&osbuild.TruncateStageOptions{
+			Filename: filename,
+			Size:     fmt.Sprintf("%d", size),
+		}))
+		devices := map[string]osbuild.Device{
+			"device": *osbuild.NewLoopbackDevice(&osbuild.LoopbackDeviceOptions{Filename: filename}),
+		}
+		switch d.filesystem() {
+		case "xfs":
+			pipeline.AddStage(osbuild.NewMkfsXfsStage(&osbuild.MkfsXfsStageOptions{UUID: fsUUID.String(), Label: d.Label}, devices))
+		case "ext4":
+			pipeline.AddStage(osbuild.NewMkfsExt4Stage(&osbuild.MkfsExt4StageOptions{UUID: fsUUID.String(), Label: d.Label}, devices))
+		default:
+			return nil, nil, fmt.Errorf("unsupported data disk filesystem %q", d.Filesystem)
+		}
+
+		var passNo uint64
+		// xfs is checked when it is mounted
+		if d.filesystem() == "ext4" {
+			passNo = 2
+		}
+		entries = append(entries, &osbuild.FSTabEntry{
+			UUID:    fsUUID.String(),
+			VFSType: d.filesystem(),
+			Path:    d.Mountpoint,
+			Options: d.options(),
+			PassNo:  passNo,
+		})
+	}
+	return pipeline, entries, nil
+}
+
+// addDataDisks adds the pipeline that creates the data disks to the
+// serialized manifest and mounts them via the fstab of the deployment.
+//
+// TODO: osbuild/images cannot add pipelines or fstab entries to the
+// bootc disk image
+func addDataDisks(m *serializedManifest, disks []DataDisk, epoch string) error {
+	pipeline, entries, err := dataDisksPipeline(disks, epoch)
+	if err != nil {
+		return err
+	}
+	err = m.updateStageOptions("ostree-deployment", "org.osbuild.fstab", func(options json.RawMessage) (json.RawMessage, error) {
+		var opts osbuild.FSTabStageOptions
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+		opts.FileSystems = append(opts.FileSystems, entries...)
+		return json.Marshal(opts)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add data disks: %w", err)
+	}
+	return m.appendPipeline(pipeline)
+}
@@ -0,0 +1,186 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func getDataDisksConfig(disks []main.DataDisk) *main.ManifestConfig {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Filesystem: []blueprint.FilesystemCustomization{
+					{Mountpoint: "/var/log", MinSize: main.GibiByte},
+				},
+			},
+		},
+		DataDisks: disks,
+	}
+	return config
+}
+
+func TestManifestDataDisks(t *testing.T) {
+	disks := []main.DataDisk{
+		{Size: "20GiB", Mountpoint: "/var/data", Label: "data"},
+		{Size: "1GiB", Filesystem: "ext4", Mountpoint: "/var/log/archive", Options: "defaults,noexec"},
+	}
+	config := getDataDisksConfig(disks)
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddDataDisks(manifestJson, disks, "")
+	require.NoError(t, err)
+
+	truncateStages, err := findStages(manifestJson, "data-disks", "org.osbuild.truncate")
+	require.NoError(t, err)
+	require.Len(t, truncateStages, 2)
+	assert.JSONEq(t, `{"filename": "data-disk-0.raw", "size": "21474836480"}`, string(truncateStages[0].Options))
+	assert.JSONEq(t, `{"filename": "data-disk-1.raw", "size": "1073741824"}`, string(truncateStages[1].Options))
+
+	var mkfs struct {
+		UUID  string `json:"uuid"`
+		Label string `json:"label"`
+	}
+	xfsStages, err := findStages(manifestJson, "data-disks", "org.osbuild.mkfs.xfs")
+	require.NoError(t, err)
+	require.Len(t, xfsStages, 1)
+	require.NoError(t, json.Unmarshal(xfsStages[0].Options, &mkfs))
+	assert.Equal(t, "data", mkfs.Label)
+	xfsUUID := mkfs.UUID
+	ext4Stages, err := findStages(manifestJson, "data-disks", "org.osbuild.mkfs.ext4")
+	require.NoError(t, err)
+	require.Len(t, ext4Stages, 1)
+	require.NoError(t, json.Unmarshal(ext4Stages[0].Options, &mkfs))
+	ext4UUID := mkfs.UUID
+
+	fstabStages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.fstab")
+	require.NoError(t, err)
+	require.Len(t, fstabStages, 1)
+	var fstab struct {
+		Filesystems []struct {
+			UUID    string `json:"uuid"`
+			VFSType string `json:"vfs_type"`
+			Path    string `json:"path"`
+			Options string `json:"options"`
+		} `json:"filesystems"`
+	}
+	require.NoError(t, json.Unmarshal(fstabStages[0].Options, &fstab))
+	byPath := make(map[string]string)
+	for _, fs := range fstab.Filesystems {
+		byPath[fs.Path] = fs.UUID + " " + fs.VFSType + " " + fs.Options
+	}
+	assert.Equal(t, xfsUUID+" xfs defaults,nofail", byPath["/var/data"])
+	assert.Equal(t, ext4UUID+" ext4 defaults,noexec", byPath["/var/log/archive"])
+	// the filesystems of the disk image are kept
+	assert.Contains(t, byPath, "/")
+	assert.Contains(t, byPath, "/var/log")
+}
+
+func dataDiskUUIDs(t *testing.T, disks []main.DataDisk, epoch string) []string {
+	mf, err := main.Manifest(getDataDisksConfig(disks))
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddDataDisks(manifestJson, disks, epoch)
+	require.NoError(t, err)
+	stages, err := findStages(manifestJson, "data-disks", "org.osbuild.mkfs.xfs")
+	require.NoError(t, err)
+	var uuids []string
+	for _, st := range stages {
+		var mkfs struct {
+			UUID string `json:"uuid"`
+		}
+		require.NoError(t, json.Unmarshal(st.Options, &mkfs))
+		uuids = append(uuids, mkfs.UUID)
+	}
+	return uuids
+}
+
+func TestManifestDataDisksUUIDs(t *testing.T) {
+	disks := []main.DataDisk{
+		{Size: "1GiB", Mountpoint: "/var/data"},
+		{Size: "1GiB", Mountpoint: "/var/cache"},
+	}
+
+	// without SOURCE_DATE_EPOCH every build gets new UUIDs
+	random := dataDiskUUIDs(t, disks, "")
+	require.Len(t, random, 2)
+	assert.NotEqual(t, random[0], random[1])
+	assert.NotEqual(t, random, dataDiskUUIDs(t, disks, ""))
+
+	// with SOURCE_DATE_EPOCH they are derived from it and the mountpoint
+	derived := dataDiskUUIDs(t, disks, "1700000000")
+	require.Len(t, derived, 2)
+	assert.NotEqual(t, derived[0], derived[1])
+	assert.Equal(t, derived, dataDiskUUIDs(t, disks, "1700000000"))
+	assert.NotEqual(t, derived, dataDiskUUIDs(t, disks, "1700000001"))
+	// and do not depend on the order of the disks
+	reversed := dataDiskUUIDs(t, []main.DataDisk{disks[1], disks[0]}, "1700000000")
+	assert.Equal(t, []string{derived[1], derived[0]}, reversed)
+}
+
+func TestManifestDataDisksErrors(t *testing.T) {
+	for _, tc := range []struct {
+		disks  []main.DataDisk
+		expErr string
+	}{
+		{
+			[]main.DataDisk{{Size: "1GiB", Mountpoint: "/var/log"}},
+			`data disk mountpoint "/var/log" clashes with a filesystem of the disk image`,
+		},
+		{
+			[]main.DataDisk{{Size: "1GiB", Mountpoint: "/boot"}},
+			`data disk mountpoint "/boot" clashes with a filesystem of the disk image`,
+		},
+		{
+			[]main.DataDisk{{Size: "1GiB", Mountpoint: "/var/data"}, {Size: "2GiB", Mountpoint: "/var/data"}},
+			`duplicate data disk mountpoint "/var/data"`,
+		},
+		{
+			[]main.DataDisk{{Size: "1GiB", Mountpoint: "var/data"}},
+			`data disk mountpoint "var/data" must be a clean absolute path`,
+		},
+		{
+			[]main.DataDisk{{Size: "1GiB", Mountpoint: "/ostree"}},
+			`cannot use data disk mountpoint: The following custom mountpoints are not supported ["/ostree"]`,
+		},
+		{
+			[]main.DataDisk{{Size: "big", Mountpoint: "/var/data"}},
+			`cannot use data disk for "/var/data": invalid disk size "big", expected a number with an optional unit (MiB, GiB, TiB, MB, GB, TB)`,
+		},
+		{
+			[]main.DataDisk{{Size: "1GiB", Filesystem: "btrfs", Mountpoint: "/var/data"}},
+			`unsupported data disk filesystem "btrfs", valid values are "xfs" and "ext4"`,
+		},
+		{
+			[]main.DataDisk{{Size: "1GiB", Mountpoint: "/var/data", Label: "much-too-long"}},
+			`data disk label "much-too-long" is too long for xfs, the maximum is 12 characters`,
+		},
+		{
+			[]main.DataDisk{{Size: "1GiB", Mountpoint: "/var/data", Label: "data disk"}},
+			`data disk label "data disk" must only contain ASCII letters, digits, '-', '.' and '_'`,
+		},
+	} {
+		_, err := main.Manifest(getDataDisksConfig(tc.disks))
+		assert.EqualError(t, err, tc.expErr)
+	}
+}
+
+func TestManifestDataDisksISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{
+		DataDisks: []main.DataDisk{{Size: "1GiB", Mountpoint: "/var/data"}},
+	}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "data_disks not supported for the iso image type")
+}
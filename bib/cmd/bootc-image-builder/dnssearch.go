@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	dnsSearchDropInPath   = "/etc/systemd/resolved.conf.d/90-bootc-image-builder-dns-search.conf"
+	nmDNSSearchDropInPath = "/etc/NetworkManager/conf.d/90-bootc-image-builder-dns-search.conf"
+)
+
+// a DNS name of letters, digits and hyphens with labels of at most 63
+// characters, a trailing dot is allowed
+var dnsDomainRegex = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.?$`)
+
+// dnsSearchConfig returns the systemd-resolved drop-in that adds the
+// given search domains to the ones NetworkManager gets for each
+// connection, e.g. from DHCP, and the NetworkManager drop-in that
+// writes them to resolv.conf on images without systemd-resolved,
+// e.g. CentOS and RHEL
+func dnsSearchConfig(domains []string) ([]*fsnode.File, error) {
+	for _, domain := range domains {
+		if len(domain) > 253 || !dnsDomainRegex.MatchString(domain) {
+			return nil, fmt.Errorf("invalid dns_search domain %q", domain)
+		}
+	}
+	resolved, err := fsnode.NewFile(dnsSearchDropInPath, nil, nil, nil, []byte(fmt.Sprintf("[Resolve]\nDomains=%s\n", strings.Join(domains, " "))))
+	if err != nil {
+		return nil, err
+	}
+	nm, err := fsnode.NewFile(nmDNSSearchDropInPath, nil, nil, nil, []byte(fmt.Sprintf("[global-dns]\nsearches=%s\n", strings.Join(domains, ","))))
+	if err != nil {
+		return nil, err
+	}
+	return []*fsnode.File{resolved, nm}, nil
+}
@@ -0,0 +1,55 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestDNSSearch(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{DNSSearch: []string{"corp.example.com", "lab.example.com."}}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/resolved.conf.d/90-bootc-image-builder-dns-search.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "[Resolve]\nDomains=corp.example.com lab.example.com.\n", content)
+
+	content, err = findFileContent(manifestJson, "ostree-deployment", "/etc/NetworkManager/conf.d/90-bootc-image-builder-dns-search.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "[global-dns]\nsearches=corp.example.com,lab.example.com.\n", content)
+}
+
+func TestManifestDNSSearchErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		domains []string
+		nm      *main.NetworkManager
+		err     string
+	}{
+		"empty":       {"qcow2", []string{""}, nil, `invalid dns_search domain ""`},
+		"underscore":  {"qcow2", []string{"corp_example.com"}, nil, `invalid dns_search domain "corp_example.com"`},
+		"space":       {"qcow2", []string{"corp.example.com lab.example.com"}, nil, `invalid dns_search domain "corp.example.com lab.example.com"`},
+		"hyphen":      {"qcow2", []string{"-corp.example.com"}, nil, `invalid dns_search domain "-corp.example.com"`},
+		"empty-label": {"qcow2", []string{"corp..example.com"}, nil, `invalid dns_search domain "corp..example.com"`},
+		"long-label":  {"qcow2", []string{strings.Repeat("a", 64) + ".com"}, nil, `invalid dns_search domain "` + strings.Repeat("a", 64) + `.com"`},
+		"global-dns":  {"qcow2", []string{"corp.example.com"}, &main.NetworkManager{GlobalDNS: &main.NMGlobalDNS{Servers: []string{"192.0.2.53"}}}, "dns_search cannot be used with network_manager global_dns, use its searches instead"},
+		"iso":         {"iso", []string{"corp.example.com"}, nil, "dns_search not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{DNSSearch: tc.domains, NetworkManager: tc.nm}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/osbuild/bootc-image-builder/bib/internal/setup"
+)
+
+// doctorMinFreeSpace is the free space the store needs for a build of
+// a disk image with the default size
+const doctorMinFreeSpace = 2 * DEFAULT_SIZE
+
+// doctorCheck is a single check of the "doctor" command. A failing
+// required check means builds cannot work, a failing optional check
+// is only a warning.
+type doctorCheck struct {
+	Name     string
+	Required bool
+	Run      func() error
+}
+
+var (
+	execLookPath = exec.LookPath
+	unixStatfs   = unix.Statfs
+)
+
+// checkTools ensures the given tools are in $PATH
+func checkTools(tools ...string) error {
+	for _, tool := range tools {
+		if _, err := execLookPath(tool); err != nil {
+			return fmt.Errorf("%s not found", tool)
+		}
+	}
+	return nil
+}
+
+// checkCharDevice ensures the given path is a character device
+func checkCharDevice(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("%s is not a character device", path)
+	}
+	return nil
+}
+
+// checkFreeSpace ensures the filesystem of the given path has at least
+// the given number of bytes available
+func checkFreeSpace(path string, required uint64) error {
+	var st unix.Statfs_t
+	if err := unixStatfs(path, &st); err != nil {
+		return err
+	}
+	// the types of the fields differ between architectures
+	free := uint64(st.Bavail) * uint64(st.Bsize)
+	if free < required {
+		return fmt.Errorf("%s has %d MiB free, at least %d MiB are needed", path, free/MebiByte, required/MebiByte)
+	}
+	return nil
+}
+
+// checkCanChown ensures files in the given directory can be chowned,
+// otherwise osbuild cannot keep the ownership of exported files
+func checkCanChown(path string) error {
+	canChown, err := canChownInPath(path)
+	if err != nil {
+		return err
+	}
+	if !canChown {
+		return fmt.Errorf("cannot change the ownership of files in %s, exported files are owned by the current user", path)
+	}
+	return nil
+}
+
+func doctorChecks(outputDir, storeDir string) []doctorCheck {
+	return []doctorCheck{
+		{"privileged rootful container", true, setup.Validate},
+		{"osbuild and container tools", true, func() error { return checkTools("osbuild", "skopeo") }},
+		{"loop devices", true, func() error { return checkCharDevice("/dev/loop-control") }},
+		{"free space in " + storeDir, true, func() error { return checkFreeSpace(storeDir, doctorMinFreeSpace) }},
+		{"ownership in " + outputDir, false, func() error { return checkCanChown(outputDir) }},
+		// bib does not need KVM, it is only used to test the image
+		{"kvm", false, func() error { return checkCharDevice("/dev/kvm") }},
+	}
+}
+
+// runDoctor runs all checks and prints the result of each one. It
+// fails if any of the required checks failed.
+func runDoctor(w io.Writer, checks []doctorCheck) error {
+	failed := 0
+	for _, check := range checks {
+		err := check.Run()
+		switch {
+		case err == nil:
+			fmt.Fprintf(w, "PASS %s\n", check.Name)
+		case check.Required:
+			fmt.Fprintf(w, "FAIL %s: %s\n", check.Name, err)
+			failed++
+		default:
+			fmt.Fprintf(w, "WARN %s: %s\n", check.Name, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d required checks failed", failed, countRequired(checks))
+	}
+	return nil
+}
+
+func countRequired(checks []doctorCheck) int {
+	n := 0
+	for _, check := range checks {
+		if check.Required {
+			n++
+		}
+	}
+	return n
+}
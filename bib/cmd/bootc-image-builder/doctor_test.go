@@ -0,0 +1,67 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestRunDoctor(t *testing.T) {
+	checks := []main.DoctorCheck{
+		{Name: "good", Required: true, Run: func() error { return nil }},
+		{Name: "optional", Required: false, Run: func() error { return fmt.Errorf("not there") }},
+	}
+	var buf bytes.Buffer
+	err := main.RunDoctor(&buf, checks)
+	assert.NoError(t, err)
+	assert.Equal(t, "PASS good\nWARN optional: not there\n", buf.String())
+
+	checks = append(checks, main.DoctorCheck{Name: "bad", Required: true, Run: func() error { return fmt.Errorf("broken") }})
+	buf.Reset()
+	err = main.RunDoctor(&buf, checks)
+	assert.EqualError(t, err, "1 of 2 required checks failed")
+	assert.Equal(t, "PASS good\nWARN optional: not there\nFAIL bad: broken\n", buf.String())
+}
+
+func TestCheckTools(t *testing.T) {
+	restore := main.MockExecLookPath(func(name string) (string, error) {
+		if name == "skopeo" {
+			return "", fmt.Errorf("not found")
+		}
+		return "/usr/bin/" + name, nil
+	})
+	defer restore()
+
+	assert.NoError(t, main.CheckTools("osbuild"))
+	assert.EqualError(t, main.CheckTools("osbuild", "skopeo"), "skopeo not found")
+}
+
+func TestCheckCharDevice(t *testing.T) {
+	assert.NoError(t, main.CheckCharDevice("/dev/null"))
+
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	assert.EqualError(t, main.CheckCharDevice(path), path+" is not a character device")
+
+	assert.ErrorIs(t, main.CheckCharDevice(filepath.Join(t.TempDir(), "missing")), os.ErrNotExist)
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	restore := main.MockUnixStatfs(func(path string, st *unix.Statfs_t) error {
+		st.Bavail = 1024
+		st.Bsize = 1024 * 1024
+		return nil
+	})
+	defer restore()
+
+	assert.NoError(t, main.CheckFreeSpace("/store", main.GibiByte))
+	assert.EqualError(t, main.CheckFreeSpace("/store", 2*main.GibiByte), "/store has 1024 MiB free, at least 2048 MiB are needed")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// defaultEFIVendor is the vendor directory of the ESP, the same as the
+// UEFI vendor of the disk image platforms
+const defaultEFIVendor = "fedora"
+
+var efiVendorRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// efiFallbackPaths returns the path of shim below the vendor directory
+// of the ESP and the removable media path that firmware boots when it
+// has no boot entries
+func efiFallbackPaths(a arch.Arch, vendor string) (string, string, error) {
+	if !efiVendorRegex.MatchString(vendor) {
+		return "", "", fmt.Errorf("invalid efi_vendor %q", vendor)
+	}
+	var suffix string
+	switch a {
+	case arch.ARCH_X86_64:
+		suffix = "x64"
+	case arch.ARCH_AARCH64:
+		suffix = "aa64"
+	default:
+		return "", "", fmt.Errorf("efi_fallback needs UEFI, which is not available on %s", a)
+	}
+	return fmt.Sprintf("EFI/%s/shim%s.efi", vendor, suffix), fmt.Sprintf("EFI/BOOT/BOOT%s.EFI", strings.ToUpper(suffix)), nil
+}
+
+// efiVendor returns the vendor directory of the ESP
+func (c *BuildConfig) efiVendor() string {
+	if c.EFIVendor == "" {
+		return defaultEFIVendor
+	}
+	return c.EFIVendor
+}
+
+// addEFIFallback copies shim from the vendor directory of the ESP,
+// where bootupd installed it, to the removable media path so firmware
+// that only boots that path finds it.
+//
+// TODO: osbuild/images leaves the ESP to bootupd
+func addEFIFallback(m *serializedManifest, a arch.Arch, vendor string) error {
+	shim, fallback, err := efiFallbackPaths(a, vendor)
+	if err != nil {
+		return err
+	}
+	err = m.appendStages(espDiskPipelineName, func(stages []map[string]json.RawMessage) ([]*osbuild.Stage, error) {
+		diskESP, _, err := findESP(stages)
+		if err != nil {
+			return nil, err
+		}
+		devices := map[string]osbuild.Device{
+			"esp": *osbuild.NewLoopbackDevice(diskESP),
+		}
+		mounts := []osbuild.Mount{
+			*osbuild.NewFATMount("esp", "esp", "/esp"),
+		}
+		mkdir := osbuild.NewMkdirStage(&osbuild.MkdirStageOptions{
+			Paths: []osbuild.MkdirStagePath{
+				{Path: "mount://esp/EFI/BOOT", Parents: true, ExistOk: true},
+			},
+		})
+		mkdir.Devices = devices
+		mkdir.Mounts = mounts
+		cp := osbuild.NewCopyStage(&osbuild.CopyStageOptions{
+			Paths: []osbuild.CopyStagePath{
+				{From: "mount://esp/" + shim, To: "mount://esp/" + fallback},
+			},
+		}, nil, devices, mounts)
+		return []*osbuild.Stage{mkdir, cp}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add the EFI fallback bootloader: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/arch"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestEFIFallback(t *testing.T) {
+	for name, tc := range map[string]struct {
+		arch     arch.Arch
+		vendor   string
+		shim     string
+		fallback string
+	}{
+		"x86_64":         {arch.ARCH_X86_64, "", "mount://esp/EFI/fedora/shimx64.efi", "mount://esp/EFI/BOOT/BOOTX64.EFI"},
+		"aarch64-centos": {arch.ARCH_AARCH64, "centos", "mount://esp/EFI/centos/shimaa64.efi", "mount://esp/EFI/BOOT/BOOTAA64.EFI"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Architecture = tc.arch
+			config.Config = &main.BuildConfig{EFIFallback: true, EFIVendor: tc.vendor}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+			vendor := tc.vendor
+			if vendor == "" {
+				vendor = "fedora"
+			}
+			manifestJson, err = main.AddEFIFallback(manifestJson, tc.arch, vendor)
+			require.NoError(t, err)
+
+			var mfs testManifest
+			require.NoError(t, json.Unmarshal(manifestJson, &mfs))
+			var imageStages []stage
+			for _, pl := range mfs.Pipelines {
+				if pl.Name == "image" {
+					imageStages = pl.Stages
+				}
+			}
+
+			// the fallback path is populated on the ESP of the disk
+			require.GreaterOrEqual(t, len(imageStages), 2)
+			added := imageStages[len(imageStages)-2:]
+			assert.Equal(t, "org.osbuild.mkdir", added[0].Type)
+			assert.JSONEq(t, `{"paths": [{"path": "mount://esp/EFI/BOOT", "parents": true, "exist_ok": true}]}`, string(added[0].Options))
+			assert.Equal(t, "org.osbuild.copy", added[1].Type)
+			var copyOpts struct {
+				Paths []struct {
+					From string `json:"from"`
+					To   string `json:"to"`
+				} `json:"paths"`
+			}
+			require.NoError(t, json.Unmarshal(added[1].Options, &copyOpts))
+			require.Len(t, copyOpts.Paths, 1)
+			assert.Equal(t, tc.shim, copyOpts.Paths[0].From)
+			assert.Equal(t, tc.fallback, copyOpts.Paths[0].To)
+			for _, st := range added {
+				assert.JSONEq(t, `[{"name": "esp", "type": "org.osbuild.fat", "source": "esp", "target": "/esp"}]`, string(st.Mounts))
+			}
+		})
+	}
+}
+
+func TestManifestEFIFallbackErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		arch    arch.Arch
+		config  main.BuildConfig
+		err     string
+	}{
+		"ppc64le":     {"qcow2", arch.ARCH_PPC64LE, main.BuildConfig{EFIFallback: true}, "efi_fallback needs UEFI, which is not available on ppc64le"},
+		"bad-vendor":  {"qcow2", arch.ARCH_X86_64, main.BuildConfig{EFIFallback: true, EFIVendor: "../fedora"}, `invalid efi_vendor "../fedora"`},
+		"vendor-only": {"qcow2", arch.ARCH_X86_64, main.BuildConfig{EFIVendor: "centos"}, "efi_vendor is only used with efi_fallback"},
+		"iso":         {"iso", arch.ARCH_X86_64, main.BuildConfig{EFIFallback: true}, "efi_fallback not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Architecture = tc.arch
+			config.Config = &tc.config
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// EmbeddedRepo is a local RPM repository that is copied into the image,
+// e.g. for package layering on hosts without network access.
+type EmbeddedRepo struct {
+	// ID is the id of the repository in its .repo file
+	ID string `json:"id"`
+	// Name is the name of the repository, it defaults to ID
+	Name string `json:"name,omitempty"`
+	// Source is a directory with the RPMs and the repodata created
+	// by createrepo_c, it must be available in the container of
+	// bootc-image-builder, e.g. as a volume
+	Source string `json:"source"`
+	// Destination is the path of the repository in the image, it
+	// defaults to /usr/local/share/bootc-image-builder/repos/<id>
+	Destination string `json:"destination,omitempty"`
+	// GPGCheck enables the signature check of the packages
+	GPGCheck bool `json:"gpgcheck,omitempty"`
+}
+
+// repo ids are used as file names and as section names of .repo files
+var embeddedRepoIDRegex = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+const embeddedRepoDefaultRoot = "/usr/local/share/bootc-image-builder/repos"
+
+func (r *EmbeddedRepo) destination() string {
+	if r.Destination == "" {
+		return path.Join(embeddedRepoDefaultRoot, r.ID)
+	}
+	return r.Destination
+}
+
+// deploymentPath returns the path that the repository is written to,
+// see OverlayPath.deploymentPath()
+func (r *EmbeddedRepo) deploymentPath() string {
+	p := OverlayPath{Source: r.destination()}
+	return p.deploymentPath()
+}
+
+// sourceFiles returns the paths of all regular files of the
+// repository, relative to its source directory
+func (r *EmbeddedRepo) sourceFiles() ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(r.Source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.Source, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read embedded_repo %q: %w", r.ID, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func validateEmbeddedRepo(r *EmbeddedRepo) error {
+	if r == nil {
+		return nil
+	}
+	if !embeddedRepoIDRegex.MatchString(r.ID) {
+		return fmt.Errorf("invalid embedded_repo id %q, must only contain letters, digits and '_.:-'", r.ID)
+	}
+	if !isCleanAbsPath(r.Source) {
+		return fmt.Errorf("embedded_repo source %q must be a clean absolute path", r.Source)
+	}
+	dst := r.destination()
+	if !isCleanAbsPath(dst) {
+		return fmt.Errorf("embedded_repo destination %q must be a clean absolute path", dst)
+	}
+	allowed := false
+	for _, root := range overlayAllowedRoots {
+		if strings.HasPrefix(dst, root+"/") {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("embedded_repo destination %q is not allowed, it must be below %s", dst, strings.Join(overlayAllowedRoots, " or "))
+	}
+
+	files, err := r.sourceFiles()
+	if err != nil {
+		return err
+	}
+	var hasRepomd, hasRPMs bool
+	for _, f := range files {
+		switch {
+		case f == "repodata/repomd.xml":
+			hasRepomd = true
+		case strings.HasSuffix(f, ".rpm"):
+			hasRPMs = true
+		}
+	}
+	if !hasRPMs {
+		return fmt.Errorf("embedded_repo source %q contains no RPMs", r.Source)
+	}
+	if !hasRepomd {
+		return fmt.Errorf("embedded_repo source %q has no repodata, create it with createrepo_c", r.Source)
+	}
+	return nil
+}
+
+// embeddedRepoFile returns the .repo file of the repository. The
+// baseurl is the destination as the system sees it.
+func embeddedRepoFile(r *EmbeddedRepo) (*fsnode.File, error) {
+	name := r.Name
+	if name == "" {
+		name = r.ID
+	}
+	gpgcheck := 0
+	if r.GPGCheck {
+		gpgcheck = 1
+	}
+	content := fmt.Sprintf("[%s]\nname=%s\nbaseurl=file://%s\nenabled=1\ngpgcheck=%d\n", r.ID, name, r.destination(), gpgcheck)
+	return fsnode.NewFile(path.Join("/etc/yum.repos.d", r.ID+".repo"), nil, nil, nil, []byte(content))
+}
+
+// addEmbeddedRepo copies the files of the repository into the
+// deployment, before it is relabeled. The files are fetched by osbuild
+// from the source directory instead of being inlined into the
+// manifest as the RPMs can be large.
+//
+// TODO: osbuild/images cannot add local files by reference to the
+// bootc disk image
+func addEmbeddedRepo(m *serializedManifest, r *EmbeddedRepo) error {
+	files, err := r.sourceFiles()
+	if err != nil {
+		return err
+	}
+	checksums := make(map[string]string, len(files))
+	for _, f := range files {
+		sum, err := sha256File(filepath.Join(r.Source, filepath.FromSlash(f)))
+		if err != nil {
+			return fmt.Errorf("cannot read embedded_repo %q: %w", r.ID, err)
+		}
+		checksums[f] = sum
+	}
+
+	err = m.updateSource("org.osbuild.curl", func(source json.RawMessage) (json.RawMessage, error) {
+		curl := osbuild.NewCurlSource()
+		if source != nil {
+			if err := json.Unmarshal(source, curl); err != nil {
+				return nil, err
+			}
+		}
+		for _, f := range files {
+			curl.Items["sha256:"+checksums[f]] = osbuild.URL("file://" + path.Join(r.Source, f))
+		}
+		return json.Marshal(curl)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add embedded_repo: %w", err)
+	}
+
+	err = m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(options json.RawMessage) ([]*osbuild.Stage, error) {
+		var selinuxOpts osbuild.OSTreeSelinuxStageOptions
+		if err := json.Unmarshal(options, &selinuxOpts); err != nil {
+			return nil, err
+		}
+		deployment := selinuxOpts.Deployment
+
+		dst := r.deploymentPath()
+		dirs := map[string]bool{dst: true}
+		var mkdirPaths []osbuild.MkdirStagePath
+		var copyPaths []osbuild.CopyStagePath
+		copyInputs := make(osbuild.CopyStageFilesInputs)
+		for _, f := range files {
+			dirs[path.Dir(path.Join(dst, f))] = true
+			inputName := fmt.Sprintf("file-%s", checksums[f])
+			copyPaths = append(copyPaths, osbuild.CopyStagePath{
+				From: fmt.Sprintf("input://%s/sha256:%s", inputName, checksums[f]),
+				To:   "tree://" + path.Join(dst, f),
+			})
+			copyInputs[inputName] = osbuild.NewFilesInput(osbuild.NewFilesInputSourceArrayRef([]osbuild.FilesInputSourceArrayRefEntry{
+				osbuild.NewFilesInputSourceArrayRefEntry("sha256:"+checksums[f], nil),
+			}))
+		}
+		sortedDirs := make([]string, 0, len(dirs))
+		for dir := range dirs {
+			sortedDirs = append(sortedDirs, dir)
+		}
+		sort.Strings(sortedDirs)
+		for _, dir := range sortedDirs {
+			mkdirPaths = append(mkdirPaths, osbuild.MkdirStagePath{
+				Path:    dir,
+				Parents: true,
+				ExistOk: true,
+			})
+		}
+
+		mkdir := osbuild.NewMkdirStage(&osbuild.MkdirStageOptions{Paths: mkdirPaths})
+		mkdir.MountOSTree(deployment.OSName, deployment.Ref, 0)
+		cp := osbuild.NewCopyStageSimple(&osbuild.CopyStageOptions{Paths: copyPaths}, &copyInputs)
+		cp.MountOSTree(deployment.OSName, deployment.Ref, 0)
+		return []*osbuild.Stage{mkdir, cp}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add embedded_repo: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+// makeEmbeddedRepoSource creates a repository directory with the given
+// files, relative to the directory
+func makeEmbeddedRepoSource(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestManifestEmbeddedRepo(t *testing.T) {
+	source := makeEmbeddedRepoSource(t, map[string]string{
+		"hello-1.0-1.x86_64.rpm":  "hello\n",
+		"repodata/repomd.xml":     "world\n",
+		"repodata/primary.xml.gz": "primary\n",
+	})
+	repo := &main.EmbeddedRepo{ID: "offline", Name: "Offline packages", Source: source}
+
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{EmbeddedRepo: repo}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddEmbeddedRepo(manifestJson, repo)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/yum.repos.d/offline.repo")
+	require.NoError(t, err)
+	assert.Equal(t, `[offline]
+name=Offline packages
+baseurl=file:///usr/local/share/bootc-image-builder/repos/offline
+enabled=1
+gpgcheck=0
+`, content)
+
+	// the files are fetched from the source directory
+	var sources struct {
+		Sources struct {
+			Curl struct {
+				Items map[string]string `json:"items"`
+			} `json:"org.osbuild.curl"`
+		} `json:"sources"`
+	}
+	require.NoError(t, json.Unmarshal(manifestJson, &sources))
+	assert.Equal(t, map[string]string{
+		"sha256:5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03": "file://" + source + "/hello-1.0-1.x86_64.rpm",
+		"sha256:e258d248fda94c63753607f7c4494ee0fcbe92f1a76bfdac795c9d84101eb317": "file://" + source + "/repodata/repomd.xml",
+		"sha256:a06ac4d8f2c389dc0f919b6ba2a809324c0d3e368741ec210be34db8179eebb7": "file://" + source + "/repodata/primary.xml.gz",
+	}, sources.Sources.Curl.Items)
+
+	// and copied into the deployment before it is relabeled
+	var mfs testManifest
+	require.NoError(t, json.Unmarshal(manifestJson, &mfs))
+	var stageTypes []string
+	var copyOptions json.RawMessage
+	for _, pl := range mfs.Pipelines {
+		if pl.Name != "ostree-deployment" {
+			continue
+		}
+		for _, st := range pl.Stages {
+			stageTypes = append(stageTypes, st.Type)
+			if st.Type == "org.osbuild.copy" {
+				copyOptions = st.Options
+			}
+		}
+	}
+	require.GreaterOrEqual(t, len(stageTypes), 3)
+	assert.Equal(t, []string{"org.osbuild.mkdir", "org.osbuild.copy", "org.osbuild.ostree.selinux"}, stageTypes[len(stageTypes)-3:])
+	assert.JSONEq(t, `{
+		"paths": [
+			{
+				"from": "input://file-5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03/sha256:5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+				"to": "tree:///var/usrlocal/share/bootc-image-builder/repos/offline/hello-1.0-1.x86_64.rpm"
+			},
+			{
+				"from": "input://file-a06ac4d8f2c389dc0f919b6ba2a809324c0d3e368741ec210be34db8179eebb7/sha256:a06ac4d8f2c389dc0f919b6ba2a809324c0d3e368741ec210be34db8179eebb7",
+				"to": "tree:///var/usrlocal/share/bootc-image-builder/repos/offline/repodata/primary.xml.gz"
+			},
+			{
+				"from": "input://file-e258d248fda94c63753607f7c4494ee0fcbe92f1a76bfdac795c9d84101eb317/sha256:e258d248fda94c63753607f7c4494ee0fcbe92f1a76bfdac795c9d84101eb317",
+				"to": "tree:///var/usrlocal/share/bootc-image-builder/repos/offline/repodata/repomd.xml"
+			}
+		]
+	}`, string(copyOptions))
+}
+
+func TestManifestEmbeddedRepoErrors(t *testing.T) {
+	validSource := makeEmbeddedRepoSource(t, map[string]string{
+		"hello-1.0-1.x86_64.rpm": "hello\n",
+		"repodata/repomd.xml":    "world\n",
+	})
+	for name, tc := range map[string]struct {
+		repo   main.EmbeddedRepo
+		expErr string
+	}{
+		"bad-id": {
+			main.EmbeddedRepo{ID: "off line", Source: validSource},
+			`invalid embedded_repo id "off line", must only contain letters, digits and '_.:-'`,
+		},
+		"relative-source": {
+			main.EmbeddedRepo{ID: "offline", Source: "repo"},
+			`embedded_repo source "repo" must be a clean absolute path`,
+		},
+		"bad-destination": {
+			main.EmbeddedRepo{ID: "offline", Source: validSource, Destination: "/usr/share/repo"},
+			`embedded_repo destination "/usr/share/repo" is not allowed, it must be below /etc or /usr/local`,
+		},
+		"missing-source": {
+			main.EmbeddedRepo{ID: "offline", Source: "/does/not/exist"},
+			`cannot read embedded_repo "offline": lstat /does/not/exist: no such file or directory`,
+		},
+		"no-rpms": {
+			main.EmbeddedRepo{ID: "offline", Source: makeEmbeddedRepoSource(t, map[string]string{"repodata/repomd.xml": "world\n"})},
+			"contains no RPMs",
+		},
+		"no-repodata": {
+			main.EmbeddedRepo{ID: "offline", Source: makeEmbeddedRepoSource(t, map[string]string{"hello-1.0-1.x86_64.rpm": "hello\n"})},
+			"has no repodata, create it with createrepo_c",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{EmbeddedRepo: &tc.repo}
+			_, err := main.Manifest(config)
+			assert.ErrorContains(t, err, tc.expErr)
+		})
+	}
+}
+
+func TestManifestEmbeddedRepoISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{EmbeddedRepo: &main.EmbeddedRepo{ID: "offline", Source: "/repo"}}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "embedded_repo not supported for the iso image type")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/manifest"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+const (
+	// espPipelineName is the name of the pipeline (and the export)
+	// with the image of the EFI system partition
+	espPipelineName = "esp"
+	espFilename     = "esp.img"
+
+	espMountpoint = "/boot/efi"
+	// the pipeline of the raw disk image that the ESP is taken from
+	espDiskPipelineName = "image"
+)
+
+// registered in init() as the disk size of the image types refers to
+// the experimental image types
+func init() {
+	experimentalImageTypes["esp"] = experimentalImageType{
+		Info:     diskImageType("esp"),
+		Export:   espPipelineName,
+		Manifest: manifestForESP,
+		Finish:   addESP,
+	}
+}
+
+// manifestForESP returns the manifest of the raw disk image, the ESP
+// is taken from it by addESP once the manifest is serialized. The
+// bootloader of the ESP is installed by bootupd, only the disk image
+// has everything that bootupd needs.
+func manifestForESP(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest, error) {
+	switch c.Architecture {
+	case arch.ARCH_X86_64, arch.ARCH_AARCH64:
+	default:
+		return nil, fmt.Errorf("image type %q needs UEFI, which is not available on %s", c.ImgType, c.Architecture)
+	}
+	rawConfig := *c
+	rawConfig.ImgType = "raw"
+	return manifestForDiskImage(&rawConfig, rng)
+}
+
+// rawLoopbackDevice is a serialized org.osbuild.loopback device
+type rawLoopbackDevice struct {
+	Type    string                        `json:"type"`
+	Options osbuild.LoopbackDeviceOptions `json:"options"`
+}
+
+// rawStageMount is a serialized mount of a stage
+type rawStageMount struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// findESP returns the loopback device of the ESP of the disk image and
+// the options of the mkfs.fat stage that created it
+func findESP(stages []map[string]json.RawMessage) (*osbuild.LoopbackDeviceOptions, *osbuild.MkfsFATStageOptions, error) {
+	var mkfsOpts *osbuild.MkfsFATStageOptions
+	for _, st := range stages {
+		var typ string
+		if err := json.Unmarshal(st["type"], &typ); err != nil {
+			return nil, nil, err
+		}
+		if typ == "org.osbuild.mkfs.fat" && mkfsOpts == nil {
+			mkfsOpts = &osbuild.MkfsFATStageOptions{}
+			if err := json.Unmarshal(st["options"], mkfsOpts); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if st["mounts"] == nil || mkfsOpts == nil {
+			continue
+		}
+
+		var mounts []rawStageMount
+		if err := json.Unmarshal(st["mounts"], &mounts); err != nil {
+			return nil, nil, err
+		}
+		for _, mnt := range mounts {
+			if mnt.Type != "org.osbuild.fat" || mnt.Target != espMountpoint {
+				continue
+			}
+			var devices map[string]rawLoopbackDevice
+			if err := json.Unmarshal(st["devices"], &devices); err != nil {
+				return nil, nil, err
+			}
+			dev, ok := devices[mnt.Source]
+			if !ok || dev.Type != "org.osbuild.loopback" {
+				return nil, nil, fmt.Errorf("the ESP is not on a loopback device")
+			}
+			return &dev.Options, mkfsOpts, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no ESP found in pipeline %q", espDiskPipelineName)
+}
+
+// addESP copies the ESP of the disk image, with the bootloader that
+// bootupd installed, into a FAT image of the same size in the disk
+// image pipeline. Devices are only available for the tree of a
+// pipeline, so this cannot happen in the esp pipeline, it only copies
+// the image from there.
+//
+// TODO: osbuild/images has no ESP image type
+func addESP(m *serializedManifest) error {
+	err := m.appendStages(espDiskPipelineName, func(stages []map[string]json.RawMessage) ([]*osbuild.Stage, error) {
+		diskESP, mkfsOpts, err := findESP(stages)
+		if err != nil {
+			return nil, err
+		}
+		sectorSize := uint64(512)
+		if diskESP.SectorSize != nil {
+			sectorSize = *diskESP.SectorSize
+		}
+
+		truncate := osbuild.NewTruncateStage(&osbuild.TruncateStageOptions{
+			Filename: espFilename,
+			Size:     fmt.Sprintf("%d", diskESP.Size*sectorSize),
+		})
+		espDevice := osbuild.NewLoopbackDevice(&osbuild.LoopbackDeviceOptions{
+			Filename: espFilename,
+			Lock:     true,
+		})
+		// same volume id as the ESP of the disk image
+		mkfs := osbuild.NewMkfsFATStage(mkfsOpts, map[string]osbuild.Device{"device": *espDevice})
+
+		devices := map[string]osbuild.Device{
+			"disk-esp": *osbuild.NewLoopbackDevice(diskESP),
+			"esp":      *espDevice,
+		}
+		mounts := []osbuild.Mount{
+			*osbuild.NewFATMount("disk-esp", "disk-esp", "/disk-esp"),
+			*osbuild.NewFATMount("esp", "esp", "/esp"),
+		}
+		cp := osbuild.NewCopyStage(&osbuild.CopyStageOptions{
+			Paths: []osbuild.CopyStagePath{
+				{From: "mount://disk-esp/", To: "mount://esp/"},
+			},
+		}, nil, devices, mounts)
+		return []*osbuild.Stage{truncate, mkfs, cp}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add esp: %w", err)
+	}
+
+	pipeline := &osbuild.Pipeline{
+		Name:  espPipelineName,
+		Build: "name:build",
+	}
+	pipeline.AddStage(osbuild.NewCopyStageSimple(&osbuild.CopyStageOptions{
+		Paths: []osbuild.CopyStagePath{
+			{
+				From: fmt.Sprintf("input://%s/%s", espDiskPipelineName, espFilename),
+				To:   "tree:///" + espFilename,
+			},
+		},
+	}, osbuild.NewPipelineTreeInputs(espDiskPipelineName, espDiskPipelineName)))
+	return m.appendPipeline(pipeline)
+}
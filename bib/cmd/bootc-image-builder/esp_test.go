@@ -0,0 +1,67 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestESP(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "esp"
+	config.AllowExperimental = true
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddESP(manifestJson)
+	require.NoError(t, err)
+
+	var mfs testManifest
+	require.NoError(t, json.Unmarshal(manifestJson, &mfs))
+	var imageStages, espStages []stage
+	for _, pl := range mfs.Pipelines {
+		switch pl.Name {
+		case "image":
+			imageStages = pl.Stages
+		case "esp":
+			espStages = pl.Stages
+		}
+	}
+
+	// the ESP of the disk image is copied into an image of its own
+	require.GreaterOrEqual(t, len(imageStages), 3)
+	added := imageStages[len(imageStages)-3:]
+	assert.Equal(t, "org.osbuild.truncate", added[0].Type)
+	assert.JSONEq(t, `{"filename": "esp.img", "size": "525336576"}`, string(added[0].Options))
+	assert.Equal(t, "org.osbuild.mkfs.fat", added[1].Type)
+	assert.JSONEq(t, `{"volid": "7B7795E7"}`, string(added[1].Options))
+	assert.Equal(t, "org.osbuild.copy", added[2].Type)
+	assert.JSONEq(t, `{"paths": [{"from": "mount://disk-esp/", "to": "mount://esp/"}]}`, string(added[2].Options))
+	assert.JSONEq(t, `[
+		{"name": "disk-esp", "type": "org.osbuild.fat", "source": "disk-esp", "target": "/disk-esp"},
+		{"name": "esp", "type": "org.osbuild.fat", "source": "esp", "target": "/esp"}
+	]`, string(added[2].Mounts))
+
+	// which is the only file of the exported pipeline
+	require.Len(t, espStages, 1)
+	assert.Equal(t, "org.osbuild.copy", espStages[0].Type)
+	assert.JSONEq(t, `{"paths": [{"from": "input://image/esp.img", "to": "tree:///esp.img"}]}`, string(espStages[0].Options))
+}
+
+func TestManifestESPErrors(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "esp"
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, `Manifest(): image type "esp" is experimental, pass --allow-experimental to build it`)
+
+	config.AllowExperimental = true
+	config.Architecture = arch.ARCH_PPC64LE
+	_, err = main.Manifest(config)
+	assert.EqualError(t, err, `image type "esp" needs UEFI, which is not available on ppc64le`)
+}
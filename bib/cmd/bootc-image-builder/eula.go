@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+const (
+	// the path of the EULA on RHEL install media
+	eulaISOPath = "/EULA"
+	// the EULA is inlined into the manifest
+	eulaMaxSize = 1 * MebiByte
+	// the installer mounts the ISO at /run/install/repo
+	eulaInstallerPath = "/run/install/repo" + eulaISOPath
+)
+
+// eulaSection returns the %pre section that shows the license on the
+// sixth console of the installer and aborts the installation unless it
+// is accepted. The kickstart of the ISO is unattended otherwise.
+func eulaSection() string {
+	return `%pre --erroronfail
+exec < /dev/tty6 > /dev/tty6 2> /dev/tty6
+chvt 6
+more ` + eulaInstallerPath + `
+answer=""
+while [ "$answer" != "yes" ] && [ "$answer" != "no" ]; do
+    printf "Do you accept the license agreement? (yes/no) "
+    read -r answer
+done
+chvt 1
+[ "$answer" = "yes" ]
+%end
+`
+}
+
+// readEULA returns the content of the license file, it must be
+// available in the container of bootc-image-builder, e.g. as a volume
+func readEULA(p string) (string, error) {
+	if !isCleanAbsPath(p) {
+		return "", fmt.Errorf("eula %q must be a clean absolute path", p)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", fmt.Errorf("cannot read eula: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("eula %q is not a regular file", p)
+	}
+	if info.Size() == 0 {
+		return "", fmt.Errorf("eula %q is empty", p)
+	}
+	if info.Size() > eulaMaxSize {
+		return "", fmt.Errorf("eula %q is larger than %d bytes", p, eulaMaxSize)
+	}
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("cannot read eula: %w", err)
+	}
+	if !utf8.Valid(content) {
+		return "", fmt.Errorf("eula %q is not a UTF-8 text file", p)
+	}
+	return string(content), nil
+}
+
+// addEULA writes the license to the root of the installer ISO and
+// adds a %pre section to the kickstart that asks to accept it.
+//
+// TODO: osbuild/images cannot add files to the ISO tree or sections to
+// the kickstart of the container installer
+func addEULA(m *serializedManifest, content string) error {
+	ks, err := readInlineFile(m, "bootiso-tree", osbuild.KickstartPathOSBuild)
+	if err != nil {
+		return fmt.Errorf("cannot add eula: %w", err)
+	}
+	if !strings.HasSuffix(ks, "\n") {
+		ks += "\n"
+	}
+	ks += eulaSection()
+
+	f, err := fsnode.NewFile(eulaISOPath, nil, nil, nil, []byte(content))
+	if err != nil {
+		return err
+	}
+	ksFile, err := fsnode.NewFile(osbuild.KickstartPathOSBuild, nil, nil, nil, []byte(ks))
+	if err != nil {
+		return err
+	}
+
+	err = m.updateSource("org.osbuild.inline", func(source json.RawMessage) (json.RawMessage, error) {
+		inline := osbuild.NewInlineSource()
+		if source != nil {
+			if err := json.Unmarshal(source, inline); err != nil {
+				return nil, err
+			}
+		}
+		inline.AddItem(content)
+		inline.AddItem(ks)
+		return json.Marshal(inline)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add eula: %w", err)
+	}
+
+	// the bootiso pipeline creates the ISO from the finished tree, the
+	// copy of the kickstart replaces the generated one
+	err = m.appendStages("bootiso-tree", func([]map[string]json.RawMessage) ([]*osbuild.Stage, error) {
+		return osbuild.GenFileNodesStages([]*fsnode.File{f, ksFile}), nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add eula: %w", err)
+	}
+	return nil
+}
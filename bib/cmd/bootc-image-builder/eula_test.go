@@ -0,0 +1,72 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestEULA(t *testing.T) {
+	eulaPath := filepath.Join(t.TempDir(), "EULA.txt")
+	require.NoError(t, os.WriteFile(eulaPath, []byte("You agree to everything.\n"), 0644))
+
+	config := getBaseConfig()
+	config.ImgType = "anaconda-iso"
+	config.Config = &main.BuildConfig{EULA: eulaPath}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(getISOPackages(), getISOContainers(), nil)
+	require.NoError(t, err)
+	generated, err := findFileContent(manifestJson, "bootiso-tree", "/osbuild.ks")
+	require.NoError(t, err)
+	manifestJson, err = main.AddEULA(manifestJson, "You agree to everything.\n")
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "bootiso-tree", "/EULA")
+	require.NoError(t, err)
+	assert.Equal(t, "You agree to everything.\n", content)
+
+	// the installer shows the license from the ISO and only continues
+	// when it is accepted
+	ks, err := findFileContent(manifestJson, "bootiso-tree", "/osbuild.ks")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(ks, generated))
+	assert.Contains(t, ks, "%pre --erroronfail\n")
+	assert.Contains(t, ks, "more /run/install/repo/EULA\n")
+	assert.Contains(t, ks, `[ "$answer" = "yes" ]`)
+}
+
+func TestManifestEULAErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(empty, nil, 0644))
+	binary := filepath.Join(dir, "binary")
+	require.NoError(t, os.WriteFile(binary, []byte{0xff, 0xfe, 0x00}, 0644))
+
+	for name, tc := range map[string]struct {
+		imgType string
+		eula    string
+		err     string
+	}{
+		"relative": {"anaconda-iso", "EULA", `eula "EULA" must be a clean absolute path`},
+		"missing":  {"anaconda-iso", filepath.Join(dir, "missing"), "cannot read eula: stat " + filepath.Join(dir, "missing") + ": no such file or directory"},
+		"dir":      {"anaconda-iso", dir, `eula "` + dir + `" is not a regular file`},
+		"empty":    {"anaconda-iso", empty, `eula "` + empty + `" is empty`},
+		"binary":   {"anaconda-iso", binary, `eula "` + binary + `" is not a UTF-8 text file`},
+		"disk":     {"qcow2", empty, "eula only supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{EULA: tc.eula}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/osbuild/images/pkg/manifest"
+)
+
+// experimentalImageType is an image type that is still in development,
+// it is only built with --allow-experimental
+type experimentalImageType struct {
+	Info ImageTypeInfo
+	// Export is the pipeline that is exported as the artifact
+	Export   string
+	Manifest func(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest, error)
+	// Finish optionally changes the serialized manifest, for what
+	// osbuild/images cannot do yet
+	Finish func(m *serializedManifest) error
+}
+
+// experimentalImageTypes are the image types that are not stable yet,
+// they are not listed by "list-types"
+var experimentalImageTypes = map[string]experimentalImageType{}
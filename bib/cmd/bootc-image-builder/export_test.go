@@ -1,5 +1,31 @@
 package main
 
+import (
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/container"
+	"github.com/osbuild/images/pkg/manifest"
+	"github.com/osbuild/images/pkg/rpmmd"
+)
+
+// patchManifest decodes the manifest, calls patch with it and encodes
+// the result
+func patchManifest(mf manifest.OSBuildManifest, patch func(m *serializedManifest) error) (manifest.OSBuildManifest, error) {
+	m, err := parseManifest(mf)
+	if err != nil {
+		return nil, err
+	}
+	if err := patch(m); err != nil {
+		return nil, err
+	}
+	return m.serialize()
+}
+
 var CanChownInPath = canChownInPath
 
 func MockOsGetuid(new func() int) (restore func()) {
@@ -9,3 +35,407 @@ func MockOsGetuid(new func() int) (restore func()) {
 		osGetuid = saved
 	}
 }
+
+var NewBuildInfo = newBuildInfo
+
+var GenPartitionLayout = genPartitionLayout
+
+var ApplyPartitionUUIDs = applyPartitionUUIDs
+
+var CheckOutputs = checkOutputs
+
+var (
+	WriteChecksums         = writeChecksums
+	WriteCombinedChecksums = writeCombinedChecksums
+	RemoveStaleSignature   = removeStaleSignature
+	SignChecksums          = signChecksums
+)
+
+func MockSignFile(new func(keyID, path string) error) (restore func()) {
+	saved := signFile
+	signFile = new
+	return func() {
+		signFile = saved
+	}
+}
+
+var WriteAMIRegisterParams = writeAMIRegisterParams
+
+var (
+	ListTypes     = listTypes
+	ParseDiskSize = parseDiskSize
+)
+
+func AddDataDisks(mf manifest.OSBuildManifest, disks []DataDisk, epoch string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addDataDisks(m, disks, epoch)
+	})
+}
+
+func SetDeploymentRootfsLabel(mf manifest.OSBuildManifest, label string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return setDeploymentRootfsLabel(m, label)
+	})
+}
+
+var (
+	NewStagedOutput  = newStagedOutput
+	NotifyInterrupt  = notifyInterrupt
+	ParseOutputOwner = parseOutputOwner
+	ChownTree        = chownTree
+)
+
+func (s *stagedOutput) Commit(outputs []string) error {
+	return s.commit(outputs)
+}
+
+func (s *stagedOutput) Cleanup() {
+	s.cleanup()
+}
+
+// InterruptExitCode returns the exit code of an interrupted build, or
+// 0 for other errors
+func InterruptExitCode(err error) int {
+	var intErr *interruptedError
+	if errors.As(err, &intErr) {
+		return intErr.exitCode()
+	}
+	return 0
+}
+
+var ValidateContainersStorage = validateContainersStorage
+
+func AddOverlays(mf manifest.OSBuildManifest, overlays []Overlay, containers []container.Spec) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addOverlays(m, overlays, containers)
+	})
+}
+
+func (c *ManifestConfig) PackageSetChains(mf *manifest.Manifest) map[string][]rpmmd.PackageSet {
+	return c.packageSetChains(mf)
+}
+
+var WriteVagrantBox = writeVagrantBox
+
+func SetMountpointOwnership(mf manifest.OSBuildManifest, mounts []MountCustomization) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return setMountpointOwnership(m, mounts)
+	})
+}
+
+type DoctorCheck = doctorCheck
+
+var (
+	RunDoctor       = runDoctor
+	CheckTools      = checkTools
+	CheckCharDevice = checkCharDevice
+	CheckFreeSpace  = checkFreeSpace
+)
+
+func MockExecLookPath(new func(string) (string, error)) (restore func()) {
+	saved := execLookPath
+	execLookPath = new
+	return func() {
+		execLookPath = saved
+	}
+}
+
+func MockUnixStatfs(new func(string, *unix.Statfs_t) error) (restore func()) {
+	saved := unixStatfs
+	unixStatfs = new
+	return func() {
+		unixStatfs = saved
+	}
+}
+
+var NewRootCmd = newRootCmd
+
+func AddGrubUserConfig(mf manifest.OSBuildManifest, lines []string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addGrubUserConfig(m, lines)
+	})
+}
+
+type ImageVerifier = imageVerifier
+
+func MockVerifyResolveDigest(new func(*ManifestConfig) (string, error)) (restore func()) {
+	saved := verifyResolveDigest
+	verifyResolveDigest = new
+	return func() {
+		verifyResolveDigest = saved
+	}
+}
+
+func MockNewImageVerifier(new func(policyPath string, tlsVerify bool) (imageVerifier, error)) (restore func()) {
+	saved := newImageVerifier
+	newImageVerifier = new
+	return func() {
+		newImageVerifier = saved
+	}
+}
+
+// RecordTimings records the timings of the given osbuild monitor output
+// for a build that ended at the given time
+func RecordTimings(monitor io.Reader, log io.Writer, end time.Time) (BuildTimings, error) {
+	var recorder timingRecorder
+	if err := recorder.readMonitor(monitor, log); err != nil {
+		return BuildTimings{}, err
+	}
+	return recorder.done(end), nil
+}
+
+var (
+	PrintTimings     = printTimings
+	WriteTimingsJSON = writeTimingsJSON
+)
+
+var CloudConfigTemplate = cloudConfigTemplate
+
+var (
+	LocalImageRef           = localImageRef
+	ManifestConfigFromCobra = manifestConfigFromCobra
+)
+
+type ExperimentalImageType = experimentalImageType
+
+func MockExperimentalImageTypes(new map[string]experimentalImageType) (restore func()) {
+	saved := experimentalImageTypes
+	experimentalImageTypes = new
+	return func() {
+		experimentalImageTypes = saved
+	}
+}
+
+var HasFirewallRules = hasFirewallRules
+
+func AddEmbeddedRepo(mf manifest.OSBuildManifest, r *EmbeddedRepo) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addEmbeddedRepo(m, r)
+	})
+}
+
+func AddBuildInfo(mf manifest.OSBuildManifest, bi *BuildInfo) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addBuildInfo(m, bi)
+	})
+}
+
+func AddSELinuxLabels(mf manifest.OSBuildManifest, contexts map[string]string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addSELinuxLabels(m, contexts)
+	})
+}
+
+func SetSELinuxConfig(mf manifest.OSBuildManifest, mode string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return setSELinuxConfig(m, mode)
+	})
+}
+
+func AddOSTreeRemote(mf manifest.OSBuildManifest, remote *OSTreeRemote) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addOSTreeRemote(m, remote)
+	})
+}
+
+type OSBuildTrace = osbuildTrace
+
+var (
+	OSBuildArgs = osbuildArgs
+	WriteTrace  = writeTrace
+)
+
+func AddCryptoPolicy(mf manifest.OSBuildManifest, policy string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addCryptoPolicy(m, policy)
+	})
+}
+
+var EffectiveCryptoPolicy = effectiveCryptoPolicy
+
+var (
+	ManifestBaseDigest    = manifestBaseDigest
+	WriteArtifactMetadata = writeArtifactMetadata
+)
+
+var (
+	RunOSBuild     = runOSBuild
+	OpenOSBuildLog = openOSBuildLog
+)
+
+func MockOSBuildStopTimeout(new time.Duration) (restore func()) {
+	saved := osbuildStopTimeout
+	osbuildStopTimeout = new
+	return func() {
+		osbuildStopTimeout = saved
+	}
+}
+
+func (l *osbuildLog) Tee(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	return l.tee(stdout, stderr)
+}
+
+func AddESP(mf manifest.OSBuildManifest) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addESP(m)
+	})
+}
+
+func SetESPLabel(mf manifest.OSBuildManifest, label string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return setESPLabel(m, label)
+	})
+}
+
+var (
+	InspectManifest         = inspectManifest
+	WriteManifestInspection = writeManifestInspection
+)
+
+func AddEULA(mf manifest.OSBuildManifest, content string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addEULA(m, content)
+	})
+}
+
+func SetLoopbackSectorSize(mf manifest.OSBuildManifest, size uint64) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return setLoopbackSectorSize(m, size)
+	})
+}
+
+func AddInstallerPostScript(mf manifest.OSBuildManifest, script string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addInstallerPostScript(m, script)
+	})
+}
+
+var InstallerPostScriptConflicts = installerPostScriptConflicts
+
+func SetISOOptions(mf manifest.OSBuildManifest, o *ISOOptions, a arch.Arch) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return setISOOptions(m, o, a)
+	})
+}
+
+func AddSquashfs(mf manifest.OSBuildManifest, compression string, a arch.Arch) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addSquashfs(m, compression, a)
+	})
+}
+
+func MockPullResolve(new func(c *ManifestConfig, a arch.Arch) (container.Spec, error)) (restore func()) {
+	saved := pullResolve
+	pullResolve = new
+	return func() {
+		pullResolve = saved
+	}
+}
+
+func MockPullFetch(new func(spec container.Spec, dest string) error) (restore func()) {
+	saved := pullFetch
+	pullFetch = new
+	return func() {
+		pullFetch = saved
+	}
+}
+
+func (c *BuildConfig) GrubUserConfigLines() ([]string, error) {
+	return c.grubUserConfigLines()
+}
+
+var SaveManifest = saveManifest
+
+func AddTimezone(mf manifest.OSBuildManifest, tz string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addTimezone(m, tz)
+	})
+}
+
+func MockHostTimezone(new func() (string, error)) (restore func()) {
+	saved := hostTimezone
+	hostTimezone = new
+	return func() {
+		hostTimezone = saved
+	}
+}
+
+func (c *ManifestConfig) InheritHostTimezone() error {
+	return c.inheritHostTimezone()
+}
+
+func (c *ManifestConfig) Timezone() string {
+	return c.timezone()
+}
+
+var DisableRepos = disableRepos
+
+type ContainerResolver = containerResolver
+
+func MockNewContainerResolver(new func(arch string) ContainerResolver) (restore func()) {
+	saved := newContainerResolver
+	newContainerResolver = new
+	return func() {
+		newContainerResolver = saved
+	}
+}
+
+var (
+	MakeManifest       = makeManifest
+	ReadContainerLock  = readContainerLock
+	WriteContainerLock = writeContainerLock
+)
+
+type UploadJob = uploadJob
+
+var RunUploads = runUploads
+
+func AddEFIFallback(mf manifest.OSBuildManifest, a arch.Arch, vendor string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addEFIFallback(m, a, vendor)
+	})
+}
+
+func AddDefaultTarget(mf manifest.OSBuildManifest, target string) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return addDefaultTarget(m, target)
+	})
+}
+
+func MockImageArch(new func(spec container.Spec) (string, error)) (restore func()) {
+	saved := imageArch
+	imageArch = new
+	return func() {
+		imageArch = saved
+	}
+}
+
+var WriteInitramfs = writeInitramfs
+
+var LoadRepos = loadRepos
+
+func MockReposStr(new string) (restore func()) {
+	saved := reposStr
+	reposStr = new
+	return func() {
+		reposStr = saved
+	}
+}
+
+func RemoveBootloader(mf manifest.OSBuildManifest) (manifest.OSBuildManifest, error) {
+	return patchManifest(mf, func(m *serializedManifest) error {
+		return removeBootloader(m)
+	})
+}
+
+var ContainersStorageImageName = containersStorageImageName
+
+func StorageSourceName(src container.SourceSpec) (string, error) {
+	named, err := storageSourceName(src)
+	if err != nil {
+		return "", err
+	}
+	return named.String(), nil
+}
+
+var ImageArchReference = imageArchReference
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const firewalldMaskPath = "/etc/systemd/system/firewalld.service"
+
+// disableFirewall returns the file that masks firewalld. systemd treats
+// an empty unit file like a symlink to /dev/null, so the unit cannot be
+// started, not even as a dependency of another unit.
+func disableFirewall() (*fsnode.File, error) {
+	return fsnode.NewFile(firewalldMaskPath, nil, nil, nil, []byte{})
+}
+
+// hasFirewallRules returns true if the customizations contain firewall
+// rules, which have no effect when firewalld is masked
+func hasFirewallRules(customizations *blueprint.Customizations) bool {
+	fw := customizations.GetFirewall()
+	if fw == nil {
+		return false
+	}
+	return len(fw.Ports) > 0 || len(fw.Zones) > 0 || (fw.Services != nil && (len(fw.Services.Enabled) > 0 || len(fw.Services.Disabled) > 0))
+}
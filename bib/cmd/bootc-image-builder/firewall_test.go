@@ -0,0 +1,72 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/blueprint"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestDisableFirewall(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{DisableFirewall: true}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	// an empty unit file masks the unit
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/firewalld.service")
+	require.NoError(t, err)
+	assert.Equal(t, "", content)
+}
+
+func TestManifestDisableFirewallDefault(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	_, err = findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/firewalld.service")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestManifestDisableFirewallISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{DisableFirewall: true}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "disable_firewall not supported for the iso image type")
+}
+
+func TestHasFirewallRules(t *testing.T) {
+	for name, tc := range map[string]struct {
+		customizations *blueprint.Customizations
+		expected       bool
+	}{
+		"no-customizations": {nil, false},
+		"no-firewall":       {&blueprint.Customizations{}, false},
+		"empty-firewall":    {&blueprint.Customizations{Firewall: &blueprint.FirewallCustomization{}}, false},
+		"ports": {
+			&blueprint.Customizations{Firewall: &blueprint.FirewallCustomization{Ports: []string{"22:tcp"}}},
+			true,
+		},
+		"services": {
+			&blueprint.Customizations{Firewall: &blueprint.FirewallCustomization{
+				Services: &blueprint.FirewallServicesCustomization{Enabled: []string{"ssh"}},
+			}},
+			true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, main.HasFirewallRules(tc.customizations))
+		})
+	}
+}
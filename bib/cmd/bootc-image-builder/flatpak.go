@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// FlatpakCustomization lists flatpak applications that are installed
+// from the given remote.
+type FlatpakCustomization struct {
+	Remote    string   `json:"remote"`
+	RemoteURL string   `json:"remote_url"`
+	Apps      []string `json:"apps"`
+}
+
+const flatpakInstallService = "bootc-flatpak-install.service"
+
+var (
+	// see https://docs.flatpak.org/en/latest/conventions.html#application-ids
+	flatpakAppIDElementRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+	flatpakRemoteNameRegex   = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+)
+
+func validateFlatpakAppID(appID string) error {
+	elements := strings.Split(appID, ".")
+	if len(appID) > 255 || len(elements) < 3 {
+		return fmt.Errorf("invalid flatpak application ID %q", appID)
+	}
+	for _, el := range elements {
+		if !flatpakAppIDElementRegex.MatchString(el) {
+			return fmt.Errorf("invalid flatpak application ID %q", appID)
+		}
+	}
+	return nil
+}
+
+func (fc *FlatpakCustomization) validate() error {
+	if !flatpakRemoteNameRegex.MatchString(fc.Remote) {
+		return fmt.Errorf("invalid flatpak remote name %q", fc.Remote)
+	}
+	if fc.RemoteURL == "" {
+		return fmt.Errorf("flatpak remote %q needs a remote_url", fc.Remote)
+	}
+	u, err := url.Parse(fc.RemoteURL)
+	if err != nil {
+		return fmt.Errorf("invalid flatpak remote url: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("flatpak remote url %q must be a http(s) url", fc.RemoteURL)
+	}
+	if len(fc.Apps) == 0 {
+		return fmt.Errorf("no flatpak applications to install from remote %q", fc.Remote)
+	}
+	for _, appID := range fc.Apps {
+		if err := validateFlatpakAppID(appID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unitFile returns a systemd unit that adds the remote and installs
+// the applications on the first boot of the system. The flatpak
+// remotes are not reachable from the osbuild buildroot so this cannot
+// happen at build time.
+func (fc *FlatpakCustomization) unitFile() (*fsnode.File, error) {
+	if err := fc.validate(); err != nil {
+		return nil, err
+	}
+	remoteAdd := []string{"/usr/bin/flatpak", "remote-add", "--system", "--if-not-exists"}
+	if strings.HasSuffix(fc.RemoteURL, ".flatpakrepo") {
+		remoteAdd = append(remoteAdd, "--from")
+	}
+	remoteAdd = append(remoteAdd, fc.Remote, fc.RemoteURL)
+	install := append([]string{"/usr/bin/flatpak", "install", "--system", "--noninteractive", fc.Remote}, fc.Apps...)
+
+	stamp := "/var/lib/bootc-flatpak-install.stamp"
+	unit := fmt.Sprintf(`[Unit]
+Description=Install Flatpak applications on the first boot
+Wants=network-online.target
+After=network-online.target
+ConditionPathExists=!%[1]s
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=%[2]s
+ExecStart=%[3]s
+ExecStartPost=/usr/bin/touch %[1]s
+
+[Install]
+WantedBy=multi-user.target
+`, stamp, strings.Join(remoteAdd, " "), strings.Join(install, " "))
+
+	mode := os.FileMode(0644)
+	return fsnode.NewFile("/etc/systemd/system/"+flatpakInstallService, &mode, nil, nil, []byte(unit))
+}
@@ -0,0 +1,94 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestFlatpaks(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Flatpaks: &main.FlatpakCustomization{
+			Remote:    "flathub",
+			RemoteURL: "https://dl.flathub.org/repo/flathub.flatpakrepo",
+			Apps:      []string{"org.mozilla.firefox", "org.gnome.Calculator"},
+		},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	unit, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/bootc-flatpak-install.service")
+	require.NoError(t, err)
+	assert.Contains(t, unit, "ExecStart=/usr/bin/flatpak remote-add --system --if-not-exists --from flathub https://dl.flathub.org/repo/flathub.flatpakrepo\n")
+	assert.Contains(t, unit, "ExecStart=/usr/bin/flatpak install --system --noninteractive flathub org.mozilla.firefox org.gnome.Calculator\n")
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.systemd")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var opts struct {
+		EnabledServices []string `json:"enabled_services"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+	assert.Equal(t, []string{"bootc-flatpak-install.service"}, opts.EnabledServices)
+}
+
+func TestManifestFlatpaksErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType  string
+		flatpaks main.FlatpakCustomization
+		err      string
+	}{
+		"no-url": {
+			imgType:  "qcow2",
+			flatpaks: main.FlatpakCustomization{Remote: "flathub", Apps: []string{"org.mozilla.firefox"}},
+			err:      `flatpak remote "flathub" needs a remote_url`,
+		},
+		"bad-url": {
+			imgType:  "qcow2",
+			flatpaks: main.FlatpakCustomization{Remote: "flathub", RemoteURL: "/srv/repo", Apps: []string{"org.mozilla.firefox"}},
+			err:      `flatpak remote url "/srv/repo" must be a http(s) url`,
+		},
+		"bad-remote": {
+			imgType:  "qcow2",
+			flatpaks: main.FlatpakCustomization{Remote: "flat hub", RemoteURL: "https://example.com/repo", Apps: []string{"org.mozilla.firefox"}},
+			err:      `invalid flatpak remote name "flat hub"`,
+		},
+		"short-app-id": {
+			imgType:  "qcow2",
+			flatpaks: main.FlatpakCustomization{Remote: "flathub", RemoteURL: "https://example.com/repo", Apps: []string{"firefox"}},
+			err:      `invalid flatpak application ID "firefox"`,
+		},
+		"bad-app-id": {
+			imgType:  "qcow2",
+			flatpaks: main.FlatpakCustomization{Remote: "flathub", RemoteURL: "https://example.com/repo", Apps: []string{"org.mozilla.1firefox"}},
+			err:      `invalid flatpak application ID "org.mozilla.1firefox"`,
+		},
+		"no-apps": {
+			imgType:  "qcow2",
+			flatpaks: main.FlatpakCustomization{Remote: "flathub", RemoteURL: "https://example.com/repo"},
+			err:      `no flatpak applications to install from remote "flathub"`,
+		},
+		"iso": {
+			imgType:  "iso",
+			flatpaks: main.FlatpakCustomization{Remote: "flathub", RemoteURL: "https://example.com/repo", Apps: []string{"org.mozilla.firefox"}},
+			err:      "flatpaks not supported for the iso image type",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			flatpaks := tc.flatpaks
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{Flatpaks: &flatpaks}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1" //nolint:gosec // the fingerprint of OpenPGP v4 keys is a SHA-1 hash
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// the keys are installed where the gpgkey= URLs of repo files point to,
+// the rpm database of the deployment is part of the container image
+const gpgKeysDir = "/etc/pki/rpm-gpg"
+
+const gpgPublicKeyType = "PGP PUBLIC KEY BLOCK"
+
+// the tag of public key packets, see RFC 4880 section 4.3
+const gpgPacketTagPublicKey = 6
+
+// gpgDearmor decodes an ASCII armored OpenPGP block (RFC 4880 section
+// 6.2) and returns its type, e.g. "PGP PUBLIC KEY BLOCK", and data
+func gpgDearmor(armored string) (string, []byte, error) {
+	scanner := bufio.NewScanner(strings.NewReader(armored))
+	blockType := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "-----BEGIN ") && strings.HasSuffix(line, "-----") {
+			blockType = strings.TrimSuffix(strings.TrimPrefix(line, "-----BEGIN "), "-----")
+			break
+		}
+	}
+	if blockType == "" {
+		return "", nil, errors.New("no armor header line")
+	}
+	// the armor headers, e.g. "Comment: ...", end with an empty line
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			break
+		}
+	}
+
+	var body strings.Builder
+	checksum := ""
+	end := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "-----END "+blockType+"-----" {
+			end = true
+			break
+		}
+		if strings.HasPrefix(line, "=") {
+			checksum = line[1:]
+			continue
+		}
+		body.WriteString(line)
+	}
+	if !end {
+		return "", nil, errors.New("no armor tail line")
+	}
+	data, err := base64.StdEncoding.DecodeString(body.String())
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid armor body: %w", err)
+	}
+	if checksum != "" {
+		sum, err := base64.StdEncoding.DecodeString(checksum)
+		if err != nil || len(sum) != 3 {
+			return "", nil, errors.New("invalid armor checksum")
+		}
+		if crc24(data) != uint32(sum[0])<<16|uint32(sum[1])<<8|uint32(sum[2]) {
+			return "", nil, errors.New("armor checksum mismatch")
+		}
+	}
+	return blockType, data, nil
+}
+
+// crc24 is the checksum of the armor, see RFC 4880 section 6.1
+func crc24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+// gpgPacket is an OpenPGP packet, see RFC 4880 section 4.2
+type gpgPacket struct {
+	tag  byte
+	body []byte
+}
+
+// gpgPackets splits the given data into its packets. Packets with a
+// partial body length are not used for keys and are not supported.
+func gpgPackets(data []byte) ([]gpgPacket, error) {
+	var packets []gpgPacket
+	for len(data) > 0 {
+		hdr := data[0]
+		if hdr&0x80 == 0 {
+			return nil, errors.New("tag byte does not have MSB set")
+		}
+		var tag byte
+		var length, n int
+		if hdr&0x40 != 0 {
+			// new format
+			tag = hdr & 0x3f
+			switch {
+			case len(data) >= 2 && data[1] < 192:
+				length, n = int(data[1]), 2
+			case len(data) >= 3 && data[1] < 224:
+				length, n = (int(data[1])-192)<<8+int(data[2])+192, 3
+			case len(data) >= 6 && data[1] == 255:
+				length, n = int(binary.BigEndian.Uint32(data[2:6])), 6
+			case len(data) >= 2 && data[1] >= 224 && data[1] < 255:
+				return nil, errors.New("partial body lengths are not supported")
+			default:
+				return nil, errors.New("truncated packet header")
+			}
+		} else {
+			// old format
+			tag = (hdr >> 2) & 0x0f
+			switch hdr & 0x03 {
+			case 0:
+				if len(data) >= 2 {
+					length, n = int(data[1]), 2
+				}
+			case 1:
+				if len(data) >= 3 {
+					length, n = int(binary.BigEndian.Uint16(data[1:3])), 3
+				}
+			case 2:
+				if len(data) >= 5 {
+					length, n = int(binary.BigEndian.Uint32(data[1:5])), 5
+				}
+			default:
+				return nil, errors.New("indeterminate packet lengths are not supported")
+			}
+			if n == 0 {
+				return nil, errors.New("truncated packet header")
+			}
+		}
+		if length < 0 || length > len(data)-n {
+			return nil, errors.New("truncated packet")
+		}
+		packets = append(packets, gpgPacket{tag: tag, body: data[n : n+length]})
+		data = data[n+length:]
+	}
+	return packets, nil
+}
+
+// gpgKeyID returns the id of the primary key of the given public key
+// packets. The id is derived from the fingerprint of the key, so the
+// key can use any algorithm, e.g. RSA or EdDSA.
+func gpgKeyID(data []byte) (string, error) {
+	packets, err := gpgPackets(data)
+	if err != nil {
+		return "", err
+	}
+	if len(packets) == 0 {
+		return "", errors.New("no key")
+	}
+	key := packets[0]
+	if key.tag != gpgPacketTagPublicKey {
+		return "", fmt.Errorf("expected a public key packet, got a packet with tag %d", key.tag)
+	}
+	if len(key.body) == 0 {
+		return "", errors.New("empty public key packet")
+	}
+	// the fingerprint of v4 keys is the SHA-1 hash of the key packet,
+	// the id is its low 64 bits, see RFC 4880 section 12.2
+	if version := key.body[0]; version != 4 {
+		return "", fmt.Errorf("unsupported key version %d", version)
+	}
+	if len(key.body) > 0xffff {
+		return "", errors.New("public key packet is too large")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(0x99)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(key.body)))
+	buf.Write(key.body)
+	fingerprint := sha1.Sum(buf.Bytes()) //nolint:gosec
+	return fmt.Sprintf("%x", fingerprint[12:20]), nil
+}
+
+// parseGPGKey returns the id of the primary key of the ASCII armored
+// public key block, the errors contain the index of the key in the
+// config
+func parseGPGKey(idx int, key string) (string, error) {
+	blockType, data, err := gpgDearmor(key)
+	if err != nil {
+		return "", fmt.Errorf("gpg key %d is not ASCII armored: %w", idx, err)
+	}
+	if blockType != gpgPublicKeyType {
+		return "", fmt.Errorf("gpg key %d is a %q, not a %q", idx, blockType, gpgPublicKeyType)
+	}
+	id, err := gpgKeyID(data)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse gpg key %d: %w", idx, err)
+	}
+	return id, nil
+}
+
+// gpgKeyFiles returns the files of the given keys, they are named
+// after the id of their primary key, e.g.
+// /etc/pki/rpm-gpg/RPM-GPG-KEY-1a2b3c4d5e6f7a8b
+func gpgKeyFiles(keys []string) ([]*fsnode.File, error) {
+	var files []*fsnode.File
+	seen := make(map[string]bool)
+	for idx, key := range keys {
+		id, err := parseGPGKey(idx, key)
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate gpg key %s", id)
+		}
+		seen[id] = true
+
+		if !strings.HasSuffix(key, "\n") {
+			key += "\n"
+		}
+		path := fmt.Sprintf("%s/RPM-GPG-KEY-%s", gpgKeysDir, id)
+		f, err := fsnode.NewFile(path, nil, nil, nil, []byte(key))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
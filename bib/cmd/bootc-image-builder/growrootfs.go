@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// bootc base images grow the root partition and filesystem to the size
+// of the disk on every boot with this unit
+const growpartMaskPath = "/etc/systemd/system/bootc-generic-growpart.service"
+
+// disableGrowRootfs returns the file that masks the unit that grows the
+// root filesystem, see disableFirewall()
+func disableGrowRootfs() (*fsnode.File, error) {
+	return fsnode.NewFile(growpartMaskPath, nil, nil, nil, []byte{})
+}
@@ -0,0 +1,50 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestGrowRootfs(t *testing.T) {
+	disabled, enabled := false, true
+	for name, tc := range map[string]struct {
+		growRootfs *bool
+		masked     bool
+	}{
+		"default":  {nil, false},
+		"enabled":  {&enabled, false},
+		"disabled": {&disabled, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{GrowRootfs: tc.growRootfs}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/bootc-generic-growpart.service")
+			if !tc.masked {
+				assert.ErrorContains(t, err, "not found")
+				return
+			}
+			require.NoError(t, err)
+			// an empty unit file masks the unit
+			assert.Equal(t, "", content)
+		})
+	}
+}
+
+func TestManifestGrowRootfsISO(t *testing.T) {
+	disabled := false
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{GrowRootfs: &disabled}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "grow_rootfs not supported for the iso image type")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// grubUserConfigPath is the path of the grub config in the physical
+// root that the static grub config of bootupd sources before the boot
+// entries are loaded, it ends up on the boot partition
+const grubUserConfigPath = "/boot/grub2/user.cfg"
+
+var grubMenuValues = []string{"hidden", "shown"}
+
+// grubMenuLines returns the grub directives for the given menu
+// visibility. A hidden menu is still shown when Esc or Shift is
+// pressed before the timeout of the static config expires.
+func grubMenuLines(menu string) ([]string, error) {
+	switch menu {
+	case "":
+		return nil, nil
+	case "hidden":
+		return []string{"set timeout_style=hidden"}, nil
+	case "shown":
+		return []string{"set timeout_style=menu"}, nil
+	}
+	return nil, fmt.Errorf("unsupported grub_menu %q, valid values are %q", menu, grubMenuValues)
+}
+
+// grubUserConfigLines returns all lines of the user.cfg, the menu
+// visibility comes first so grub_user_config can still override it
+func (c *BuildConfig) grubUserConfigLines() ([]string, error) {
+	lines, err := grubMenuLines(c.GrubMenu)
+	if err != nil {
+		return nil, err
+	}
+	return append(lines, c.GrubUserConfig...), nil
+}
+
+func validateGrubUserConfig(lines []string) error {
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			return fmt.Errorf("grub user config lines must not be empty")
+		}
+		if strings.ContainsAny(line, "\r\n") {
+			return fmt.Errorf("grub user config line %q must be a single line", line)
+		}
+	}
+	return nil
+}
+
+// grubUserConfig returns the content of the user.cfg, the lines are
+// delimited so they can be told apart from later manual changes
+func grubUserConfig(lines []string) string {
+	var content strings.Builder
+	content.WriteString("# BEGIN bootc-image-builder grub_user_config\n")
+	for _, line := range lines {
+		content.WriteString(line + "\n")
+	}
+	content.WriteString("# END bootc-image-builder grub_user_config\n")
+	return content.String()
+}
+
+// addGrubUserConfig writes the given lines to the user.cfg on the boot
+// partition.
+//
+// TODO: osbuild/images can only add files to the deployment
+func addGrubUserConfig(m *serializedManifest, lines []string) error {
+	content := grubUserConfig(lines)
+	f, err := fsnode.NewFile(grubUserConfigPath, nil, nil, nil, []byte(content))
+	if err != nil {
+		return err
+	}
+
+	err = m.updateSource("org.osbuild.inline", func(source json.RawMessage) (json.RawMessage, error) {
+		inline := osbuild.NewInlineSource()
+		if source != nil {
+			if err := json.Unmarshal(source, inline); err != nil {
+				return nil, err
+			}
+		}
+		inline.AddItem(content)
+		return json.Marshal(inline)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add grub user config: %w", err)
+	}
+
+	err = m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(json.RawMessage) ([]*osbuild.Stage, error) {
+		// not mounted in the deployment, the stages work on the
+		// physical root
+		mkdir := osbuild.NewMkdirStage(&osbuild.MkdirStageOptions{
+			Paths: []osbuild.MkdirStagePath{{Path: "/boot/grub2", Parents: true, ExistOk: true}},
+		})
+		return append([]*osbuild.Stage{mkdir}, osbuild.GenFileNodesStages([]*fsnode.File{f})...), nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add grub user config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestGrubUserConfig(t *testing.T) {
+	lines := []string{"serial --unit=0 --speed=115200", "terminal_input serial console"}
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{GrubUserConfig: lines}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddGrubUserConfig(manifestJson, lines)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/boot/grub2/user.cfg")
+	require.NoError(t, err)
+	expected := `# BEGIN bootc-image-builder grub_user_config
+serial --unit=0 --speed=115200
+terminal_input serial console
+# END bootc-image-builder grub_user_config
+`
+	assert.Equal(t, expected, content)
+
+	// the file is written to the boot partition, not the deployment
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.copy")
+	require.NoError(t, err)
+	found := false
+	for _, st := range stages {
+		if strings.Contains(string(st.Options), "tree:///boot/grub2/user.cfg") {
+			assert.Empty(t, st.Mounts)
+			found = true
+		}
+	}
+	assert.True(t, found)
+	// the other files are still there
+	_, err = findFileContent(manifestJson, "ostree-deployment", "/etc/machine-id")
+	assert.NoError(t, err)
+}
+
+func TestManifestGrubUserConfigErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		lines   []string
+		err     string
+	}{
+		"empty":     {"qcow2", []string{" "}, "grub user config lines must not be empty"},
+		"multiline": {"qcow2", []string{"set a=1\nset b=2"}, `grub user config line "set a=1\nset b=2" must be a single line`},
+		"iso":       {"iso", []string{"serial"}, "grub_user_config not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{GrubUserConfig: tc.lines}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestManifestGrubMenu(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config   main.BuildConfig
+		expected string
+	}{
+		"hidden": {
+			main.BuildConfig{GrubMenu: "hidden"},
+			"set timeout_style=hidden\n",
+		},
+		"shown": {
+			main.BuildConfig{GrubMenu: "shown"},
+			"set timeout_style=menu\n",
+		},
+		"with-user-config": {
+			main.BuildConfig{GrubMenu: "hidden", GrubUserConfig: []string{"set timeout=2"}},
+			"set timeout_style=hidden\nset timeout=2\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &tc.config
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+			lines, err := tc.config.GrubUserConfigLines()
+			require.NoError(t, err)
+			manifestJson, err = main.AddGrubUserConfig(manifestJson, lines)
+			require.NoError(t, err)
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/boot/grub2/user.cfg")
+			require.NoError(t, err)
+			expected := "# BEGIN bootc-image-builder grub_user_config\n" + tc.expected + "# END bootc-image-builder grub_user_config\n"
+			assert.Equal(t, expected, content)
+		})
+	}
+}
+
+func TestManifestGrubMenuErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		menu    string
+		err     string
+	}{
+		"bad-value": {"qcow2", "countdown", `unsupported grub_menu "countdown", valid values are ["hidden" "shown"]`},
+		"iso":       {"iso", "hidden", "grub_menu not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{GrubMenu: tc.menu}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
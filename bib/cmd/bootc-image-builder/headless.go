@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+const headlessTarget = "multi-user.target"
+
+// graphicalPackages are the packages and groups that pull in a
+// graphical session
+var graphicalPackages = map[string]bool{
+	"gdm":                              true,
+	"sddm":                             true,
+	"lightdm":                          true,
+	"gnome-shell":                      true,
+	"gnome-session":                    true,
+	"plasma-workspace":                 true,
+	"xfce4-session":                    true,
+	"xorg-x11-server-Xorg":             true,
+	"@base-x":                          true,
+	"@gnome-desktop":                   true,
+	"@kde-desktop":                     true,
+	"@xfce-desktop":                    true,
+	"@workstation-product-environment": true,
+	"@graphical-server-environment":    true,
+}
+
+// headlessConflicts returns the graphical packages and groups of the
+// blueprint, groups are prefixed with "@"
+func headlessConflicts(bp *blueprint.Blueprint) []string {
+	if bp == nil {
+		return nil
+	}
+	var conflicts []string
+	for _, pkg := range append(bp.Packages, bp.Modules...) {
+		if graphicalPackages[pkg.Name] {
+			conflicts = append(conflicts, pkg.Name)
+		}
+	}
+	for _, group := range bp.Groups {
+		if graphicalPackages["@"+group.Name] {
+			conflicts = append(conflicts, "@"+group.Name)
+		}
+	}
+	return conflicts
+}
+
+// addDefaultTarget sets the default target of the deployment.
+//
+// TODO: osbuild/images does not set the default target of bootc
+// images
+func addDefaultTarget(m *serializedManifest, target string) error {
+	err := m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(options json.RawMessage) ([]*osbuild.Stage, error) {
+		var selinuxOpts osbuild.OSTreeSelinuxStageOptions
+		if err := json.Unmarshal(options, &selinuxOpts); err != nil {
+			return nil, err
+		}
+		deployment := selinuxOpts.Deployment
+
+		stage := osbuild.NewSystemdStage(&osbuild.SystemdStageOptions{DefaultTarget: target})
+		stage.MountOSTree(deployment.OSName, deployment.Ref, 0)
+		return []*osbuild.Stage{stage}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set the default target: %w", err)
+	}
+	return nil
+}
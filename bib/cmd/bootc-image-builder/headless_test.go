@@ -0,0 +1,91 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestHeadless(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{Headless: true}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddDefaultTarget(manifestJson, "multi-user.target")
+	require.NoError(t, err)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.systemd")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var opts struct {
+		DefaultTarget string `json:"default_target"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+	assert.Equal(t, "multi-user.target", opts.DefaultTarget)
+	assert.Contains(t, string(stages[0].Mounts), "org.osbuild.ostree.deployment")
+}
+
+func TestManifestHeadlessISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{Headless: true}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "headless not supported for the iso image type")
+}
+
+func TestHeadlessWarnsAboutGraphicalPackages(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config  string
+		warning string
+	}{
+		"graphical": {
+			`{"headless": true, "blueprint": {"packages": [{"name": "vim-enhanced"}, {"name": "gdm"}], "groups": [{"name": "gnome-desktop"}]}}`,
+			"WARNING: headless is set but the blueprint adds the graphical packages gdm, @gnome-desktop\n",
+		},
+		"server": {
+			`{"headless": true, "blueprint": {"packages": [{"name": "vim-enhanced"}]}}`,
+			"",
+		},
+		"not-headless": {
+			`{"blueprint": {"packages": [{"name": "gdm"}]}}`,
+			"",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			require.NoError(t, os.WriteFile(configPath, []byte(tc.config), 0644))
+
+			r, w, err := os.Pipe()
+			require.NoError(t, err)
+			savedStderr := os.Stderr
+			os.Stderr = w
+			defer func() { os.Stderr = savedStderr }()
+
+			rootCmd, err := main.NewRootCmd()
+			require.NoError(t, err)
+			rootCmd.SetOut(io.Discard)
+			rootCmd.SetErr(io.Discard)
+			rootCmd.SetArgs([]string{"manifest", "--only-manifest-validate", "--type", "qcow2", "--config", configPath, "testempty"})
+			err = rootCmd.Execute()
+			w.Close()
+			os.Stderr = savedStderr
+			require.NoError(t, err)
+
+			var stderr bytes.Buffer
+			_, err = io.Copy(&stderr, r)
+			require.NoError(t, err)
+			assert.Equal(t, tc.warning, stderr.String())
+		})
+	}
+}
@@ -6,15 +6,21 @@ import (
 	"math"
 	"math/big"
 	"math/rand"
+	"os"
+	"regexp"
+	"strings"
 
 	"github.com/osbuild/images/pkg/arch"
 	"github.com/osbuild/images/pkg/blueprint"
 	"github.com/osbuild/images/pkg/container"
+	"github.com/osbuild/images/pkg/customizations/fsnode"
 	"github.com/osbuild/images/pkg/customizations/users"
 	"github.com/osbuild/images/pkg/disk"
 	"github.com/osbuild/images/pkg/image"
 	"github.com/osbuild/images/pkg/manifest"
+	"github.com/osbuild/images/pkg/osbuild"
 	"github.com/osbuild/images/pkg/platform"
+	"github.com/osbuild/images/pkg/policies"
 	"github.com/osbuild/images/pkg/rpmmd"
 	"github.com/osbuild/images/pkg/runner"
 )
@@ -40,32 +46,273 @@ type ManifestConfig struct {
 	// CPU architecture of the image
 	Architecture arch.Arch
 
+	// DiskSize is the size of the disk image in bytes, the default
+	// of the image type is used when unset
+	DiskSize uint64
+
 	// TLSVerify specifies whether HTTPS and a valid TLS certificate are required
 	TLSVerify bool
+
+	// BuildInfo is written to /etc/bootc-build-info.json in the image
+	// when set
+	BuildInfo *BuildInfo
+
+	// ContainersStorage is the path of a local containers storage,
+	// the image is taken from it instead of a registry when set
+	ContainersStorage string
+
+	// AllowExperimental allows building experimental image types
+	AllowExperimental bool
+
+	// HostTimezone is the timezone of the build host that disk images
+	// without a timezone customization get
+	HostTimezone string
+
+	// NoArchCheck skips the check that the base image is built for
+	// the target architecture
+	NoArchCheck bool
+
+	// ContainerLock pins the digests of the containers, see
+	// --container-lockfile
+	ContainerLock *ContainerLock
+
+	// PartitionTable is set by Manifest() to the partition table of
+	// the disk image, see --export-partition-table
+	PartitionTable *disk.PartitionTable
+}
+
+// containerSource returns the source of the bootc container image
+func (c *ManifestConfig) containerSource() container.SourceSpec {
+	spec := container.SourceSpec{
+		Source:    c.Imgref,
+		Name:      c.Imgref,
+		TLSVerify: &c.TLSVerify,
+	}
+	if c.ContainersStorage != "" {
+		transport := osbuild.ContainersStorageTransport
+		storagePath := c.ContainersStorage
+		spec.ContainersTransport = &transport
+		spec.StoragePath = &storagePath
+	}
+	return spec
 }
 
 func Manifest(c *ManifestConfig) (*manifest.Manifest, error) {
 	rng := createRand()
 
+	if c.ImgType != "squashfs" && c.Config != nil && c.Config.SquashfsCompression != "" {
+		return nil, fmt.Errorf("squashfs_compression only supported for the squashfs image type")
+	}
+
+	if c.Config != nil && c.Config.Bootloader != "" {
+		if err := c.validateBootloader(); err != nil {
+			return nil, err
+		}
+	}
+
 	switch c.ImgType {
-	case "ami", "qcow2", "raw":
+	case "ami", "qcow2", "raw", "vagrant-libvirt":
 		return manifestForDiskImage(c, rng)
 	case "anaconda-iso", "iso":
 		return manifestForISO(c, rng)
+	case "squashfs":
+		return manifestForSquashfs(c, rng)
 	default:
+		if exp, ok := experimentalImageTypes[c.ImgType]; ok {
+			if !c.AllowExperimental {
+				return nil, fmt.Errorf("Manifest(): image type %q is experimental, pass --allow-experimental to build it", c.ImgType)
+			}
+			fmt.Fprintf(os.Stderr, "WARNING: image type %q is experimental, not for production\n", c.ImgType)
+			return exp.Manifest(c, rng)
+		}
 		return nil, fmt.Errorf("Manifest(): unsupported image type %q", c.ImgType)
 	}
 }
 
+var bootEntryLabelRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._]*$`)
+
+// validateBootEntryLabel ensures the label is plain ASCII that can be
+// passed to efibootmgr. Anaconda cuts the product name at the first
+// "-" when creating the entry so dashes are rejected too.
+func validateBootEntryLabel(label string) error {
+	if label == "" {
+		return fmt.Errorf("boot entry label must not be empty")
+	}
+	if !bootEntryLabelRegex.MatchString(label) {
+		return fmt.Errorf("boot entry label %q must only contain ASCII letters, digits, spaces, '.' and '_'", label)
+	}
+	return nil
+}
+
+// isoLabelMaxLen is the length of the volume identifier field in the
+// ISO9660 primary volume descriptor
+const isoLabelMaxLen = 32
+
+var isoLabelRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validateISOLabel ensures the label fits into the ISO9660 volume
+// identifier. The label also ends up unquoted on the installer kernel
+// command line (inst.stage2=hd:LABEL=...) so spaces are not allowed.
+func validateISOLabel(label string) error {
+	if label == "" {
+		return fmt.Errorf("iso label must not be empty")
+	}
+	if len(label) > isoLabelMaxLen {
+		return fmt.Errorf("iso label %q is too long (%d characters), the maximum is %d", label, len(label), isoLabelMaxLen)
+	}
+	if !isoLabelRegex.MatchString(label) {
+		return fmt.Errorf("iso label %q must only contain ASCII letters, digits, '-', '.' and '_'", label)
+	}
+	return nil
+}
+
+// validateInstallerKernelArgs ensures each argument is a single word
+// and does not override the arguments that bib uses to find the
+// installer and the kickstart on the ISO.
+func validateInstallerKernelArgs(args []string) error {
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n") {
+			return fmt.Errorf("installer kernel argument %q must be a single non-empty word", arg)
+		}
+		for _, reserved := range []string{"inst.stage2=", "inst.ks="} {
+			if strings.HasPrefix(arg, reserved) {
+				return fmt.Errorf("installer kernel argument %q is set by bootc-image-builder and cannot be overridden", arg)
+			}
+		}
+	}
+	return nil
+}
+
+// validateKernelName rejects a kernel name in the blueprint of a disk
+// image. A bootc deployment boots the kernel that is shipped in the
+// container image (in /usr/lib/modules), the bootloader entries are
+// generated from it and there is no kernel package that could be
+// selected instead.
+func validateKernelName(customizations *blueprint.Customizations) error {
+	if customizations == nil || customizations.Kernel == nil || customizations.Kernel.Name == "" {
+		return nil
+	}
+	return fmt.Errorf("kernel name only supported for the iso image type, the installed system boots the kernel of the container image, install %q in the container image instead", customizations.Kernel.Name)
+}
+
+var kernelNameRegex = regexp.MustCompile(`^kernel(-[a-z0-9]+)*$`)
+
+// installerKernelName returns the kernel package of the installer,
+// e.g. kernel-64k for aarch64 systems with 64k pages. The installed
+// system still boots the kernel of the container image.
+func installerKernelName(customizations *blueprint.Customizations) (string, error) {
+	if customizations == nil || customizations.Kernel == nil || customizations.Kernel.Name == "" {
+		return "kernel", nil
+	}
+	name := customizations.Kernel.Name
+	if !kernelNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid kernel name %q, expected a kernel package like kernel-64k", name)
+	}
+	return name, nil
+}
+
+// withKernel returns the given installer packages with the kernel
+// package replaced by the given one
+func withKernel(packages []string, kernel string) []string {
+	result := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		if pkg == "kernel" {
+			pkg = kernel
+		}
+		result = append(result, pkg)
+	}
+	return result
+}
+
+// genPartitionTable creates the partition table of a disk image from the
+// base table of the architecture and the filesystem customizations.
+func genPartitionTable(c *ManifestConfig, customizations *blueprint.Customizations, rng *rand.Rand) (*disk.PartitionTable, error) {
+	mountpoints := customizations.GetFilesystems()
+	if err := blueprint.CheckMountpointsPolicy(mountpoints, policies.OstreeMountpointPolicies); err != nil {
+		return nil, err
+	}
+	if err := blueprint.CheckMountpointsPolicy(mountpoints, policies.MountpointPolicies); err != nil {
+		return nil, err
+	}
+
+	basept, ok := partitionTables[c.Architecture.String()]
+	if !ok {
+		return nil, fmt.Errorf("pipelines: no partition tables defined for %s", c.Architecture)
+	}
+	if c.Config != nil && c.Config.SectorSize != 0 {
+		if err := validateSectorSize(c.Config.SectorSize); err != nil {
+			return nil, err
+		}
+		basept.SectorSize = c.Config.SectorSize
+	}
+	size, err := c.diskSize()
+	if err != nil {
+		return nil, err
+	}
+	pt, err := disk.NewPartitionTable(&basept, mountpoints, size, disk.RawPartitioningMode, nil, rng)
+	if err != nil {
+		return nil, err
+	}
+	var partUUIDs *PartitionUUIDs
+	if c.Config != nil {
+		partUUIDs = c.Config.PartitionUUIDs
+	}
+	if err := applyPartitionUUIDs(pt, &basept, partUUIDs, os.Getenv("SOURCE_DATE_EPOCH")); err != nil {
+		return nil, err
+	}
+	if c.Config != nil {
+		if err := applyMountCustomizations(pt, c.Config.Mounts); err != nil {
+			return nil, err
+		}
+		if err := applyRootfsCustomizations(pt, c.Config.RootfsLabel, c.Config.RootfsUUID); err != nil {
+			return nil, err
+		}
+		if err := applyBootFSType(pt, c.Config.BootFSType); err != nil {
+			return nil, err
+		}
+		if err := validateUniqueLabels(pt, c.Config.DataDisks); err != nil {
+			return nil, err
+		}
+	}
+	return pt, nil
+}
+
+// customFileNodes validates the file and directory customizations and
+// converts them to the nodes that are created in the deployment.
+func customFileNodes(customizations *blueprint.Customizations) ([]*fsnode.Directory, []*fsnode.File, error) {
+	dirCustomizations := customizations.GetDirectories()
+	fileCustomizations := customizations.GetFiles()
+	if err := blueprint.ValidateDirFileCustomizations(dirCustomizations, fileCustomizations); err != nil {
+		return nil, nil, err
+	}
+	if err := blueprint.CheckDirectoryCustomizationsPolicy(dirCustomizations, policies.CustomDirectoriesPolicies); err != nil {
+		return nil, nil, err
+	}
+	if err := blueprint.CheckFileCustomizationsPolicy(fileCustomizations, policies.CustomFilesPolicies); err != nil {
+		return nil, nil, err
+	}
+
+	dirs, err := blueprint.DirectoryCustomizationsToFsNodeDirectories(dirCustomizations)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := blueprint.FileCustomizationsToFsNodeFiles(fileCustomizations)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dirs, files, nil
+}
+
 func manifestForDiskImage(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest, error) {
 	if c.Imgref == "" {
 		return nil, fmt.Errorf("pipeline: no base image defined")
 	}
-	containerSource := container.SourceSpec{
-		Source:    c.Imgref,
-		Name:      c.Imgref,
-		TLSVerify: &c.TLSVerify,
+	if c.Config != nil {
+		if opts := c.Config.isoOnlyOptions(); len(opts) > 0 {
+			return nil, fmt.Errorf("%s only supported for the iso image type", strings.Join(opts, ", "))
+		}
 	}
+	containerSource := c.containerSource()
 
 	var customizations *blueprint.Customizations
 	if c.Config != nil && c.Config.Blueprint != nil {
@@ -73,6 +320,12 @@ func manifestForDiskImage(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest
 	}
 
 	img := image.NewBootcDiskImage(containerSource)
+	if err := validateUserPasswords(customizations.GetUsers()); err != nil {
+		return nil, err
+	}
+	if err := validateKernelName(customizations); err != nil {
+		return nil, err
+	}
 	img.Users = users.UsersFromBP(customizations.GetUsers())
 	img.Groups = users.GroupsFromBP(customizations.GetGroups())
 
@@ -86,10 +339,122 @@ func manifestForDiskImage(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest
 
 	img.SysrootReadOnly = true
 
+	// the machine-id of the container image is never used, clones of
+	// the image would share it
+	var machineIDMode, machineIDValue string
+	if c.Config != nil {
+		machineIDMode, machineIDValue = c.Config.MachineID, c.Config.MachineIDValue
+	}
+	machineID, err := machineIDFile(machineIDMode, machineIDValue)
+	if err != nil {
+		return nil, err
+	}
+	img.Files = append(img.Files, machineID)
+
+	dirs, files, err := customFileNodes(customizations)
+	if err != nil {
+		return nil, err
+	}
+	img.Directories = append(img.Directories, dirs...)
+	img.Files = append(img.Files, files...)
+	if c.Config != nil && len(c.Config.SELinuxContexts) > 0 {
+		f, err := selinuxContextsFile(c.Config.SELinuxContexts, dirs, files)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+
+	workload := &ServicesWorkload{}
+	if c.Config != nil && c.Config.Flatpaks != nil {
+		f, err := c.Config.Flatpaks.unitFile()
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+		workload.EnabledServices = append(workload.EnabledServices, flatpakInstallService)
+	}
+	if c.Config != nil && len(c.Config.MountUnits) > 0 {
+		files, units, err := mountUnitFiles(c.Config.MountUnits)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+		workload.EnabledServices = append(workload.EnabledServices, units...)
+	}
+	if c.Config != nil && c.Config.OSRelease != nil {
+		files, units, err := osReleaseFiles(c.Config.OSRelease)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+		workload.EnabledServices = append(workload.EnabledServices, units...)
+	}
+	if c.Config != nil && c.Config.SyslogForward != nil {
+		f, err := syslogForwardConfig(c.Config.SyslogForward)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+		workload.EnabledServices = append(workload.EnabledServices, rsyslogService)
+	}
+	if c.Config != nil && len(c.Config.CrypttabEntries) > 0 {
+		f, err := crypttabFile(c.Config.CrypttabEntries)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && c.Config.Skel != nil {
+		dirs, files, err := skelNodes(c.Config.Skel)
+		if err != nil {
+			return nil, err
+		}
+		img.Directories = append(img.Directories, dirs...)
+		img.Files = append(img.Files, files...)
+	}
+	if c.Config != nil && c.Config.EFIVendor != "" && !c.Config.EFIFallback {
+		return nil, fmt.Errorf("efi_vendor is only used with efi_fallback")
+	}
+	if c.Config != nil && c.Config.EFIFallback {
+		if _, _, err := efiFallbackPaths(c.Architecture, c.Config.efiVendor()); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.AutoUpdate != nil {
+		files, enabled, disabled, err := autoUpdateFiles(c.Config.AutoUpdate)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+		workload.EnabledServices = append(workload.EnabledServices, enabled...)
+		workload.DisabledServices = append(workload.DisabledServices, disabled...)
+	}
+	if c.Config != nil && c.Config.Motd != nil {
+		dir, files, units, err := motdNodes(c.Config.Motd)
+		if err != nil {
+			return nil, err
+		}
+		if dir != nil {
+			img.Directories = append(img.Directories, dir)
+		}
+		img.Files = append(img.Files, files...)
+		workload.EnabledServices = append(workload.EnabledServices, units...)
+	}
+	if c.Config != nil && c.Config.Bootupd != "" {
+		enabled, disabled, err := bootupdServices(c.Config.Bootupd, c.Architecture)
+		if err != nil {
+			return nil, err
+		}
+		workload.EnabledServices = append(workload.EnabledServices, enabled...)
+		workload.DisabledServices = append(workload.DisabledServices, disabled...)
+	}
+	img.Workload = workload
+
 	var imageFormat platform.ImageFormat
 	var filename string
 	switch c.ImgType {
-	case "qcow2":
+	case "qcow2", "vagrant-libvirt":
 		imageFormat = platform.FORMAT_QCOW2
 		filename = "disk.qcow2"
 	case "ami", "raw":
@@ -115,177 +480,500 @@ func manifestForDiskImage(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest
 		}
 	}
 
+	if c.Config != nil && c.Config.SELinuxMode != "" {
+		karg, err := selinuxMode(c.Config.SELinuxMode)
+		if err != nil {
+			return nil, err
+		}
+		img.KernelOptionsAppend = append(img.KernelOptionsAppend, karg)
+	}
+	if c.Config != nil && c.Config.EmbeddedRepo != nil {
+		f, err := embeddedRepoFile(c.Config.EmbeddedRepo)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && c.Config.DisableFirewall {
+		f, err := disableFirewall()
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && c.Config.DisableCloudInit {
+		files, err := disableCloudInit()
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+	}
+	if c.Config != nil && c.Config.GrowRootfs != nil && !*c.Config.GrowRootfs {
+		f, err := disableGrowRootfs()
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && c.Config.VConsole != nil {
+		f, err := vconsoleConfig(c.Config.VConsole)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && c.Config.Journald != nil {
+		f, err := journaldConfig(c.Config.Journald)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && len(c.Config.SSHHostKeys) > 0 {
+		files, err := sshHostKeyFiles(c.Config.SSHHostKeys)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+	}
+	if c.Config != nil && len(c.Config.GPGKeys) > 0 {
+		files, err := gpgKeyFiles(c.Config.GPGKeys)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+	}
+	if c.Config != nil && len(c.Config.RootSSHKeys) > 0 {
+		root, f, err := rootSSHKeys(c.Config.RootSSHKeys, img.Users)
+		if err != nil {
+			return nil, err
+		}
+		img.Users = append(img.Users, *root)
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && c.Config.DisableIPv6 {
+		f, karg, err := disableIPv6()
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+		img.KernelOptionsAppend = append(img.KernelOptionsAppend, karg)
+	}
+	if c.Config != nil && c.Config.ContainerStorage != nil {
+		f, err := containerStorageConfig(c.Config.ContainerStorage)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && c.Config.ContainerProxy != nil {
+		files, err := containerProxyFiles(c.Config.ContainerProxy)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+	}
+	if c.Config != nil && len(c.Config.DNSSearch) > 0 {
+		// both write the global-dns section of NetworkManager
+		if c.Config.NetworkManager != nil && c.Config.NetworkManager.GlobalDNS != nil {
+			return nil, fmt.Errorf("dns_search cannot be used with network_manager global_dns, use its searches instead")
+		}
+		files, err := dnsSearchConfig(c.Config.DNSSearch)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+	}
+	if c.Config != nil && c.Config.NetworkManager != nil {
+		files, err := networkManagerFiles(c.Config.NetworkManager)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+	}
+	if c.Config != nil && c.Config.Umask != "" {
+		files, err := umaskFiles(c.Config.Umask)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, files...)
+	}
+	if c.Config != nil && len(c.Config.DefaultLimits) > 0 {
+		f, err := defaultLimitsConfig(c.Config.DefaultLimits)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && len(c.Config.Sysusers) > 0 {
+		f, err := sysusersDropIn(c.Config.Sysusers)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && len(c.Config.Tmpfiles) > 0 {
+		f, err := tmpfilesDropIn(c.Config.Tmpfiles)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && len(c.Config.IOScheduler) > 0 {
+		f, err := ioSchedulerRules(c.Config.IOScheduler)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+	}
+	if c.Config != nil && c.Config.NetNaming != "" {
+		f, kargs, err := netNaming(c.Config.NetNaming)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			img.Files = append(img.Files, f)
+		}
+		img.KernelOptionsAppend = append(img.KernelOptionsAppend, kargs...)
+	}
+	if c.Config != nil && c.Config.NetworkRoot != nil {
+		f, kargs, err := networkRoot(c.Config.NetworkRoot)
+		if err != nil {
+			return nil, err
+		}
+		img.Files = append(img.Files, f)
+		img.KernelOptionsAppend = append(img.KernelOptionsAppend, kargs...)
+	}
+
 	if kopts := customizations.GetKernel(); kopts != nil && kopts.Append != "" {
 		img.KernelOptionsAppend = append(img.KernelOptionsAppend, kopts.Append)
 	}
 
-	basept, ok := partitionTables[c.Architecture.String()]
-	if !ok {
-		return nil, fmt.Errorf("pipelines: no partition tables defined for %s", c.Architecture)
-	}
-	pt, err := disk.NewPartitionTable(&basept, nil, DEFAULT_SIZE, disk.RawPartitioningMode, nil, rng)
+	pt, err := genPartitionTable(c, customizations, rng)
 	if err != nil {
 		return nil, err
 	}
 	img.PartitionTable = pt
+	c.PartitionTable = pt
+	if c.Config != nil {
+		if err := validateDataDisks(c.Config.DataDisks, pt); err != nil {
+			return nil, err
+		}
+		if err := validateOverlays(c.Config.Overlays); err != nil {
+			return nil, err
+		}
+		if err := validateEmbeddedRepo(c.Config.EmbeddedRepo); err != nil {
+			return nil, err
+		}
+		if c.Config.CryptoPolicy != "" {
+			if err := validateCryptoPolicy(c.Config.CryptoPolicy); err != nil {
+				return nil, err
+			}
+		}
+		if c.Config.OSTreeRemote != nil {
+			if err := c.Config.OSTreeRemote.validate(); err != nil {
+				return nil, err
+			}
+		}
+		if err := validateGrubUserConfig(c.Config.GrubUserConfig); err != nil {
+			return nil, err
+		}
+		if _, err := grubMenuLines(c.Config.GrubMenu); err != nil {
+			return nil, err
+		}
+	}
+	if tz := c.timezone(); tz != "" {
+		if err := validateTimezone(tz); err != nil {
+			return nil, err
+		}
+	}
 
 	img.Filename = filename
 
 	mf := manifest.New()
 	mf.Distro = manifest.DISTRO_FEDORA
 	runner := &runner.Linux{}
-	containerSources := []container.SourceSpec{
-		{
-			Source:    c.Imgref,
-			Name:      c.Imgref,
-			TLSVerify: &c.TLSVerify,
-		},
-	}
+	containerSources := []container.SourceSpec{c.containerSource()}
 	_, err = img.InstantiateManifestFromContainers(&mf, containerSources, runner, rng)
 
 	return &mf, err
 }
 
+const (
+	ISOVariantFull    = "full"
+	ISOVariantMinimal = "minimal"
+)
+
+func (c *ManifestConfig) isoVariant() string {
+	if c.Config == nil || c.Config.ISOVariant == "" {
+		return ISOVariantFull
+	}
+	return c.Config.ISOVariant
+}
+
+// anacondaFullPackages is the package set of the default installer, it
+// supports all installation methods (including graphical and VNC) and
+// contains a wide range of firmware.
+var anacondaFullPackages = []string{
+	"aajohan-comfortaa-fonts",
+	"abattis-cantarell-fonts",
+	"alsa-firmware",
+	"alsa-tools-firmware",
+	"anaconda",
+	"anaconda-dracut",
+	"anaconda-install-env-deps",
+	"anaconda-widgets",
+	"atheros-firmware",
+	"audit",
+	"bind-utils",
+	"bitmap-fangsongti-fonts",
+	"brcmfmac-firmware",
+	"bzip2",
+	"cryptsetup",
+	"curl",
+	"dbus-x11",
+	"dejavu-sans-fonts",
+	"dejavu-sans-mono-fonts",
+	"device-mapper-persistent-data",
+	"dmidecode",
+	"dnf",
+	"dracut-config-generic",
+	"dracut-network",
+	"efibootmgr",
+	"ethtool",
+	"fcoe-utils",
+	"ftp",
+	"gdb-gdbserver",
+	"gdisk",
+	"glibc-all-langpacks",
+	"gnome-kiosk",
+	"google-noto-sans-cjk-ttc-fonts",
+	"grub2-tools",
+	"grub2-tools-extra",
+	"grub2-tools-minimal",
+	"grubby",
+	"gsettings-desktop-schemas",
+	"hdparm",
+	"hexedit",
+	"hostname",
+	"initscripts",
+	"ipmitool",
+	"iwlwifi-dvm-firmware",
+	"iwlwifi-mvm-firmware",
+	"jomolhari-fonts",
+	"kbd",
+	"kbd-misc",
+	"kdump-anaconda-addon",
+	"kernel",
+	"khmeros-base-fonts",
+	"less",
+	"libblockdev-lvm-dbus",
+	"libibverbs",
+	"libreport-plugin-bugzilla",
+	"libreport-plugin-reportuploader",
+	"librsvg2",
+	"linux-firmware",
+	"lldpad",
+	"lsof",
+	"madan-fonts",
+	"mt-st",
+	"mtr",
+	"net-tools",
+	"nfs-utils",
+	"nm-connection-editor",
+	"nmap-ncat",
+	"nss-tools",
+	"openssh-clients",
+	"openssh-server",
+	"ostree",
+	"pciutils",
+	"perl-interpreter",
+	"pigz",
+	"plymouth",
+	"python3-pyatspi",
+	"rdma-core",
+	"realtek-firmware",
+	"rit-meera-new-fonts",
+	"rng-tools",
+	"rpcbind",
+	"rpm-ostree",
+	"rsync",
+	"rsyslog",
+	"selinux-policy-targeted",
+	"sg3_utils",
+	"sil-abyssinica-fonts",
+	"sil-padauk-fonts",
+	"smartmontools",
+	"spice-vdagent",
+	"strace",
+	"systemd",
+	"tar",
+	"tigervnc-server-minimal",
+	"tigervnc-server-module",
+	"udisks2",
+	"udisks2-iscsi",
+	"usbutils",
+	"vim-minimal",
+	"volume_key",
+	"wget",
+	"xfsdump",
+	"xfsprogs",
+	"xorg-x11-drivers",
+	"xorg-x11-fonts-misc",
+	"xorg-x11-server-Xorg",
+	"xorg-x11-xauth",
+	"xrdb",
+	"xz",
+}
+
+// anacondaMinimalPackages is the package set for installers that only
+// need to deploy the container via the embedded kickstart (text mode)
+// to hardware that does not need extra firmware. openssh-server is kept
+// so the installation can still be followed with inst.sshd.
+var anacondaMinimalPackages = []string{
+	"anaconda",
+	"anaconda-dracut",
+	"anaconda-install-env-deps",
+	"audit",
+	"bzip2",
+	"cryptsetup",
+	"curl",
+	"device-mapper-persistent-data",
+	"dmidecode",
+	"dracut-config-generic",
+	"dracut-network",
+	"efibootmgr",
+	"gdisk",
+	"grub2-tools",
+	"grub2-tools-extra",
+	"grub2-tools-minimal",
+	"grubby",
+	"hostname",
+	"kbd",
+	"kernel",
+	"less",
+	"libblockdev-lvm-dbus",
+	"openssh-clients",
+	"openssh-server",
+	"ostree",
+	"pigz",
+	"rpm-ostree",
+	"rsync",
+	"selinux-policy-targeted",
+	"systemd",
+	"tar",
+	"vim-minimal",
+	"xfsprogs",
+	"xz",
+}
+
 func manifestForISO(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest, error) {
 	if c.Imgref == "" {
 		return nil, fmt.Errorf("pipeline: no base image defined")
 	}
 
-	containerSource := container.SourceSpec{
-		Source:    c.Imgref,
-		Name:      c.Imgref,
-		TLSVerify: &c.TLSVerify,
+	if c.BuildInfo != nil {
+		return nil, fmt.Errorf("embedding build info is not supported for the iso image type")
+	}
+	if c.DiskSize != 0 {
+		return nil, fmt.Errorf("disk size is not supported for the iso image type")
+	}
+	if c.HostTimezone != "" {
+		return nil, fmt.Errorf("inheriting the host timezone is not supported for the iso image type")
+	}
+	if c.Config != nil {
+		if opts := c.Config.diskOnlyOptions(); len(opts) > 0 {
+			return nil, fmt.Errorf("%s not supported for the iso image type", strings.Join(opts, ", "))
+		}
 	}
 
+	containerSource := c.containerSource()
+
 	// The ref is not needed and will be removed from the ctor later
 	// in time
-	img := image.NewAnacondaContainerInstaller(containerSource, "")
+	img := &containerInstaller{
+		AnacondaContainerInstaller: image.NewAnacondaContainerInstaller(containerSource, ""),
+	}
 	img.SquashfsCompression = "zstd"
 
-	// TODO: Parametrize me!
+	// Anaconda uses the product name as the label for the UEFI boot
+	// entry of the installed system.
 	img.Product = "Fedora"
+	if c.Config != nil && c.Config.BootEntryLabel != nil {
+		if err := validateBootEntryLabel(*c.Config.BootEntryLabel); err != nil {
+			return nil, err
+		}
+		img.Product = *c.Config.BootEntryLabel
+	}
 
+	var packages []string
+	switch c.isoVariant() {
+	case ISOVariantFull:
+		packages = anacondaFullPackages
+	case ISOVariantMinimal:
+		packages = anacondaMinimalPackages
+	default:
+		return nil, fmt.Errorf("unsupported iso_variant %q, valid values are %q and %q", c.isoVariant(), ISOVariantFull, ISOVariantMinimal)
+	}
 	img.ExtraBasePackages = rpmmd.PackageSet{
-		Include: []string{
-			"aajohan-comfortaa-fonts",
-			"abattis-cantarell-fonts",
-			"alsa-firmware",
-			"alsa-tools-firmware",
-			"anaconda",
-			"anaconda-dracut",
-			"anaconda-install-env-deps",
-			"anaconda-widgets",
-			"atheros-firmware",
-			"audit",
-			"bind-utils",
-			"bitmap-fangsongti-fonts",
-			"brcmfmac-firmware",
-			"bzip2",
-			"cryptsetup",
-			"curl",
-			"dbus-x11",
-			"dejavu-sans-fonts",
-			"dejavu-sans-mono-fonts",
-			"device-mapper-persistent-data",
-			"dmidecode",
-			"dnf",
-			"dracut-config-generic",
-			"dracut-network",
-			"efibootmgr",
-			"ethtool",
-			"fcoe-utils",
-			"ftp",
-			"gdb-gdbserver",
-			"gdisk",
-			"glibc-all-langpacks",
-			"gnome-kiosk",
-			"google-noto-sans-cjk-ttc-fonts",
-			"grub2-tools",
-			"grub2-tools-extra",
-			"grub2-tools-minimal",
-			"grubby",
-			"gsettings-desktop-schemas",
-			"hdparm",
-			"hexedit",
-			"hostname",
-			"initscripts",
-			"ipmitool",
-			"iwlwifi-dvm-firmware",
-			"iwlwifi-mvm-firmware",
-			"jomolhari-fonts",
-			"kbd",
-			"kbd-misc",
-			"kdump-anaconda-addon",
-			"kernel",
-			"khmeros-base-fonts",
-			"less",
-			"libblockdev-lvm-dbus",
-			"libibverbs",
-			"libreport-plugin-bugzilla",
-			"libreport-plugin-reportuploader",
-			"librsvg2",
-			"linux-firmware",
-			"lldpad",
-			"lsof",
-			"madan-fonts",
-			"mt-st",
-			"mtr",
-			"net-tools",
-			"nfs-utils",
-			"nm-connection-editor",
-			"nmap-ncat",
-			"nss-tools",
-			"openssh-clients",
-			"openssh-server",
-			"ostree",
-			"pciutils",
-			"perl-interpreter",
-			"pigz",
-			"plymouth",
-			"python3-pyatspi",
-			"rdma-core",
-			"realtek-firmware",
-			"rit-meera-new-fonts",
-			"rng-tools",
-			"rpcbind",
-			"rpm-ostree",
-			"rsync",
-			"rsyslog",
-			"selinux-policy-targeted",
-			"sg3_utils",
-			"sil-abyssinica-fonts",
-			"sil-padauk-fonts",
-			"smartmontools",
-			"spice-vdagent",
-			"strace",
-			"systemd",
-			"tar",
-			"tigervnc-server-minimal",
-			"tigervnc-server-module",
-			"udisks2",
-			"udisks2-iscsi",
-			"usbutils",
-			"vim-minimal",
-			"volume_key",
-			"wget",
-			"xfsdump",
-			"xfsprogs",
-			"xorg-x11-drivers",
-			"xorg-x11-fonts-misc",
-			"xorg-x11-server-Xorg",
-			"xorg-x11-xauth",
-			"xrdb",
-			"xz",
-		},
+		Include: packages,
 	}
 
 	img.ISOLabelTempl = "Container-Installer-%s"
+	if c.Config != nil && c.Config.ISOLabel != nil {
+		if err := validateISOLabel(*c.Config.ISOLabel); err != nil {
+			return nil, err
+		}
+		// the template is formatted with the architecture, consume
+		// it without printing anything
+		img.ISOLabelTempl = *c.Config.ISOLabel + "%.0s"
+	}
+
+	if c.Config != nil && c.Config.ISOOptions != nil {
+		if err := validateISOOptions(c.Config.ISOOptions, c.Architecture); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.EULA != "" {
+		if _, err := readEULA(c.Config.EULA); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.InstallerPostScript != "" {
+		if err := validateInstallerPostScript(c.Config.InstallerPostScript); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Config != nil && len(c.Config.InstallerKernelArgs) > 0 {
+		if err := validateInstallerKernelArgs(c.Config.InstallerKernelArgs); err != nil {
+			return nil, err
+		}
+		img.KernelOpts = c.Config.InstallerKernelArgs
+	}
+	if c.Config != nil && (c.Config.InstallerLocale != "" || c.Config.InstallerKeyboard != "") {
+		kargs, err := installerLocaleKernelArgs(c.Config.InstallerLocale, c.Config.InstallerKeyboard, c.Config.InstallerKernelArgs)
+		if err != nil {
+			return nil, err
+		}
+		// do not append to the installer_kernel_args of the config
+		img.KernelOpts = append(img.KernelOpts[:len(img.KernelOpts):len(img.KernelOpts)], kargs...)
+	}
 
 	var customizations *blueprint.Customizations
 	if c.Config != nil && c.Config.Blueprint != nil {
 		customizations = c.Config.Blueprint.Customizations
 	}
 
+	if err := validateUserPasswords(customizations.GetUsers()); err != nil {
+		return nil, err
+	}
+	kernelName, err := installerKernelName(customizations)
+	if err != nil {
+		return nil, err
+	}
+	img.KernelName = kernelName
+	img.ExtraBasePackages.Include = withKernel(img.ExtraBasePackages.Include, img.KernelName)
 	img.Users = users.UsersFromBP(customizations.GetUsers())
 	img.Groups = users.GroupsFromBP(customizations.GetGroups())
 
@@ -313,7 +1001,7 @@ func manifestForISO(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest, erro
 	mf := manifest.New()
 	mf.Distro = manifest.DISTRO_FEDORA
 	runner := &runner.Fedora{Version: 39}
-	_, err := img.InstantiateManifest(&mf, c.Repos, runner, rng)
+	_, err = img.InstantiateManifest(&mf, c.Repos, runner, rng)
 	return &mf, err
 }
 
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// ImageTypeInfo describes an image type as listed by "list-types".
+// The sizes are in bytes, they are unset for image types without a
+// disk.
+type ImageTypeInfo struct {
+	Name            string `json:"name"`
+	DefaultDiskSize uint64 `json:"default_disk_size,omitempty"`
+	MinDiskSize     uint64 `json:"min_disk_size,omitempty"`
+}
+
+// minDiskSize is the smallest size that fits the partition table of
+// all architectures
+const minDiskSize = uint64(5 * GibiByte)
+
+// diskImageType returns the info of an image type with a disk. All of
+// them share the partition table, so they share the default and the
+// minimum disk size as well.
+func diskImageType(name string) ImageTypeInfo {
+	return ImageTypeInfo{Name: name, DefaultDiskSize: DEFAULT_SIZE, MinDiskSize: minDiskSize}
+}
+
+// imageTypes are the supported image types, the experimental ones are
+// not listed
+var imageTypes = []ImageTypeInfo{
+	diskImageType("qcow2"),
+	diskImageType("ami"),
+	diskImageType("raw"),
+	diskImageType("vagrant-libvirt"),
+	{Name: "anaconda-iso"},
+	{Name: "iso"},
+	{Name: "squashfs"},
+}
+
+func imageTypeInfo(name string) (*ImageTypeInfo, error) {
+	for i := range imageTypes {
+		if imageTypes[i].Name == name {
+			return &imageTypes[i], nil
+		}
+	}
+	if exp, ok := experimentalImageTypes[name]; ok {
+		return &exp.Info, nil
+	}
+	return nil, fmt.Errorf("unsupported image type %q", name)
+}
+
+// diskSize returns the size of the disk of the image, either the
+// default of the image type or the requested size.
+func (c *ManifestConfig) diskSize() (uint64, error) {
+	info, err := imageTypeInfo(c.ImgType)
+	if err != nil {
+		return 0, err
+	}
+	if info.DefaultDiskSize == 0 {
+		return 0, fmt.Errorf("image type %q has no disk, a disk size cannot be set", c.ImgType)
+	}
+	if c.DiskSize == 0 {
+		return info.DefaultDiskSize, nil
+	}
+	if c.DiskSize < info.MinDiskSize {
+		return 0, fmt.Errorf("disk size %d is too small for image type %q, the minimum is %d (%d GiB)", c.DiskSize, c.ImgType, info.MinDiskSize, info.MinDiskSize/GibiByte)
+	}
+	return c.DiskSize, nil
+}
+
+var diskSizeRegex = regexp.MustCompile(`^([0-9]+)\s*(MiB|GiB|TiB|MB|GB|TB)?$`)
+
+var diskSizeUnits = map[string]uint64{
+	"":    1,
+	"MB":  1000 * 1000,
+	"MiB": MebiByte,
+	"GB":  1000 * 1000 * 1000,
+	"GiB": GibiByte,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"TiB": 1024 * GibiByte,
+}
+
+// parseDiskSize parses a size in bytes with an optional unit suffix,
+// e.g. "20GiB"
+func parseDiskSize(s string) (uint64, error) {
+	m := diskSizeRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid disk size %q, expected a number with an optional unit (MiB, GiB, TiB, MB, GB, TB)", s)
+	}
+	n, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid disk size %q: %w", s, err)
+	}
+	unit := diskSizeUnits[m[2]]
+	if n > math.MaxUint64/unit {
+		return 0, fmt.Errorf("invalid disk size %q: value out of range", s)
+	}
+	return n * unit, nil
+}
+
+// listTypes writes the supported image types to w, one per line or
+// as JSON with their disk sizes. The experimental image types are not
+// listed.
+func listTypes(w io.Writer, asJSON bool) error {
+	if asJSON {
+		data, err := json.MarshalIndent(imageTypes, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	for _, info := range imageTypes {
+		if _, err := fmt.Fprintln(w, info.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
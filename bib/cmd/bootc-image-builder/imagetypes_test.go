@@ -0,0 +1,172 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/manifest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestListTypesJSON(t *testing.T) {
+	var buf bytes.Buffer
+	err := main.ListTypes(&buf, true)
+	require.NoError(t, err)
+
+	var types []main.ImageTypeInfo
+	err = json.Unmarshal(buf.Bytes(), &types)
+	require.NoError(t, err)
+
+	expected := []main.ImageTypeInfo{
+		{Name: "qcow2", DefaultDiskSize: 10 * main.GibiByte, MinDiskSize: 5 * main.GibiByte},
+		{Name: "ami", DefaultDiskSize: 10 * main.GibiByte, MinDiskSize: 5 * main.GibiByte},
+		{Name: "raw", DefaultDiskSize: 10 * main.GibiByte, MinDiskSize: 5 * main.GibiByte},
+		{Name: "vagrant-libvirt", DefaultDiskSize: 10 * main.GibiByte, MinDiskSize: 5 * main.GibiByte},
+		{Name: "anaconda-iso"},
+		{Name: "iso"},
+		{Name: "squashfs"},
+	}
+	assert.Equal(t, expected, types)
+}
+
+func TestListTypesPlain(t *testing.T) {
+	var buf bytes.Buffer
+	err := main.ListTypes(&buf, false)
+	require.NoError(t, err)
+	assert.Equal(t, "qcow2\nami\nraw\nvagrant-libvirt\nanaconda-iso\niso\nsquashfs\n", buf.String())
+}
+
+func TestDiskSizeDefaultPerType(t *testing.T) {
+	for _, imgType := range []string{"qcow2", "ami", "raw"} {
+		for _, arc := range []arch.Arch{arch.ARCH_X86_64, arch.ARCH_AARCH64} {
+			config := getBaseConfig()
+			config.ImgType = imgType
+			config.Architecture = arc
+			layout, err := main.GenPartitionLayout(config)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(10*main.GibiByte), layout.Size, "%s/%s", imgType, arc)
+		}
+	}
+}
+
+func TestDiskSizeOverride(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Architecture = arch.ARCH_X86_64
+	config.DiskSize = 20 * main.GibiByte
+	layout, err := main.GenPartitionLayout(config)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20*main.GibiByte), layout.Size)
+
+	// the minimum fits the partition table of all architectures
+	for _, arc := range []arch.Arch{arch.ARCH_X86_64, arch.ARCH_AARCH64} {
+		config.Architecture = arc
+		config.DiskSize = 5 * main.GibiByte
+		layout, err = main.GenPartitionLayout(config)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(5*main.GibiByte), layout.Size)
+	}
+}
+
+func TestDiskSizeTooSmall(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "raw"
+	config.Architecture = arch.ARCH_X86_64
+	config.DiskSize = 2 * main.GibiByte
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, `disk size 2147483648 is too small for image type "raw", the minimum is 5368709120 (5 GiB)`)
+}
+
+func TestDiskSizeISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Architecture = arch.ARCH_X86_64
+	config.DiskSize = 20 * main.GibiByte
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "disk size is not supported for the iso image type")
+}
+
+func TestParseDiskSize(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		expected uint64
+	}{
+		{"1073741824", 1 * main.GibiByte},
+		{"20GiB", 20 * main.GibiByte},
+		{"20 GiB", 20 * main.GibiByte},
+		{"512MiB", 512 * main.MebiByte},
+		{"1TiB", 1024 * main.GibiByte},
+		{"10GB", 10 * 1000 * 1000 * 1000},
+	} {
+		size, err := main.ParseDiskSize(tc.in)
+		require.NoError(t, err, tc.in)
+		assert.Equal(t, tc.expected, size, tc.in)
+	}
+
+	// the unit must not overflow the size
+	_, err := main.ParseDiskSize("16777216TiB")
+	assert.EqualError(t, err, `invalid disk size "16777216TiB": value out of range`)
+	size, err := main.ParseDiskSize("16777215TiB")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(16777215*1024*main.GibiByte), size)
+
+	for _, in := range []string{"", "GiB", "20G", "-1GiB", "1.5GiB", "18446744073709551616"} {
+		_, err := main.ParseDiskSize(in)
+		assert.ErrorContains(t, err, "invalid disk size", in)
+	}
+}
+
+func mockExperimentalQcow2(t *testing.T) {
+	restore := main.MockExperimentalImageTypes(map[string]main.ExperimentalImageType{
+		"qcow2-next": {
+			Info:   main.ImageTypeInfo{Name: "qcow2-next", DefaultDiskSize: main.DEFAULT_SIZE, MinDiskSize: 5 * main.GibiByte},
+			Export: "qcow2",
+			Manifest: func(c *main.ManifestConfig, rng *rand.Rand) (*manifest.Manifest, error) {
+				qcow2Config := *c
+				qcow2Config.ImgType = "qcow2"
+				return main.Manifest(&qcow2Config)
+			},
+		},
+	})
+	t.Cleanup(restore)
+}
+
+func TestManifestExperimentalImageType(t *testing.T) {
+	mockExperimentalQcow2(t)
+
+	config := getBaseConfig()
+	config.ImgType = "qcow2-next"
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, `Manifest(): image type "qcow2-next" is experimental, pass --allow-experimental to build it`)
+
+	config.AllowExperimental = true
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	stages, err := findStages(manifestJson, "qcow2", "org.osbuild.qemu")
+	require.NoError(t, err)
+	assert.Len(t, stages, 1)
+}
+
+func TestManifestExperimentalImageTypeNotListed(t *testing.T) {
+	mockExperimentalQcow2(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, main.ListTypes(&buf, false))
+	assert.NotContains(t, buf.String(), "qcow2-next")
+}
+
+func TestManifestUnknownImageTypeWithAllowExperimental(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2-next"
+	config.AllowExperimental = true
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, `Manifest(): unsupported image type "qcow2-next"`)
+}
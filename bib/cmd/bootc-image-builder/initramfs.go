@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/osbuild/images/pkg/manifest"
+)
+
+const (
+	// initramfsExport is the pipeline with the deployed tree that the
+	// initramfs is packed from after the build
+	initramfsExport = "ostree-deployment"
+
+	initramfsFilename = "initramfs.img"
+	initramfsKernel   = "vmlinuz"
+
+	// the file sizes of the newc format are 32 bit
+	initramfsMaxFileSize = 1<<32 - 1
+	// boot loaders and firmware often fail to load larger initramfs
+	// images, and the system needs more RAM than that to run
+	initramfsWarnSize = 2 * GibiByte
+)
+
+// registered in init() as the image types refer to the experimental
+// image types
+func init() {
+	experimentalImageTypes["initramfs"] = experimentalImageType{
+		Info:     ImageTypeInfo{Name: "initramfs"},
+		Export:   initramfsExport,
+		Manifest: manifestForInitramfs,
+	}
+}
+
+// manifestForInitramfs returns the manifest of the raw disk image, only
+// its ostree-deployment pipeline is built. The initramfs is packed
+// from the exported tree by writeInitramfs.
+//
+// TODO: osbuild has no stage that creates cpio archives, and the
+// kernel version of the container is unknown until the tree is deployed
+func manifestForInitramfs(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest, error) {
+	if c.DiskSize != 0 {
+		return nil, fmt.Errorf("disk size is not supported for the initramfs image type")
+	}
+	if c.Config != nil && len(c.Config.DataDisks) > 0 {
+		return nil, fmt.Errorf("data_disks not supported for the initramfs image type")
+	}
+	rawConfig := *c
+	rawConfig.ImgType = "raw"
+	return manifestForDiskImage(&rawConfig, rng)
+}
+
+// findDeployment returns the root of the only deployment of the
+// exported physical root and the kernel that it boots
+func findDeployment(treeDir string) (string, string, error) {
+	deployments, err := filepath.Glob(filepath.Join(treeDir, "ostree/deploy/*/deploy/*.[0-9]*"))
+	if err != nil {
+		return "", "", err
+	}
+	var roots []string
+	for _, d := range deployments {
+		// the origin files of the deployments are next to them
+		if info, err := os.Stat(d); err == nil && info.IsDir() {
+			roots = append(roots, d)
+		}
+	}
+	if len(roots) != 1 {
+		return "", "", fmt.Errorf("expected one deployment in %s, found %d", treeDir, len(roots))
+	}
+	kernels, err := filepath.Glob(filepath.Join(roots[0], "usr/lib/modules/*/vmlinuz"))
+	if err != nil {
+		return "", "", err
+	}
+	if len(kernels) != 1 {
+		return "", "", fmt.Errorf("expected one kernel in the deployment, found %d", len(kernels))
+	}
+	return roots[0], kernels[0], nil
+}
+
+// cpioWriter writes a cpio archive in the "newc" format that the
+// kernel unpacks initramfs images from
+type cpioWriter struct {
+	w   *bufio.Writer
+	ino uint32
+	// inodes maps the device and inode of files with more than one
+	// link to their inode in the archive
+	inodes map[[2]uint64]uint32
+	// size is the sum of the sizes of the files
+	size uint64
+}
+
+func (cw *cpioWriter) pad(n int64) error {
+	_, err := cw.w.Write(make([]byte, (4-n%4)%4))
+	return err
+}
+
+func (cw *cpioWriter) writeHeader(name string, mode, uid, gid uint32, mtime int64, size uint64) error {
+	cw.ino++
+	return cw.writeInodeHeader(name, cw.ino, 1, mode, uid, gid, mtime, size)
+}
+
+func (cw *cpioWriter) writeInodeHeader(name string, ino, nlink, mode, uid, gid uint32, mtime int64, size uint64) error {
+	// 110 bytes of header, the name and its NUL are padded to 4 bytes
+	hdr := fmt.Sprintf("070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		ino, mode, uid, gid, nlink, mtime, size, 0, 0, 0, 0, len(name)+1, 0)
+	if _, err := cw.w.WriteString(hdr + name + "\x00"); err != nil {
+		return err
+	}
+	return cw.pad(int64(len(hdr) + len(name) + 1))
+}
+
+func (cw *cpioWriter) writeData(name string, mode, uid, gid uint32, mtime int64, data io.Reader, size int64) error {
+	cw.ino++
+	return cw.writeInodeData(name, cw.ino, 1, mode, uid, gid, mtime, data, size)
+}
+
+// writeLink writes a regular file with more than one link. Its data is
+// only written with the first of its names, the kernel links the
+// following names with the same inode to the file of the first one.
+func (cw *cpioWriter) writeLink(name string, st *syscall.Stat_t, mtime int64, data io.Reader, size int64) error {
+	if cw.inodes == nil {
+		cw.inodes = make(map[[2]uint64]uint32)
+	}
+	key := [2]uint64{uint64(st.Dev), uint64(st.Ino)}
+	if ino, ok := cw.inodes[key]; ok {
+		return cw.writeInodeHeader(name, ino, uint32(st.Nlink), st.Mode, st.Uid, st.Gid, mtime, 0)
+	}
+	cw.ino++
+	cw.inodes[key] = cw.ino
+	return cw.writeInodeData(name, cw.ino, uint32(st.Nlink), st.Mode, st.Uid, st.Gid, mtime, data, size)
+}
+
+func (cw *cpioWriter) writeInodeData(name string, ino, nlink, mode, uid, gid uint32, mtime int64, data io.Reader, size int64) error {
+	if size > initramfsMaxFileSize {
+		return fmt.Errorf("%s is larger than the 4 GiB limit of the initramfs format", name)
+	}
+	if err := cw.writeInodeHeader(name, ino, nlink, mode, uid, gid, mtime, uint64(size)); err != nil {
+		return err
+	}
+	n, err := io.Copy(cw.w, data)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%s changed while the initramfs was written", name)
+	}
+	cw.size += uint64(size)
+	return cw.pad(size)
+}
+
+func (cw *cpioWriter) close() error {
+	if err := cw.writeHeader("TRAILER!!!", 0, 0, 0, 0, 0); err != nil {
+		return err
+	}
+	return cw.w.Flush()
+}
+
+// writeCpio writes the tree below root, the given files are replaced
+// and extra symlinks are added, both with paths relative to root
+func writeCpio(w io.Writer, root string, replace map[string][]byte, symlinks map[string]string) (uint64, error) {
+	cw := &cpioWriter{w: bufio.NewWriter(w)}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("cannot stat %s", path)
+		}
+		mode, uid, gid, mtime := st.Mode, st.Uid, st.Gid, info.ModTime().Unix()
+
+		switch {
+		case replace[name] != nil:
+			data := replace[name]
+			return cw.writeData(name, syscall.S_IFREG|0644, 0, 0, mtime, bytes.NewReader(data), int64(len(data)))
+		case info.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if st.Nlink > 1 {
+				return cw.writeLink(name, st, mtime, f, info.Size())
+			}
+			return cw.writeData(name, mode, uid, gid, mtime, f, info.Size())
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return cw.writeData(name, mode, uid, gid, mtime, bytes.NewReader([]byte(target)), int64(len(target)))
+		case info.IsDir():
+			return cw.writeHeader(name, mode, uid, gid, mtime, 0)
+		}
+		// devices, fifos and sockets are created at runtime
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for name, target := range symlinks {
+		if err := cw.writeData(name, syscall.S_IFLNK|0777, 0, 0, 0, bytes.NewReader([]byte(target)), int64(len(target))); err != nil {
+			return 0, err
+		}
+	}
+	return cw.size, cw.close()
+}
+
+// initramfsSELinuxConfig returns the SELinux config of the deployment
+// with an enforcing mode switched to permissive, or nil if there is
+// none. The newc format has no extended attributes, the files of the
+// initramfs are unlabeled and a relabel on boot is lost with the tmpfs
+// on the reboot that follows it.
+func initramfsSELinuxConfig(root string) ([]byte, error) {
+	content, err := os.ReadFile(filepath.Join(root, "etc/selinux/config"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	for idx, line := range lines {
+		if strings.TrimSpace(line) == "SELINUX=enforcing" {
+			lines[idx] = "SELINUX=permissive\n"
+		}
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+func copyKernel(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeInitramfs packs the deployment of the exported physical root
+// into a gzip compressed initramfs that the kernel runs the system
+// from, the kernel is copied next to it. The fstab is emptied as there
+// are no partitions, SELinux is permissive and /init starts systemd.
+// The size of the uncompressed files is returned.
+func writeInitramfs(outputDir, treeDir string) (uint64, error) {
+	root, kernel, err := findDeployment(treeDir)
+	if err != nil {
+		return 0, fmt.Errorf("cannot write initramfs: %w", err)
+	}
+	replace := map[string][]byte{"etc/fstab": {}}
+	selinuxConfig, err := initramfsSELinuxConfig(root)
+	if err != nil {
+		return 0, fmt.Errorf("cannot write initramfs: %w", err)
+	}
+	if selinuxConfig != nil {
+		replace["etc/selinux/config"] = selinuxConfig
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return 0, err
+	}
+	if err := copyKernel(filepath.Join(outputDir, initramfsKernel), kernel); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Create(filepath.Join(outputDir, initramfsFilename))
+	if err != nil {
+		return 0, err
+	}
+	zw := gzip.NewWriter(f)
+	symlinks := map[string]string{}
+	if _, err := os.Lstat(filepath.Join(root, "init")); os.IsNotExist(err) {
+		symlinks["init"] = "usr/lib/systemd/systemd"
+	}
+	size, err := writeCpio(zw, root, replace, symlinks)
+	if err != nil {
+		f.Close()
+		return 0, fmt.Errorf("cannot write initramfs: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return size, f.Close()
+}
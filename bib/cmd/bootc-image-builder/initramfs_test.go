@@ -0,0 +1,158 @@
+package main_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestInitramfs(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "initramfs"
+	config.AllowExperimental = true
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	// the initramfs is packed from the deployed tree
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.ostree.deploy.container")
+	require.NoError(t, err)
+	assert.Len(t, stages, 1)
+}
+
+func TestManifestInitramfsErrors(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "initramfs"
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, `Manifest(): image type "initramfs" is experimental, pass --allow-experimental to build it`)
+
+	config.AllowExperimental = true
+	config.DiskSize = 10 * main.GibiByte
+	_, err = main.Manifest(config)
+	assert.EqualError(t, err, "disk size is not supported for the initramfs image type")
+}
+
+type cpioEntry struct {
+	mode uint64
+	data string
+}
+
+// readCpio returns the entries of a "newc" cpio archive by name, names
+// of an inode with more than one link get the data of its first name
+// like the kernel links them
+func readCpio(t *testing.T, r io.Reader) map[string]cpioEntry {
+	pad := func(n int) {
+		_, err := io.CopyN(io.Discard, r, int64((4-n%4)%4))
+		require.NoError(t, err)
+	}
+	entries := make(map[string]cpioEntry)
+	links := make(map[uint64]string)
+	for {
+		hdr := make([]byte, 110)
+		_, err := io.ReadFull(r, hdr)
+		require.NoError(t, err)
+		require.Equal(t, "070701", string(hdr[:6]))
+		field := func(idx int) uint64 {
+			v, err := strconv.ParseUint(string(hdr[6+8*idx:14+8*idx]), 16, 32)
+			require.NoError(t, err)
+			return v
+		}
+		name := make([]byte, field(11))
+		_, err = io.ReadFull(r, name)
+		require.NoError(t, err)
+		pad(110 + len(name))
+		data := make([]byte, field(6))
+		_, err = io.ReadFull(r, data)
+		require.NoError(t, err)
+		pad(len(data))
+
+		n := string(bytes.TrimSuffix(name, []byte{0}))
+		if n == "TRAILER!!!" {
+			return entries
+		}
+		if field(4) > 1 {
+			if first, ok := links[field(0)]; ok {
+				require.Empty(t, data, "data of %s is written again for %s", first, n)
+				data = []byte(entries[first].data)
+			} else {
+				links[field(0)] = n
+			}
+		}
+		entries[n] = cpioEntry{mode: field(1), data: string(data)}
+	}
+}
+
+func TestWriteInitramfs(t *testing.T) {
+	treeDir := t.TempDir()
+	root := filepath.Join(treeDir, "ostree/deploy/default/deploy/0123abcd.0")
+	modules := filepath.Join(root, "usr/lib/modules/6.8.0-1.fc40.x86_64")
+	require.NoError(t, os.MkdirAll(modules, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(modules, "vmlinuz"), []byte("kernel"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc/fstab"), []byte("UUID=1234 / xfs defaults 0 0\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc/hostname"), []byte("bootc\n"), 0600))
+	// the data of hardlinks is only in the archive once
+	require.NoError(t, os.Link(filepath.Join(root, "etc/hostname"), filepath.Join(root, "etc/hostname.link")))
+	require.NoError(t, os.Symlink("../usr/lib/os-release", filepath.Join(root, "etc/os-release")))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc/selinux"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc/selinux/config"), []byte("SELINUX=enforcing\nSELINUXTYPE=mls\n"), 0644))
+	// the origin file of the deployment is not part of the initramfs
+	require.NoError(t, os.WriteFile(root+".origin", []byte("[origin]\n"), 0644))
+
+	outputDir := filepath.Join(t.TempDir(), "initramfs")
+	size, err := main.WriteInitramfs(outputDir, treeDir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len("kernel")+len("bootc\n")+len("SELINUX=permissive\nSELINUXTYPE=mls\n")+len("../usr/lib/os-release")+len("usr/lib/systemd/systemd")), size)
+
+	kernel, err := os.ReadFile(filepath.Join(outputDir, "vmlinuz"))
+	require.NoError(t, err)
+	assert.Equal(t, "kernel", string(kernel))
+
+	f, err := os.Open(filepath.Join(outputDir, "initramfs.img"))
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	entries := readCpio(t, zr)
+
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{
+		"etc", "etc/fstab", "etc/hostname", "etc/hostname.link", "etc/os-release", "etc/selinux", "etc/selinux/config", "init",
+		"usr", "usr/lib", "usr/lib/modules", "usr/lib/modules/6.8.0-1.fc40.x86_64",
+		"usr/lib/modules/6.8.0-1.fc40.x86_64/vmlinuz",
+	}, names)
+	// there are no partitions to mount
+	assert.Equal(t, cpioEntry{mode: 0100644, data: ""}, entries["etc/fstab"])
+	assert.Equal(t, cpioEntry{mode: 0100600, data: "bootc\n"}, entries["etc/hostname"])
+	assert.Equal(t, cpioEntry{mode: 0100600, data: "bootc\n"}, entries["etc/hostname.link"])
+	assert.Equal(t, cpioEntry{mode: 0120777, data: "../usr/lib/os-release"}, entries["etc/os-release"])
+	// the files are not labeled, SELinux cannot enforce
+	assert.Equal(t, cpioEntry{mode: 0100644, data: "SELINUX=permissive\nSELINUXTYPE=mls\n"}, entries["etc/selinux/config"])
+	assert.Equal(t, cpioEntry{mode: 0120777, data: "usr/lib/systemd/systemd"}, entries["init"])
+	assert.Equal(t, uint64(040755), entries["etc"].mode)
+}
+
+func TestWriteInitramfsErrors(t *testing.T) {
+	treeDir := t.TempDir()
+	_, err := main.WriteInitramfs(t.TempDir(), treeDir)
+	assert.EqualError(t, err, "cannot write initramfs: expected one deployment in "+treeDir+", found 0")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(treeDir, "ostree/deploy/default/deploy/0123abcd.0"), 0755))
+	_, err = main.WriteInitramfs(t.TempDir(), treeDir)
+	assert.EqualError(t, err, "cannot write initramfs: expected one kernel in the deployment, found 0")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// knownPipelineNames are the pipelines of the manifests of all image
+// types, any other pipeline was not generated by bootc-image-builder
+var knownPipelineNames = map[string]bool{
+	"build":               true,
+	"ostree-deployment":   true,
+	"image":               true,
+	"qcow2":               true,
+	dataDisksPipelineName: true,
+	espPipelineName:       true,
+	squashfsPipelineName:  true,
+	"anaconda-tree":       true,
+	"rootfs-image":        true,
+	"efiboot-tree":        true,
+	"bootiso-tree":        true,
+	"bootiso":             true,
+}
+
+// InspectedPipeline is a pipeline of an inspected manifest with the
+// types of its stages
+type InspectedPipeline struct {
+	Name   string   `json:"name"`
+	Build  string   `json:"build,omitempty"`
+	Stages []string `json:"stages"`
+}
+
+// ManifestInspection is the structure of a manifest and the problems
+// that were found in it
+type ManifestInspection struct {
+	Version   string              `json:"version"`
+	Pipelines []InspectedPipeline `json:"pipelines"`
+	Sources   []string            `json:"sources"`
+	Warnings  []string            `json:"warnings,omitempty"`
+}
+
+// inspectManifest parses the osbuild manifest and checks its pipelines.
+// Only a manifest that cannot be parsed is an error, everything else
+// is reported as a warning.
+func inspectManifest(data []byte) (*ManifestInspection, error) {
+	var raw rawManifest
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest: %w", err)
+	}
+	if raw.Version != "2" {
+		return nil, fmt.Errorf("unsupported manifest version %q, expected \"2\"", raw.Version)
+	}
+
+	mi := &ManifestInspection{
+		Version:   raw.Version,
+		Pipelines: []InspectedPipeline{},
+		Sources:   []string{},
+	}
+	seen := make(map[string]bool)
+	for idx, data := range raw.Pipelines {
+		var pl rawPipeline
+		if err := json.Unmarshal(data, &pl); err != nil {
+			return nil, fmt.Errorf("cannot parse pipeline %d: %w", idx, err)
+		}
+		inspected := InspectedPipeline{Name: pl.Name, Build: pl.Build, Stages: []string{}}
+		for _, st := range pl.Stages {
+			var typ string
+			if err := json.Unmarshal(st["type"], &typ); err != nil {
+				return nil, fmt.Errorf("cannot parse stage of pipeline %q: %w", pl.Name, err)
+			}
+			inspected.Stages = append(inspected.Stages, typ)
+		}
+
+		switch {
+		case seen[pl.Name]:
+			mi.Warnings = append(mi.Warnings, fmt.Sprintf("duplicate pipeline name %q", pl.Name))
+		case !knownPipelineNames[pl.Name]:
+			mi.Warnings = append(mi.Warnings, fmt.Sprintf("unknown pipeline name %q", pl.Name))
+		}
+		// osbuild runs the pipelines in order, the build pipeline must
+		// come first
+		if build := strings.TrimPrefix(pl.Build, "name:"); build != pl.Build && !seen[build] {
+			mi.Warnings = append(mi.Warnings, fmt.Sprintf("pipeline %q uses the build pipeline %q that is not defined before it", pl.Name, build))
+		}
+		seen[pl.Name] = true
+		mi.Pipelines = append(mi.Pipelines, inspected)
+	}
+
+	if len(raw.Sources) > 0 {
+		var sources map[string]json.RawMessage
+		if err := json.Unmarshal(raw.Sources, &sources); err != nil {
+			return nil, fmt.Errorf("cannot parse sources: %w", err)
+		}
+		for name := range sources {
+			mi.Sources = append(mi.Sources, name)
+		}
+		sort.Strings(mi.Sources)
+	}
+	return mi, nil
+}
+
+// writeManifestInspection writes the pipelines with their stages as a
+// tree, followed by the warnings, or everything as JSON
+func writeManifestInspection(w io.Writer, mi *ManifestInspection, asJSON bool) error {
+	if asJSON {
+		data, err := json.MarshalIndent(mi, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "manifest version %s\n", mi.Version)
+	for _, pl := range mi.Pipelines {
+		if pl.Build != "" {
+			fmt.Fprintf(&b, "%s (build: %s)\n", pl.Name, strings.TrimPrefix(pl.Build, "name:"))
+		} else {
+			fmt.Fprintf(&b, "%s\n", pl.Name)
+		}
+		for idx, typ := range pl.Stages {
+			branch := "├─"
+			if idx == len(pl.Stages)-1 {
+				branch = "└─"
+			}
+			fmt.Fprintf(&b, "  %s %s\n", branch, typ)
+		}
+	}
+	if len(mi.Sources) > 0 {
+		fmt.Fprintf(&b, "sources: %s\n", strings.Join(mi.Sources, ", "))
+	}
+	for _, warning := range mi.Warnings {
+		fmt.Fprintf(&b, "WARNING: %s\n", warning)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
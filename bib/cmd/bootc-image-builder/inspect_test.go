@@ -0,0 +1,98 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestInspectManifest(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	mi, err := main.InspectManifest(manifestJson)
+	require.NoError(t, err)
+	assert.Empty(t, mi.Warnings)
+	var names []string
+	for _, pl := range mi.Pipelines {
+		names = append(names, pl.Name)
+	}
+	assert.Equal(t, []string{"build", "ostree-deployment", "image", "qcow2"}, names)
+	assert.Equal(t, "name:build", mi.Pipelines[2].Build)
+	assert.Equal(t, []string{"org.osbuild.qemu"}, mi.Pipelines[3].Stages)
+	assert.Equal(t, []string{"org.osbuild.inline", "org.osbuild.skopeo"}, mi.Sources)
+
+	var buf bytes.Buffer
+	require.NoError(t, main.WriteManifestInspection(&buf, mi, false))
+	assert.Contains(t, buf.String(), "manifest version 2\n")
+	assert.Contains(t, buf.String(), "image (build: build)\n  ├─ org.osbuild.truncate\n")
+	assert.Contains(t, buf.String(), "qcow2\n  └─ org.osbuild.qemu\n")
+	assert.NotContains(t, buf.String(), "WARNING")
+}
+
+func TestInspectManifestWarnings(t *testing.T) {
+	manifestJson := []byte(`{
+		"version": "2",
+		"pipelines": [
+			{"name": "build", "stages": [{"type": "org.osbuild.rpm"}]},
+			{"name": "image", "build": "name:build", "stages": [{"type": "org.osbuild.truncate"}, {"type": "org.osbuild.sfdisk"}]},
+			{"name": "image", "build": "name:build"},
+			{"name": "extra", "build": "name:later"}
+		],
+		"sources": {}
+	}`)
+	mi, err := main.InspectManifest(manifestJson)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`duplicate pipeline name "image"`,
+		`unknown pipeline name "extra"`,
+		`pipeline "extra" uses the build pipeline "later" that is not defined before it`,
+	}, mi.Warnings)
+
+	var buf bytes.Buffer
+	require.NoError(t, main.WriteManifestInspection(&buf, mi, false))
+	assert.Equal(t, `manifest version 2
+build
+  └─ org.osbuild.rpm
+image (build: build)
+  ├─ org.osbuild.truncate
+  └─ org.osbuild.sfdisk
+image (build: build)
+extra (build: later)
+WARNING: duplicate pipeline name "image"
+WARNING: unknown pipeline name "extra"
+WARNING: pipeline "extra" uses the build pipeline "later" that is not defined before it
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, main.WriteManifestInspection(&buf, mi, true))
+	var asJSON main.ManifestInspection
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &asJSON))
+	assert.Equal(t, *mi, asJSON)
+}
+
+func TestInspectManifestErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		manifest string
+		err      string
+	}{
+		"not-json":    {`pipelines`, "cannot parse manifest: invalid character 'p' looking for beginning of value"},
+		"version":     {`{"version": "1", "pipelines": []}`, `unsupported manifest version "1", expected "2"`},
+		"bad-stage":   {`{"version": "2", "pipelines": [{"name": "build", "stages": [{"type": 1}]}]}`, `cannot parse stage of pipeline "build": json: cannot unmarshal number`},
+		"bad-sources": {`{"version": "2", "pipelines": [], "sources": []}`, "cannot parse sources: json: cannot unmarshal array"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := main.InspectManifest([]byte(tc.manifest))
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// locales like "de_DE.UTF-8", "sr_RS@latin" or "fil_PH"
+var installerLocaleRegex = regexp.MustCompile(`^[a-z]{2,3}(_[A-Z]{2})?(\.(UTF-8|utf8))?(@[a-z]+)?$`)
+
+// installerLocaleKernelArgs returns the kernel arguments that set the
+// language and the keyboard layout of the installer. The kickstart is
+// not changed, it configures the installed system.
+func installerLocaleKernelArgs(locale, keyboard string, kernelArgs []string) ([]string, error) {
+	var kargs []string
+	if locale != "" {
+		if !installerLocaleRegex.MatchString(locale) {
+			return nil, fmt.Errorf("invalid installer_locale %q, expected a locale like \"de_DE.UTF-8\"", locale)
+		}
+		kargs = append(kargs, "inst.lang="+locale)
+	}
+	if keyboard != "" {
+		// the same names as the console keymaps
+		if !vconsoleNameRegex.MatchString(keyboard) {
+			return nil, fmt.Errorf("invalid installer_keyboard %q, expected a keymap like \"de-nodeadkeys\"", keyboard)
+		}
+		kargs = append(kargs, "inst.keymap="+keyboard)
+	}
+	for _, arg := range kernelArgs {
+		for _, karg := range kargs {
+			name, _, _ := strings.Cut(karg, "=")
+			if strings.HasPrefix(arg, name+"=") {
+				return nil, fmt.Errorf("installer kernel argument %q conflicts with %q", arg, karg)
+			}
+		}
+	}
+	return kargs, nil
+}
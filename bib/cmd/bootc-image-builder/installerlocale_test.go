@@ -0,0 +1,79 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestInstallerLocale(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Architecture = arch.ARCH_X86_64
+	config.Config = &main.BuildConfig{
+		InstallerLocale:     "de_DE.UTF-8",
+		InstallerKeyboard:   "de-nodeadkeys",
+		InstallerKernelArgs: []string{"inst.sshd"},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(getISOPackages(), getISOContainers(), nil)
+	require.NoError(t, err)
+
+	expected := []string{"inst.sshd", "inst.lang=de_DE.UTF-8", "inst.keymap=de-nodeadkeys"}
+	var bootOpts struct {
+		Kernel struct {
+			Opts []string `json:"opts"`
+		} `json:"kernel"`
+	}
+	for _, loader := range []struct{ pipeline, stage string }{
+		{"efiboot-tree", "org.osbuild.grub2.iso"},
+		{"bootiso-tree", "org.osbuild.isolinux"},
+	} {
+		stages, err := findStages(manifestJson, loader.pipeline, loader.stage)
+		require.NoError(t, err)
+		require.Len(t, stages, 1)
+		require.NoError(t, json.Unmarshal(stages[0].Options, &bootOpts))
+		assert.Subset(t, bootOpts.Kernel.Opts, expected)
+	}
+	// the config is not changed
+	assert.Equal(t, []string{"inst.sshd"}, config.Config.InstallerKernelArgs)
+
+	// the installed system keeps the defaults of the kickstart
+	stages, err := findStages(manifestJson, "bootiso-tree", "org.osbuild.kickstart")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var kickstart struct {
+		Lang     string `json:"lang"`
+		Keyboard string `json:"keyboard"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &kickstart))
+	assert.Equal(t, "en_US.UTF-8", kickstart.Lang)
+	assert.Equal(t, "us", kickstart.Keyboard)
+}
+
+func TestManifestInstallerLocaleErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		config  main.BuildConfig
+		err     string
+	}{
+		"bad-locale":   {"iso", main.BuildConfig{InstallerLocale: "german"}, `invalid installer_locale "german", expected a locale like "de_DE.UTF-8"`},
+		"bad-keyboard": {"iso", main.BuildConfig{InstallerKeyboard: "de nodeadkeys"}, `invalid installer_keyboard "de nodeadkeys", expected a keymap like "de-nodeadkeys"`},
+		"conflict":     {"iso", main.BuildConfig{InstallerLocale: "de_DE.UTF-8", InstallerKernelArgs: []string{"inst.lang=fr_FR.UTF-8"}}, `installer kernel argument "inst.lang=fr_FR.UTF-8" conflicts with "inst.lang=de_DE.UTF-8"`},
+		"disk":         {"qcow2", main.BuildConfig{InstallerLocale: "de_DE.UTF-8", InstallerKeyboard: "de"}, "installer_locale, installer_keyboard only supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &tc.config
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// validateInstallerPostScript checks that the script can be embedded
+// as a %post section, section markers would end it early
+func validateInstallerPostScript(script string) error {
+	if strings.TrimSpace(script) == "" {
+		return fmt.Errorf("installer_post_script must not be empty")
+	}
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "%") {
+			return fmt.Errorf("installer_post_script line %q must not start a kickstart section", line)
+		}
+	}
+	return nil
+}
+
+// installerPostScriptConflicts returns the lines of the script that
+// interfere with the sections of the generated kickstart, which
+// switches the installed system to the container image and reboots
+func installerPostScriptConflicts(script string) []string {
+	var conflicts []string
+	for _, line := range strings.Split(script, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case len(fields) > 1 && fields[0] == "bootc" && fields[1] == "switch":
+			conflicts = append(conflicts, strings.TrimSpace(line))
+		case fields[0] == "reboot" || fields[0] == "poweroff" || fields[0] == "halt" || fields[0] == "shutdown":
+			conflicts = append(conflicts, strings.TrimSpace(line))
+		}
+	}
+	return conflicts
+}
+
+// installerPostSection returns the %post section of the script, it
+// runs in the chroot of the installed system after the generated one
+func installerPostSection(script string) string {
+	if !strings.HasSuffix(script, "\n") {
+		script += "\n"
+	}
+	return "%post --erroronfail\n" + script + "%end\n"
+}
+
+// readInlineFile returns the content of the file that a copy stage of
+// the given pipeline writes from the inline source
+func readInlineFile(m *serializedManifest, plName, path string) (string, error) {
+	// a later copy overwrites the file, use the last one
+	var checksum string
+	for _, pl := range m.Pipelines {
+		if pl.Name != plName {
+			continue
+		}
+		for _, st := range pl.Stages {
+			typ, err := stageTypeOf(st)
+			if err != nil {
+				return "", err
+			}
+			if typ != "org.osbuild.copy" {
+				continue
+			}
+			var opts osbuild.CopyStageOptions
+			if err := json.Unmarshal(st["options"], &opts); err != nil {
+				return "", err
+			}
+			for _, p := range opts.Paths {
+				if p.To == "tree://"+path {
+					checksum = p.From[strings.LastIndex(p.From, "/")+1:]
+				}
+			}
+		}
+	}
+	if checksum == "" {
+		return "", fmt.Errorf("no file %q found in pipeline %q", path, plName)
+	}
+	var inline osbuild.InlineSource
+	if source := m.Sources["org.osbuild.inline"]; source != nil {
+		if err := json.Unmarshal(source, &inline); err != nil {
+			return "", err
+		}
+	}
+	item, ok := inline.Items[checksum]
+	if !ok {
+		return "", fmt.Errorf("no inline source for %q", checksum)
+	}
+	data, err := base64.StdEncoding.DecodeString(item.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// addInstallerPostScript appends the script as a %post section to the
+// kickstart of the installer ISO.
+//
+// TODO: osbuild/images cannot add sections to the kickstart of the
+// container installer
+func addInstallerPostScript(m *serializedManifest, script string) error {
+	ks, err := readInlineFile(m, "bootiso-tree", osbuild.KickstartPathOSBuild)
+	if err != nil {
+		return fmt.Errorf("cannot add installer post script: %w", err)
+	}
+	if !strings.HasSuffix(ks, "\n") {
+		ks += "\n"
+	}
+	content := ks + installerPostSection(script)
+	f, err := fsnode.NewFile(osbuild.KickstartPathOSBuild, nil, nil, nil, []byte(content))
+	if err != nil {
+		return err
+	}
+
+	err = m.updateSource("org.osbuild.inline", func(source json.RawMessage) (json.RawMessage, error) {
+		inline := osbuild.NewInlineSource()
+		if source != nil {
+			if err := json.Unmarshal(source, inline); err != nil {
+				return nil, err
+			}
+		}
+		inline.AddItem(content)
+		return json.Marshal(inline)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add installer post script: %w", err)
+	}
+
+	// the copy replaces the generated kickstart
+	err = m.appendStages("bootiso-tree", func([]map[string]json.RawMessage) ([]*osbuild.Stage, error) {
+		return osbuild.GenFileNodesStages([]*fsnode.File{f}), nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add installer post script: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestInstallerPostScript(t *testing.T) {
+	script := "echo installed > /etc/installed-by\nsystemctl enable example.service"
+
+	config := getBaseConfig()
+	config.ImgType = "anaconda-iso"
+	config.Config = &main.BuildConfig{InstallerPostScript: script}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(getISOPackages(), getISOContainers(), nil)
+	require.NoError(t, err)
+	generated, err := findFileContent(manifestJson, "bootiso-tree", "/osbuild.ks")
+	require.NoError(t, err)
+
+	manifestJson, err = main.AddInstallerPostScript(manifestJson, script)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "bootiso-tree", "/osbuild.ks")
+	require.NoError(t, err)
+	// the generated sections are kept and the script comes last
+	assert.True(t, strings.HasPrefix(content, generated))
+	assert.True(t, strings.HasSuffix(content, "%post --erroronfail\n"+script+"\n%end\n"))
+	assert.Contains(t, content, "bootc switch")
+}
+
+func TestManifestInstallerPostScriptErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		script  string
+		err     string
+	}{
+		"blank":   {"anaconda-iso", " \n\t", "installer_post_script must not be empty"},
+		"end":     {"anaconda-iso", "echo hi\n%end\nrm -rf /", `installer_post_script line "%end" must not start a kickstart section`},
+		"section": {"anaconda-iso", "  %pre\necho hi", `installer_post_script line "  %pre" must not start a kickstart section`},
+		"disk":    {"qcow2", "echo hi", "installer_post_script only supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{InstallerPostScript: tc.script}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestInstallerPostScriptConflicts(t *testing.T) {
+	for name, tc := range map[string]struct {
+		script    string
+		conflicts []string
+	}{
+		"none":   {"echo reboot\nbootc status", nil},
+		"switch": {"  bootc switch quay.io/example/os:latest", []string{"bootc switch quay.io/example/os:latest"}},
+		"reboot": {"sync\nreboot\npoweroff -f", []string{"reboot", "poweroff -f"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.conflicts, main.InstallerPostScriptConflicts(tc.script))
+		})
+	}
+}
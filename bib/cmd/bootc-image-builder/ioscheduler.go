@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// the rules run after 60-block-scheduler.rules of systemd, which sets
+// the scheduler of some devices
+const ioSchedulerRulePath = "/etc/udev/rules.d/61-bootc-image-builder-io-scheduler.rules"
+
+// ioSchedulers are the multiqueue I/O schedulers of the kernel
+var ioSchedulers = []string{"bfq", "kyber", "mq-deadline", "none"}
+
+// ioSchedulerDeviceClasses are the udev matches of the device classes,
+// only whole disks are matched as partitions have no scheduler
+var ioSchedulerDeviceClasses = map[string]string{
+	"nvme":   `KERNEL=="nvme[0-9]*n[0-9]*"`,
+	"ssd":    `KERNEL=="sd[a-z]*", ATTR{queue/rotational}=="0"`,
+	"hdd":    `KERNEL=="sd[a-z]*", ATTR{queue/rotational}=="1"`,
+	"virtio": `KERNEL=="vd[a-z]*"`,
+}
+
+// ioSchedulerRules returns the udev rules that set the I/O scheduler
+// of the devices, the keys are device classes like "nvme"
+func ioSchedulerRules(schedulers map[string]string) (*fsnode.File, error) {
+	classes := make([]string, 0, len(schedulers))
+	for class := range schedulers {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	var content strings.Builder
+	for _, class := range classes {
+		match, ok := ioSchedulerDeviceClasses[class]
+		if !ok {
+			valid := make([]string, 0, len(ioSchedulerDeviceClasses))
+			for c := range ioSchedulerDeviceClasses {
+				valid = append(valid, c)
+			}
+			sort.Strings(valid)
+			return nil, fmt.Errorf("unsupported io_scheduler device class %q, valid values are %q", class, valid)
+		}
+		scheduler := schedulers[class]
+		valid := false
+		for _, s := range ioSchedulers {
+			if scheduler == s {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unsupported io_scheduler %q for %s, valid values are %q", scheduler, class, ioSchedulers)
+		}
+		fmt.Fprintf(&content, "ACTION==\"add|change\", SUBSYSTEM==\"block\", ENV{DEVTYPE}==\"disk\", %s, ATTR{queue/scheduler}=\"%s\"\n", match, scheduler)
+	}
+	return fsnode.NewFile(ioSchedulerRulePath, nil, nil, nil, []byte(content.String()))
+}
@@ -0,0 +1,49 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestIOScheduler(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{IOScheduler: map[string]string{
+		"nvme": "none",
+		"hdd":  "mq-deadline",
+	}}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/udev/rules.d/61-bootc-image-builder-io-scheduler.rules")
+	require.NoError(t, err)
+	assert.Equal(t, `ACTION=="add|change", SUBSYSTEM=="block", ENV{DEVTYPE}=="disk", KERNEL=="sd[a-z]*", ATTR{queue/rotational}=="1", ATTR{queue/scheduler}="mq-deadline"
+ACTION=="add|change", SUBSYSTEM=="block", ENV{DEVTYPE}=="disk", KERNEL=="nvme[0-9]*n[0-9]*", ATTR{queue/scheduler}="none"
+`, content)
+}
+
+func TestManifestIOSchedulerErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType    string
+		schedulers map[string]string
+		err        string
+	}{
+		"bad-class":     {"qcow2", map[string]string{"scsi": "none"}, `unsupported io_scheduler device class "scsi", valid values are ["hdd" "nvme" "ssd" "virtio"]`},
+		"bad-scheduler": {"qcow2", map[string]string{"nvme": "deadline"}, `unsupported io_scheduler "deadline" for nvme, valid values are ["bfq" "kyber" "mq-deadline" "none"]`},
+		"iso":           {"iso", map[string]string{"nvme": "none"}, "io_scheduler not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{IOScheduler: tc.schedulers}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const disableIPv6SysctlPath = "/etc/sysctl.d/90-disable-ipv6.conf"
+
+// disableIPv6 returns the sysctl drop-in and the kernel argument that
+// disable IPv6. The kernel argument keeps the ipv6 module from
+// initializing at all, the sysctl drop-in also covers the case where
+// the kernel argument is removed later.
+func disableIPv6() (*fsnode.File, string, error) {
+	content := "net.ipv6.conf.all.disable_ipv6 = 1\nnet.ipv6.conf.default.disable_ipv6 = 1\n"
+	f, err := fsnode.NewFile(disableIPv6SysctlPath, nil, nil, nil, []byte(content))
+	if err != nil {
+		return nil, "", err
+	}
+	return f, "ipv6.disable=1", nil
+}
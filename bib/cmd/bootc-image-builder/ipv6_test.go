@@ -0,0 +1,55 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestDisableIPv6(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{DisableIPv6: true}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/sysctl.d/90-disable-ipv6.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "net.ipv6.conf.all.disable_ipv6 = 1\nnet.ipv6.conf.default.disable_ipv6 = 1\n", content)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.ostree.deploy.container")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var deploy struct {
+		KernelOpts []string `json:"kernel_opts"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &deploy))
+	assert.Contains(t, deploy.KernelOpts, "ipv6.disable=1")
+}
+
+func TestManifestDisableIPv6Default(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	_, err = findFileContent(manifestJson, "ostree-deployment", "/etc/sysctl.d/90-disable-ipv6.conf")
+	assert.ErrorContains(t, err, "not found")
+	assert.NotContains(t, string(manifestJson), "ipv6.disable=1")
+}
+
+func TestManifestDisableIPv6ISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{DisableIPv6: true}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "disable_ipv6 not supported for the iso image type")
+}
@@ -0,0 +1,180 @@
+package main_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func anacondaTreePackages(t *testing.T, variant string) []string {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{ISOVariant: variant}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+
+	chain := mf.GetPackageSetChains()["anaconda-tree"]
+	require.Len(t, chain, 1)
+	return chain[0].Include
+}
+
+func TestManifestISOVariant(t *testing.T) {
+	defaultPkgs := anacondaTreePackages(t, "")
+	fullPkgs := anacondaTreePackages(t, "full")
+	minimalPkgs := anacondaTreePackages(t, "minimal")
+
+	assert.Equal(t, defaultPkgs, fullPkgs)
+	assert.Less(t, len(minimalPkgs), len(fullPkgs))
+	// the minimal variant is a subset of the full one
+	assert.Subset(t, fullPkgs, minimalPkgs)
+	for _, pkg := range []string{"kernel", "anaconda", "rpm-ostree", "openssh-server"} {
+		assert.Contains(t, minimalPkgs, pkg)
+	}
+	for _, pkg := range []string{"linux-firmware", "xorg-x11-server-Xorg", "tigervnc-server-minimal"} {
+		assert.Contains(t, fullPkgs, pkg)
+		assert.NotContains(t, minimalPkgs, pkg)
+	}
+
+	// the pipeline still serializes with the minimal package set
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{ISOVariant: "minimal"}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	_, err = mf.Serialize(getISOPackages(), getISOContainers(), nil)
+	require.NoError(t, err)
+}
+
+func TestManifestISOVariantErrors(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{ISOVariant: "tiny"}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, `unsupported iso_variant "tiny", valid values are "full" and "minimal"`)
+
+	config.ImgType = "raw"
+	config.Config = &main.BuildConfig{ISOVariant: "minimal"}
+	_, err = main.Manifest(config)
+	assert.EqualError(t, err, "iso_variant only supported for the iso image type")
+}
+
+func isoVolID(t *testing.T, config *main.ManifestConfig) string {
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(getISOPackages(), getISOContainers(), nil)
+	require.NoError(t, err)
+
+	stages, err := findStages(manifestJson, "bootiso", "org.osbuild.xorrisofs")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var xorrisofs struct {
+		VolID string `json:"volid"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &xorrisofs))
+	return xorrisofs.VolID
+}
+
+func TestManifestISOLabel(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Architecture = arch.ARCH_X86_64
+	assert.Equal(t, "Container-Installer-x86_64", isoVolID(t, config))
+
+	label := "ACME-Appliance-1.0"
+	config.Config = &main.BuildConfig{ISOLabel: &label}
+	assert.Equal(t, label, isoVolID(t, config))
+}
+
+func TestManifestISOLabelErrors(t *testing.T) {
+	for _, tc := range []struct {
+		imgType string
+		label   string
+		err     string
+	}{
+		{"iso", "", "iso label must not be empty"},
+		{"iso", "ACME-Appliance-Installer-x86_64-1", `iso label "ACME-Appliance-Installer-x86_64-1" is too long (33 characters), the maximum is 32`},
+		{"iso", "ACME Appliance", `iso label "ACME Appliance" must only contain ASCII letters, digits, '-', '.' and '_'`},
+		{"iso", "ACME%s", `iso label "ACME%s" must only contain ASCII letters, digits, '-', '.' and '_'`},
+		{"qcow2", "ACME", "iso_label only supported for the iso image type"},
+	} {
+		t.Run(tc.imgType+"-"+tc.label, func(t *testing.T) {
+			label := tc.label
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{ISOLabel: &label}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestManifestInstallerKernelArgs(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Architecture = arch.ARCH_X86_64
+	config.Config = &main.BuildConfig{
+		InstallerKernelArgs: []string{"inst.sshd", "inst.text"},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(getISOPackages(), getISOContainers(), nil)
+	require.NoError(t, err)
+
+	var bootOpts struct {
+		Kernel struct {
+			Opts []string `json:"opts"`
+		} `json:"kernel"`
+	}
+	// UEFI
+	stages, err := findStages(manifestJson, "efiboot-tree", "org.osbuild.grub2.iso")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	require.NoError(t, json.Unmarshal(stages[0].Options, &bootOpts))
+	assert.Subset(t, bootOpts.Kernel.Opts, []string{"inst.sshd", "inst.text"})
+	assert.Contains(t, bootOpts.Kernel.Opts, "inst.ks=hd:LABEL=Container-Installer-x86_64:/osbuild.ks")
+	// BIOS
+	stages, err = findStages(manifestJson, "bootiso-tree", "org.osbuild.isolinux")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	require.NoError(t, json.Unmarshal(stages[0].Options, &bootOpts))
+	assert.Subset(t, bootOpts.Kernel.Opts, []string{"inst.sshd", "inst.text"})
+
+	// the kickstart configures the installed system, the installer
+	// arguments must not end up there
+	stages, err = findStages(manifestJson, "bootiso-tree", "org.osbuild.kickstart")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	assert.NotContains(t, string(stages[0].Options), "inst.sshd")
+	kickstart, err := findFileContent(manifestJson, "bootiso-tree", "/osbuild.ks")
+	require.NoError(t, err)
+	assert.Contains(t, kickstart, "%include")
+	assert.NotContains(t, kickstart, "inst.sshd")
+	assert.NotContains(t, kickstart, "inst.text")
+}
+
+func TestManifestInstallerKernelArgsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		imgType string
+		args    []string
+		err     string
+	}{
+		{"iso", []string{""}, `installer kernel argument "" must be a single non-empty word`},
+		{"iso", []string{"inst.sshd inst.text"}, `installer kernel argument "inst.sshd inst.text" must be a single non-empty word`},
+		{"iso", []string{"inst.ks=http://example.com/ks.cfg"}, `installer kernel argument "inst.ks=http://example.com/ks.cfg" is set by bootc-image-builder and cannot be overridden`},
+		{"raw", []string{"inst.sshd"}, "installer_kernel_args only supported for the iso image type"},
+	} {
+		t.Run(tc.imgType+"-"+strings.Join(tc.args, ","), func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{InstallerKernelArgs: tc.args}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
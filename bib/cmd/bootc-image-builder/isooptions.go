@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// ISOOptions are the options of the ISO 9660 filesystem of the
+// installer ISO. The xorrisofs stage of osbuild always adds the Joliet
+// and Rock Ridge extensions and the El Torito entry for UEFI, they
+// cannot be changed.
+type ISOOptions struct {
+	// ISOLevel is the ISO 9660 conformance level, 1 to 4, the default
+	// is 3. Only level 3 and above allow files larger than 4 GiB.
+	ISOLevel int `json:"iso_level,omitempty"`
+	// BIOSBoot adds the El Torito entry that boots isolinux on BIOS
+	// systems, it defaults to true on x86_64
+	BIOSBoot *bool `json:"bios_boot,omitempty"`
+	// Isohybrid adds the MBR that boots the ISO from a USB drive on
+	// BIOS systems, it defaults to true on x86_64
+	Isohybrid *bool `json:"isohybrid,omitempty"`
+	// BootCatalog replaces the El Torito entry of bios_boot with one
+	// that boots the given image
+	BootCatalog *ISOBootCatalog `json:"boot_catalog,omitempty"`
+}
+
+// ISOBootCatalog is the El Torito boot entry of the ISO. The xorrisofs
+// stage always uses a load size of 4 sectors, no emulation and the x86
+// platform id.
+type ISOBootCatalog struct {
+	// Image is the path of the boot image in the ISO
+	Image string `json:"image"`
+	// Catalog is the path of the boot catalog in the ISO
+	Catalog string `json:"catalog"`
+}
+
+const isolinuxBootImage = "isolinux/isolinux.bin"
+
+// isoBootImages returns the images of the ISO tree that can be booted
+// with El Torito
+func isoBootImages(a arch.Arch) []string {
+	if a == arch.ARCH_X86_64 {
+		return []string{isolinuxBootImage, "images/efiboot.img"}
+	}
+	return []string{"images/efiboot.img"}
+}
+
+// elToritoImage returns the image of the El Torito entry for BIOS
+// systems, if there is one
+func (o *ISOOptions) elToritoImage(a arch.Arch) string {
+	if o.BootCatalog != nil {
+		return o.BootCatalog.Image
+	}
+	if o.biosBoot(a) {
+		return isolinuxBootImage
+	}
+	return ""
+}
+
+func validateISOBootCatalog(bc *ISOBootCatalog, a arch.Arch) error {
+	images := isoBootImages(a)
+	found := false
+	for _, image := range images {
+		if bc.Image == image {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("boot_catalog image %q is not in the ISO, valid images on %s are %q", bc.Image, a, images)
+	}
+	dirs := map[string]bool{".": true, "images": true}
+	if a == arch.ARCH_X86_64 {
+		dirs["isolinux"] = true
+	}
+	if bc.Catalog == "" || path.IsAbs(bc.Catalog) || path.Clean(bc.Catalog) != bc.Catalog || !dirs[path.Dir(bc.Catalog)] {
+		return fmt.Errorf("invalid boot_catalog catalog %q, expected a file name in the root, images or isolinux directory of the ISO", bc.Catalog)
+	}
+	for _, image := range images {
+		if bc.Catalog == image {
+			return fmt.Errorf("boot_catalog catalog %q would overwrite a boot image", bc.Catalog)
+		}
+	}
+	return nil
+}
+
+func (o *ISOOptions) biosBoot(a arch.Arch) bool {
+	if o.BIOSBoot != nil {
+		return *o.BIOSBoot
+	}
+	return a == arch.ARCH_X86_64
+}
+
+func (o *ISOOptions) isohybrid(a arch.Arch) bool {
+	if o.Isohybrid != nil {
+		return *o.Isohybrid
+	}
+	return o.elToritoImage(a) == isolinuxBootImage
+}
+
+func validateISOOptions(o *ISOOptions, a arch.Arch) error {
+	if o.ISOLevel != 0 && (o.ISOLevel < 1 || o.ISOLevel > 4) {
+		return fmt.Errorf("invalid iso_level %d, expected a level between 1 and 4", o.ISOLevel)
+	}
+	if o.BootCatalog != nil {
+		if o.BIOSBoot != nil {
+			return fmt.Errorf("boot_catalog replaces the entry of bios_boot, they cannot be combined")
+		}
+		if err := validateISOBootCatalog(o.BootCatalog, a); err != nil {
+			return err
+		}
+	}
+	// isolinux only exists on x86_64
+	if a != arch.ARCH_X86_64 && (o.biosBoot(a) || o.isohybrid(a)) {
+		return fmt.Errorf("bios_boot and isohybrid are not supported on %s", a)
+	}
+	// the isohybrid MBR chainloads the isolinux of the El Torito entry
+	if o.isohybrid(a) && o.elToritoImage(a) != isolinuxBootImage {
+		if o.BootCatalog != nil {
+			return fmt.Errorf("isohybrid needs the boot_catalog image %q", isolinuxBootImage)
+		}
+		return fmt.Errorf("isohybrid needs bios_boot")
+	}
+	return nil
+}
+
+// setISOOptions changes the options of the xorrisofs stage that creates
+// the installer ISO.
+//
+// TODO: osbuild/images always uses the same options for the ISO
+func setISOOptions(m *serializedManifest, o *ISOOptions, a arch.Arch) error {
+	err := m.updateStageOptions("bootiso", "org.osbuild.xorrisofs", func(options json.RawMessage) (json.RawMessage, error) {
+		var opts osbuild.XorrisofsStageOptions
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+		if o.ISOLevel != 0 {
+			opts.ISOLevel = o.ISOLevel
+		}
+		if o.BootCatalog != nil {
+			opts.Boot = &osbuild.XorrisofsBoot{
+				Image:   o.BootCatalog.Image,
+				Catalog: o.BootCatalog.Catalog,
+			}
+		} else if !o.biosBoot(a) {
+			opts.Boot = nil
+		}
+		if !o.isohybrid(a) {
+			opts.IsohybridMBR = ""
+		}
+		return json.Marshal(opts)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set the iso options: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestISOOptions(t *testing.T) {
+	disabled := false
+	for name, tc := range map[string]struct {
+		arch     arch.Arch
+		options  main.ISOOptions
+		expected string
+	}{
+		"defaults": {arch.ARCH_X86_64, main.ISOOptions{}, `{
+			"filename": "install.iso",
+			"volid": "Container-Installer-x86_64",
+			"sysid": "LINUX",
+			"boot": {"image": "isolinux/isolinux.bin", "catalog": "isolinux/boot.cat"},
+			"efi": "images/efiboot.img",
+			"isohybridmbr": "/usr/share/syslinux/isohdpfx.bin",
+			"isolevel": 3
+		}`},
+		"level-4-no-isohybrid": {arch.ARCH_X86_64, main.ISOOptions{ISOLevel: 4, Isohybrid: &disabled}, `{
+			"filename": "install.iso",
+			"volid": "Container-Installer-x86_64",
+			"sysid": "LINUX",
+			"boot": {"image": "isolinux/isolinux.bin", "catalog": "isolinux/boot.cat"},
+			"efi": "images/efiboot.img",
+			"isolevel": 4
+		}`},
+		"uefi-only": {arch.ARCH_X86_64, main.ISOOptions{BIOSBoot: &disabled}, `{
+			"filename": "install.iso",
+			"volid": "Container-Installer-x86_64",
+			"sysid": "LINUX",
+			"efi": "images/efiboot.img",
+			"isolevel": 3
+		}`},
+		"boot-catalog-efi": {arch.ARCH_X86_64, main.ISOOptions{BootCatalog: &main.ISOBootCatalog{Image: "images/efiboot.img", Catalog: "images/boot.cat"}}, `{
+			"filename": "install.iso",
+			"volid": "Container-Installer-x86_64",
+			"sysid": "LINUX",
+			"boot": {"image": "images/efiboot.img", "catalog": "images/boot.cat"},
+			"efi": "images/efiboot.img",
+			"isolevel": 3
+		}`},
+		"boot-catalog-isolinux": {arch.ARCH_X86_64, main.ISOOptions{BootCatalog: &main.ISOBootCatalog{Image: "isolinux/isolinux.bin", Catalog: "boot.catalog"}}, `{
+			"filename": "install.iso",
+			"volid": "Container-Installer-x86_64",
+			"sysid": "LINUX",
+			"boot": {"image": "isolinux/isolinux.bin", "catalog": "boot.catalog"},
+			"efi": "images/efiboot.img",
+			"isohybridmbr": "/usr/share/syslinux/isohdpfx.bin",
+			"isolevel": 3
+		}`},
+		"boot-catalog-aarch64": {arch.ARCH_AARCH64, main.ISOOptions{BootCatalog: &main.ISOBootCatalog{Image: "images/efiboot.img", Catalog: "images/boot.cat"}}, `{
+			"filename": "install.iso",
+			"volid": "Container-Installer-aarch64",
+			"sysid": "LINUX",
+			"boot": {"image": "images/efiboot.img", "catalog": "images/boot.cat"},
+			"efi": "images/efiboot.img",
+			"isolevel": 3
+		}`},
+		"aarch64": {arch.ARCH_AARCH64, main.ISOOptions{ISOLevel: 2}, `{
+			"filename": "install.iso",
+			"volid": "Container-Installer-aarch64",
+			"sysid": "LINUX",
+			"efi": "images/efiboot.img",
+			"isolevel": 2
+		}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			options := tc.options
+			config := getBaseConfig()
+			config.ImgType = "iso"
+			config.Architecture = tc.arch
+			config.Config = &main.BuildConfig{ISOOptions: &options}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(getISOPackages(), getISOContainers(), nil)
+			require.NoError(t, err)
+			manifestJson, err = main.SetISOOptions(manifestJson, &options, tc.arch)
+			require.NoError(t, err)
+
+			stages, err := findStages(manifestJson, "bootiso", "org.osbuild.xorrisofs")
+			require.NoError(t, err)
+			require.Len(t, stages, 1)
+			assert.JSONEq(t, tc.expected, string(stages[0].Options))
+		})
+	}
+}
+
+func TestManifestISOOptionsErrors(t *testing.T) {
+	enabled, disabled := true, false
+	for name, tc := range map[string]struct {
+		imgType string
+		arch    arch.Arch
+		options main.ISOOptions
+		err     string
+	}{
+		"level":             {"iso", arch.ARCH_X86_64, main.ISOOptions{ISOLevel: 5}, "invalid iso_level 5, expected a level between 1 and 4"},
+		"isohybrid-no-bios": {"iso", arch.ARCH_X86_64, main.ISOOptions{BIOSBoot: &disabled, Isohybrid: &enabled}, "isohybrid needs bios_boot"},
+		"bios-aarch64":      {"iso", arch.ARCH_AARCH64, main.ISOOptions{BIOSBoot: &enabled}, "bios_boot and isohybrid are not supported on aarch64"},
+		"catalog-image":     {"iso", arch.ARCH_X86_64, main.ISOOptions{BootCatalog: &main.ISOBootCatalog{Image: "images/pxeboot/vmlinuz", Catalog: "boot.cat"}}, `boot_catalog image "images/pxeboot/vmlinuz" is not in the ISO, valid images on x86_64 are ["isolinux/isolinux.bin" "images/efiboot.img"]`},
+		"catalog-isolinux":  {"iso", arch.ARCH_AARCH64, main.ISOOptions{BootCatalog: &main.ISOBootCatalog{Image: "isolinux/isolinux.bin", Catalog: "boot.cat"}}, `boot_catalog image "isolinux/isolinux.bin" is not in the ISO, valid images on aarch64 are ["images/efiboot.img"]`},
+		"catalog-path":      {"iso", arch.ARCH_X86_64, main.ISOOptions{BootCatalog: &main.ISOBootCatalog{Image: "images/efiboot.img", Catalog: "../boot.cat"}}, `invalid boot_catalog catalog "../boot.cat", expected a file name in the root, images or isolinux directory of the ISO`},
+		"catalog-no-path":   {"iso", arch.ARCH_X86_64, main.ISOOptions{BootCatalog: &main.ISOBootCatalog{Image: "images/efiboot.img"}}, `invalid boot_catalog catalog "", expected a file name in the root, images or isolinux directory of the ISO`},
+		"catalog-overwrite": {"iso", arch.ARCH_X86_64, main.ISOOptions{BootCatalog: &main.ISOBootCatalog{Image: "images/efiboot.img", Catalog: "isolinux/isolinux.bin"}}, `boot_catalog catalog "isolinux/isolinux.bin" would overwrite a boot image`},
+		"catalog-bios":      {"iso", arch.ARCH_X86_64, main.ISOOptions{BIOSBoot: &enabled, BootCatalog: &main.ISOBootCatalog{Image: "images/efiboot.img", Catalog: "boot.cat"}}, "boot_catalog replaces the entry of bios_boot, they cannot be combined"},
+		"catalog-isohybrid": {"iso", arch.ARCH_X86_64, main.ISOOptions{Isohybrid: &enabled, BootCatalog: &main.ISOBootCatalog{Image: "images/efiboot.img", Catalog: "boot.cat"}}, `isohybrid needs the boot_catalog image "isolinux/isolinux.bin"`},
+		"disk":              {"qcow2", arch.ARCH_X86_64, main.ISOOptions{ISOLevel: 3}, "iso_options only supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			options := tc.options
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Architecture = tc.arch
+			config.Config = &main.BuildConfig{ISOOptions: &options}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const journaldDropInPath = "/etc/systemd/journald.conf.d/90-bootc-image-builder.conf"
+
+// JournaldCustomization configures the storage of the journal
+type JournaldCustomization struct {
+	// Storage is one of "persistent", "volatile", "auto" or "none"
+	Storage string `json:"storage,omitempty"`
+	// SystemMaxUse is the maximum disk space of the persistent
+	// journal, e.g. "500M"
+	SystemMaxUse string `json:"system_max_use,omitempty"`
+}
+
+var journaldStorageValues = []string{"persistent", "volatile", "auto", "none"}
+
+// journald takes sizes in bytes with an optional base 1024 suffix
+var journaldSizeRegex = regexp.MustCompile(`^[0-9]+[KMGTPE]?$`)
+
+// journaldConfig returns the journald drop-in for the given
+// customization
+func journaldConfig(jc *JournaldCustomization) (*fsnode.File, error) {
+	if jc.Storage == "" && jc.SystemMaxUse == "" {
+		return nil, fmt.Errorf("journald needs a storage or a system_max_use")
+	}
+	var content strings.Builder
+	content.WriteString("[Journal]\n")
+	if jc.Storage != "" {
+		valid := false
+		for _, v := range journaldStorageValues {
+			if jc.Storage == v {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unsupported journald storage %q, valid values are %q", jc.Storage, journaldStorageValues)
+		}
+		fmt.Fprintf(&content, "Storage=%s\n", jc.Storage)
+	}
+	if jc.SystemMaxUse != "" {
+		if !journaldSizeRegex.MatchString(jc.SystemMaxUse) {
+			return nil, fmt.Errorf("invalid journald system_max_use %q, expected a number with an optional unit (K, M, G, T, P, E)", jc.SystemMaxUse)
+		}
+		fmt.Fprintf(&content, "SystemMaxUse=%s\n", jc.SystemMaxUse)
+	}
+	return fsnode.NewFile(journaldDropInPath, nil, nil, nil, []byte(content.String()))
+}
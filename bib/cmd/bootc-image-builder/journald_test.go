@@ -0,0 +1,58 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestJournald(t *testing.T) {
+	for name, tc := range map[string]struct {
+		journald main.JournaldCustomization
+		expected string
+	}{
+		"both":    {main.JournaldCustomization{Storage: "persistent", SystemMaxUse: "500M"}, "[Journal]\nStorage=persistent\nSystemMaxUse=500M\n"},
+		"storage": {main.JournaldCustomization{Storage: "volatile"}, "[Journal]\nStorage=volatile\n"},
+		"size":    {main.JournaldCustomization{SystemMaxUse: "1073741824"}, "[Journal]\nSystemMaxUse=1073741824\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			journald := tc.journald
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{Journald: &journald}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/journald.conf.d/90-bootc-image-builder.conf")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, content)
+		})
+	}
+}
+
+func TestManifestJournaldErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType  string
+		journald main.JournaldCustomization
+		err      string
+	}{
+		"empty":       {"qcow2", main.JournaldCustomization{}, "journald needs a storage or a system_max_use"},
+		"bad-storage": {"qcow2", main.JournaldCustomization{Storage: "disk"}, `unsupported journald storage "disk", valid values are ["persistent" "volatile" "auto" "none"]`},
+		"bad-size":    {"qcow2", main.JournaldCustomization{SystemMaxUse: "500MB"}, `invalid journald system_max_use "500MB", expected a number with an optional unit (K, M, G, T, P, E)`},
+		"iso":         {"iso", main.JournaldCustomization{Storage: "volatile"}, "journald not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			journald := tc.journald
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{Journald: &journald}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const defaultLimitsDropInPath = "/etc/systemd/system.conf.d/90-bootc-image-builder-limits.conf"
+
+// defaultLimitNames are the resources of the DefaultLimit* settings of
+// systemd-system.conf(5), in lowercase
+var defaultLimitNames = []string{
+	"as", "core", "cpu", "data", "fsize", "locks", "memlock", "msgqueue",
+	"nice", "nofile", "nproc", "rss", "rtprio", "rttime", "sigpending", "stack",
+}
+
+// parseLimit parses a single limit, a number or "infinity"
+func parseLimit(s string) (uint64, error) {
+	if s == "infinity" {
+		return ^uint64(0), nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// validateLimit ensures the value is a limit ("1024", "infinity") or a
+// soft and a hard limit ("1024:524288") with the soft one not above the
+// hard one
+func validateLimit(name, value string) error {
+	soft, hard, hasHard := strings.Cut(value, ":")
+	if !hasHard {
+		hard = soft
+	}
+	softN, errSoft := parseLimit(soft)
+	hardN, errHard := parseLimit(hard)
+	if errSoft != nil || errHard != nil {
+		return fmt.Errorf("invalid default_limits %s %q, expected a number, \"infinity\" or \"<soft>:<hard>\"", name, value)
+	}
+	if softN > hardN {
+		return fmt.Errorf("invalid default_limits %s %q, the soft limit is above the hard limit", name, value)
+	}
+	return nil
+}
+
+// defaultLimitsConfig returns the systemd drop-in that sets the default
+// resource limits of all services, the keys are the resources like
+// "nofile"
+func defaultLimitsConfig(limits map[string]string) (*fsnode.File, error) {
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	content.WriteString("[Manager]\n")
+	for _, name := range names {
+		valid := false
+		for _, n := range defaultLimitNames {
+			if name == n {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unsupported default_limits resource %q, valid values are %q", name, defaultLimitNames)
+		}
+		if err := validateLimit(name, limits[name]); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&content, "DefaultLimit%s=%s\n", strings.ToUpper(name), limits[name])
+	}
+	return fsnode.NewFile(defaultLimitsDropInPath, nil, nil, nil, []byte(content.String()))
+}
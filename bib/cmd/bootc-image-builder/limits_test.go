@@ -0,0 +1,51 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestDefaultLimits(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{DefaultLimits: map[string]string{
+		"nofile":  "1048576",
+		"nproc":   "infinity",
+		"memlock": "65536:131072",
+	}}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system.conf.d/90-bootc-image-builder-limits.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "[Manager]\nDefaultLimitMEMLOCK=65536:131072\nDefaultLimitNOFILE=1048576\nDefaultLimitNPROC=infinity\n", content)
+}
+
+func TestManifestDefaultLimitsErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		limits  map[string]string
+		err     string
+	}{
+		"bad-resource": {"qcow2", map[string]string{"NOFILE": "1024"}, `unsupported default_limits resource "NOFILE", valid values are ["as" "core" "cpu" "data" "fsize" "locks" "memlock" "msgqueue" "nice" "nofile" "nproc" "rss" "rtprio" "rttime" "sigpending" "stack"]`},
+		"not-numeric":  {"qcow2", map[string]string{"nofile": "1M"}, `invalid default_limits nofile "1M", expected a number, "infinity" or "<soft>:<hard>"`},
+		"negative":     {"qcow2", map[string]string{"nofile": "-1"}, `invalid default_limits nofile "-1", expected a number, "infinity" or "<soft>:<hard>"`},
+		"empty-hard":   {"qcow2", map[string]string{"nofile": "1024:"}, `invalid default_limits nofile "1024:", expected a number, "infinity" or "<soft>:<hard>"`},
+		"soft-above":   {"qcow2", map[string]string{"nofile": "infinity:1024"}, `invalid default_limits nofile "infinity:1024", the soft limit is above the hard limit`},
+		"iso":          {"iso", map[string]string{"nofile": "1024"}, "default_limits not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{DefaultLimits: tc.limits}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
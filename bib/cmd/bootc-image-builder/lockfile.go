@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/osbuild/images/pkg/container"
+)
+
+// LockedContainer is a resolved container of the lockfile
+type LockedContainer struct {
+	// Ref is the reference the container was resolved from, e.g.
+	// "quay.io/centos-bootc/centos-bootc:stream9"
+	Ref  string `json:"ref"`
+	Arch string `json:"arch"`
+
+	// Name is the reference without the tag
+	Name       string `json:"name"`
+	Digest     string `json:"digest"`
+	ImageID    string `json:"image_id"`
+	ListDigest string `json:"list_digest,omitempty"`
+}
+
+// ContainerLock pins the digests of all containers of a build, the
+// base image as well as e.g. the images of overlays. An empty lock is
+// filled while the containers are resolved, a lock that was read from a
+// lockfile is used instead of resolving.
+type ContainerLock struct {
+	Containers []LockedContainer `json:"containers"`
+
+	// frozen is set for locks that were read from a lockfile
+	frozen bool
+}
+
+func (l *ContainerLock) find(ref, arch string) *LockedContainer {
+	for idx := range l.Containers {
+		if l.Containers[idx].Ref == ref && l.Containers[idx].Arch == arch {
+			return &l.Containers[idx]
+		}
+	}
+	return nil
+}
+
+// readContainerLock reads the lockfile at the given path, an empty lock
+// is returned if there is none yet
+func readContainerLock(path string) (*ContainerLock, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ContainerLock{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lock ContainerLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("cannot parse container lockfile %q: %w", path, err)
+	}
+	for _, lc := range lock.Containers {
+		if lc.Ref == "" || lc.Arch == "" || lc.Name == "" || lc.Digest == "" || lc.ImageID == "" {
+			return nil, fmt.Errorf("cannot parse container lockfile %q: entry %+v is incomplete", path, lc)
+		}
+	}
+	lock.frozen = true
+	return &lock, nil
+}
+
+// writeContainerLock writes the lock to the given path unless it was
+// read from there
+func writeContainerLock(lock *ContainerLock, path string) error {
+	if lock.frozen {
+		return nil
+	}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// containerResolver is implemented by container.Resolver and
+// storageResolver
+type containerResolver interface {
+	Add(spec container.SourceSpec)
+	Finish() ([]container.Spec, error)
+}
+
+// lockedResolver resolves the containers with the lock, containers
+// that are not locked yet are resolved and added to the lock
+type lockedResolver struct {
+	lock *ContainerLock
+	arch string
+
+	queue []container.SourceSpec
+}
+
+var newContainerResolver = func(arch string) containerResolver {
+	return newStorageResolver(arch)
+}
+
+// newResolver returns the resolver for the containers of the given
+// architecture
+func (c *ManifestConfig) newResolver(arch string) containerResolver {
+	if c.ContainerLock == nil {
+		return newContainerResolver(arch)
+	}
+	return &lockedResolver{lock: c.ContainerLock, arch: arch}
+}
+
+func (r *lockedResolver) Add(spec container.SourceSpec) {
+	r.queue = append(r.queue, spec)
+}
+
+// Finish returns the specs in the order they were added
+func (r *lockedResolver) Finish() ([]container.Spec, error) {
+	queue := r.queue
+	r.queue = nil
+
+	specs := make([]container.Spec, 0, len(queue))
+	for _, src := range queue {
+		lc := r.lock.find(src.Source, r.arch)
+		if lc == nil {
+			if r.lock.frozen {
+				return nil, fmt.Errorf("container %s for %s is not in the container lockfile", src.Source, r.arch)
+			}
+			resolver := newContainerResolver(r.arch)
+			resolver.Add(src)
+			resolved, err := resolver.Finish()
+			if err != nil {
+				return nil, err
+			}
+			r.lock.Containers = append(r.lock.Containers, LockedContainer{
+				Ref:        src.Source,
+				Arch:       r.arch,
+				Name:       resolved[0].Source,
+				Digest:     resolved[0].Digest,
+				ImageID:    resolved[0].ImageID,
+				ListDigest: resolved[0].ListDigest,
+			})
+			lc = &r.lock.Containers[len(r.lock.Containers)-1]
+		}
+		localName := src.Name
+		if localName == "" {
+			localName = src.Source
+		}
+		specs = append(specs, container.Spec{
+			Source:              lc.Name,
+			Digest:              lc.Digest,
+			TLSVerify:           src.TLSVerify,
+			ImageID:             lc.ImageID,
+			LocalName:           localName,
+			ListDigest:          lc.ListDigest,
+			ContainersTransport: src.ContainersTransport,
+			StoragePath:         src.StoragePath,
+		})
+	}
+	return specs, nil
+}
@@ -0,0 +1,148 @@
+package main_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/container"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+// fakeResolver resolves every container to testContainerSpec, with the
+// given list digest as if it was chosen from a manifest list
+type fakeResolver struct {
+	calls      *int
+	listDigest string
+	sources    []container.SourceSpec
+}
+
+func (r *fakeResolver) Add(spec container.SourceSpec) {
+	r.sources = append(r.sources, spec)
+}
+
+func (r *fakeResolver) Finish() ([]container.Spec, error) {
+	var specs []container.Spec
+	for _, src := range r.sources {
+		*r.calls++
+		spec := testContainerSpec
+		spec.Source = strings.Split(src.Source, ":")[0]
+		spec.LocalName = src.Source
+		spec.ListDigest = r.listDigest
+		specs = append(specs, spec)
+	}
+	r.sources = nil
+	return specs, nil
+}
+
+func lockTestConfig() *main.ManifestConfig {
+	return &main.ManifestConfig{
+		Imgref:       "quay.io/example/bootc:latest",
+		ImgType:      "qcow2",
+		Architecture: arch.Current(),
+		TLSVerify:    true,
+		// the fake image is not inspected
+		NoArchCheck: true,
+	}
+}
+
+func TestContainerLockfileRoundTrip(t *testing.T) {
+	calls := 0
+	restore := main.MockNewContainerResolver(func(string) main.ContainerResolver {
+		return &fakeResolver{calls: &calls}
+	})
+	defer restore()
+
+	lockPath := filepath.Join(t.TempDir(), "containers.lock")
+	lock, err := main.ReadContainerLock(lockPath)
+	require.NoError(t, err)
+	assert.Empty(t, lock.Containers)
+
+	// the first build resolves and records the container
+	c := lockTestConfig()
+	c.ContainerLock = lock
+	_, err = main.MakeManifest(c, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	require.NoError(t, main.WriteContainerLock(lock, lockPath))
+
+	readLock, err := main.ReadContainerLock(lockPath)
+	require.NoError(t, err)
+	assert.Equal(t, []main.LockedContainer{
+		{
+			Ref:     "quay.io/example/bootc:latest",
+			Arch:    arch.Current().String(),
+			Name:    "quay.io/example/bootc",
+			Digest:  testContainerSpec.Digest,
+			ImageID: testContainerSpec.ImageID,
+		},
+	}, readLock.Containers)
+
+	// pin a different digest, the build must use it without resolving
+	pinned := "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"
+	pinnedID := "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	readLock.Containers[0].Digest = pinned
+	readLock.Containers[0].ImageID = pinnedID
+	c = lockTestConfig()
+	c.ContainerLock = readLock
+	mf, err := main.MakeManifest(c, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Contains(t, string(mf), pinned)
+	assert.Contains(t, string(mf), pinnedID)
+	assert.NotContains(t, string(mf), testContainerSpec.Digest)
+
+	// a lock read from a lockfile is never written back
+	require.NoError(t, os.WriteFile(lockPath, []byte(`{"containers":[]}`), 0644))
+	require.NoError(t, main.WriteContainerLock(readLock, lockPath))
+	data, err := os.ReadFile(lockPath)
+	require.NoError(t, err)
+	assert.Equal(t, `{"containers":[]}`, string(data))
+}
+
+func TestContainerLockfileMissingContainer(t *testing.T) {
+	restore := main.MockNewContainerResolver(func(string) main.ContainerResolver {
+		panic("unexpected resolve")
+	})
+	defer restore()
+
+	lockPath := filepath.Join(t.TempDir(), "containers.lock")
+	require.NoError(t, os.WriteFile(lockPath, []byte(`{"containers":[]}`), 0644))
+	lock, err := main.ReadContainerLock(lockPath)
+	require.NoError(t, err)
+
+	c := lockTestConfig()
+	c.ContainerLock = lock
+	_, err = main.MakeManifest(c, "")
+	assert.EqualError(t, err, fmt.Sprintf("container quay.io/example/bootc:latest for %s is not in the container lockfile", arch.Current()))
+}
+
+func TestContainerLockfileErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		expErr  string
+	}{
+		"invalid-json": {
+			content: `{`,
+			expErr:  "unexpected end of JSON input",
+		},
+		"incomplete-entry": {
+			content: `{"containers":[{"ref":"quay.io/example/bootc:latest","arch":"x86_64"}]}`,
+			expErr:  "is incomplete",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lockPath := filepath.Join(t.TempDir(), "containers.lock")
+			require.NoError(t, os.WriteFile(lockPath, []byte(tc.content), 0644))
+			_, err := main.ReadContainerLock(lockPath)
+			assert.ErrorContains(t, err, tc.expErr)
+		})
+	}
+}
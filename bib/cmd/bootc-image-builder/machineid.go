@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	// MachineIDFirstboot makes systemd treat the first boot as such
+	// and generate a new machine-id (this is the default)
+	MachineIDFirstboot = "firstboot"
+	// MachineIDEmpty leaves the machine-id empty, it is generated on
+	// boot but the boot is not considered the first boot
+	MachineIDEmpty = "empty"
+	// MachineIDPreset sets a fixed machine-id
+	MachineIDPreset = "preset"
+)
+
+var machineIDRegex = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// machineIDFile returns the /etc/machine-id for the given mode, see
+// machine-id(5) for the meaning of the content. An empty mode is
+// "firstboot".
+func machineIDFile(mode, value string) (*fsnode.File, error) {
+	if mode == "" {
+		mode = MachineIDFirstboot
+	}
+	if value != "" && mode != MachineIDPreset {
+		return nil, fmt.Errorf("machine_id_value can only be used with machine_id %q", MachineIDPreset)
+	}
+
+	var content string
+	switch mode {
+	case MachineIDFirstboot:
+		content = "uninitialized\n"
+	case MachineIDEmpty:
+		content = ""
+	case MachineIDPreset:
+		if !machineIDRegex.MatchString(value) || value == "00000000000000000000000000000000" {
+			return nil, fmt.Errorf("invalid machine_id_value %q: must be 32 lowercase hexadecimal characters", value)
+		}
+		content = value + "\n"
+	default:
+		return nil, fmt.Errorf("unsupported machine_id %q, valid values are %q, %q and %q", mode, MachineIDFirstboot, MachineIDEmpty, MachineIDPreset)
+	}
+
+	fileMode := os.FileMode(0444)
+	return fsnode.NewFile("/etc/machine-id", &fileMode, nil, nil, []byte(content))
+}
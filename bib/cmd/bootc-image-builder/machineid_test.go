@@ -0,0 +1,81 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestMachineID(t *testing.T) {
+	for name, tc := range map[string]struct {
+		mode    string
+		value   string
+		content string
+	}{
+		"firstboot": {"firstboot", "", "uninitialized\n"},
+		"empty":     {"empty", "", ""},
+		"preset":    {"preset", "0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{
+				MachineID:      tc.mode,
+				MachineIDValue: tc.value,
+			}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/machine-id")
+			require.NoError(t, err)
+			assert.Equal(t, tc.content, content)
+		})
+	}
+}
+
+func TestManifestMachineIDUnset(t *testing.T) {
+	// clones of the image get their own machine-id
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/machine-id")
+	require.NoError(t, err)
+	assert.Equal(t, "uninitialized\n", content)
+}
+
+func TestManifestMachineIDErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		mode    string
+		value   string
+		err     string
+	}{
+		"bad-mode":        {"qcow2", "random", "", `unsupported machine_id "random", valid values are "firstboot", "empty" and "preset"`},
+		"preset-no-value": {"qcow2", "preset", "", `invalid machine_id_value "": must be 32 lowercase hexadecimal characters`},
+		"preset-bad":      {"qcow2", "preset", "0123456789ABCDEF0123456789ABCDEF", `invalid machine_id_value "0123456789ABCDEF0123456789ABCDEF": must be 32 lowercase hexadecimal characters`},
+		"preset-zero":     {"qcow2", "preset", "00000000000000000000000000000000", `invalid machine_id_value "00000000000000000000000000000000": must be 32 lowercase hexadecimal characters`},
+		"value-no-preset": {"qcow2", "empty", "0123456789abcdef0123456789abcdef", `machine_id_value can only be used with machine_id "preset"`},
+		"iso":             {"iso", "empty", "", "machine_id not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{
+				MachineID:      tc.mode,
+				MachineIDValue: tc.value,
+			}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
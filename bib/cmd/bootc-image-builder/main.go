@@ -3,19 +3,23 @@ package main
 import (
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/osbuild/bootc-image-builder/bib/internal/setup"
+	"github.com/osbuild/bootc-image-builder/bib/internal/util"
 	"github.com/osbuild/images/pkg/arch"
 	"github.com/osbuild/images/pkg/blueprint"
 	"github.com/osbuild/images/pkg/cloud/awscloud"
 	"github.com/osbuild/images/pkg/container"
 	"github.com/osbuild/images/pkg/dnfjson"
 	"github.com/osbuild/images/pkg/manifest"
-	"github.com/osbuild/images/pkg/osbuild"
 	"github.com/osbuild/images/pkg/rpmmd"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -32,6 +36,421 @@ const (
 
 type BuildConfig struct {
 	Blueprint *blueprint.Blueprint `json:"blueprint,omitempty"`
+
+	// BootEntryLabel is the label of the UEFI boot entry that is
+	// created when the image is installed
+	BootEntryLabel *string `json:"boot_entry_label,omitempty"`
+
+	// Bootloader "none" builds disk images without a bootloader, by
+	// default bootupd installs it
+	Bootloader string `json:"bootloader,omitempty"`
+
+	// Flatpaks are installed on the first boot of the system
+	Flatpaks *FlatpakCustomization `json:"flatpaks,omitempty"`
+
+	// MachineID controls the content of /etc/machine-id, one of
+	// "firstboot" (default), "empty" or "preset" (MachineIDValue), the
+	// file of the container image is never kept
+	MachineID      string `json:"machine_id,omitempty"`
+	MachineIDValue string `json:"machine_id_value,omitempty"`
+
+	// ISOVariant selects the installer package set, "full" (default)
+	// or "minimal"
+	ISOVariant string `json:"iso_variant,omitempty"`
+
+	// ISOLabel is the volume ID of the installer ISO, it defaults to
+	// "Container-Installer-<arch>"
+	ISOLabel *string `json:"iso_label,omitempty"`
+
+	// ISOOptions are the options of the ISO 9660 filesystem of the
+	// installer
+	ISOOptions *ISOOptions `json:"iso_options,omitempty"`
+
+	// InstallerKernelArgs are added to the kernel command line of the
+	// installer, the installed system does not get them
+	InstallerKernelArgs []string `json:"installer_kernel_args,omitempty"`
+
+	// InstallerLocale and InstallerKeyboard are the language and the
+	// keyboard layout of the installer, the installed system does not
+	// get them
+	InstallerLocale   string `json:"installer_locale,omitempty"`
+	InstallerKeyboard string `json:"installer_keyboard,omitempty"`
+
+	// InstallWeakDeps controls if the weak dependencies (recommends,
+	// supplements) of the installer packages are installed, the
+	// default is true
+	InstallWeakDeps *bool `json:"install_weak_deps,omitempty"`
+
+	// SquashfsCompression is the compression of the squashfs image
+	// type, "zstd" by default
+	SquashfsCompression string `json:"squashfs_compression,omitempty"`
+
+	// EULA is a license file that is added to the installer ISO
+	EULA string `json:"eula,omitempty"`
+
+	// InstallerPostScript is added as a %post section to the kickstart
+	// of the installer, it runs after the system is installed
+	InstallerPostScript string `json:"installer_post_script,omitempty"`
+
+	// SELinuxContexts maps paths of customized files and directories
+	// to the SELinux context they are labeled with
+	SELinuxContexts map[string]string `json:"selinux_contexts,omitempty"`
+
+	// SELinuxMode is one of "enforcing", "permissive" or "disabled",
+	// by default the mode of the container image is kept
+	SELinuxMode string `json:"selinux_mode,omitempty"`
+
+	// VConsole sets the keymap and the font of the virtual console
+	VConsole *VConsoleCustomization `json:"vconsole,omitempty"`
+
+	// Journald configures the storage of the journal
+	Journald *JournaldCustomization `json:"journald,omitempty"`
+
+	// SyslogForward forwards the syslog messages to a remote server
+	SyslogForward *SyslogForward `json:"syslog_forward,omitempty"`
+
+	// CrypttabEntries are written to /etc/crypttab, e.g. for encrypted
+	// data disks that are provisioned at runtime
+	CrypttabEntries []CrypttabEntry `json:"crypttab_entries,omitempty"`
+
+	// Skel populates /etc/skel for the users that are created on the
+	// installed system
+	Skel *Skel `json:"skel,omitempty"`
+
+	// EFIFallback installs shim to the removable media path of the
+	// ESP as well, e.g. EFI/BOOT/BOOTX64.EFI
+	EFIFallback bool `json:"efi_fallback,omitempty"`
+	// EFIVendor is the vendor directory of the ESP that shim is taken
+	// from, "fedora" by default
+	EFIVendor string `json:"efi_vendor,omitempty"`
+
+	// Headless sets multi-user.target as the default target of the
+	// installed system
+	Headless bool `json:"headless,omitempty"`
+
+	// AutoUpdate configures the automatic updates of bootc
+	AutoUpdate *AutoUpdate `json:"auto_update,omitempty"`
+
+	// Motd is the static and dynamic message of the day shown on login
+	Motd *Motd `json:"motd,omitempty"`
+
+	// Bootupd is "enabled" or "disabled", it controls if bootupd
+	// updates the bootloader of the installed system, by default the
+	// preset of the container image applies
+	Bootupd string `json:"bootupd,omitempty"`
+
+	// SSHHostKeys are installed instead of generating host keys on
+	// the first boot
+	SSHHostKeys []SSHHostKey `json:"ssh_host_keys,omitempty"`
+
+	// RootSSHKeys are authorized to log in as root via SSH
+	RootSSHKeys []string `json:"root_ssh_keys,omitempty"`
+
+	// GPGKeys are ASCII armored public keys that are installed to
+	// /etc/pki/rpm-gpg for the signature checks of rpm repositories
+	GPGKeys []string `json:"gpg_keys,omitempty"`
+
+	// DisableIPv6 disables IPv6 in the installed system
+	DisableIPv6 bool `json:"disable_ipv6,omitempty"`
+
+	// DisableFirewall masks firewalld in the installed system
+	DisableFirewall bool `json:"disable_firewall,omitempty"`
+
+	// DisableCloudInit masks the units of cloud-init in the installed
+	// system, e.g. for images that are provisioned with Ignition
+	DisableCloudInit bool `json:"disable_cloud_init,omitempty"`
+
+	// CryptoPolicy is the system wide crypto policy, e.g. "FUTURE"
+	CryptoPolicy string `json:"crypto_policy,omitempty"`
+
+	// ContainerStorage configures the storage of the container
+	// runtimes of the installed system
+	ContainerStorage *ContainerStorageCustomization `json:"container_storage,omitempty"`
+
+	// ContainerProxy is the proxy that the container runtimes of the
+	// installed system pull images through
+	ContainerProxy *ContainerProxy `json:"container_proxy,omitempty"`
+
+	// DNSSearch are the DNS search domains of the installed system
+	DNSSearch []string `json:"dns_search,omitempty"`
+
+	// NetworkManager configures the DNS and the connectivity check of
+	// NetworkManager
+	NetworkManager *NetworkManager `json:"network_manager,omitempty"`
+
+	// Umask is the default octal umask of login shells and services,
+	// e.g. "0077"
+	Umask string `json:"umask,omitempty"`
+
+	// DefaultLimits are the default resource limits of all services,
+	// e.g. {"nofile": "1048576"} for DefaultLimitNOFILE
+	DefaultLimits map[string]string `json:"default_limits,omitempty"`
+
+	// Sysusers are sysusers.d(5) lines of the system users and groups
+	// that services expect, e.g. "u myservice - \"My Service\""
+	Sysusers []string `json:"sysusers,omitempty"`
+
+	// Tmpfiles are tmpfiles.d(5) lines of the files and directories
+	// created on boot, e.g. "d /run/myservice 0750 myservice myservice -"
+	Tmpfiles []string `json:"tmpfiles,omitempty"`
+
+	// IOScheduler is the I/O scheduler of the disks of a device class,
+	// e.g. {"nvme": "none"}
+	IOScheduler map[string]string `json:"io_scheduler,omitempty"`
+
+	// NetNaming is the network interface naming scheme, "predictable"
+	// (the default) or "classic" for eth0 style names
+	NetNaming string `json:"net_naming,omitempty"`
+
+	// NetworkRoot attaches the disk with the root filesystem over the
+	// network in the initramfs, e.g. from an iSCSI target
+	NetworkRoot *NetworkRoot `json:"network_root,omitempty"`
+
+	// OSRelease overrides fields of the os-release of the container
+	// image, e.g. VARIANT or BUILD_ID
+	OSRelease map[string]string `json:"os_release,omitempty"`
+
+	// Mounts customize the mountpoints of disk images
+	Mounts []MountCustomization `json:"mounts,omitempty"`
+
+	// MountUnits are mounted by systemd mount units instead of fstab
+	// entries
+	MountUnits []MountUnit `json:"mount_units,omitempty"`
+
+	// DataDisks are built as separate, empty disk images that are
+	// mounted by the system
+	DataDisks []DataDisk `json:"data_disks,omitempty"`
+
+	// RootfsLabel and RootfsUUID set the label and the UUID of the root
+	// filesystem of disk images, by default the label is "root" and the
+	// UUID is random
+	RootfsLabel string `json:"rootfs_label,omitempty"`
+	RootfsUUID  string `json:"rootfs_uuid,omitempty"`
+
+	// PartitionUUIDs set the GUIDs of the GPT partition table of disk
+	// images
+	PartitionUUIDs *PartitionUUIDs `json:"partition_uuids,omitempty"`
+
+	// BootFSType is the filesystem of the /boot partition of disk
+	// images, "ext4" (the default) or "xfs"
+	BootFSType string `json:"boot_fstype,omitempty"`
+
+	// SectorSize is the logical sector size of disk images, 512 (the
+	// default) or 4096 for 4K native disks
+	SectorSize uint64 `json:"sector_size,omitempty"`
+
+	// GrowRootfs set to false keeps the size of the root filesystem
+	// when the image is booted on a larger disk, it is grown by default
+	GrowRootfs *bool `json:"grow_rootfs,omitempty"`
+
+	// Overlays copy content from other container images into the
+	// system
+	Overlays []Overlay `json:"overlays,omitempty"`
+
+	// EmbeddedRepo is a local RPM repository that is copied into the
+	// system
+	EmbeddedRepo *EmbeddedRepo `json:"embedded_repo,omitempty"`
+
+	// OSTreeRemote is configured in the ostree repository of the
+	// system
+	OSTreeRemote *OSTreeRemote `json:"ostree_remote,omitempty"`
+
+	// GrubUserConfig are raw grub directives that are sourced before
+	// the boot entries of disk images are loaded
+	GrubUserConfig []string `json:"grub_user_config,omitempty"`
+
+	// GrubMenu is "hidden" or "shown", by default the menu is shown
+	// as configured by bootupd
+	GrubMenu string `json:"grub_menu,omitempty"`
+}
+
+// isoOnlyOptions returns the names of the options that are set but
+// can only be applied to installer images.
+func (c *BuildConfig) isoOnlyOptions() []string {
+	var opts []string
+	// disk images have no boot entry, the firmware falls back to the
+	// removable media path
+	if c.BootEntryLabel != nil {
+		opts = append(opts, "boot_entry_label")
+	}
+	if c.ISOVariant != "" {
+		opts = append(opts, "iso_variant")
+	}
+	if c.ISOLabel != nil {
+		opts = append(opts, "iso_label")
+	}
+	if c.ISOOptions != nil {
+		opts = append(opts, "iso_options")
+	}
+	if len(c.InstallerKernelArgs) > 0 {
+		opts = append(opts, "installer_kernel_args")
+	}
+	if c.InstallerLocale != "" {
+		opts = append(opts, "installer_locale")
+	}
+	if c.InstallerKeyboard != "" {
+		opts = append(opts, "installer_keyboard")
+	}
+	// disk images get all their packages from the container
+	if c.InstallWeakDeps != nil {
+		opts = append(opts, "install_weak_deps")
+	}
+	if c.EULA != "" {
+		opts = append(opts, "eula")
+	}
+	if c.InstallerPostScript != "" {
+		opts = append(opts, "installer_post_script")
+	}
+	return opts
+}
+
+// diskOnlyOptions returns the names of the options that are set but
+// can only be applied to disk images.
+func (c *BuildConfig) diskOnlyOptions() []string {
+	var opts []string
+	if c.Flatpaks != nil {
+		opts = append(opts, "flatpaks")
+	}
+	if c.MachineID != "" || c.MachineIDValue != "" {
+		opts = append(opts, "machine_id")
+	}
+	if len(c.SELinuxContexts) > 0 {
+		opts = append(opts, "selinux_contexts")
+	}
+	if c.SELinuxMode != "" {
+		opts = append(opts, "selinux_mode")
+	}
+	if c.VConsole != nil {
+		opts = append(opts, "vconsole")
+	}
+	if c.Journald != nil {
+		opts = append(opts, "journald")
+	}
+	if c.SyslogForward != nil {
+		opts = append(opts, "syslog_forward")
+	}
+	if len(c.CrypttabEntries) > 0 {
+		opts = append(opts, "crypttab_entries")
+	}
+	if c.Skel != nil {
+		opts = append(opts, "skel")
+	}
+	if c.AutoUpdate != nil {
+		opts = append(opts, "auto_update")
+	}
+	if c.Bootupd != "" {
+		opts = append(opts, "bootupd")
+	}
+	if c.Motd != nil {
+		opts = append(opts, "motd")
+	}
+	if c.EFIFallback || c.EFIVendor != "" {
+		opts = append(opts, "efi_fallback")
+	}
+	if c.Headless {
+		opts = append(opts, "headless")
+	}
+	if len(c.SSHHostKeys) > 0 {
+		opts = append(opts, "ssh_host_keys")
+	}
+	if len(c.RootSSHKeys) > 0 {
+		opts = append(opts, "root_ssh_keys")
+	}
+	if len(c.GPGKeys) > 0 {
+		opts = append(opts, "gpg_keys")
+	}
+	if c.DisableIPv6 {
+		opts = append(opts, "disable_ipv6")
+	}
+	if c.DisableFirewall {
+		opts = append(opts, "disable_firewall")
+	}
+	if c.DisableCloudInit {
+		opts = append(opts, "disable_cloud_init")
+	}
+	if c.CryptoPolicy != "" {
+		opts = append(opts, "crypto_policy")
+	}
+	if c.ContainerStorage != nil {
+		opts = append(opts, "container_storage")
+	}
+	if c.ContainerProxy != nil {
+		opts = append(opts, "container_proxy")
+	}
+	if len(c.DNSSearch) > 0 {
+		opts = append(opts, "dns_search")
+	}
+	if c.NetworkManager != nil {
+		opts = append(opts, "network_manager")
+	}
+	if c.Umask != "" {
+		opts = append(opts, "umask")
+	}
+	if len(c.DefaultLimits) > 0 {
+		opts = append(opts, "default_limits")
+	}
+	if len(c.Sysusers) > 0 {
+		opts = append(opts, "sysusers")
+	}
+	if len(c.Tmpfiles) > 0 {
+		opts = append(opts, "tmpfiles")
+	}
+	if len(c.IOScheduler) > 0 {
+		opts = append(opts, "io_scheduler")
+	}
+	if c.NetNaming != "" {
+		opts = append(opts, "net_naming")
+	}
+	if c.NetworkRoot != nil {
+		opts = append(opts, "network_root")
+	}
+	if len(c.OSRelease) > 0 {
+		opts = append(opts, "os_release")
+	}
+	if len(c.Mounts) > 0 {
+		opts = append(opts, "mounts")
+	}
+	if len(c.MountUnits) > 0 {
+		opts = append(opts, "mount_units")
+	}
+	if len(c.DataDisks) > 0 {
+		opts = append(opts, "data_disks")
+	}
+	if c.RootfsLabel != "" {
+		opts = append(opts, "rootfs_label")
+	}
+	if c.RootfsUUID != "" {
+		opts = append(opts, "rootfs_uuid")
+	}
+	if c.PartitionUUIDs != nil {
+		opts = append(opts, "partition_uuids")
+	}
+	if c.BootFSType != "" {
+		opts = append(opts, "boot_fstype")
+	}
+	if c.SectorSize != 0 {
+		opts = append(opts, "sector_size")
+	}
+	if c.GrowRootfs != nil {
+		opts = append(opts, "grow_rootfs")
+	}
+	if len(c.Overlays) > 0 {
+		opts = append(opts, "overlays")
+	}
+	if c.EmbeddedRepo != nil {
+		opts = append(opts, "embedded_repo")
+	}
+	if c.OSTreeRemote != nil {
+		opts = append(opts, "ostree_remote")
+	}
+	// the installer has its own grub config
+	if len(c.GrubUserConfig) > 0 {
+		opts = append(opts, "grub_user_config")
+	}
+	if c.GrubMenu != "" {
+		opts = append(opts, "grub_menu")
+	}
+	return opts
 }
 
 var (
@@ -77,6 +496,82 @@ func loadRepos(archName string) ([]rpmmd.RepoConfig, error) {
 	return archRepos, nil
 }
 
+// disableRepos removes the repositories with the given ids from the
+// given ones, the repositories of the JSON files only have a name which
+// is used as their id
+func disableRepos(repos []rpmmd.RepoConfig, ids []string) ([]rpmmd.RepoConfig, error) {
+	repoID := func(repo rpmmd.RepoConfig) string {
+		if repo.Id != "" {
+			return repo.Id
+		}
+		return repo.Name
+	}
+	disabled := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		found := false
+		for _, repo := range repos {
+			if repoID(repo) == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			var known []string
+			for _, repo := range repos {
+				known = append(known, repoID(repo))
+			}
+			return nil, fmt.Errorf("cannot disable repository %q, the repositories are %q", id, known)
+		}
+		disabled[id] = true
+	}
+
+	var enabled []rpmmd.RepoConfig
+	for _, repo := range repos {
+		if !disabled[repoID(repo)] {
+			enabled = append(enabled, repo)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil, fmt.Errorf("cannot disable all repositories")
+	}
+	return enabled, nil
+}
+
+// validateContainersStorage ensures the given path is a directory that
+// looks like a containers storage
+func validateContainersStorage(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("containers storage %q must be an absolute path", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot use containers storage: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("containers storage %q is not a directory", path)
+	}
+	return nil
+}
+
+// defaultContainersStoragePath is the system containers storage that
+// "podman build" writes to when run as root
+const defaultContainersStoragePath = "/var/lib/containers/storage"
+
+// localImageRef returns the name of a locally built image as podman
+// stores it, names without a registry are stored below "localhost/".
+func localImageRef(imgref string) (string, error) {
+	if imgref == "" || strings.Contains(imgref, "://") || strings.HasPrefix(imgref, "containers-storage:") {
+		return "", fmt.Errorf("--local needs the name of a local image, e.g. my-image:latest, not %q", imgref)
+	}
+	// same rules as podman: the first component is a registry if it
+	// has a dot or a port or is "localhost"
+	first, _, found := strings.Cut(imgref, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return imgref, nil
+	}
+	return "localhost/" + imgref, nil
+}
+
 func loadConfig(path string) (*BuildConfig, error) {
 	fp, err := os.Open(path)
 	if err != nil {
@@ -97,6 +592,20 @@ func loadConfig(path string) (*BuildConfig, error) {
 	return &conf, nil
 }
 
+// packageSetChains returns the package sets of the manifest that are
+// depsolved with the options of the build config applied
+func (c *ManifestConfig) packageSetChains(mf *manifest.Manifest) map[string][]rpmmd.PackageSet {
+	chains := mf.GetPackageSetChains()
+	if c.Config != nil && c.Config.InstallWeakDeps != nil {
+		for _, chain := range chains {
+			for idx := range chain {
+				chain[idx].InstallWeakDeps = *c.Config.InstallWeakDeps
+			}
+		}
+	}
+	return chains
+}
+
 func makeManifest(c *ManifestConfig, cacheRoot string) (manifest.OSBuildManifest, error) {
 	manifest, err := Manifest(c)
 	if err != nil {
@@ -106,7 +615,7 @@ func makeManifest(c *ManifestConfig, cacheRoot string) (manifest.OSBuildManifest
 	// depsolve packages
 	solver := dnfjson.NewSolver(modulePlatformID, releaseVersion, c.Architecture.String(), distroName, cacheRoot)
 	depsolvedSets := make(map[string][]rpmmd.PackageSpec)
-	for name, pkgSet := range manifest.GetPackageSetChains() {
+	for name, pkgSet := range c.packageSetChains(manifest) {
 		res, err := solver.Depsolve(pkgSet)
 		if err != nil {
 			return nil, err
@@ -123,15 +632,15 @@ func makeManifest(c *ManifestConfig, cacheRoot string) (manifest.OSBuildManifest
 	hostArch := arch.Current().String()
 	targetArch := c.Architecture.String()
 
-	resolverNative := container.NewResolver(hostArch)
+	resolverNative := c.newResolver(hostArch)
 	resolverTarget := resolverNative
 	if hostArch != targetArch {
-		resolverTarget = container.NewResolver(targetArch)
+		resolverTarget = c.newResolver(targetArch)
 	}
 
 	containerSpecs := make(map[string][]container.Spec)
 	for plName, sourceSpecs := range manifest.GetContainerSourceSpecs() {
-		var resolver *container.Resolver
+		var resolver containerResolver
 		if plName == "build" {
 			resolver = resolverNative
 		} else {
@@ -143,15 +652,195 @@ func makeManifest(c *ManifestConfig, cacheRoot string) (manifest.OSBuildManifest
 		}
 		containerSpecs[plName], err = resolver.Finish()
 		if err != nil {
+			if c.ContainersStorage != "" {
+				return nil, fmt.Errorf("cannot find %s in the containers storage %s, build or pull it first: %w", c.Imgref, c.ContainersStorage, err)
+			}
 			return nil, err
 		}
 	}
 
+	if !c.NoArchCheck {
+		if err := c.checkBaseImageArch(containerSpecs); err != nil {
+			return nil, err
+		}
+	}
+	if c.BuildInfo != nil {
+		// the build info records the digest of the resolved base image
+		// that is built, it is added when the manifest is serialized
+		spec, ok := c.baseImageSpec(containerSpecs)
+		if !ok {
+			return nil, fmt.Errorf("cannot find the digest of %s", c.Imgref)
+		}
+		c.BuildInfo.BaseDigest = spec.Digest
+	}
+
+	return c.serializeManifest(manifest, depsolvedSets, containerSpecs, resolverTarget)
+}
+
+// serializeManifest serializes the manifest with the given packages and
+// containers and patches the customizations into it that osbuild/images
+// cannot express. The containers of the overlays are resolved with the
+// given resolver.
+func (c *ManifestConfig) serializeManifest(manifest *manifest.Manifest, depsolvedSets map[string][]rpmmd.PackageSpec, containerSpecs map[string][]container.Spec, resolverTarget containerResolver) (manifest.OSBuildManifest, error) {
 	mf, err := manifest.Serialize(depsolvedSets, containerSpecs, nil)
 	if err != nil {
 		return nil, fmt.Errorf("[ERROR] manifest serialization failed: %s", err.Error())
 	}
-	return mf, nil
+	// the customizations that osbuild/images cannot express are patched
+	// into the serialized manifest, see serialized_manifest.go
+	m, err := parseManifest(mf)
+	if err != nil {
+		return nil, err
+	}
+	// Most patches append stages or pipelines, or change the options of
+	// one stage, and do not depend on each other. The order matters for:
+	//   - the sector size, it goes first: the ESP and EFI fallback
+	//     stages copy the loopback device of the ESP and must inherit
+	//     its sector size
+	//   - the overlays, they go before the other patches that insert
+	//     stages before org.osbuild.ostree.selinux: those stages run
+	//     after the overlays, so the customizations win over the files
+	//     of the overlays
+	//   - the ESP label and the EFI fallback, they change the ESP
+	//     before exp.Finish of the esp image type copies it
+	//   - the eula and the installer post script, both replace the
+	//     kickstart with an extended copy of the last one: the post
+	//     script goes after the eula so its %post section comes last
+	//   - exp.Finish, it goes last as it builds on the final pipelines
+	if c.Config != nil && c.Config.SectorSize != 0 {
+		if err := setLoopbackSectorSize(m, c.Config.SectorSize); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && len(c.Config.Overlays) > 0 {
+		// resolved one by one as the resolver does not keep the order
+		var overlayContainers []container.Spec
+		for _, sourceSpec := range overlaySourceSpecs(c.Config.Overlays, c.TLSVerify) {
+			resolverTarget.Add(sourceSpec)
+			specs, err := resolverTarget.Finish()
+			if err != nil {
+				return nil, err
+			}
+			overlayContainers = append(overlayContainers, specs[0])
+		}
+		if err := addOverlays(m, c.Config.Overlays, overlayContainers); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.EmbeddedRepo != nil {
+		if err := addEmbeddedRepo(m, c.Config.EmbeddedRepo); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.CryptoPolicy != "" {
+		var customizations *blueprint.Customizations
+		if c.Config.Blueprint != nil {
+			customizations = c.Config.Blueprint.Customizations
+		}
+		if err := addCryptoPolicy(m, effectiveCryptoPolicy(c.Config.CryptoPolicy, customizations)); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.OSTreeRemote != nil {
+		if err := addOSTreeRemote(m, c.Config.OSTreeRemote); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.RootfsLabel != "" {
+		if err := setDeploymentRootfsLabel(m, c.Config.RootfsLabel); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && len(c.Config.Mounts) > 0 {
+		if err := setMountpointOwnership(m, c.Config.Mounts); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && len(c.Config.SELinuxContexts) > 0 {
+		if err := addSELinuxLabels(m, c.Config.SELinuxContexts); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.SELinuxMode != "" {
+		if err := setSELinuxConfig(m, c.Config.SELinuxMode); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && espLabel(c.Config.Mounts) != "" {
+		if err := setESPLabel(m, espLabel(c.Config.Mounts)); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && (len(c.Config.GrubUserConfig) > 0 || c.Config.GrubMenu != "") {
+		lines, err := c.Config.grubUserConfigLines()
+		if err != nil {
+			return nil, err
+		}
+		if err := addGrubUserConfig(m, lines); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.EFIFallback {
+		if err := addEFIFallback(m, c.Architecture, c.Config.efiVendor()); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.Bootloader == bootloaderNone {
+		if err := removeBootloader(m); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && len(c.Config.DataDisks) > 0 {
+		if err := addDataDisks(m, c.Config.DataDisks, os.Getenv("SOURCE_DATE_EPOCH")); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.ISOOptions != nil {
+		if err := setISOOptions(m, c.Config.ISOOptions, c.Architecture); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.EULA != "" {
+		content, err := readEULA(c.Config.EULA)
+		if err != nil {
+			return nil, err
+		}
+		if err := addEULA(m, content); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.InstallerPostScript != "" {
+		if err := addInstallerPostScript(m, c.Config.InstallerPostScript); err != nil {
+			return nil, err
+		}
+	}
+	if c.Config != nil && c.Config.Headless {
+		if err := addDefaultTarget(m, headlessTarget); err != nil {
+			return nil, err
+		}
+	}
+	// the installer does not set a timezone
+	if tz := c.timezone(); tz != "" && c.ImgType != "anaconda-iso" && c.ImgType != "iso" {
+		if err := addTimezone(m, tz); err != nil {
+			return nil, err
+		}
+	}
+	if c.BuildInfo != nil {
+		if err := addBuildInfo(m, c.BuildInfo); err != nil {
+			return nil, err
+		}
+	}
+	if c.ImgType == "squashfs" {
+		if err := addSquashfs(m, c.squashfsCompression(), c.Architecture); err != nil {
+			return nil, err
+		}
+	}
+	if exp, ok := experimentalImageTypes[c.ImgType]; ok && exp.Finish != nil {
+		if err := exp.Finish(m); err != nil {
+			return nil, err
+		}
+	}
+	return m.serialize()
 }
 
 func saveManifest(ms manifest.OSBuildManifest, fpath string) error {
@@ -160,30 +849,117 @@ func saveManifest(ms manifest.OSBuildManifest, fpath string) error {
 		return fmt.Errorf("failed to marshal data for %q: %s", fpath, err.Error())
 	}
 	b = append(b, '\n') // add new line at end of file
-	fp, err := os.Create(fpath)
+	// write to a temporary file first so that an interrupted write
+	// leaves no truncated manifest behind
+	fp, err := os.CreateTemp(filepath.Dir(fpath), "."+filepath.Base(fpath)+"-")
 	if err != nil {
 		return fmt.Errorf("failed to create output file %q: %s", fpath, err.Error())
 	}
+	defer os.Remove(fp.Name())
 	defer fp.Close()
 	if _, err := fp.Write(b); err != nil {
 		return fmt.Errorf("failed to write output file %q: %s", fpath, err.Error())
 	}
+	if err := fp.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to write output file %q: %s", fpath, err.Error())
+	}
+	if err := os.Rename(fp.Name(), fpath); err != nil {
+		return fmt.Errorf("failed to write output file %q: %s", fpath, err.Error())
+	}
 	return nil
 }
 
-func manifestFromCobra(cmd *cobra.Command, args []string) ([]byte, error) {
+// checkOutputs ensures that the given outputs (relative to outputDir)
+// do not exist yet, with force they may exist. They are only replaced
+// when the build succeeded, see stagedOutput.commit().
+func checkOutputs(outputDir string, outputs []string, force bool) error {
+	if force {
+		return nil
+	}
+	var existing []string
+	for _, output := range outputs {
+		_, err := os.Lstat(filepath.Join(outputDir, output))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		existing = append(existing, output)
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("output directory %q already contains %s, use --force to overwrite", outputDir, strings.Join(existing, ", "))
+	}
+	return nil
+}
+
+// copyExportsSparse copies the given osbuild exports from srcDir to
+// dstDir, holes in the files are preserved.
+func copyExportsSparse(dstDir, srcDir string, exports []string) error {
+	for _, export := range exports {
+		root := filepath.Join(srcDir, export)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(srcDir, path)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(dstDir, rel)
+			if d.IsDir() {
+				return os.MkdirAll(dst, 0755)
+			}
+			if !d.Type().IsRegular() {
+				return fmt.Errorf("cannot copy %q: not a regular file", path)
+			}
+			return util.CopyFileSparse(dst, path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// manifestConfigFromCobra creates the manifest config from the command
+// line, it does not resolve anything
+func manifestConfigFromCobra(cmd *cobra.Command, args []string) (*ManifestConfig, error) {
 	buildArch := arch.Current()
 	repos, err := loadRepos(buildArch.String())
 	if err != nil {
 		return nil, err
 	}
+	disabledRepos, _ := cmd.Flags().GetStringArray("disable-repo")
+	if len(disabledRepos) > 0 {
+		repos, err = disableRepos(repos, disabledRepos)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	imgref := args[0]
-	rpmCacheRoot, _ := cmd.Flags().GetString("rpmmd")
 	configFile, _ := cmd.Flags().GetString("config")
 	tlsVerify, _ := cmd.Flags().GetBool("tls-verify")
 	imgType, _ := cmd.Flags().GetString("type")
 	targetArch, _ := cmd.Flags().GetString("target-arch")
+	embedBuildInfo, _ := cmd.Flags().GetBool("embed-build-info")
+	diskSizeStr, _ := cmd.Flags().GetString("disk-size")
+	containersStorage, _ := cmd.Flags().GetString("containers-storage")
+	allowExperimental, _ := cmd.Flags().GetBool("allow-experimental")
+	inheritHostTimezone, _ := cmd.Flags().GetBool("inherit-host-timezone")
+	containerLockfile, _ := cmd.Flags().GetString("container-lockfile")
+	noArchCheck, _ := cmd.Flags().GetBool("no-arch-check")
+	local, _ := cmd.Flags().GetBool("local")
+	if local {
+		imgref, err = localImageRef(imgref)
+		if err != nil {
+			return nil, err
+		}
+		if containersStorage == "" {
+			containersStorage = defaultContainersStoragePath
+		}
+	}
 	if targetArch != "" {
 		// TODO: detect if binfmt_misc for target arch is
 		// available, e.g. by mounting the binfmt_misc fs into
@@ -207,6 +983,23 @@ func manifestFromCobra(cmd *cobra.Command, args []string) ([]byte, error) {
 	} else {
 		config = &BuildConfig{}
 	}
+	if config.SELinuxMode == SELinuxModeDisabled {
+		fmt.Fprintf(os.Stderr, "WARNING: SELinux is disabled in the image, this removes an important security layer and relabeling is needed to enable it again\n")
+	}
+	if config.DisableFirewall && config.Blueprint != nil && hasFirewallRules(config.Blueprint.Customizations) {
+		fmt.Fprintf(os.Stderr, "WARNING: disable_firewall masks firewalld, the firewall customizations have no effect\n")
+	}
+	if config.Headless {
+		if conflicts := headlessConflicts(config.Blueprint); len(conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "WARNING: headless is set but the blueprint adds the graphical packages %s\n", strings.Join(conflicts, ", "))
+		}
+	}
+	if conflicts := installerPostScriptConflicts(config.InstallerPostScript); len(conflicts) > 0 {
+		fmt.Fprintf(os.Stderr, "WARNING: installer_post_script runs after the generated kickstart sections, %q may conflict with them\n", conflicts)
+	}
+	if config.CryptoPolicy != "" && config.CryptoPolicy != "FIPS" && config.Blueprint != nil && config.Blueprint.Customizations.GetFIPS() {
+		fmt.Fprintf(os.Stderr, "WARNING: FIPS mode is enabled, the FIPS crypto policy is used instead of %s\n", config.CryptoPolicy)
+	}
 
 	manifestConfig := &ManifestConfig{
 		Imgref:       imgref,
@@ -215,12 +1008,74 @@ func manifestFromCobra(cmd *cobra.Command, args []string) ([]byte, error) {
 		Repos:        repos,
 		Architecture: buildArch,
 		TLSVerify:    tlsVerify,
+
+		AllowExperimental: allowExperimental,
+		NoArchCheck:       noArchCheck,
+	}
+	if containersStorage != "" {
+		if err := validateContainersStorage(containersStorage); err != nil {
+			return nil, err
+		}
+		manifestConfig.ContainersStorage = containersStorage
+	}
+	if diskSizeStr != "" {
+		manifestConfig.DiskSize, err = parseDiskSize(diskSizeStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if inheritHostTimezone {
+		if err := manifestConfig.inheritHostTimezone(); err != nil {
+			return nil, err
+		}
+	}
+	if containerLockfile != "" {
+		manifestConfig.ContainerLock, err = readContainerLock(containerLockfile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if embedBuildInfo {
+		manifestConfig.BuildInfo, err = newBuildInfo(imgref, imgType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifestConfig, nil
+}
+
+func manifestFromCobra(cmd *cobra.Command, args []string) ([]byte, *ManifestConfig, error) {
+	manifestConfig, err := manifestConfigFromCobra(cmd, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	rpmCacheRoot, _ := cmd.Flags().GetString("rpmmd")
+	mf, err := makeManifest(manifestConfig, rpmCacheRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lockPath, _ := cmd.Flags().GetString("container-lockfile"); lockPath != "" {
+		if err := writeContainerLock(manifestConfig.ContainerLock, lockPath); err != nil {
+			return nil, nil, fmt.Errorf("cannot write container lockfile: %w", err)
+		}
 	}
-	return makeManifest(manifestConfig, rpmCacheRoot)
+	return mf, manifestConfig, nil
 }
 
 func cmdManifest(cmd *cobra.Command, args []string) error {
-	mf, err := manifestFromCobra(cmd, args)
+	if validateOnly, _ := cmd.Flags().GetBool("only-manifest-validate"); validateOnly {
+		return validateManifestFromCobra(cmd, args)
+	}
+	if ptPath, _ := cmd.Flags().GetString("export-partition-table"); ptPath != "" {
+		// the layout does not depend on the resolved packages and
+		// containers
+		manifestConfig, err := manifestConfigFromCobra(cmd, args)
+		if err != nil {
+			return err
+		}
+		return exportPartitionLayout(manifestConfig, ptPath)
+	}
+	mf, _, err := manifestFromCobra(cmd, args)
 	if err != nil {
 		return err
 	}
@@ -228,15 +1083,137 @@ func cmdManifest(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// validateManifestFromCobra creates the manifest from the command line
+// without resolving the container or depsolving packages, so only the
+// errors of the config itself are reported and no network is needed.
+func validateManifestFromCobra(cmd *cobra.Command, args []string) error {
+	manifestConfig, err := manifestConfigFromCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+	if err := validateManifest(manifestConfig); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "manifest for %s is valid\n", manifestConfig.ImgType)
+	return nil
+}
+
+// validateManifest creates and patches the manifest like makeManifest
+// but with placeholders for the packages and containers, so the
+// customizations are checked without resolving anything
+func validateManifest(c *ManifestConfig) error {
+	manifest, err := Manifest(c)
+	if err != nil {
+		return err
+	}
+	depsolvedSets := make(map[string][]rpmmd.PackageSpec)
+	for name, chain := range c.packageSetChains(manifest) {
+		depsolvedSets[name] = placeholderPackages(chain, c.Architecture)
+	}
+	resolver := &placeholderResolver{}
+	containerSpecs := make(map[string][]container.Spec)
+	for plName, sourceSpecs := range manifest.GetContainerSourceSpecs() {
+		for _, spec := range sourceSpecs {
+			resolver.Add(spec)
+		}
+		if containerSpecs[plName], err = resolver.Finish(); err != nil {
+			return err
+		}
+	}
+	_, err = c.serializeManifest(manifest, depsolvedSets, containerSpecs, resolver)
+	return err
+}
+
+// placeholderDigest is the checksum of every placeholder package and
+// container of validateManifest
+const placeholderDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+// placeholderPackages returns a package for every package that is
+// included by the given package sets
+func placeholderPackages(chain []rpmmd.PackageSet, a arch.Arch) []rpmmd.PackageSpec {
+	var packages []rpmmd.PackageSpec
+	for _, pkgSet := range chain {
+		for _, name := range pkgSet.Include {
+			packages = append(packages, rpmmd.PackageSpec{
+				Name:     name,
+				Version:  "0",
+				Release:  "0",
+				Arch:     a.String(),
+				Checksum: placeholderDigest,
+			})
+		}
+	}
+	return packages
+}
+
+// placeholderResolver "resolves" every container to a placeholder
+// without looking at it
+type placeholderResolver struct {
+	sources []container.SourceSpec
+}
+
+func (r *placeholderResolver) Add(spec container.SourceSpec) {
+	r.sources = append(r.sources, spec)
+}
+
+func (r *placeholderResolver) Finish() ([]container.Spec, error) {
+	specs := make([]container.Spec, 0, len(r.sources))
+	for _, src := range r.sources {
+		specs = append(specs, container.Spec{
+			Source:              src.Source,
+			Digest:              placeholderDigest,
+			ImageID:             placeholderDigest,
+			LocalName:           src.Name,
+			TLSVerify:           src.TLSVerify,
+			ContainersTransport: src.ContainersTransport,
+			StoragePath:         src.StoragePath,
+		})
+	}
+	r.sources = nil
+	return specs, nil
+}
+
 func cmdBuild(cmd *cobra.Command, args []string) error {
 	outputDir, _ := cmd.Flags().GetString("output")
 	osbuildStore, _ := cmd.Flags().GetString("store")
 	imgType, _ := cmd.Flags().GetString("type")
 	targetArch, _ := cmd.Flags().GetString("target-arch")
+	force, _ := cmd.Flags().GetBool("force")
+	signKey, _ := cmd.Flags().GetString("sign-key")
+	emitAMIRegisterParams, _ := cmd.Flags().GetBool("emit-ami-register-params")
+	emitCloudConfigTemplate, _ := cmd.Flags().GetBool("emit-cloud-config-template")
+	outputOwner, _ := cmd.Flags().GetString("output-owner")
+	showTimings, _ := cmd.Flags().GetBool("timings")
+	timingsJSON, _ := cmd.Flags().GetString("timings-json")
+	trace, _ := cmd.Flags().GetBool("trace")
+	osbuildLogPath, _ := cmd.Flags().GetString("osbuild-log")
+	exportManifestPath, _ := cmd.Flags().GetString("export-manifest")
+	resolveOnly, _ := cmd.Flags().GetBool("resolve-only")
+	parallelUploads, _ := cmd.Flags().GetInt("parallel-uploads")
 
+	if resolveOnly && exportManifestPath == "" {
+		return fmt.Errorf("--resolve-only needs --export-manifest to write the manifest to")
+	}
+	if parallelUploads < 1 {
+		return fmt.Errorf("--parallel-uploads must be at least 1, got %d", parallelUploads)
+	}
 	if err := setup.Validate(); err != nil {
 		return err
 	}
+	var ownerUID, ownerGID int
+	if outputOwner != "" {
+		var err error
+		ownerUID, ownerGID, err = parseOutputOwner(outputOwner)
+		if err != nil {
+			return err
+		}
+	}
+	// fail early instead of after a long build
+	if signKey != "" {
+		if err := checkSignKey(signKey); err != nil {
+			return err
+		}
+	}
 	if err := setup.EnsureEnvironment(); err != nil {
 		return err
 	}
@@ -245,6 +1222,13 @@ func cmdBuild(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if emitAMIRegisterParams && imgType != "ami" {
+		return fmt.Errorf("--emit-ami-register-params is only supported for the ami image type (type is set to %s)", imgType)
+	}
+	if emitCloudConfigTemplate && (imgType == "anaconda-iso" || imgType == "iso") {
+		return fmt.Errorf("--emit-cloud-config-template is only supported for disk image types (type is set to %s)", imgType)
+	}
+
 	upload := false
 	if region, _ := cmd.Flags().GetString("aws-region"); region != "" {
 		if imgType != "ami" {
@@ -267,28 +1251,91 @@ func cmdBuild(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if outputOwner != "" && !canChown {
+		return fmt.Errorf("cannot set the output owner to %s, the ownership of files in %s cannot be changed", outputOwner, outputDir)
+	}
+	// the initramfs is packed from the exported tree, the system does
+	// not run with the files owned by the user that built it
+	if imgType == "initramfs" && !canChown {
+		return fmt.Errorf("cannot build the initramfs image type, the ownership of files in %s cannot be changed", outputDir)
+	}
 
 	manifest_fname := fmt.Sprintf("manifest-%s.json", imgType)
 	fmt.Printf("Generating %s ... ", manifest_fname)
-	mf, err := manifestFromCobra(cmd, args)
+	mf, manifestConfig, err := manifestFromCobra(cmd, args)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if emitCloudConfigTemplate && manifestConfig.Config != nil && manifestConfig.Config.DisableCloudInit {
+		fmt.Fprintf(os.Stderr, "WARNING: disable_cloud_init masks cloud-init, the image does not use the cloud-config template\n")
 	}
 	fmt.Print("DONE\n")
+	// the manifest has the checksums of the packages and the digests
+	// of the containers, osbuild builds it later without resolving
+	if exportManifestPath != "" {
+		if err := saveManifest(mf, exportManifestPath); err != nil {
+			return err
+		}
+		fmt.Printf("Manifest written to %s\n", exportManifestPath)
+	}
+	if resolveOnly {
+		return nil
+	}
 
 	var exports []string
 	switch imgType {
-	case "qcow2":
+	case "qcow2", "vagrant-libvirt":
 		exports = []string{"qcow2"}
 	case "ami", "raw":
 		exports = []string{"image"}
 	case "anaconda-iso", "iso":
 		exports = []string{"bootiso"}
+	case "squashfs":
+		exports = []string{squashfsPipelineName}
 	default:
-		return fmt.Errorf("valid types are 'qcow2', 'ami', 'raw', 'anaconda-iso', not: '%s'", imgType)
+		exp, ok := experimentalImageTypes[imgType]
+		if !ok {
+			return fmt.Errorf("valid types are 'qcow2', 'ami', 'raw', 'vagrant-libvirt', 'anaconda-iso', 'squashfs', not: '%s'", imgType)
+		}
+		exports = []string{exp.Export}
+	}
+	hasDataDisks := len(manifestConfig.Config.DataDisks) > 0
+	if hasDataDisks {
+		exports = append(exports, dataDisksPipelineName)
+	}
+	// the outputs are the exports, unless an export is packaged
+	// into another output after the build
+	outputs := exports
+	if imgType == "vagrant-libvirt" || imgType == "initramfs" {
+		outputs = append([]string{imgType}, exports[1:]...)
+	}
+
+	if err := checkOutputs(outputDir, append([]string{manifest_fname}, outputs...), force); err != nil {
+		return err
+	}
+	// the combined checksums of all outputs (and their signature)
+	// are written to the output directory itself, they are merged
+	// with the ones of previous builds into the same directory
+	committed := append([]string{manifest_fname}, outputs...)
+	committed = append(committed, checksumsFilename)
+	if signKey != "" {
+		committed = append(committed, checksumsFilename+signatureSuffix)
+	}
+
+	// Artifacts (and the manifest) are written to a staging directory
+	// and only moved into place once the build succeeded, failed or
+	// interrupted builds leave the previous artifacts untouched.
+	staging, err := newStagedOutput(outputDir)
+	if err != nil {
+		return err
 	}
+	defer staging.cleanup()
+	// SIGINT and SIGTERM stop osbuild, the staging (and export) dir is
+	// removed by the deferred cleanups once it exited
+	ctx, interrupted, stopInterrupt := notifyInterrupt()
+	defer stopInterrupt()
 
-	manifestPath := filepath.Join(outputDir, manifest_fname)
+	manifestPath := filepath.Join(staging.Dir, manifest_fname)
 	if err := saveManifest(mf, manifestPath); err != nil {
 		return err
 	}
@@ -300,29 +1347,263 @@ func cmdBuild(cmd *cobra.Command, args []string) error {
 		// set export options for osbuild
 		osbuildEnv = []string{"OSBUILD_EXPORT_FORCE_NO_PRESERVE_OWNER=1"}
 	}
-	_, err = osbuild.RunOSBuild(mf, osbuildStore, outputDir, exports, nil, osbuildEnv, false, os.Stderr)
+
+	// Raw images are mostly empty, export them into the store first
+	// and then copy them to the staging dir without writing the holes.
+	// The tree of the initramfs is only packed, it is not an output.
+	exportDir := staging.Dir
+	sparseExport := imgType == "ami" || imgType == "raw" || hasDataDisks
+	if sparseExport || imgType == "initramfs" {
+		if err := os.MkdirAll(osbuildStore, 0755); err != nil {
+			return err
+		}
+		exportDir, err = os.MkdirTemp(osbuildStore, "export-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(exportDir)
+	}
+	var osbuildStdout, osbuildStderr io.Writer = os.Stdout, os.Stderr
+	if osbuildLogPath != "" {
+		osbuildLog, err := openOSBuildLog(osbuildLogPath)
+		if err != nil {
+			return err
+		}
+		defer osbuildLog.Close()
+		osbuildStdout, osbuildStderr = osbuildLog.tee(osbuildStdout, osbuildStderr)
+	}
+
+	withTimings := showTimings || timingsJSON != ""
+	var osbuildTraceInfo osbuildTrace
+	if trace {
+		var extraArgs []string
+		if withTimings {
+			extraArgs = timingsMonitorArgs
+		}
+		osbuildTraceInfo = osbuildTrace{
+			Args:     osbuildArgs(osbuildStore, exportDir, exports, extraArgs),
+			Environ:  append(os.Environ(), osbuildEnv...),
+			Store:    osbuildStore,
+			Output:   outputDir,
+			Manifest: manifestPath,
+		}
+		writeTrace(os.Stderr, "running osbuild", osbuildTraceInfo)
+	}
+	traceFailure := func(err error) error {
+		if intErr := interrupted(); intErr != nil {
+			return intErr
+		}
+		if trace {
+			writeTrace(os.Stderr, fmt.Sprintf("osbuild failed: %s", err), osbuildTraceInfo)
+		}
+		return err
+	}
+
+	if withTimings {
+		timings, err := runOSBuildWithTimings(ctx, mf, osbuildStore, exportDir, exports, osbuildEnv, osbuildStdout, osbuildStderr)
+		if err != nil {
+			return traceFailure(err)
+		}
+		if showTimings {
+			if err := printTimings(os.Stdout, timings); err != nil {
+				return err
+			}
+		}
+		if timingsJSON != "" {
+			if err := writeTimingsJSON(timingsJSON, timings); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := runOSBuild(ctx, mf, osbuildStore, exportDir, exports, osbuildEnv, osbuildStdout, osbuildStderr); err != nil {
+			return traceFailure(err)
+		}
+	}
+	if sparseExport {
+		if err := copyExportsSparse(staging.Dir, exportDir, exports); err != nil {
+			return err
+		}
+	}
+
+	if imgType == "vagrant-libvirt" {
+		diskSize, err := manifestConfig.diskSize()
+		if err != nil {
+			return err
+		}
+		if err := os.Mkdir(filepath.Join(staging.Dir, imgType), 0755); err != nil {
+			return err
+		}
+		boxPath := filepath.Join(staging.Dir, imgType, vagrantBoxFilename)
+		if err := writeVagrantBox(boxPath, filepath.Join(staging.Dir, "qcow2", "disk.qcow2"), diskSize); err != nil {
+			return err
+		}
+	}
+
+	if imgType == "initramfs" {
+		size, err := writeInitramfs(filepath.Join(staging.Dir, imgType), filepath.Join(exportDir, initramfsExport))
+		if err != nil {
+			return err
+		}
+		if size > initramfsWarnSize {
+			fmt.Fprintf(os.Stderr, "WARNING: the initramfs unpacks to %d MiB, boot loaders may fail to load it and the system needs more RAM than that\n", size/MebiByte)
+		}
+	}
+
+	checksumFiles, err := writeChecksums(staging.Dir, outputs)
+	if err != nil {
+		return err
+	}
+	baseDigest, err := manifestBaseDigest(mf, manifestConfig.Imgref)
+	if err != nil {
+		return err
+	}
+	meta := ArtifactMetadata{
+		ImageType:  imgType,
+		Arch:       manifestConfig.Architecture.String(),
+		BaseImage:  manifestConfig.Imgref,
+		BaseDigest: baseDigest,
+		BibVersion: bibVersion,
+	}
+	if err := writeArtifactMetadata(staging.Dir, outputs, meta); err != nil {
+		return err
+	}
+	if emitAMIRegisterParams {
+		buildArch := arch.Current()
+		if targetArch != "" {
+			buildArch = arch.FromString(targetArch)
+		}
+		paramsPath := filepath.Join(staging.Dir, exports[0], "ami-register-params.json")
+		if err := writeAMIRegisterParams(paramsPath, buildArch); err != nil {
+			return err
+		}
+	}
+	if emitCloudConfigTemplate {
+		var customizations *blueprint.Customizations
+		if manifestConfig.Config != nil && manifestConfig.Config.Blueprint != nil {
+			customizations = manifestConfig.Config.Blueprint.Customizations
+		}
+		templatePath := filepath.Join(staging.Dir, outputs[0], cloudConfigTemplateFilename)
+		if err := writeCloudConfigTemplate(templatePath, customizations); err != nil {
+			return err
+		}
+	}
+	// the combined checksums cover the files next to the artifacts as
+	// well, so they are written (and signed) after all of them
+	combinedChecksums, err := writeCombinedChecksums(staging.Dir, outputDir, outputs)
 	if err != nil {
 		return err
 	}
+	checksumFiles = append(checksumFiles, combinedChecksums)
+	if signKey != "" {
+		if _, err := signChecksums(signKey, checksumFiles); err != nil {
+			return err
+		}
+	}
+	if outputOwner != "" {
+		// after all sidecar files are written so that they are
+		// included
+		for _, output := range committed {
+			if err := chownTree(filepath.Join(staging.Dir, output), ownerUID, ownerGID); err != nil {
+				return err
+			}
+		}
+	}
+	// nothing is committed once the build was interrupted
+	if err := interrupted(); err != nil {
+		return err
+	}
+	if err := staging.commit(committed); err != nil {
+		return err
+	}
+	if signKey == "" {
+		if err := removeStaleSignature(outputDir); err != nil {
+			return err
+		}
+	}
 
 	fmt.Println("Build complete!")
 	if upload {
+		var jobs []uploadJob
 		switch imgType {
 		case "ami":
 			diskpath := filepath.Join(outputDir, exports[0], "disk.raw")
-			if err := uploadAMI(diskpath, targetArch, cmd.Flags()); err != nil {
-				return err
-			}
+			jobs = append(jobs, uploadJob{
+				Name: "ami",
+				Upload: func() error {
+					return uploadAMI(diskpath, targetArch, cmd.Flags())
+				},
+			})
 		default:
 			return fmt.Errorf("upload set but image type %s doesn't support uploading", imgType)
 		}
+		if err := runUploads(jobs, parallelUploads); err != nil {
+			return err
+		}
 	} else {
 		fmt.Printf("Results saved in\n%s\n", outputDir)
 	}
 	return nil
 }
 
-func run() error {
+func cmdListTypes(cmd *cobra.Command, args []string) error {
+	asJSON, _ := cmd.Flags().GetBool("json")
+	return listTypes(os.Stdout, asJSON)
+}
+
+func cmdDoctor(cmd *cobra.Command, args []string) error {
+	outputDir, _ := cmd.Flags().GetString("output")
+	storeDir, _ := cmd.Flags().GetString("store")
+	return runDoctor(os.Stdout, doctorChecks(outputDir, storeDir))
+}
+
+func cmdInspectManifest(cmd *cobra.Command, args []string) error {
+	asJSON, _ := cmd.Flags().GetBool("json")
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	mi, err := inspectManifest(data)
+	if err != nil {
+		return err
+	}
+	return writeManifestInspection(os.Stdout, mi, asJSON)
+}
+
+func cmdVerify(cmd *cobra.Command, args []string) error {
+	tlsVerify, _ := cmd.Flags().GetBool("tls-verify")
+	targetArch, _ := cmd.Flags().GetString("target-arch")
+	policyPath, _ := cmd.Flags().GetString("policy")
+
+	imgArch := arch.Current()
+	if targetArch != "" {
+		imgArch = arch.FromString(targetArch)
+	}
+	c := &ManifestConfig{
+		Imgref:       args[0],
+		Architecture: imgArch,
+		TLSVerify:    tlsVerify,
+	}
+	return verifyImage(cmd.OutOrStdout(), c, policyPath)
+}
+
+func cmdPull(cmd *cobra.Command, args []string) error {
+	tlsVerify, _ := cmd.Flags().GetBool("tls-verify")
+	targetArch, _ := cmd.Flags().GetString("target-arch")
+	storeDir, _ := cmd.Flags().GetString("store")
+
+	imgArch := arch.Current()
+	if targetArch != "" {
+		imgArch = arch.FromString(targetArch)
+	}
+	c := &ManifestConfig{
+		Imgref:       args[0],
+		Architecture: imgArch,
+		TLSVerify:    tlsVerify,
+	}
+	return pullImage(cmd.OutOrStdout(), c, storeDir)
+}
+
+func newRootCmd() (*cobra.Command, error) {
 	rootCmd := &cobra.Command{
 		Use:  "bootc-image-builder",
 		Long: "create a bootable image from an ostree native container",
@@ -346,36 +1627,128 @@ func run() error {
 		SilenceUsage:          true,
 	}
 	rootCmd.AddCommand(manifestCmd)
+	listTypesCmd := &cobra.Command{
+		Use:                   "list-types",
+		Long:                  "list the supported image types, the experimental image types that need --allow-experimental are not listed",
+		Args:                  cobra.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE:                  cmdListTypes,
+		SilenceUsage:          true,
+	}
+	rootCmd.AddCommand(listTypesCmd)
+	listTypesCmd.Flags().Bool("json", false, "list the image types with their default and minimum disk sizes as JSON")
+	doctorCmd := &cobra.Command{
+		Use:                   "doctor",
+		Long:                  "check that the environment can run builds",
+		Args:                  cobra.NoArgs,
+		DisableFlagsInUseLine: true,
+		RunE:                  cmdDoctor,
+		SilenceUsage:          true,
+	}
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().String("output", ".", "artifact output directory to check")
+	doctorCmd.Flags().String("store", "/store", "osbuild store to check")
+	verifyCmd := &cobra.Command{
+		Use:                   "verify",
+		Long:                  "resolve the digest of a container image and verify its signature without building anything",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		RunE:                  cmdVerify,
+		SilenceUsage:          true,
+	}
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().String("policy", "", "containers-policy.json(5) to verify the signature against, e.g. "+defaultPolicyPath)
+	verifyCmd.Flags().Bool("tls-verify", true, "require HTTPS and verify certificates when contacting registries")
+	verifyCmd.Flags().String("target-arch", "", "resolve the image for the given target architecture")
+	pullCmd := &cobra.Command{
+		Use:                   "pull",
+		Long:                  "resolve a container image and download it into the osbuild store without building anything",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		RunE:                  cmdPull,
+		SilenceUsage:          true,
+	}
+	rootCmd.AddCommand(pullCmd)
+	pullCmd.Flags().String("store", "/store", "osbuild store to download the image to")
+	pullCmd.Flags().Bool("tls-verify", true, "require HTTPS and verify certificates when contacting registries")
+	pullCmd.Flags().String("target-arch", "", "pull the image for the given target architecture")
+	inspectManifestCmd := &cobra.Command{
+		Use:                   "inspect-manifest",
+		Long:                  "print the pipelines and stages of an osbuild manifest and check its pipelines",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		RunE:                  cmdInspectManifest,
+		SilenceUsage:          true,
+	}
+	rootCmd.AddCommand(inspectManifestCmd)
+	inspectManifestCmd.Flags().Bool("json", false, "print the pipelines, sources and warnings as JSON")
 	manifestCmd.Flags().String("rpmmd", "/rpmmd", "rpm metadata cache directory")
 	manifestCmd.Flags().String("config", "", "build config file")
-	manifestCmd.Flags().String("type", "qcow2", "image type to build [qcow2, ami]")
+	manifestCmd.Flags().String("type", "qcow2", "image type to build [qcow2, ami, raw, vagrant-libvirt, anaconda-iso, squashfs]")
 	manifestCmd.Flags().Bool("tls-verify", true, "require HTTPS and verify certificates when contacting registries")
 	manifestCmd.Flags().String("target-arch", "", "build for the given target architecture (experimental)")
+	manifestCmd.Flags().String("containers-storage", "", "take the image from the containers storage at the given path instead of a registry")
+	manifestCmd.Flags().Bool("allow-experimental", false, "allow building experimental image types, they are not for production")
+	manifestCmd.Flags().Bool("local", false, "take a locally built image, e.g. my-image:latest, from the containers storage ("+defaultContainersStoragePath+" unless --containers-storage is set)")
+	manifestCmd.Flags().StringArray("disable-repo", nil, "do not use the repository with the given id for the build packages (can be repeated)")
+	manifestCmd.Flags().String("disk-size", "", "size of the disk image, e.g. 20GiB (see \"list-types --json\" for the defaults)")
+	manifestCmd.Flags().Bool("embed-build-info", false, "write build information to "+buildInfoPath+" in the image")
+	manifestCmd.Flags().Bool("inherit-host-timezone", false, "set the timezone of the host in disk images without a timezone customization")
+	manifestCmd.Flags().Bool("no-arch-check", false, "do not check that the base image is built for the target architecture")
+	manifestCmd.Flags().String("container-lockfile", "", "use the container digests of the given lockfile, it is written with the resolved digests if it does not exist")
 
 	logrus.SetLevel(logrus.ErrorLevel)
 	buildCmd.Flags().AddFlagSet(manifestCmd.Flags())
+	// only valid for "manifest", the build needs no separate layout
+	manifestCmd.Flags().String("export-partition-table", "", "only write the partition layout of the disk image as JSON to the given path, nothing is resolved")
+	manifestCmd.Flags().Bool("only-manifest-validate", false, "only check that the manifest can be created from the config, nothing is resolved")
 	buildCmd.Flags().String("output", ".", "artifact output directory")
 	buildCmd.Flags().String("store", "/store", "osbuild store for intermediate pipeline trees")
+	buildCmd.Flags().Bool("force", false, "overwrite existing artifacts in the output directory")
+	buildCmd.Flags().String("export-manifest", "", "write the resolved manifest with pinned packages and containers to the given path")
+	buildCmd.Flags().Bool("resolve-only", false, "only resolve the packages and containers and write the manifest (needs --export-manifest), nothing is built")
+	buildCmd.Flags().Bool("timings", false, "print the duration of each osbuild stage after the build")
+	buildCmd.Flags().String("timings-json", "", "write the duration of each osbuild stage as JSON to the given path")
+	buildCmd.Flags().String("osbuild-log", "", "write the output of osbuild to the given file as well")
+	buildCmd.Flags().Bool("trace", false, "print the osbuild command line and its environment before the build and when it fails")
+	buildCmd.Flags().String("output-owner", "", "set the owner of the artifacts to the given numeric uid:gid")
+	buildCmd.Flags().String("sign-key", "", "GPG key to create detached signatures of the checksum files with")
+	buildCmd.Flags().Int("parallel-uploads", 1, "maximum number of artifacts that are uploaded to clouds at the same time")
 	buildCmd.Flags().String("aws-region", "", "target region for AWS uploads (only for type=ami)")
 	buildCmd.Flags().String("aws-bucket", "", "target S3 bucket name for intermediate storage when creating AMI (only for type=ami)")
 	buildCmd.Flags().String("aws-ami-name", "", "name for the AMI in AWS (only for type=ami)")
+	buildCmd.Flags().Bool("emit-ami-register-params", false, "write the parameters to register the AMI with to ami-register-params.json (only for type=ami)")
+	buildCmd.Flags().Bool("emit-cloud-config-template", false, "write a sample cloud-config for the image to "+cloudConfigTemplateFilename+" (only for disk images)")
 
 	// flag rules
-	for _, dname := range []string{"output", "store", "rpmmd"} {
+	for _, dname := range []string{"output", "store", "rpmmd", "containers-storage"} {
 		if err := buildCmd.MarkFlagDirname(dname); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	if err := buildCmd.MarkFlagFilename("config"); err != nil {
-		return err
+		return nil, err
 	}
 	buildCmd.MarkFlagsRequiredTogether("aws-region", "aws-bucket", "aws-ami-name")
 
+	return rootCmd, nil
+}
+
+func run() error {
+	rootCmd, err := newRootCmd()
+	if err != nil {
+		return err
+	}
 	return rootCmd.Execute()
 }
 
 func main() {
 	if err := run(); err != nil {
+		var intErr *interruptedError
+		if errors.As(err, &intErr) {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(intErr.exitCode())
+		}
 		log.Fatalf("error: %s", err)
 	}
 }
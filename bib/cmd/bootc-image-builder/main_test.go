@@ -1,16 +1,22 @@
 package main_test
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+	"github.com/osbuild/images/pkg/arch"
 	"github.com/osbuild/images/pkg/blueprint"
 	"github.com/osbuild/images/pkg/container"
 	"github.com/osbuild/images/pkg/manifest"
@@ -58,7 +64,13 @@ type manifestTestCase struct {
 	containers map[string][]container.Spec
 	expStages  map[string][]string
 	nexpStages map[string][]string
+	// expOptions are json values that the options of a stage of the
+	// given type must contain
+	expOptions map[string]map[string][]string
 	err        interface{}
+	// patch changes the serialized manifest like the build does for
+	// what osbuild/images cannot do yet
+	patch func(manifest.OSBuildManifest) (manifest.OSBuildManifest, error)
 }
 
 func getBaseConfig() *main.ManifestConfig {
@@ -88,6 +100,57 @@ func getUserConfig() *main.ManifestConfig {
 	}
 }
 
+// Disk images require a container for the build pipeline and the ostree-deployment.
+func getDiskContainers() map[string][]container.Spec {
+	return map[string][]container.Spec{
+		"build": {
+			testContainerSpec,
+		},
+		"ostree-deployment": {
+			testContainerSpec,
+		},
+	}
+}
+
+// ISOs require a container for the bootiso-tree, build packages, and packages for the anaconda-tree (with a kernel).
+func getISOContainers() map[string][]container.Spec {
+	return map[string][]container.Spec{
+		"bootiso-tree": {
+			testContainerSpec,
+		},
+	}
+}
+
+func getISOPackages() map[string][]rpmmd.PackageSpec {
+	return map[string][]rpmmd.PackageSpec{
+		"build": {
+			{
+				Name:     "package",
+				Version:  "113",
+				Checksum: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+			},
+		},
+		"anaconda-tree": {
+			{
+				Name:     "kernel",
+				Version:  "10.11",
+				Checksum: "sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc",
+			},
+			{
+				Name:     "package",
+				Version:  "113",
+				Checksum: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+			},
+		},
+	}
+}
+
+var testContainerSpec = container.Spec{
+	Source:  "test-container",
+	Digest:  "sha256:dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd",
+	ImageID: "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+}
+
 func TestManifestGenerationEmptyConfig(t *testing.T) {
 	baseConfig := getBaseConfig()
 	testCases := map[string]manifestTestCase{
@@ -164,66 +227,21 @@ func TestManifestSerialization(t *testing.T) {
 	// Tests that the manifest is generated without error and is serialized
 	// with expected key stages.
 
-	// Disk images require a container for the build pipeline and the ostree-deployment.
-	containerSpec := container.Spec{
-		Source:  "test-container",
-		Digest:  "sha256:dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd",
-		ImageID: "sha256:1111111111111111111111111111111111111111111111111111111111111111",
-	}
-	diskContainers := map[string][]container.Spec{
-		"build": {
-			containerSpec,
-		},
-		"ostree-deployment": {
-			containerSpec,
-		},
-	}
-
-	// ISOs require a container for the bootiso-tree, build packages, and packages for the anaconda-tree (with a kernel).
-	isoContainers := map[string][]container.Spec{
-		"bootiso-tree": {
-			containerSpec,
-		},
-	}
-	isoPackages := map[string][]rpmmd.PackageSpec{
-		"build": {
-			{
-				Name:     "package",
-				Version:  "113",
-				Checksum: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
-			},
-		},
-		"anaconda-tree": {
-			{
-				Name:     "kernel",
-				Version:  "10.11",
-				Checksum: "sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc",
-			},
-			{
-				Name:     "package",
-				Version:  "113",
-				Checksum: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
-			},
-		},
-	}
+	diskContainers := getDiskContainers()
+	isoContainers := getISOContainers()
+	isoPackages := getISOPackages()
 
 	pkgsNoBuild := map[string][]rpmmd.PackageSpec{
-		"anaconda-tree": {
-			{
-				Name:     "kernel",
-				Version:  "10.11",
-				Checksum: "sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc",
-			},
-			{
-				Name:     "package",
-				Version:  "113",
-				Checksum: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
-			},
-		},
+		"anaconda-tree": isoPackages["anaconda-tree"],
 	}
 
 	baseConfig := getBaseConfig()
 	userConfig := getUserConfig()
+	bootloaderNoneConfig := getBaseConfig()
+	bootloaderNoneConfig.Config = &main.BuildConfig{Bootloader: "none"}
+	bootEntryLabel := "ACME Appliance"
+	bootEntryLabelConfig := getBaseConfig()
+	bootEntryLabelConfig.Config = &main.BuildConfig{BootEntryLabel: &bootEntryLabel}
 	testCases := map[string]manifestTestCase{
 		"ami-base": {
 			config:     baseConfig,
@@ -321,6 +339,33 @@ func TestManifestSerialization(t *testing.T) {
 				"build": {"org.osbuild.rpm"},
 			},
 		},
+		"qcow2-bootloader-none": {
+			config:     bootloaderNoneConfig,
+			imageType:  "qcow2",
+			containers: diskContainers,
+			patch:      main.RemoveBootloader,
+			expStages: map[string][]string{
+				"image": {"org.osbuild.sfdisk", "org.osbuild.copy"},
+				"ostree-deployment": {
+					"org.osbuild.ostree.deploy.container",
+				},
+			},
+			nexpStages: map[string][]string{
+				"image": {"org.osbuild.bootupd", "org.osbuild.grub2", "org.osbuild.grub2.inst"},
+				"ostree-deployment": {
+					"org.osbuild.grub2",
+				},
+			},
+		},
+		"ami-bootloader-none": {
+			config:     bootloaderNoneConfig,
+			imageType:  "ami",
+			containers: diskContainers,
+			patch:      main.RemoveBootloader,
+			nexpStages: map[string][]string{
+				"image": {"org.osbuild.bootupd", "org.osbuild.grub2", "org.osbuild.grub2.inst"},
+			},
+		},
 		"iso-user": {
 			config:     userConfig,
 			imageType:  "iso",
@@ -331,6 +376,17 @@ func TestManifestSerialization(t *testing.T) {
 				"bootiso-tree": {"org.osbuild.skopeo"}, // adds the container to the ISO tree
 			},
 		},
+		"iso-boot-entry-label": {
+			config:     bootEntryLabelConfig,
+			imageType:  "iso",
+			containers: isoContainers,
+			packages:   isoPackages,
+			// anaconda creates the UEFI boot entry from the product name
+			expOptions: map[string]map[string][]string{
+				"anaconda-tree": {"org.osbuild.buildstamp": {`{"product": "ACME Appliance"}`}},
+				"efiboot-tree":  {"org.osbuild.grub2.iso": {`{"product": {"name": "ACME Appliance"}}`}},
+			},
+		},
 		"iso-nobuildpkg": {
 			config:     userConfig,
 			imageType:  "iso",
@@ -378,7 +434,12 @@ func TestManifestSerialization(t *testing.T) {
 			} else {
 				manifestJson, err := mf.Serialize(tc.packages, tc.containers, nil)
 				assert.NoError(err)
+				if tc.patch != nil {
+					manifestJson, err = tc.patch(manifestJson)
+					assert.NoError(err)
+				}
 				assert.NoError(checkStages(manifestJson, tc.expStages, tc.nexpStages))
+				assert.NoError(checkStageOptions(manifestJson, tc.expOptions))
 			}
 		})
 	}
@@ -402,15 +463,131 @@ func TestManifestSerialization(t *testing.T) {
 }
 
 // simplified representation of a manifest
+func TestManifestFilesystemCustomizations(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Filesystem: []blueprint.FilesystemCustomization{
+					{Mountpoint: "/var", MinSize: 5 * 1024 * 1024 * 1024},
+				},
+			},
+		},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	// the separate /var is created and mounted by the system
+	stages, err := findStages(manifestJson, "image", "org.osbuild.mkfs.xfs")
+	require.NoError(t, err)
+	assert.Len(t, stages, 1)
+	stages, err = findStages(manifestJson, "ostree-deployment", "org.osbuild.fstab")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	assert.Contains(t, string(stages[0].Options), `"path":"/var"`)
+}
+
+func TestManifestFilesystemCustomizationsErrors(t *testing.T) {
+	for _, mountpoint := range []string{"/var/home", "/etc"} {
+		t.Run(mountpoint, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{
+				Blueprint: &blueprint.Blueprint{
+					Customizations: &blueprint.Customizations{
+						Filesystem: []blueprint.FilesystemCustomization{
+							{Mountpoint: mountpoint, MinSize: 1024 * 1024 * 1024},
+						},
+					},
+				},
+			}
+			_, err := main.Manifest(config)
+			assert.ErrorContains(t, err, "The following custom mountpoints are not supported")
+		})
+	}
+}
+
 type testManifest struct {
 	Pipelines []pipeline `json:"pipelines"`
+	Sources   struct {
+		Inline struct {
+			Items map[string]struct {
+				Data string `json:"data"`
+			} `json:"items"`
+		} `json:"org.osbuild.inline"`
+	} `json:"sources"`
 }
 type pipeline struct {
 	Name   string  `json:"name"`
 	Stages []stage `json:"stages"`
 }
 type stage struct {
-	Type string `json:"type"`
+	Type    string          `json:"type"`
+	Options json.RawMessage `json:"options"`
+	Mounts  json.RawMessage `json:"mounts"`
+}
+
+// findStages returns all stages of the given type in the given pipeline
+func findStages(serialized manifest.OSBuildManifest, plname, stageType string) ([]stage, error) {
+	mf := &testManifest{}
+	if err := json.Unmarshal(serialized, mf); err != nil {
+		return nil, err
+	}
+	for _, pl := range mf.Pipelines {
+		if pl.Name != plname {
+			continue
+		}
+		var stages []stage
+		for _, st := range pl.Stages {
+			if st.Type == stageType {
+				stages = append(stages, st)
+			}
+		}
+		return stages, nil
+	}
+	return nil, fmt.Errorf("pipeline %q not found", plname)
+}
+
+// findFileContent returns the content of the file that is copied to
+// path by the given pipeline
+func findFileContent(serialized manifest.OSBuildManifest, plname, path string) (string, error) {
+	mf := &testManifest{}
+	if err := json.Unmarshal(serialized, mf); err != nil {
+		return "", err
+	}
+	copyStages, err := findStages(serialized, plname, "org.osbuild.copy")
+	if err != nil {
+		return "", err
+	}
+	// a later copy overwrites the file
+	for i := len(copyStages) - 1; i >= 0; i-- {
+		st := copyStages[i]
+		var opts struct {
+			Paths []struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+			} `json:"paths"`
+		}
+		if err := json.Unmarshal(st.Options, &opts); err != nil {
+			return "", err
+		}
+		for _, p := range opts.Paths {
+			if p.To != "tree://"+path {
+				continue
+			}
+			checksum := p.From[strings.LastIndex(p.From, "/")+1:]
+			item, ok := mf.Sources.Inline.Items[checksum]
+			if !ok {
+				return "", fmt.Errorf("no inline source for %q", p.From)
+			}
+			data, err := base64.StdEncoding.DecodeString(item.Data)
+			return string(data), err
+		}
+	}
+	return "", fmt.Errorf("pipeline %q - file %q - not found", plname, path)
 }
 
 func checkStages(serialized manifest.OSBuildManifest, pipelineStages map[string][]string, missingStages map[string][]string) error {
@@ -459,3 +636,370 @@ func checkStages(serialized manifest.OSBuildManifest, pipelineStages map[string]
 
 	return nil
 }
+
+// jsonContains checks that actual contains expected: objects have at
+// least the keys of the expected object with matching values, arrays
+// have a matching element for every expected element and all other
+// values are equal
+func jsonContains(actual, expected interface{}) bool {
+	switch exp := expected.(type) {
+	case map[string]interface{}:
+		act, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range exp {
+			if !jsonContains(act[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		act, ok := actual.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, value := range exp {
+			found := false
+			for _, a := range act {
+				if jsonContains(a, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return actual == expected
+	}
+}
+
+// checkStageOptions checks that for every expected value a stage of the
+// given type in the pipeline has options that contain it
+func checkStageOptions(serialized manifest.OSBuildManifest, options map[string]map[string][]string) error {
+	for plname, stageOptions := range options {
+		for stageType, expected := range stageOptions {
+			stages, err := findStages(serialized, plname, stageType)
+			if err != nil {
+				return err
+			}
+			for _, exp := range expected {
+				var expValue interface{}
+				if err := json.Unmarshal([]byte(exp), &expValue); err != nil {
+					return err
+				}
+				found := false
+				for _, st := range stages {
+					var actValue interface{}
+					if err := json.Unmarshal(st.Options, &actValue); err != nil {
+						return err
+					}
+					if jsonContains(actValue, expValue) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return fmt.Errorf("pipeline %q - stage %q - with options %s not found", plname, stageType, exp)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestManifestBootEntryLabelErrors(t *testing.T) {
+	for _, tc := range []struct {
+		imgType string
+		label   string
+		err     string
+	}{
+		{"iso", "", "boot entry label must not be empty"},
+		{"iso", "ACME-Appliance", `boot entry label "ACME-Appliance" must only contain ASCII letters, digits, spaces, '.' and '_'`},
+		{"iso", "Ünicode", `boot entry label "Ünicode" must only contain ASCII letters, digits, spaces, '.' and '_'`},
+		{"qcow2", "ACME", "boot_entry_label only supported for the iso image type"},
+	} {
+		t.Run(tc.imgType+"-"+tc.label, func(t *testing.T) {
+			label := tc.label
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{BootEntryLabel: &label}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestManifestBootloaderErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		arch    string
+		config  *main.BuildConfig
+		err     string
+	}{
+		"unknown":         {"qcow2", "x86_64", &main.BuildConfig{Bootloader: "grub"}, `unsupported bootloader "grub", valid values are ["none"]`},
+		"iso":             {"iso", "x86_64", &main.BuildConfig{Bootloader: "none"}, `bootloader "none" is not supported for the iso image type`},
+		"vagrant-libvirt": {"vagrant-libvirt", "x86_64", &main.BuildConfig{Bootloader: "none"}, `bootloader "none" is not supported for the vagrant-libvirt image type`},
+		"squashfs":        {"squashfs", "x86_64", &main.BuildConfig{Bootloader: "none"}, `bootloader "none" is not supported for the squashfs image type`},
+		"s390x":           {"raw", "s390x", &main.BuildConfig{Bootloader: "none"}, `bootloader "none" is not supported on s390x`},
+		"grub-options": {"qcow2", "aarch64", &main.BuildConfig{Bootloader: "none", Bootupd: "disabled", EFIFallback: true, GrubMenu: "hidden"},
+			`bootupd, efi_fallback, grub_menu cannot be used with bootloader "none"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Architecture = arch.FromString(tc.arch)
+			config.Config = tc.config
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestManifestInstallWeakDeps(t *testing.T) {
+	yes, no := true, false
+	for _, tc := range []struct {
+		name            string
+		installWeakDeps *bool
+		expected        bool
+	}{
+		{"default", nil, true},
+		{"enabled", &yes, true},
+		{"disabled", &no, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "iso"
+			config.Config = &main.BuildConfig{InstallWeakDeps: tc.installWeakDeps}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+
+			chains := config.PackageSetChains(mf)
+			require.Contains(t, chains, "anaconda-tree")
+			for _, pkgSet := range chains["anaconda-tree"] {
+				assert.Equal(t, tc.expected, pkgSet.InstallWeakDeps)
+			}
+		})
+	}
+}
+
+func TestManifestInstallWeakDepsDiskImage(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	no := false
+	config.Config = &main.BuildConfig{InstallWeakDeps: &no}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "install_weak_deps only supported for the iso image type")
+}
+
+func kernelNameConfig(imgType, name string) *main.ManifestConfig {
+	config := getBaseConfig()
+	config.ImgType = imgType
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Kernel: &blueprint.KernelCustomization{Name: name},
+			},
+		},
+	}
+	return config
+}
+
+func TestManifestKernelNameISO(t *testing.T) {
+	for _, variant := range []string{"full", "minimal"} {
+		t.Run(variant, func(t *testing.T) {
+			config := kernelNameConfig("iso", "kernel-64k")
+			config.Config.ISOVariant = variant
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+
+			// the kernel package of the installer is replaced
+			chain := mf.GetPackageSetChains()["anaconda-tree"]
+			require.Len(t, chain, 1)
+			assert.Contains(t, chain[0].Include, "kernel-64k")
+			assert.NotContains(t, chain[0].Include, "kernel")
+
+			packages := getISOPackages()
+			packages["anaconda-tree"][0].Name = "kernel-64k"
+			packages["anaconda-tree"][0].Release = "1.fc39"
+			packages["anaconda-tree"][0].Arch = "aarch64"
+			manifestJson, err := mf.Serialize(packages, getISOContainers(), nil)
+			require.NoError(t, err)
+			stages, err := findStages(manifestJson, "anaconda-tree", "org.osbuild.dracut")
+			require.NoError(t, err)
+			require.Len(t, stages, 1)
+			assert.Contains(t, string(stages[0].Options), `"kernel":["10.11-1.fc39.aarch64"]`)
+		})
+	}
+
+	_, err := main.Manifest(kernelNameConfig("iso", "linux-firmware"))
+	assert.EqualError(t, err, `invalid kernel name "linux-firmware", expected a kernel package like kernel-64k`)
+}
+
+func TestManifestKernelNameDisk(t *testing.T) {
+	// the disk image boots the kernel of the container image
+	for _, imgType := range []string{"ami", "qcow2", "raw", "squashfs"} {
+		_, err := main.Manifest(kernelNameConfig(imgType, "kernel-64k"))
+		assert.EqualError(t, err, `kernel name only supported for the iso image type, the installed system boots the kernel of the container image, install "kernel-64k" in the container image instead`)
+	}
+
+	// appending kernel arguments is still possible
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Kernel: &blueprint.KernelCustomization{Append: "console=ttyS0"},
+			},
+		},
+	}
+	_, err := main.Manifest(config)
+	assert.NoError(t, err)
+}
+
+func TestCheckOutputs(t *testing.T) {
+	outputDir := t.TempDir()
+	outputs := []string{"manifest-qcow2.json", "qcow2"}
+
+	// nothing to do for an empty output dir
+	require.NoError(t, main.CheckOutputs(outputDir, outputs, false))
+
+	manifestPath := filepath.Join(outputDir, "manifest-qcow2.json")
+	diskPath := filepath.Join(outputDir, "qcow2", "disk.qcow2")
+	require.NoError(t, os.WriteFile(manifestPath, []byte("{}"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Dir(diskPath), 0755))
+	require.NoError(t, os.WriteFile(diskPath, []byte("good image"), 0644))
+
+	err := main.CheckOutputs(outputDir, outputs, false)
+	assert.EqualError(t, err, fmt.Sprintf(`output directory %q already contains manifest-qcow2.json, qcow2, use --force to overwrite`, outputDir))
+
+	// with force the existing artifacts are kept until the build
+	// replaces them
+	require.NoError(t, main.CheckOutputs(outputDir, outputs, true))
+	content, err := os.ReadFile(diskPath)
+	require.NoError(t, err)
+	assert.Equal(t, "good image", string(content))
+	assert.FileExists(t, manifestPath)
+}
+
+func TestManifestOnlyValidateCLI(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args []string
+		err  string
+	}{
+		"qcow2-base":     {[]string{"--type", "qcow2", "testempty"}, ""},
+		"iso-base":       {[]string{"--type", "iso", "testempty"}, ""},
+		"empty-config":   {[]string{"--type", "qcow2", ""}, "pipeline: no base image defined"},
+		"bad-image-type": {[]string{"--type", "bad", "testempty"}, `Manifest(): unsupported image type "bad"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rootCmd, err := main.NewRootCmd()
+			require.NoError(t, err)
+			var stdout bytes.Buffer
+			rootCmd.SetOut(&stdout)
+			rootCmd.SetErr(io.Discard)
+			rootCmd.SetArgs(append([]string{"manifest", "--only-manifest-validate"}, tc.args...))
+
+			err = rootCmd.Execute()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("manifest for %s is valid\n", tc.args[1]), stdout.String())
+		})
+	}
+}
+
+func TestManifestOnlyValidateCLIPatchErrors(t *testing.T) {
+	// the customizations that are patched into the serialized
+	// manifest are checked as well
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"eula": "/nonexistent/EULA"}`), 0644))
+
+	rootCmd, err := main.NewRootCmd()
+	require.NoError(t, err)
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	rootCmd.SetArgs([]string{"manifest", "--only-manifest-validate", "--type", "iso", "--config", configPath, "testempty"})
+	err = rootCmd.Execute()
+	assert.EqualError(t, err, "cannot read eula: stat /nonexistent/EULA: no such file or directory")
+}
+
+func TestSaveManifestPinned(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(getISOPackages(), getISOContainers(), nil)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	require.NoError(t, main.SaveManifest(manifestJson, path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var exported struct {
+		Sources map[string]struct {
+			Items map[string]json.RawMessage `json:"items"`
+		} `json:"sources"`
+	}
+	require.NoError(t, json.Unmarshal(data, &exported))
+	// packages are pinned by their checksum
+	assert.Contains(t, exported.Sources["org.osbuild.curl"].Items, "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	assert.Contains(t, exported.Sources["org.osbuild.curl"].Items, "sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc")
+	// containers by their digest
+	container, ok := exported.Sources["org.osbuild.skopeo"].Items[testContainerSpec.ImageID]
+	require.True(t, ok)
+	assert.Contains(t, string(container), testContainerSpec.Digest)
+}
+
+func TestBuildResolveOnlyNeedsExportManifest(t *testing.T) {
+	rootCmd, err := main.NewRootCmd()
+	require.NoError(t, err)
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	rootCmd.SetArgs([]string{"build", "--resolve-only", "testempty"})
+	err = rootCmd.Execute()
+	assert.EqualError(t, err, "--resolve-only needs --export-manifest to write the manifest to")
+}
+
+func TestDisableRepos(t *testing.T) {
+	restore := main.MockReposStr(`{
+  "x86_64": [
+    {"name": "baseos", "baseurl": "https://example.com/baseos/"},
+    {"name": "appstream", "baseurl": "https://example.com/appstream/"},
+    {"id": "crb-mirror", "name": "crb", "baseurl": "https://example.com/crb/"}
+  ]
+}`)
+	defer restore()
+	repos, err := main.LoadRepos("x86_64")
+	require.NoError(t, err)
+
+	enabled, err := main.DisableRepos(repos, []string{"appstream", "crb-mirror"})
+	require.NoError(t, err)
+	require.Len(t, enabled, 1)
+	assert.Equal(t, "baseos", enabled[0].Name)
+
+	// the disabled repositories are not used for depsolving
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Repos = enabled
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	for name, chain := range mf.GetPackageSetChains() {
+		for _, set := range chain {
+			for _, repo := range set.Repositories {
+				assert.Equal(t, []string{"https://example.com/baseos/"}, repo.BaseURLs, name)
+			}
+		}
+	}
+
+	_, err = main.DisableRepos(repos, []string{"epel"})
+	assert.EqualError(t, err, `cannot disable repository "epel", the repositories are ["baseos" "appstream" "crb-mirror"]`)
+	_, err = main.DisableRepos(repos, []string{"baseos", "appstream", "crb-mirror"})
+	assert.EqualError(t, err, "cannot disable all repositories")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/osbuild/images/pkg/manifest"
+)
+
+const metadataSuffix = ".meta.json"
+
+// ArtifactMetadata is written next to every artifact for artifact
+// stores that index images by their metadata
+type ArtifactMetadata struct {
+	ImageType  string `json:"image_type"`
+	Arch       string `json:"arch"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	BaseImage  string `json:"base_image"`
+	BaseDigest string `json:"base_digest"`
+	BibVersion string `json:"bib_version"`
+}
+
+// manifestBaseDigest returns the digest of the given container image in
+// the sources of the manifest, i.e. the digest that was built from
+func manifestBaseDigest(mf manifest.OSBuildManifest, imgref string) (string, error) {
+	var raw struct {
+		Sources struct {
+			Skopeo struct {
+				Items map[string]struct {
+					Image struct {
+						Name   string `json:"name"`
+						Digest string `json:"digest"`
+					} `json:"image"`
+				} `json:"items"`
+			} `json:"org.osbuild.skopeo"`
+		} `json:"sources"`
+	}
+	if err := json.Unmarshal(mf, &raw); err != nil {
+		return "", err
+	}
+	for _, item := range raw.Sources.Skopeo.Items {
+		if item.Image.Name == imgref {
+			return item.Image.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find %s in the manifest sources", imgref)
+}
+
+// writeArtifactMetadata writes the metadata of every artifact of the
+// given outputs to <artifact>.meta.json. The checksums are taken from
+// the checksum files, so it must run after writeChecksums.
+func writeArtifactMetadata(outputDir string, outputs []string, meta ArtifactMetadata) error {
+	for _, output := range outputs {
+		err := filepath.WalkDir(filepath.Join(outputDir, output), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() || isSidecar(path) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			checksum, err := os.ReadFile(path + checksumSuffix)
+			if err != nil {
+				return err
+			}
+			sum, _, _ := strings.Cut(string(checksum), " ")
+
+			artifactMeta := meta
+			artifactMeta.Size = info.Size()
+			artifactMeta.SHA256 = sum
+			b, err := json.MarshalIndent(artifactMeta, "", "  ")
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(path+metadataSuffix, append(b, '\n'), 0644)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestBaseDigest(t *testing.T) {
+	config := getBaseConfig()
+	config.Imgref = testContainerSpec.Source
+	config.ImgType = "qcow2"
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	digest, err := main.ManifestBaseDigest(manifestJson, testContainerSpec.Source)
+	require.NoError(t, err)
+	assert.Equal(t, testContainerSpec.Digest, digest)
+
+	_, err = main.ManifestBaseDigest(manifestJson, "quay.io/example/other")
+	assert.EqualError(t, err, "cannot find quay.io/example/other in the manifest sources")
+}
+
+func TestWriteArtifactMetadata(t *testing.T) {
+	outputDir := t.TempDir()
+	diskPath := filepath.Join(outputDir, "qcow2", "disk.qcow2")
+	require.NoError(t, os.MkdirAll(filepath.Dir(diskPath), 0755))
+	require.NoError(t, os.WriteFile(diskPath, []byte("hello\n"), 0644))
+	_, err := main.WriteChecksums(outputDir, []string{"qcow2"})
+	require.NoError(t, err)
+
+	err = main.WriteArtifactMetadata(outputDir, []string{"qcow2"}, main.ArtifactMetadata{
+		ImageType:  "qcow2",
+		Arch:       "x86_64",
+		BaseImage:  "quay.io/centos-bootc/centos-bootc:stream9",
+		BaseDigest: testContainerSpec.Digest,
+		BibVersion: "1.2.3",
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(diskPath + ".meta.json")
+	require.NoError(t, err)
+	var meta map[string]interface{}
+	require.NoError(t, json.Unmarshal(content, &meta))
+	assert.Equal(t, map[string]interface{}{
+		"image_type":  "qcow2",
+		"arch":        "x86_64",
+		"size":        float64(6),
+		"sha256":      "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		"base_image":  "quay.io/centos-bootc/centos-bootc:stream9",
+		"base_digest": testContainerSpec.Digest,
+		"bib_version": "1.2.3",
+	}, meta)
+
+	// only the artifact gets metadata, not the checksum file
+	_, err = os.Stat(diskPath + ".sha256.meta.json")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	motdMessagePath     = "/etc/motd.d/50-bootc-image-builder"
+	motdScriptsDir      = "/etc/update-motd.d"
+	motdService         = "bootc-update-motd.service"
+	motdTimer           = "bootc-update-motd.timer"
+	motdDefaultInterval = "10min"
+	// pam_motd shows the files of /run/motd.d by default
+	motdDynamicPath = "/run/motd.d/50-bootc-image-builder-dynamic"
+)
+
+// Motd configures the message of the day that pam_motd shows on login
+type Motd struct {
+	// Message is a static message
+	Message string `json:"message,omitempty"`
+	// Scripts are run in the order of their names, their output is
+	// the dynamic part of the message
+	Scripts []MotdScript `json:"scripts,omitempty"`
+	// RefreshInterval is how often the scripts run, "10min" by default
+	RefreshInterval string `json:"refresh_interval,omitempty"`
+}
+
+// MotdScript is an executable of /etc/update-motd.d
+type MotdScript struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+// the names that run-parts(8) accepts
+var motdScriptNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+func validateMotdScript(script *MotdScript) error {
+	if !motdScriptNameRegex.MatchString(script.Name) {
+		return fmt.Errorf("invalid motd script name %q, must only contain ASCII letters, digits, '_' and '-'", script.Name)
+	}
+	if !strings.HasPrefix(script.Data, "#!") {
+		return fmt.Errorf("motd script %q must start with a shebang line like \"#!/bin/sh\"", script.Name)
+	}
+	return nil
+}
+
+// motdUnits returns the service that collects the output of the
+// scripts and the timer that refreshes it. The output is written to a
+// temporary file first so a login never shows a partial message.
+func motdUnits(interval string) (string, string) {
+	tmp := motdDynamicPath + ".tmp"
+	// $$ is a literal $ for systemd
+	run := fmt.Sprintf(`for f in %s/*; do [ -x "$$f" ] && "$$f"; done > %s; mv -f %s %s`, motdScriptsDir, tmp, tmp, motdDynamicPath)
+	service := fmt.Sprintf(`[Unit]
+Description=Update the dynamic message of the day
+After=network-online.target
+
+[Service]
+Type=oneshot
+RuntimeDirectory=motd.d
+RuntimeDirectoryPreserve=yes
+ExecStart=/bin/sh -c '%s'
+
+[Install]
+WantedBy=multi-user.target
+`, run)
+	timer := fmt.Sprintf(`[Unit]
+Description=Update the dynamic message of the day periodically
+
+[Timer]
+OnBootSec=%[1]s
+OnUnitActiveSec=%[1]s
+
+[Install]
+WantedBy=timers.target
+`, interval)
+	return service, timer
+}
+
+// motdNodes returns the directory of the scripts, the files of the
+// static and the dynamic message of the day and the units to enable
+func motdNodes(motd *Motd) (*fsnode.Directory, []*fsnode.File, []string, error) {
+	if motd.Message == "" && len(motd.Scripts) == 0 {
+		return nil, nil, nil, fmt.Errorf("motd needs a message or scripts")
+	}
+	if motd.RefreshInterval != "" && len(motd.Scripts) == 0 {
+		return nil, nil, nil, fmt.Errorf("motd refresh_interval is only used with scripts")
+	}
+
+	var files []*fsnode.File
+	if motd.Message != "" {
+		message := motd.Message
+		if !strings.HasSuffix(message, "\n") {
+			message += "\n"
+		}
+		f, err := fsnode.NewFile(motdMessagePath, nil, nil, nil, []byte(message))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, f)
+	}
+	if len(motd.Scripts) == 0 {
+		return nil, files, nil, nil
+	}
+
+	interval := motd.RefreshInterval
+	if interval == "" {
+		interval = motdDefaultInterval
+	}
+	if !timeSpanRegex.MatchString(interval) {
+		return nil, nil, nil, fmt.Errorf("invalid motd refresh_interval %q, expected a time span like \"10min\" or \"1h\"", interval)
+	}
+
+	scriptMode := os.FileMode(0755)
+	dir, err := fsnode.NewDirectory(motdScriptsDir, &scriptMode, nil, nil, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	seen := make(map[string]bool)
+	for i := range motd.Scripts {
+		script := &motd.Scripts[i]
+		if err := validateMotdScript(script); err != nil {
+			return nil, nil, nil, err
+		}
+		if seen[script.Name] {
+			return nil, nil, nil, fmt.Errorf("duplicate motd script %q", script.Name)
+		}
+		seen[script.Name] = true
+		f, err := fsnode.NewFile(motdScriptsDir+"/"+script.Name, &scriptMode, nil, nil, []byte(script.Data))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, f)
+	}
+
+	service, timer := motdUnits(interval)
+	unitMode := os.FileMode(0644)
+	for _, unit := range []struct{ name, content string }{{motdService, service}, {motdTimer, timer}} {
+		f, err := fsnode.NewFile("/etc/systemd/system/"+unit.name, &unitMode, nil, nil, []byte(unit.content))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, f)
+	}
+	return dir, files, []string{motdService, motdTimer}, nil
+}
@@ -0,0 +1,107 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestMotd(t *testing.T) {
+	sysinfo := "#!/bin/sh\necho \"load: $(cut -d' ' -f1-3 /proc/loadavg)\"\n"
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Motd: &main.Motd{
+			Message:         "Managed by the platform team",
+			Scripts:         []main.MotdScript{{Name: "10-sysinfo", Data: sysinfo}},
+			RefreshInterval: "5min",
+		},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/motd.d/50-bootc-image-builder")
+	require.NoError(t, err)
+	assert.Equal(t, "Managed by the platform team\n", content)
+	content, err = findFileContent(manifestJson, "ostree-deployment", "/etc/update-motd.d/10-sysinfo")
+	require.NoError(t, err)
+	assert.Equal(t, sysinfo, content)
+
+	// the output of the scripts goes to the motd.d directory in /run
+	// that pam_motd shows by default
+	service, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/bootc-update-motd.service")
+	require.NoError(t, err)
+	assert.Contains(t, service, "RuntimeDirectory=motd.d\n")
+	assert.Contains(t, service, `ExecStart=/bin/sh -c 'for f in /etc/update-motd.d/*; do [ -x "$$f" ] && "$$f"; done > /run/motd.d/50-bootc-image-builder-dynamic.tmp; mv -f /run/motd.d/50-bootc-image-builder-dynamic.tmp /run/motd.d/50-bootc-image-builder-dynamic'`)
+	timer, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/bootc-update-motd.timer")
+	require.NoError(t, err)
+	assert.Contains(t, timer, "OnBootSec=5min\nOnUnitActiveSec=5min\n")
+
+	enabled, disabled := systemdServices(t, manifestJson)
+	assert.Equal(t, []string{"bootc-update-motd.service", "bootc-update-motd.timer"}, enabled)
+	assert.Empty(t, disabled)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.chmod")
+	require.NoError(t, err)
+	modes := make(map[string]string)
+	for _, st := range stages {
+		var chmod struct {
+			Items map[string]struct {
+				Mode string `json:"mode"`
+			} `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(st.Options, &chmod))
+		for path, item := range chmod.Items {
+			modes[path] = item.Mode
+		}
+	}
+	assert.Equal(t, "0755", modes["/etc/update-motd.d"])
+	assert.Equal(t, "0755", modes["/etc/update-motd.d/10-sysinfo"])
+}
+
+func TestManifestMotdMessageOnly(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{Motd: &main.Motd{Message: "Hello\n"}}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/motd.d/50-bootc-image-builder")
+	require.NoError(t, err)
+	assert.Equal(t, "Hello\n", content)
+	_, err = findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/bootc-update-motd.service")
+	assert.Error(t, err)
+}
+
+func TestManifestMotdErrors(t *testing.T) {
+	script := main.MotdScript{Name: "10-sysinfo", Data: "#!/bin/sh\nuptime\n"}
+	for name, tc := range map[string]struct {
+		imgType string
+		motd    main.Motd
+		err     string
+	}{
+		"empty":            {"qcow2", main.Motd{}, "motd needs a message or scripts"},
+		"interval-only":    {"qcow2", main.Motd{Message: "hi", RefreshInterval: "1h"}, "motd refresh_interval is only used with scripts"},
+		"bad-interval":     {"qcow2", main.Motd{Scripts: []main.MotdScript{script}, RefreshInterval: "often"}, `invalid motd refresh_interval "often", expected a time span like "10min" or "1h"`},
+		"no-shebang":       {"qcow2", main.Motd{Scripts: []main.MotdScript{{Name: "10-sysinfo", Data: "uptime\n"}}}, `motd script "10-sysinfo" must start with a shebang line like "#!/bin/sh"`},
+		"bad-name":         {"qcow2", main.Motd{Scripts: []main.MotdScript{{Name: "../sysinfo", Data: script.Data}}}, `invalid motd script name "../sysinfo", must only contain ASCII letters, digits, '_' and '-'`},
+		"duplicate-script": {"qcow2", main.Motd{Scripts: []main.MotdScript{script, script}}, `duplicate motd script "10-sysinfo"`},
+		"iso":              {"anaconda-iso", main.Motd{Message: "hi"}, "motd not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{Motd: &tc.motd}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/osbuild/images/pkg/disk"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// MountCustomization sets properties of a mountpoint of the disk
+// image, the mountpoint is either part of the default partition table
+// or created by the blueprint filesystem customizations.
+type MountCustomization struct {
+	Mountpoint string `json:"mountpoint"`
+	// Options are the mount options of the fstab entry
+	Options string `json:"options,omitempty"`
+	// Owner and Group are the numeric uid and gid of the root
+	// directory of the filesystem, they are not resolved via
+	// /etc/passwd of the deployment
+	Owner *int64 `json:"owner,omitempty"`
+	Group *int64 `json:"group,omitempty"`
+	// Mode are the octal permissions of the root directory of the
+	// filesystem, e.g. "0750"
+	Mode string `json:"mode,omitempty"`
+	// Label is the label of the filesystem, the root filesystem is
+	// labeled with rootfs_label instead
+	Label string `json:"label,omitempty"`
+}
+
+func (m *MountCustomization) hasOwnership() bool {
+	return m.Owner != nil || m.Group != nil || m.Mode != ""
+}
+
+// comma separated list of options, each one either a flag or a
+// key=value pair
+var mountOptionsRegex = regexp.MustCompile(`^[a-z0-9_-]+(=[^,\s]+)?(,[a-z0-9_-]+(=[^,\s]+)?)*$`)
+
+var mountModeRegex = regexp.MustCompile(`^0?[0-7]{3}$|^[0-7]{4}$`)
+
+// the volume label of FAT filesystems, vfat is not in
+// filesystemLabelMaxLen as it is not a valid data disk filesystem
+const fatLabelMaxLen = 11
+
+func validateFilesystemLabel(label, fstype, mountpoint string) error {
+	maxLen, ok := filesystemLabelMaxLen[fstype]
+	if fstype == "vfat" {
+		maxLen, ok = fatLabelMaxLen, true
+	}
+	if !ok {
+		return fmt.Errorf("cannot set the label of the %s filesystem of %q", fstype, mountpoint)
+	}
+	if len(label) > maxLen {
+		return fmt.Errorf("label %q of %q is too long for %s, the maximum is %d characters", label, mountpoint, fstype, maxLen)
+	}
+	if !rootfsLabelRegex.MatchString(label) {
+		return fmt.Errorf("label %q of %q must only contain ASCII letters, digits, '-', '.' and '_'", label, mountpoint)
+	}
+	return nil
+}
+
+// the largest valid id, (uint32)-1 is reserved
+const maxID = 1<<32 - 2
+
+func validateID(id *int64, what, mountpoint string) error {
+	if id != nil && (*id < 0 || *id > maxID) {
+		return fmt.Errorf("invalid %s %d for %q, expected a number between 0 and %d", what, *id, mountpoint, maxID)
+	}
+	return nil
+}
+
+// applyMountCustomizations applies the mount customizations to the
+// filesystems of the partition table.
+func applyMountCustomizations(pt *disk.PartitionTable, mounts []MountCustomization) error {
+	seen := make(map[string]bool)
+	for _, mnt := range mounts {
+		if seen[mnt.Mountpoint] {
+			return fmt.Errorf("duplicate mount customization for %q", mnt.Mountpoint)
+		}
+		seen[mnt.Mountpoint] = true
+
+		fs, ok := pt.FindMountable(mnt.Mountpoint).(*disk.Filesystem)
+		if !ok {
+			return fmt.Errorf("cannot customize mountpoint %q: no such filesystem in the partition table", mnt.Mountpoint)
+		}
+		if mnt.Options != "" {
+			if !mountOptionsRegex.MatchString(mnt.Options) {
+				return fmt.Errorf("invalid mount options %q for %q", mnt.Options, mnt.Mountpoint)
+			}
+			fs.FSTabOptions = mnt.Options
+		}
+		if mnt.Label != "" {
+			// the deployment finds the root filesystem by its
+			// label, rootfs_label changes both
+			if mnt.Mountpoint == "/" {
+				return fmt.Errorf("cannot set the label of the root filesystem in mounts, use rootfs_label instead")
+			}
+			if err := validateFilesystemLabel(mnt.Label, fs.Type, mnt.Mountpoint); err != nil {
+				return err
+			}
+			fs.Label = mnt.Label
+		}
+		if mnt.hasOwnership() {
+			// the root filesystem is the physical root of the
+			// ostree system, not the root of the deployment
+			if mnt.Mountpoint == "/" {
+				return fmt.Errorf("cannot set the ownership of the root filesystem")
+			}
+			if fs.Type == "vfat" {
+				return fmt.Errorf("cannot set the ownership of %q, vfat has no permissions", mnt.Mountpoint)
+			}
+			if err := validateID(mnt.Owner, "owner", mnt.Mountpoint); err != nil {
+				return err
+			}
+			if err := validateID(mnt.Group, "group", mnt.Mountpoint); err != nil {
+				return err
+			}
+			if mnt.Mode != "" && !mountModeRegex.MatchString(mnt.Mode) {
+				return fmt.Errorf("invalid mode %q for %q, expected octal permissions like \"0750\"", mnt.Mode, mnt.Mountpoint)
+			}
+		}
+	}
+	return nil
+}
+
+// validateUniqueLabels ensures that the filesystems of the partition
+// table and the data disks have distinct labels, the links in
+// /dev/disk/by-label/ would be ambiguous otherwise
+func validateUniqueLabels(pt *disk.PartitionTable, dataDisks []DataDisk) error {
+	labels := make(map[string]string)
+	check := func(label, mountpoint string) error {
+		if label == "" {
+			return nil
+		}
+		if other, ok := labels[label]; ok {
+			return fmt.Errorf("duplicate filesystem label %q for %q and %q", label, other, mountpoint)
+		}
+		labels[label] = mountpoint
+		return nil
+	}
+	err := pt.ForEachMountable(func(mnt disk.Mountable, _ []disk.Entity) error {
+		return check(mnt.GetFSSpec().Label, mnt.GetMountpoint())
+	})
+	if err != nil {
+		return err
+	}
+	for _, d := range dataDisks {
+		if err := check(d.Label, d.Mountpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// espLabel returns the label of the EFI system partition from the
+// mount customizations
+func espLabel(mounts []MountCustomization) string {
+	for _, mnt := range mounts {
+		if mnt.Mountpoint == espMountpoint {
+			return mnt.Label
+		}
+	}
+	return ""
+}
+
+// setESPLabel sets the label of the FAT filesystem of the EFI system
+// partition.
+//
+// TODO: osbuild/images only sets the volume id of FAT filesystems
+func setESPLabel(m *serializedManifest, label string) error {
+	err := m.updateStageOptions("image", "org.osbuild.mkfs.fat", func(options json.RawMessage) (json.RawMessage, error) {
+		var opts osbuild.MkfsFATStageOptions
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+		opts.Label = label
+		return json.Marshal(opts)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set the label of the EFI system partition: %w", err)
+	}
+	return nil
+}
+
+// mountpointOwnershipStages returns the stages that set the ownership
+// and permissions of the given mountpoints. They work on the physical
+// root of the tree, the filesystems are mounted there when the tree is
+// copied to the disk and the copy keeps the attributes of the
+// directories.
+func mountpointOwnershipStages(mounts []MountCustomization) []*osbuild.Stage {
+	var mkdirPaths []osbuild.MkdirStagePath
+	chown := &osbuild.ChownStageOptions{Items: make(map[string]osbuild.ChownStagePathOptions)}
+	chmod := &osbuild.ChmodStageOptions{Items: make(map[string]osbuild.ChmodStagePathOptions)}
+	for _, mnt := range mounts {
+		if !mnt.hasOwnership() {
+			continue
+		}
+		mkdirPaths = append(mkdirPaths, osbuild.MkdirStagePath{
+			Path:    mnt.Mountpoint,
+			Parents: true,
+			ExistOk: true,
+		})
+		if mnt.Owner != nil || mnt.Group != nil {
+			var opts osbuild.ChownStagePathOptions
+			if mnt.Owner != nil {
+				opts.User = *mnt.Owner
+			}
+			if mnt.Group != nil {
+				opts.Group = *mnt.Group
+			}
+			chown.Items[mnt.Mountpoint] = opts
+		}
+		if mnt.Mode != "" {
+			chmod.Items[mnt.Mountpoint] = osbuild.ChmodStagePathOptions{Mode: mnt.Mode}
+		}
+	}
+	if len(mkdirPaths) == 0 {
+		return nil
+	}
+
+	stages := []*osbuild.Stage{osbuild.NewMkdirStage(&osbuild.MkdirStageOptions{Paths: mkdirPaths})}
+	if len(chown.Items) > 0 {
+		stages = append(stages, osbuild.NewChownStage(chown))
+	}
+	if len(chmod.Items) > 0 {
+		stages = append(stages, osbuild.NewChmodStage(chmod))
+	}
+	return stages
+}
+
+// setMountpointOwnership adds the stages that set the ownership and
+// permissions of the mountpoints to the deployment.
+//
+// TODO: osbuild/images cannot set the attributes of mountpoints
+func setMountpointOwnership(m *serializedManifest, mounts []MountCustomization) error {
+	stages := mountpointOwnershipStages(mounts)
+	if len(stages) == 0 {
+		return nil
+	}
+	err := m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(json.RawMessage) ([]*osbuild.Stage, error) {
+		return stages, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set the ownership of mountpoints: %w", err)
+	}
+	return nil
+}
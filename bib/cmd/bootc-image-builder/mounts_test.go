@@ -0,0 +1,162 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func getMountsConfig(mounts []main.MountCustomization) *main.ManifestConfig {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Filesystem: []blueprint.FilesystemCustomization{
+					{Mountpoint: "/var/log", MinSize: main.GibiByte},
+				},
+			},
+		},
+		Mounts: mounts,
+	}
+	return config
+}
+
+func TestManifestMountOptions(t *testing.T) {
+	config := getMountsConfig([]main.MountCustomization{
+		{Mountpoint: "/var/log", Options: "nodev,nosuid,noexec"},
+		{Mountpoint: "/", Options: "defaults,noatime"},
+	})
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.fstab")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var fstab struct {
+		Filesystems []struct {
+			Path    string `json:"path"`
+			Options string `json:"options"`
+		} `json:"filesystems"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &fstab))
+	options := make(map[string]string)
+	for _, fs := range fstab.Filesystems {
+		options[fs.Path] = fs.Options
+	}
+	assert.Equal(t, "nodev,nosuid,noexec", options["/var/log"])
+	assert.Equal(t, "defaults,noatime", options["/"])
+	// unchanged
+	assert.Equal(t, "ro", options["/boot"])
+}
+
+func TestManifestMountOptionsErrors(t *testing.T) {
+	uid, badID, reservedID := int64(1000), int64(-1), int64(1<<32-1)
+	for name, tc := range map[string]struct {
+		imgType string
+		mounts  []main.MountCustomization
+		err     string
+	}{
+		"bad-options": {"qcow2", []main.MountCustomization{{Mountpoint: "/var/log", Options: "nodev, nosuid"}}, `invalid mount options "nodev, nosuid" for "/var/log"`},
+		"empty-entry": {"qcow2", []main.MountCustomization{{Mountpoint: "/var/log", Options: "nodev,,nosuid"}}, `invalid mount options "nodev,,nosuid" for "/var/log"`},
+		"unknown":     {"qcow2", []main.MountCustomization{{Mountpoint: "/srv", Options: "nodev"}}, `cannot customize mountpoint "/srv": no such filesystem in the partition table`},
+		"duplicate":   {"qcow2", []main.MountCustomization{{Mountpoint: "/", Options: "noatime"}, {Mountpoint: "/", Options: "relatime"}}, `duplicate mount customization for "/"`},
+		"iso":         {"iso", []main.MountCustomization{{Mountpoint: "/", Options: "noatime"}}, "mounts not supported for the iso image type"},
+		"owner-root":  {"qcow2", []main.MountCustomization{{Mountpoint: "/", Mode: "0755"}}, "cannot set the ownership of the root filesystem"},
+		"owner-vfat":  {"qcow2", []main.MountCustomization{{Mountpoint: "/boot/efi", Owner: &uid}}, `cannot set the ownership of "/boot/efi", vfat has no permissions`},
+		"bad-owner":   {"qcow2", []main.MountCustomization{{Mountpoint: "/var/log", Owner: &badID}}, `invalid owner -1 for "/var/log", expected a number between 0 and 4294967294`},
+		"bad-group":   {"qcow2", []main.MountCustomization{{Mountpoint: "/var/log", Group: &reservedID}}, `invalid group 4294967295 for "/var/log", expected a number between 0 and 4294967294`},
+		"bad-mode":    {"qcow2", []main.MountCustomization{{Mountpoint: "/var/log", Mode: "u+rwx"}}, `invalid mode "u+rwx" for "/var/log", expected octal permissions like "0750"`},
+		"label-root":  {"qcow2", []main.MountCustomization{{Mountpoint: "/", Label: "system"}}, "cannot set the label of the root filesystem in mounts, use rootfs_label instead"},
+		"label-long":  {"qcow2", []main.MountCustomization{{Mountpoint: "/boot/efi", Label: "EFI-PARTITION"}}, `label "EFI-PARTITION" of "/boot/efi" is too long for vfat, the maximum is 11 characters`},
+		"label-chars": {"qcow2", []main.MountCustomization{{Mountpoint: "/var/log", Label: "var log"}}, `label "var log" of "/var/log" must only contain ASCII letters, digits, '-', '.' and '_'`},
+		"label-dup":   {"qcow2", []main.MountCustomization{{Mountpoint: "/var/log", Label: "boot"}}, `duplicate filesystem label "boot" for "/boot" and "/var/log"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getMountsConfig(tc.mounts)
+			config.ImgType = tc.imgType
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestManifestMountpointOwnership(t *testing.T) {
+	uid, gid := int64(1000), int64(0)
+	mounts := []main.MountCustomization{
+		{Mountpoint: "/var/log", Owner: &uid, Group: &gid, Mode: "0750"},
+		{Mountpoint: "/", Options: "noatime"},
+	}
+	config := getMountsConfig(mounts)
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.SetMountpointOwnership(manifestJson, mounts)
+	require.NoError(t, err)
+
+	// the stages work on the physical root that the filesystem is
+	// mounted on when the tree is copied to the disk
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.chown")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	assert.JSONEq(t, `{"items": {"/var/log": {"user": 1000, "group": 0}}}`, string(stages[0].Options))
+
+	stages, err = findStages(manifestJson, "ostree-deployment", "org.osbuild.chmod")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	assert.JSONEq(t, `{"items": {"/var/log": {"mode": "0750"}}}`, string(stages[0].Options))
+
+	stages, err = findStages(manifestJson, "ostree-deployment", "org.osbuild.mkdir")
+	require.NoError(t, err)
+	require.Len(t, stages, 2)
+	assert.JSONEq(t, `{"paths": [{"path": "/var/log", "parents": true, "exist_ok": true}]}`, string(stages[1].Options))
+}
+
+func TestManifestMountpointOwnershipUnset(t *testing.T) {
+	mounts := []main.MountCustomization{{Mountpoint: "/", Options: "noatime"}}
+	config := getMountsConfig(mounts)
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	patched, err := main.SetMountpointOwnership(manifestJson, mounts)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(manifestJson), string(patched))
+}
+
+func TestManifestMountLabels(t *testing.T) {
+	config := getMountsConfig([]main.MountCustomization{
+		{Mountpoint: "/boot", Label: "sysboot"},
+		{Mountpoint: "/boot/efi", Label: "SYSEFI"},
+		{Mountpoint: "/var/log", Label: "logs"},
+	})
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.SetESPLabel(manifestJson, "SYSEFI")
+	require.NoError(t, err)
+
+	var labels []string
+	for _, stageType := range []string{"org.osbuild.mkfs.fat", "org.osbuild.mkfs.ext4", "org.osbuild.mkfs.xfs"} {
+		stages, err := findStages(manifestJson, "image", stageType)
+		require.NoError(t, err)
+		for _, st := range stages {
+			var opts struct {
+				Label string `json:"label"`
+			}
+			require.NoError(t, json.Unmarshal(st.Options, &opts))
+			labels = append(labels, opts.Label)
+		}
+	}
+	// the root filesystem keeps its default label
+	assert.ElementsMatch(t, []string{"SYSEFI", "sysboot", "logs", "root"}, labels)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// MountUnit is a filesystem that is mounted by a systemd mount unit
+// instead of an fstab entry, e.g. a network filesystem
+type MountUnit struct {
+	// What is the device or the remote share, e.g.
+	// "nfs.example.com:/export"
+	What string `json:"what"`
+	// Where is the mountpoint
+	Where string `json:"where"`
+	// Type is the filesystem type, e.g. "nfs"
+	Type    string `json:"type"`
+	Options string `json:"options,omitempty"`
+	// Automount mounts the filesystem on the first access instead of
+	// during the boot
+	Automount bool `json:"automount,omitempty"`
+}
+
+var mountUnitTypeRegex = regexp.MustCompile(`^[a-z0-9]+(\.[a-z0-9]+)?$`)
+
+// filesystems that need the network, they are ordered after it
+var networkFilesystemTypes = map[string]bool{
+	"nfs":        true,
+	"nfs4":       true,
+	"cifs":       true,
+	"smb3":       true,
+	"glusterfs":  true,
+	"ceph":       true,
+	"fuse.sshfs": true,
+}
+
+// systemdEscapePath returns the unit name prefix of the given path like
+// "systemd-escape --path" does
+func systemdEscapePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "-"
+	}
+	var escaped strings.Builder
+	for i, c := range []byte(path) {
+		switch {
+		case c == '/':
+			escaped.WriteByte('-')
+		case c == '.' && i == 0:
+			fmt.Fprintf(&escaped, `\x%02x`, c)
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == ':', c == '_', c == '.':
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, `\x%02x`, c)
+		}
+	}
+	return escaped.String()
+}
+
+func (m *MountUnit) validate() error {
+	if !isCleanAbsPath(m.Where) || m.Where == "/" {
+		return fmt.Errorf("mount unit mountpoint %q must be a clean absolute path below /", m.Where)
+	}
+	if m.What == "" || strings.ContainsAny(m.What, " \t\r\n") {
+		return fmt.Errorf("mount unit for %q needs a source without whitespace", m.Where)
+	}
+	if !mountUnitTypeRegex.MatchString(m.Type) {
+		return fmt.Errorf("invalid filesystem type %q of the mount unit for %q", m.Type, m.Where)
+	}
+	if m.Options != "" && !mountOptionsRegex.MatchString(m.Options) {
+		return fmt.Errorf("invalid mount options %q for %q", m.Options, m.Where)
+	}
+	return nil
+}
+
+// mountUnitFiles returns the unit files of the given mounts and the
+// units that need to be enabled, the automount unit is enabled instead
+// of the mount unit if there is one.
+func mountUnitFiles(mounts []MountUnit) ([]*fsnode.File, []string, error) {
+	var files []*fsnode.File
+	var enabled []string
+	seen := make(map[string]bool)
+	mode := os.FileMode(0644)
+	for i := range mounts {
+		m := &mounts[i]
+		if err := m.validate(); err != nil {
+			return nil, nil, err
+		}
+		if seen[m.Where] {
+			return nil, nil, fmt.Errorf("duplicate mount unit for %q", m.Where)
+		}
+		seen[m.Where] = true
+
+		target := "local-fs.target"
+		var unitDeps string
+		if networkFilesystemTypes[m.Type] {
+			target = "remote-fs.target"
+			unitDeps = "Wants=network-online.target\nAfter=network-online.target\n"
+		}
+		options := m.Options
+		if options == "" {
+			options = "defaults"
+		}
+		name := systemdEscapePath(m.Where)
+
+		install := fmt.Sprintf("\n[Install]\nWantedBy=%s\n", target)
+		if m.Automount {
+			// the automount unit pulls in the mount unit
+			install = ""
+		}
+		mountUnit := fmt.Sprintf("[Unit]\nDescription=Mount %s\n%s\n[Mount]\nWhat=%s\nWhere=%s\nType=%s\nOptions=%s\n%s",
+			m.Where, unitDeps, m.What, m.Where, m.Type, options, install)
+		f, err := fsnode.NewFile("/etc/systemd/system/"+name+".mount", &mode, nil, nil, []byte(mountUnit))
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, f)
+
+		if !m.Automount {
+			enabled = append(enabled, name+".mount")
+			continue
+		}
+		automountUnit := fmt.Sprintf("[Unit]\nDescription=Automount %s\n\n[Automount]\nWhere=%s\n\n[Install]\nWantedBy=%s\n",
+			m.Where, m.Where, target)
+		f, err = fsnode.NewFile("/etc/systemd/system/"+name+".automount", &mode, nil, nil, []byte(automountUnit))
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, f)
+		enabled = append(enabled, name+".automount")
+	}
+	return files, enabled, nil
+}
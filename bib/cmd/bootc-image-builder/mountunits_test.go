@@ -0,0 +1,100 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestMountUnits(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		MountUnits: []main.MountUnit{
+			{What: "nfs.example.com:/export", Where: "/var/mnt/nfs", Type: "nfs", Options: "ro,soft"},
+			{What: "/dev/disk/by-label/scratch", Where: "/var/scratch-data", Type: "xfs", Automount: true},
+		},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	unit, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/var-mnt-nfs.mount")
+	require.NoError(t, err)
+	assert.Equal(t, `[Unit]
+Description=Mount /var/mnt/nfs
+Wants=network-online.target
+After=network-online.target
+
+[Mount]
+What=nfs.example.com:/export
+Where=/var/mnt/nfs
+Type=nfs
+Options=ro,soft
+
+[Install]
+WantedBy=remote-fs.target
+`, unit)
+
+	// the automount unit is enabled instead of the mount unit
+	unit, err = findFileContent(manifestJson, "ostree-deployment", `/etc/systemd/system/var-scratch\x2ddata.mount`)
+	require.NoError(t, err)
+	assert.Equal(t, `[Unit]
+Description=Mount /var/scratch-data
+
+[Mount]
+What=/dev/disk/by-label/scratch
+Where=/var/scratch-data
+Type=xfs
+Options=defaults
+`, unit)
+	unit, err = findFileContent(manifestJson, "ostree-deployment", `/etc/systemd/system/var-scratch\x2ddata.automount`)
+	require.NoError(t, err)
+	assert.Equal(t, `[Unit]
+Description=Automount /var/scratch-data
+
+[Automount]
+Where=/var/scratch-data
+
+[Install]
+WantedBy=local-fs.target
+`, unit)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.systemd")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var opts struct {
+		EnabledServices []string `json:"enabled_services"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+	assert.Equal(t, []string{"var-mnt-nfs.mount", `var-scratch\x2ddata.automount`}, opts.EnabledServices)
+}
+
+func TestManifestMountUnitsErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		mounts  []main.MountUnit
+		err     string
+	}{
+		"root":        {"qcow2", []main.MountUnit{{What: "/dev/sda", Where: "/", Type: "xfs"}}, `mount unit mountpoint "/" must be a clean absolute path below /`},
+		"relative":    {"qcow2", []main.MountUnit{{What: "/dev/sda", Where: "var/data", Type: "xfs"}}, `mount unit mountpoint "var/data" must be a clean absolute path below /`},
+		"no-what":     {"qcow2", []main.MountUnit{{Where: "/var/data", Type: "xfs"}}, `mount unit for "/var/data" needs a source without whitespace`},
+		"bad-type":    {"qcow2", []main.MountUnit{{What: "/dev/sda", Where: "/var/data", Type: "xfs\n[Service]"}}, "invalid filesystem type \"xfs\\n[Service]\" of the mount unit for \"/var/data\""},
+		"bad-options": {"qcow2", []main.MountUnit{{What: "/dev/sda", Where: "/var/data", Type: "xfs", Options: "ro, noexec"}}, `invalid mount options "ro, noexec" for "/var/data"`},
+		"duplicate":   {"qcow2", []main.MountUnit{{What: "/dev/sda", Where: "/var/data", Type: "xfs"}, {What: "/dev/sdb", Where: "/var/data", Type: "xfs"}}, `duplicate mount unit for "/var/data"`},
+		"iso":         {"iso", []main.MountUnit{{What: "/dev/sda", Where: "/var/data", Type: "xfs"}}, "mount_units not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{MountUnits: tc.mounts}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
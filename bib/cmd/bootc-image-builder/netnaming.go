@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// the rule of the same name in /usr/lib/udev/rules.d applies the
+// predictable names, a rule in /etc overrides it
+const netSetupLinkRulePath = "/etc/udev/rules.d/80-net-setup-link.rules"
+
+var netNamingValues = []string{"predictable", "classic"}
+
+// netNaming returns the udev rule and the kernel arguments for the
+// given network interface naming scheme. The predictable names are
+// the default of the container image and need nothing.
+func netNaming(naming string) (*fsnode.File, []string, error) {
+	switch naming {
+	case "predictable":
+		return nil, nil, nil
+	case "classic":
+		// an empty rule masks the one in /usr, the kernel arguments
+		// also cover the initramfs and systems with biosdevname
+		content := "# predictable network interface names are disabled by bootc-image-builder\n"
+		f, err := fsnode.NewFile(netSetupLinkRulePath, nil, nil, nil, []byte(content))
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, []string{"net.ifnames=0", "biosdevname=0"}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported net_naming %q, valid values are %q", naming, netNamingValues)
+	}
+}
@@ -0,0 +1,77 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func deployKernelOpts(t *testing.T, manifestJson []byte) []string {
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.ostree.deploy.container")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var deploy struct {
+		KernelOpts []string `json:"kernel_opts"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &deploy))
+	return deploy.KernelOpts
+}
+
+func TestManifestNetNamingClassic(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{NetNaming: "classic"}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/udev/rules.d/80-net-setup-link.rules")
+	require.NoError(t, err)
+	assert.Equal(t, "# predictable network interface names are disabled by bootc-image-builder\n", content)
+
+	kernelOpts := deployKernelOpts(t, manifestJson)
+	assert.Contains(t, kernelOpts, "net.ifnames=0")
+	assert.Contains(t, kernelOpts, "biosdevname=0")
+}
+
+func TestManifestNetNamingPredictable(t *testing.T) {
+	for _, naming := range []string{"", "predictable"} {
+		t.Run(naming, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{NetNaming: naming}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			_, err = findFileContent(manifestJson, "ostree-deployment", "/etc/udev/rules.d/80-net-setup-link.rules")
+			assert.ErrorContains(t, err, "not found")
+			assert.NotContains(t, deployKernelOpts(t, manifestJson), "net.ifnames=0")
+		})
+	}
+}
+
+func TestManifestNetNamingErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType   string
+		netNaming string
+		err       string
+	}{
+		"bad-value": {"qcow2", "eth", `unsupported net_naming "eth", valid values are ["predictable" "classic"]`},
+		"iso":       {"iso", "classic", "net_naming not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{NetNaming: tc.netNaming}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
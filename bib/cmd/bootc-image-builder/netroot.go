@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// NetworkRoot configures the initramfs to attach the disk with the
+// root filesystem over the network before it is mounted, e.g. for
+// systems that boot from an iSCSI LUN.
+type NetworkRoot struct {
+	// ISCSI is the target with the disk, it is the only supported
+	// network root at the moment
+	ISCSI *ISCSIRoot `json:"iscsi,omitempty"`
+	// IP is the dracut network configuration (ip=...), "dhcp" by
+	// default
+	IP string `json:"ip,omitempty"`
+}
+
+// ISCSIRoot is the iSCSI target with the root filesystem
+type ISCSIRoot struct {
+	// Initiator is the IQN of the system
+	Initiator string `json:"initiator"`
+	// Target is the IQN of the target
+	Target string `json:"target"`
+	// Server is the host name or the IP address of the target
+	Server string `json:"server"`
+	// Port defaults to 3260
+	Port int `json:"port,omitempty"`
+	LUN  int `json:"lun,omitempty"`
+}
+
+const (
+	iscsiDefaultPort = 3260
+
+	netrootDracutConfPath = "/etc/dracut.conf.d/90-bootc-image-builder-netroot.conf"
+)
+
+var (
+	// iqn.yyyy-mm.<reversed domain>[:<unique name>]
+	iqnRegex = regexp.MustCompile(`^iqn\.[0-9]{4}-[0-9]{2}\.[a-z0-9][a-z0-9.-]*(:[^\s]+)?$`)
+	// host names, they end up unquoted on the kernel command line and in
+	// config files
+	hostnameRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+
+	netrootIPKeywords = []string{"dhcp", "dhcp6", "auto6", "ibft"}
+)
+
+func (r *NetworkRoot) ip() string {
+	if r.IP == "" {
+		return "dhcp"
+	}
+	return r.IP
+}
+
+func (i *ISCSIRoot) port() int {
+	if i.Port == 0 {
+		return iscsiDefaultPort
+	}
+	return i.Port
+}
+
+func validateNetrootIP(ip string) error {
+	for _, kw := range netrootIPKeywords {
+		if ip == kw {
+			return nil
+		}
+	}
+	// <client-IP>:[<peer>]:<gateway-IP>:<netmask>:<hostname>:<interface>:{none|off|dhcp|...}
+	fields := strings.Split(ip, ":")
+	if len(fields) >= 7 && net.ParseIP(fields[0]) != nil && !strings.ContainsAny(ip, " \t\n") {
+		return nil
+	}
+	return fmt.Errorf("invalid network_root ip %q, expected one of %q or a static configuration like \"192.0.2.10::192.0.2.1:255.255.255.0:host:eth0:none\"", ip, netrootIPKeywords)
+}
+
+func validateNetworkRoot(r *NetworkRoot) error {
+	if r.ISCSI == nil {
+		return fmt.Errorf("network_root needs an iscsi target")
+	}
+	i := r.ISCSI
+	if !iqnRegex.MatchString(i.Initiator) {
+		return fmt.Errorf("invalid iscsi initiator %q, expected an IQN like \"iqn.2024-01.com.example:host\"", i.Initiator)
+	}
+	if !iqnRegex.MatchString(i.Target) {
+		return fmt.Errorf("invalid iscsi target %q, expected an IQN like \"iqn.2024-01.com.example:storage\"", i.Target)
+	}
+	if net.ParseIP(i.Server) == nil && !hostnameRegex.MatchString(i.Server) {
+		return fmt.Errorf("invalid iscsi server %q, expected a host name or an IP address", i.Server)
+	}
+	if i.Port < 0 || i.Port > 65535 {
+		return fmt.Errorf("invalid iscsi port %d", i.Port)
+	}
+	if i.LUN < 0 {
+		return fmt.Errorf("invalid iscsi lun %d", i.LUN)
+	}
+	return validateNetrootIP(r.ip())
+}
+
+// networkRoot returns the dracut configuration and the kernel arguments
+// that attach the iSCSI target in the initramfs. The root filesystem is
+// still found by its label once the disk is attached.
+func networkRoot(r *NetworkRoot) (*fsnode.File, []string, error) {
+	if err := validateNetworkRoot(r); err != nil {
+		return nil, nil, err
+	}
+	i := r.ISCSI
+	server := i.Server
+	if strings.Contains(server, ":") {
+		server = "[" + server + "]"
+	}
+	kargs := []string{
+		"rd.iscsi.initiator=" + i.Initiator,
+		// iscsi:<server>:<protocol>:<port>:<lun>:<target>
+		fmt.Sprintf("netroot=iscsi:%s::%d:%d:%s", server, i.port(), i.LUN, i.Target),
+		"ip=" + r.ip(),
+		"rd.neednet=1",
+	}
+
+	// the initramfs of the container image is used as it is, the
+	// configuration applies when it is regenerated
+	content := "# added by bootc-image-builder for network_root\nadd_dracutmodules+=\" network iscsi \"\nhostonly=\"no\"\n"
+	f, err := fsnode.NewFile(netrootDracutConfPath, nil, nil, nil, []byte(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, kargs, nil
+}
@@ -0,0 +1,105 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestNetworkRootISCSI(t *testing.T) {
+	for name, tc := range map[string]struct {
+		root          main.NetworkRoot
+		expectedKargs []string
+	}{
+		"defaults": {
+			main.NetworkRoot{ISCSI: &main.ISCSIRoot{
+				Initiator: "iqn.2024-01.com.example:host1",
+				Target:    "iqn.2024-01.com.example:storage.root",
+				Server:    "192.0.2.5",
+			}},
+			[]string{
+				"rd.iscsi.initiator=iqn.2024-01.com.example:host1",
+				"netroot=iscsi:192.0.2.5::3260:0:iqn.2024-01.com.example:storage.root",
+				"ip=dhcp",
+				"rd.neednet=1",
+			},
+		},
+		"ipv6-static": {
+			main.NetworkRoot{
+				ISCSI: &main.ISCSIRoot{
+					Initiator: "iqn.2024-01.com.example:host1",
+					Target:    "iqn.2024-01.com.example:storage.root",
+					Server:    "2001:db8::5",
+					Port:      3261,
+					LUN:       2,
+				},
+				IP: "192.0.2.10::192.0.2.1:255.255.255.0:host1:eth0:none",
+			},
+			[]string{
+				"rd.iscsi.initiator=iqn.2024-01.com.example:host1",
+				"netroot=iscsi:[2001:db8::5]::3261:2:iqn.2024-01.com.example:storage.root",
+				"ip=192.0.2.10::192.0.2.1:255.255.255.0:host1:eth0:none",
+				"rd.neednet=1",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{NetworkRoot: &tc.root}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			kernelOpts := deployKernelOpts(t, manifestJson)
+			for _, karg := range tc.expectedKargs {
+				assert.Contains(t, kernelOpts, karg)
+			}
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/dracut.conf.d/90-bootc-image-builder-netroot.conf")
+			require.NoError(t, err)
+			assert.Equal(t, "# added by bootc-image-builder for network_root\nadd_dracutmodules+=\" network iscsi \"\nhostonly=\"no\"\n", content)
+		})
+	}
+}
+
+func TestManifestNetworkRootErrors(t *testing.T) {
+	valid := func() *main.ISCSIRoot {
+		return &main.ISCSIRoot{
+			Initiator: "iqn.2024-01.com.example:host1",
+			Target:    "iqn.2024-01.com.example:storage.root",
+			Server:    "storage.example.com",
+		}
+	}
+	withISCSI := func(update func(i *main.ISCSIRoot)) *main.NetworkRoot {
+		i := valid()
+		update(i)
+		return &main.NetworkRoot{ISCSI: i}
+	}
+	for name, tc := range map[string]struct {
+		imgType string
+		root    *main.NetworkRoot
+		err     string
+	}{
+		"no-target":     {"qcow2", &main.NetworkRoot{}, "network_root needs an iscsi target"},
+		"bad-initiator": {"qcow2", withISCSI(func(i *main.ISCSIRoot) { i.Initiator = "host1" }), `invalid iscsi initiator "host1", expected an IQN like "iqn.2024-01.com.example:host"`},
+		"bad-target":    {"qcow2", withISCSI(func(i *main.ISCSIRoot) { i.Target = "iqn.24-1.com.example" }), `invalid iscsi target "iqn.24-1.com.example", expected an IQN like "iqn.2024-01.com.example:storage"`},
+		"bad-server":    {"qcow2", withISCSI(func(i *main.ISCSIRoot) { i.Server = "storage:3260" }), `invalid iscsi server "storage:3260", expected a host name or an IP address`},
+		"bad-port":      {"qcow2", withISCSI(func(i *main.ISCSIRoot) { i.Port = 70000 }), "invalid iscsi port 70000"},
+		"bad-lun":       {"qcow2", withISCSI(func(i *main.ISCSIRoot) { i.LUN = -1 }), "invalid iscsi lun -1"},
+		"bad-ip":        {"qcow2", &main.NetworkRoot{ISCSI: valid(), IP: "static"}, `invalid network_root ip "static", expected one of ["dhcp" "dhcp6" "auto6" "ibft"] or a static configuration like "192.0.2.10::192.0.2.1:255.255.255.0:host:eth0:none"`},
+		"iso":           {"iso", &main.NetworkRoot{ISCSI: valid()}, "network_root not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{NetworkRoot: tc.root}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	nmDNSDropInPath          = "/etc/NetworkManager/conf.d/90-bootc-image-builder-dns.conf"
+	nmConnectivityDropInPath = "/etc/NetworkManager/conf.d/90-bootc-image-builder-connectivity.conf"
+)
+
+// NetworkManager configures NetworkManager globally, the connections
+// are not touched
+type NetworkManager struct {
+	// DNS is the DNS processing mode, e.g. "systemd-resolved"
+	DNS string `json:"dns,omitempty"`
+	// GlobalDNS is used instead of the DNS configuration of the
+	// connections
+	GlobalDNS *NMGlobalDNS `json:"global_dns,omitempty"`
+	// Connectivity configures the connectivity check
+	Connectivity *NMConnectivity `json:"connectivity,omitempty"`
+}
+
+// NMGlobalDNS is the global DNS configuration of NetworkManager
+type NMGlobalDNS struct {
+	// Servers are the IP addresses of the name servers
+	Servers []string `json:"servers"`
+	// Searches are the search domains
+	Searches []string `json:"searches,omitempty"`
+}
+
+// NMConnectivity configures the connectivity check of NetworkManager
+type NMConnectivity struct {
+	// Enabled set to false disables the check
+	Enabled *bool `json:"enabled,omitempty"`
+	// URI is the URL that is checked
+	URI string `json:"uri,omitempty"`
+	// Interval is the time between checks in seconds, 0 disables
+	// periodic checks
+	Interval *int `json:"interval,omitempty"`
+}
+
+var nmDNSModes = []string{"default", "dnsmasq", "systemd-resolved", "none"}
+
+func nmDNSConfig(nm *NetworkManager) (*fsnode.File, error) {
+	var content strings.Builder
+	if nm.DNS != "" {
+		valid := false
+		for _, mode := range nmDNSModes {
+			if nm.DNS == mode {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unsupported network_manager dns %q, valid values are %q", nm.DNS, nmDNSModes)
+		}
+		fmt.Fprintf(&content, "[main]\ndns=%s\n", nm.DNS)
+	}
+	if g := nm.GlobalDNS; g != nil {
+		if len(g.Servers) == 0 {
+			return nil, fmt.Errorf("network_manager global_dns needs servers")
+		}
+		for _, server := range g.Servers {
+			if net.ParseIP(server) == nil {
+				return nil, fmt.Errorf("invalid network_manager global_dns server %q, expected an IP address", server)
+			}
+		}
+		for _, domain := range g.Searches {
+			if len(domain) > 253 || !dnsDomainRegex.MatchString(domain) {
+				return nil, fmt.Errorf("invalid network_manager global_dns search domain %q", domain)
+			}
+		}
+		if content.Len() > 0 {
+			content.WriteString("\n")
+		}
+		content.WriteString("[global-dns]\n")
+		if len(g.Searches) > 0 {
+			fmt.Fprintf(&content, "searches=%s\n", strings.Join(g.Searches, ","))
+		}
+		// the servers of the "*" domain are used for all names
+		fmt.Fprintf(&content, "\n[global-dns-domain-*]\nservers=%s\n", strings.Join(g.Servers, ","))
+	}
+	if content.Len() == 0 {
+		return nil, nil
+	}
+	return fsnode.NewFile(nmDNSDropInPath, nil, nil, nil, []byte(content.String()))
+}
+
+func nmConnectivityConfig(c *NMConnectivity) (*fsnode.File, error) {
+	disabled := c.Enabled != nil && !*c.Enabled
+	if disabled && (c.URI != "" || c.Interval != nil) {
+		return nil, fmt.Errorf("network_manager connectivity uri and interval have no effect when the check is disabled")
+	}
+	var content strings.Builder
+	content.WriteString("[connectivity]\n")
+	if c.Enabled != nil {
+		fmt.Fprintf(&content, "enabled=%t\n", *c.Enabled)
+	}
+	if c.URI != "" {
+		u, err := url.Parse(c.URI)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(c.URI, " \t\n") {
+			return nil, fmt.Errorf("invalid network_manager connectivity uri %q, expected an http or https URL", c.URI)
+		}
+		fmt.Fprintf(&content, "uri=%s\n", c.URI)
+	}
+	if c.Interval != nil {
+		if *c.Interval < 0 {
+			return nil, fmt.Errorf("invalid network_manager connectivity interval %d", *c.Interval)
+		}
+		fmt.Fprintf(&content, "interval=%d\n", *c.Interval)
+	}
+	return fsnode.NewFile(nmConnectivityDropInPath, nil, nil, nil, []byte(content.String()))
+}
+
+// networkManagerFiles returns the NetworkManager drop-ins of the given
+// configuration
+func networkManagerFiles(nm *NetworkManager) ([]*fsnode.File, error) {
+	if nm.DNS == "" && nm.GlobalDNS == nil && nm.Connectivity == nil {
+		return nil, fmt.Errorf("network_manager needs dns, global_dns or connectivity")
+	}
+	var files []*fsnode.File
+	dns, err := nmDNSConfig(nm)
+	if err != nil {
+		return nil, err
+	}
+	if dns != nil {
+		files = append(files, dns)
+	}
+	if nm.Connectivity != nil {
+		f, err := nmConnectivityConfig(nm.Connectivity)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
@@ -0,0 +1,88 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestNetworkManager(t *testing.T) {
+	disabled := false
+	interval := 600
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		NetworkManager: &main.NetworkManager{
+			DNS: "systemd-resolved",
+			GlobalDNS: &main.NMGlobalDNS{
+				Servers:  []string{"192.0.2.53", "2001:db8::53"},
+				Searches: []string{"example.com", "lab.example.com"},
+			},
+			Connectivity: &main.NMConnectivity{Enabled: &disabled},
+		},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/NetworkManager/conf.d/90-bootc-image-builder-dns.conf")
+	require.NoError(t, err)
+	assert.Equal(t, `[main]
+dns=systemd-resolved
+
+[global-dns]
+searches=example.com,lab.example.com
+
+[global-dns-domain-*]
+servers=192.0.2.53,2001:db8::53
+`, content)
+	content, err = findFileContent(manifestJson, "ostree-deployment", "/etc/NetworkManager/conf.d/90-bootc-image-builder-connectivity.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "[connectivity]\nenabled=false\n", content)
+
+	// only the connectivity check
+	config.Config.NetworkManager = &main.NetworkManager{
+		Connectivity: &main.NMConnectivity{URI: "http://check.example.com/check_network_status.txt", Interval: &interval},
+	}
+	mf, err = main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err = mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	_, err = findFileContent(manifestJson, "ostree-deployment", "/etc/NetworkManager/conf.d/90-bootc-image-builder-dns.conf")
+	assert.ErrorContains(t, err, "not found")
+	content, err = findFileContent(manifestJson, "ostree-deployment", "/etc/NetworkManager/conf.d/90-bootc-image-builder-connectivity.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "[connectivity]\nuri=http://check.example.com/check_network_status.txt\ninterval=600\n", content)
+}
+
+func TestManifestNetworkManagerErrors(t *testing.T) {
+	disabled := false
+	negative := -1
+	for name, tc := range map[string]struct {
+		imgType string
+		nm      main.NetworkManager
+		err     string
+	}{
+		"empty":        {"qcow2", main.NetworkManager{}, "network_manager needs dns, global_dns or connectivity"},
+		"bad-dns":      {"qcow2", main.NetworkManager{DNS: "unbound"}, `unsupported network_manager dns "unbound", valid values are ["default" "dnsmasq" "systemd-resolved" "none"]`},
+		"no-servers":   {"qcow2", main.NetworkManager{GlobalDNS: &main.NMGlobalDNS{}}, "network_manager global_dns needs servers"},
+		"bad-server":   {"qcow2", main.NetworkManager{GlobalDNS: &main.NMGlobalDNS{Servers: []string{"dns.example.com"}}}, `invalid network_manager global_dns server "dns.example.com", expected an IP address`},
+		"bad-search":   {"qcow2", main.NetworkManager{GlobalDNS: &main.NMGlobalDNS{Servers: []string{"192.0.2.53"}, Searches: []string{"bad domain"}}}, `invalid network_manager global_dns search domain "bad domain"`},
+		"bad-uri":      {"qcow2", main.NetworkManager{Connectivity: &main.NMConnectivity{URI: "ftp://check.example.com"}}, `invalid network_manager connectivity uri "ftp://check.example.com", expected an http or https URL`},
+		"disabled-uri": {"qcow2", main.NetworkManager{Connectivity: &main.NMConnectivity{Enabled: &disabled, URI: "http://check.example.com"}}, "network_manager connectivity uri and interval have no effect when the check is disabled"},
+		"bad-interval": {"qcow2", main.NetworkManager{Connectivity: &main.NMConnectivity{Interval: &negative}}, "invalid network_manager connectivity interval -1"},
+		"iso":          {"iso", main.NetworkManager{DNS: "default"}, "network_manager not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{NetworkManager: &tc.nm}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
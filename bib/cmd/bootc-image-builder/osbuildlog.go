@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// osbuildStopTimeout is how long osbuild gets to unmount its trees and
+// detach its loop devices once it is interrupted, it is killed after
+// that
+var osbuildStopTimeout = 60 * time.Second
+
+// osbuildCmd returns the command that runs osbuild with the given
+// arguments, the manifest is passed on stdin
+func osbuildCmd(manifest []byte, args, extraEnv []string, stdout, stderr io.Writer) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	if len(extraEnv) > 0 {
+		cmd.Env = append(os.Environ(), extraEnv...)
+	}
+	cmd.Stdin = bytes.NewReader(manifest)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// osbuild gets its own process group so that a SIGINT from the
+	// terminal reaches it only once, forwarded by startOSBuild
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// startOSBuild starts osbuild and returns the function that waits for
+// it to exit. When the context is canceled the process group of
+// osbuild gets SIGINT, osbuild then cleans up like after Ctrl-C (it
+// has no handler for SIGTERM). It is only killed when it does not
+// exit within osbuildStopTimeout.
+//
+// TODO: use cmd.Cancel and cmd.WaitDelay once we require go 1.20
+func startOSBuild(ctx context.Context, cmd *exec.Cmd) (wait func() error, err error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pgid := cmd.Process.Pid
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-exited:
+			return
+		}
+		_ = syscall.Kill(-pgid, syscall.SIGINT)
+		select {
+		case <-exited:
+		case <-time.After(osbuildStopTimeout):
+			fmt.Fprintf(os.Stderr, "WARNING: osbuild did not stop within %s, killing it\n", osbuildStopTimeout)
+			_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+	}()
+	return func() error {
+		defer close(exited)
+		return cmd.Wait()
+	}, nil
+}
+
+// runOSBuild runs osbuild like osbuild.RunOSBuild but writes its output
+// to the given writers instead of the standard output and error. It is
+// stopped when the context is canceled, see startOSBuild.
+func runOSBuild(ctx context.Context, manifest []byte, store, outputDirectory string, exports, extraEnv []string, stdout, stderr io.Writer) error {
+	cmd := osbuildCmd(manifest, osbuildArgs(store, outputDirectory, exports, nil), extraEnv, stdout, stderr)
+	wait, err := startOSBuild(ctx, cmd)
+	if err == nil {
+		err = wait()
+	}
+	if err != nil {
+		return fmt.Errorf("running osbuild failed: %w", err)
+	}
+	return nil
+}
+
+// osbuildLog is a file that the output of osbuild is copied to
+type osbuildLog struct {
+	f *os.File
+}
+
+func openOSBuildLog(path string) (*osbuildLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create osbuild log: %w", err)
+	}
+	return &osbuildLog{f: f}, nil
+}
+
+// tee returns writers that write to the given writers and the log
+func (l *osbuildLog) tee(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	return io.MultiWriter(stdout, l.f), io.MultiWriter(stderr, l.f)
+}
+
+// Close flushes the log to disk, it is called for failed builds as
+// well as the log is most useful then
+func (l *osbuildLog) Close() error {
+	if err := l.f.Sync(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
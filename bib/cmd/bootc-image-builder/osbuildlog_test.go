@@ -0,0 +1,88 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+// mockOSBuild puts an osbuild script with the given body first in PATH
+func mockOSBuild(t *testing.T, body string) {
+	binDir := t.TempDir()
+	script := "#!/bin/sh\n" + body
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "osbuild"), []byte(script), 0755))
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+}
+
+func TestRunOSBuildLog(t *testing.T) {
+	mockOSBuild(t, `cat >/dev/null
+echo "args: $*"
+echo "org.osbuild.container-deploy: copying image"
+echo "org.osbuild.selinux: cannot relabel" >&2
+exit 1
+`)
+	logPath := filepath.Join(t.TempDir(), "osbuild.log")
+	osbuildLog, err := main.OpenOSBuildLog(logPath)
+	require.NoError(t, err)
+
+	var stdout, stderr bytes.Buffer
+	teeStdout, teeStderr := osbuildLog.Tee(&stdout, &stderr)
+	err = main.RunOSBuild(context.Background(), []byte("{}"), "/store", "/output", []string{"qcow2"}, nil, teeStdout, teeStderr)
+	assert.EqualError(t, err, "running osbuild failed: exit status 1")
+	require.NoError(t, osbuildLog.Close())
+
+	// the output still goes to the regular writers
+	assert.Contains(t, stdout.String(), "org.osbuild.container-deploy: copying image\n")
+	assert.Equal(t, "org.osbuild.selinux: cannot relabel\n", stderr.String())
+
+	// and all of it to the log, also for a failed build
+	content, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "args: --store /store --output-directory /output - --export qcow2\n")
+	assert.Contains(t, string(content), "org.osbuild.container-deploy: copying image\n")
+	assert.Contains(t, string(content), "org.osbuild.selinux: cannot relabel\n")
+}
+
+func TestRunOSBuildCanceled(t *testing.T) {
+	mockOSBuild(t, `trap 'kill $!; echo cleaning up; exit 130' INT
+sleep 60 >/dev/null 2>&1 &
+wait
+`)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	var stdout bytes.Buffer
+	start := time.Now()
+	err := main.RunOSBuild(ctx, []byte("{}"), "/store", "/output", []string{"qcow2"}, nil, &stdout, &bytes.Buffer{})
+	assert.EqualError(t, err, "running osbuild failed: exit status 130")
+	assert.Equal(t, "cleaning up\n", stdout.String())
+	assert.Less(t, time.Since(start), 30*time.Second)
+}
+
+func TestRunOSBuildCanceledKilledAfterTimeout(t *testing.T) {
+	restore := main.MockOSBuildStopTimeout(200 * time.Millisecond)
+	defer restore()
+	mockOSBuild(t, `trap '' INT
+sleep 60
+`)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := main.RunOSBuild(ctx, []byte("{}"), "/store", "/output", []string{"qcow2"}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	assert.EqualError(t, err, "running osbuild failed: signal: killed")
+	assert.Less(t, time.Since(start), 30*time.Second)
+}
+
+func TestOpenOSBuildLogError(t *testing.T) {
+	_, err := main.OpenOSBuildLog(filepath.Join(t.TempDir(), "missing", "osbuild.log"))
+	assert.ErrorContains(t, err, "cannot create osbuild log: ")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	osReleaseOverridesPath = "/etc/bootc-image-builder/os-release"
+	osReleaseService       = "bootc-os-release-overrides.service"
+)
+
+// os-release(5) field names are uppercase shell variable names
+var osReleaseKeyRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// osReleaseQuote quotes the value with the shell compatible escaping
+// of os-release(5)
+func osReleaseQuote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return `"` + r.Replace(value) + `"`
+}
+
+// osReleaseFiles returns the files that override the given fields of
+// the os-release of the container image. The os-release in /usr
+// cannot be changed, systemd prefers /etc/os-release though. It is
+// generated from the one in /usr and the overrides on every boot so
+// that the other fields follow updates of the image.
+func osReleaseFiles(fields map[string]string) ([]*fsnode.File, []string, error) {
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("os_release needs at least one field")
+	}
+	keys := make([]string, 0, len(fields))
+	for key, value := range fields {
+		if !osReleaseKeyRegex.MatchString(key) {
+			return nil, nil, fmt.Errorf("invalid os_release field %q, must be an uppercase identifier", key)
+		}
+		if strings.ContainsAny(value, "\n\r\x00") {
+			return nil, nil, fmt.Errorf("invalid os_release value for %s, must be a single line", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var overrides strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&overrides, "%s=%s\n", key, osReleaseQuote(fields[key]))
+	}
+	overridesFile, err := fsnode.NewFile(osReleaseOverridesPath, nil, nil, nil, []byte(overrides.String()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merge := fmt.Sprintf("grep -v -E '^(%s)=' /usr/lib/os-release > /etc/os-release.tmp && cat %s >> /etc/os-release.tmp && mv /etc/os-release.tmp /etc/os-release",
+		strings.Join(keys, "|"), osReleaseOverridesPath)
+	unit := fmt.Sprintf(`[Unit]
+Description=Apply the os-release overrides of bootc-image-builder
+DefaultDependencies=no
+After=systemd-remount-fs.service
+Before=sysinit.target
+ConditionPathExists=%s
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh -c "%s"
+
+[Install]
+WantedBy=sysinit.target
+`, osReleaseOverridesPath, merge)
+
+	mode := os.FileMode(0644)
+	unitFile, err := fsnode.NewFile("/etc/systemd/system/"+osReleaseService, &mode, nil, nil, []byte(unit))
+	if err != nil {
+		return nil, nil, err
+	}
+	return []*fsnode.File{overridesFile, unitFile}, []string{osReleaseService}, nil
+}
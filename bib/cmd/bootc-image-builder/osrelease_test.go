@@ -0,0 +1,71 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestOSRelease(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		OSRelease: map[string]string{
+			"VARIANT":  `ACME "Edge" Edition`,
+			"BUILD_ID": "2024.05.1",
+			"IMAGE_ID": "acme-edge",
+		},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	overrides, err := findFileContent(manifestJson, "ostree-deployment", "/etc/bootc-image-builder/os-release")
+	require.NoError(t, err)
+	assert.Equal(t, `BUILD_ID="2024.05.1"
+IMAGE_ID="acme-edge"
+VARIANT="ACME \"Edge\" Edition"
+`, overrides)
+
+	// only the overridden fields are dropped from the os-release of
+	// the image
+	unit, err := findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/bootc-os-release-overrides.service")
+	require.NoError(t, err)
+	assert.Contains(t, unit, `ExecStart=/bin/sh -c "grep -v -E '^(BUILD_ID|IMAGE_ID|VARIANT)=' /usr/lib/os-release > /etc/os-release.tmp && cat /etc/bootc-image-builder/os-release >> /etc/os-release.tmp && mv /etc/os-release.tmp /etc/os-release"`)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.systemd")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var opts struct {
+		EnabledServices []string `json:"enabled_services"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+	assert.Equal(t, []string{"bootc-os-release-overrides.service"}, opts.EnabledServices)
+}
+
+func TestManifestOSReleaseErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType   string
+		osRelease map[string]string
+		err       string
+	}{
+		"empty":     {"qcow2", map[string]string{}, "os_release needs at least one field"},
+		"lowercase": {"qcow2", map[string]string{"variant": "edge"}, `invalid os_release field "variant", must be an uppercase identifier`},
+		"bad-key":   {"qcow2", map[string]string{"BUILD-ID": "1"}, `invalid os_release field "BUILD-ID", must be an uppercase identifier`},
+		"multiline": {"qcow2", map[string]string{"VARIANT": "edge\nID=other"}, "invalid os_release value for VARIANT, must be a single line"},
+		"iso":       {"iso", map[string]string{"VARIANT": "edge"}, "os_release not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{OSRelease: tc.osRelease}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// OSTreeRemote is an ostree remote that is configured in the system
+// repository, e.g. for a later switch to an image with the
+// ostree-remote-registry transport.
+type OSTreeRemote struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// GPGKeyPaths are the paths of the ASCII armored GPG keys in the
+	// system that verify the remote, without keys the remote is not
+	// verified
+	GPGKeyPaths []string `json:"gpg_key_paths,omitempty"`
+}
+
+var ostreeRemoteNameRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+func (r *OSTreeRemote) validate() error {
+	if !ostreeRemoteNameRegex.MatchString(r.Name) {
+		return fmt.Errorf("invalid ostree remote name %q", r.Name)
+	}
+	if r.URL == "" {
+		return fmt.Errorf("ostree remote %q needs a url", r.Name)
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return fmt.Errorf("invalid ostree remote url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Errorf("ostree remote url %q has no host", r.URL)
+		}
+	case "file":
+		if u.Path == "" {
+			return fmt.Errorf("ostree remote url %q has no path", r.URL)
+		}
+	default:
+		return fmt.Errorf("ostree remote url %q must be a http(s) or file url", r.URL)
+	}
+	for _, p := range r.GPGKeyPaths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("ostree remote gpg key path %q must be absolute", p)
+		}
+	}
+	return nil
+}
+
+// addOSTreeRemote configures the remote in the system repository of
+// the deployment.
+//
+// TODO: osbuild/images only configures remotes for ostree commits
+func addOSTreeRemote(m *serializedManifest, remote *OSTreeRemote) error {
+	err := m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(json.RawMessage) ([]*osbuild.Stage, error) {
+		stage := osbuild.NewOSTreeRemotesStage(&osbuild.OSTreeRemotesStageOptions{
+			Repo: "/ostree/repo",
+			Remotes: []osbuild.OSTreeRemote{
+				{
+					Name:        remote.Name,
+					URL:         remote.URL,
+					GPGKeyPaths: remote.GPGKeyPaths,
+				},
+			},
+		})
+		return []*osbuild.Stage{stage}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add ostree remote: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestOSTreeRemote(t *testing.T) {
+	remote := &main.OSTreeRemote{
+		Name:        "example",
+		URL:         "https://ostree.example.com/repo",
+		GPGKeyPaths: []string{"/etc/pki/rpm-gpg/RPM-GPG-KEY-example"},
+	}
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{OSTreeRemote: remote}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddOSTreeRemote(manifestJson, remote)
+	require.NoError(t, err)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.ostree.remotes")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	assert.JSONEq(t, `{
+		"repo": "/ostree/repo",
+		"remotes": [
+			{
+				"name": "example",
+				"url": "https://ostree.example.com/repo",
+				"gpgkeypaths": ["/etc/pki/rpm-gpg/RPM-GPG-KEY-example"]
+			}
+		]
+	}`, string(stages[0].Options))
+}
+
+func TestManifestOSTreeRemoteErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		remote main.OSTreeRemote
+		expErr string
+	}{
+		"bad-name": {
+			main.OSTreeRemote{Name: "ex/ample", URL: "https://ostree.example.com"},
+			`invalid ostree remote name "ex/ample"`,
+		},
+		"no-url": {
+			main.OSTreeRemote{Name: "example"},
+			`ostree remote "example" needs a url`,
+		},
+		"bad-scheme": {
+			main.OSTreeRemote{Name: "example", URL: "ftp://ostree.example.com"},
+			`ostree remote url "ftp://ostree.example.com" must be a http(s) or file url`,
+		},
+		"no-host": {
+			main.OSTreeRemote{Name: "example", URL: "https:///repo"},
+			`ostree remote url "https:///repo" has no host`,
+		},
+		"unparsable": {
+			main.OSTreeRemote{Name: "example", URL: "https://ostree example.com"},
+			"invalid ostree remote url: ",
+		},
+		"relative-key": {
+			main.OSTreeRemote{Name: "example", URL: "file:///srv/repo", GPGKeyPaths: []string{"key.gpg"}},
+			`ostree remote gpg key path "key.gpg" must be absolute`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{OSTreeRemote: &tc.remote}
+			_, err := main.Manifest(config)
+			assert.ErrorContains(t, err, tc.expErr)
+		})
+	}
+}
+
+func TestManifestOSTreeRemoteISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{OSTreeRemote: &main.OSTreeRemote{Name: "example", URL: "https://ostree.example.com"}}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "ostree_remote not supported for the iso image type")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// stagedOutput collects the artifacts of a build in a hidden directory
+// inside the output directory. They are moved into place only when the
+// build succeeded, an interrupted build leaves no partial artifacts
+// behind that look valid.
+type stagedOutput struct {
+	outputDir string
+	// Dir is where the artifacts are written to during the build
+	Dir string
+}
+
+func newStagedOutput(outputDir string) (*stagedOutput, error) {
+	dir, err := os.MkdirTemp(outputDir, ".bib-staging-")
+	if err != nil {
+		return nil, err
+	}
+	return &stagedOutput{outputDir: outputDir, Dir: dir}, nil
+}
+
+// commit moves the given outputs (relative to the output directory)
+// into place, the rename is atomic as both are on the same filesystem.
+// Existing outputs are replaced, they are moved into the staging dir
+// first and removed with it. If an output cannot be moved the ones
+// that already are are moved back, the output directory has either
+// all new or all previous outputs.
+func (s *stagedOutput) commit(outputs []string) (err error) {
+	replacedDir := filepath.Join(s.Dir, ".replaced")
+	type committedOutput struct {
+		name     string
+		replaced bool
+	}
+	var done []committedOutput
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(done) - 1; i >= 0; i-- {
+			s.rollback(done[i].name, done[i].replaced, replacedDir)
+		}
+	}()
+
+	for _, output := range outputs {
+		dst := filepath.Join(s.outputDir, output)
+		replaced := false
+		_, err := os.Lstat(dst)
+		if err == nil {
+			if err := os.MkdirAll(replacedDir, 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(dst, filepath.Join(replacedDir, output)); err != nil {
+				return fmt.Errorf("cannot replace %s in the output directory: %w", output, err)
+			}
+			replaced = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := os.Rename(filepath.Join(s.Dir, output), dst); err != nil {
+			if replaced {
+				s.restore(output, replacedDir)
+			}
+			return fmt.Errorf("cannot move %s into the output directory: %w", output, err)
+		}
+		done = append(done, committedOutput{name: output, replaced: replaced})
+	}
+	for _, output := range done {
+		if output.replaced {
+			fmt.Printf("Replaced existing %s\n", filepath.Join(s.outputDir, output.name))
+		}
+	}
+	return nil
+}
+
+// rollback moves a committed output back into the staging dir and
+// restores the output it replaced, it is best effort and only warns
+func (s *stagedOutput) rollback(output string, replaced bool, replacedDir string) {
+	dst := filepath.Join(s.outputDir, output)
+	if err := os.Rename(dst, filepath.Join(s.Dir, output)); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: cannot roll back %s: %s\n", dst, err)
+		return
+	}
+	if replaced {
+		s.restore(output, replacedDir)
+	}
+}
+
+// restore moves a replaced output back into the output directory
+func (s *stagedOutput) restore(output, replacedDir string) {
+	dst := filepath.Join(s.outputDir, output)
+	if err := os.Rename(filepath.Join(replacedDir, output), dst); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: cannot restore the previous %s: %s\n", dst, err)
+	}
+}
+
+// cleanup removes everything that was not committed, it is best
+// effort and ignores errors
+func (s *stagedOutput) cleanup() {
+	os.RemoveAll(s.Dir)
+}
+
+// parseOutputOwner parses the "uid:gid" of the --output-owner flag,
+// both ids must be numeric as the names of the users of the host are
+// unknown inside the container.
+func parseOutputOwner(owner string) (uid, gid int, err error) {
+	uidStr, gidStr, found := strings.Cut(owner, ":")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid output owner %q, must be uid:gid", owner)
+	}
+	uid, err = parseOwnerID(uidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid in output owner %q: %w", owner, err)
+	}
+	gid, err = parseOwnerID(gidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid gid in output owner %q: %w", owner, err)
+	}
+	return uid, gid, nil
+}
+
+func parseOwnerID(s string) (int, error) {
+	// -1 is accepted by chown(2) to keep the id, do not allow it
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || id == 1<<32-1 {
+		return 0, fmt.Errorf("%q is not a numeric id", s)
+	}
+	return int(id), nil
+}
+
+// chownTree changes the owner of path and, for directories, of
+// everything below it. Symlinks are changed, not their targets.
+func chownTree(path string, uid, gid int) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(p, uid, gid); err != nil {
+			return fmt.Errorf("cannot set the owner of %s: %w", p, err)
+		}
+		return nil
+	})
+}
+
+// interruptedError is returned when the build was stopped by a signal
+type interruptedError struct {
+	sig syscall.Signal
+}
+
+func (e *interruptedError) Error() string {
+	return fmt.Sprintf("build interrupted by %s", e.sig)
+}
+
+// exitCode is the exit code of a process that is killed by the signal
+func (e *interruptedError) exitCode() int {
+	return 128 + int(e.sig)
+}
+
+// notifyInterrupt returns a context that is canceled when SIGINT or
+// SIGTERM is received, e.g. to stop osbuild. The build cleans up once
+// osbuild exited, interrupted then returns the error with the received
+// signal. The signals are handled until stop is called.
+func notifyInterrupt() (ctx context.Context, interrupted func() error, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	var mu sync.Mutex
+	var received syscall.Signal
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			fmt.Fprintf(os.Stderr, "Received %s, stopping the build\n", sig)
+			mu.Lock()
+			received = sig.(syscall.Signal)
+			mu.Unlock()
+			cancel()
+		case <-done:
+		}
+	}()
+
+	interrupted = func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if received == 0 {
+			return nil
+		}
+		return &interruptedError{sig: received}
+	}
+	stop = func() {
+		signal.Stop(sigs)
+		close(done)
+		cancel()
+	}
+	return ctx, interrupted, stop
+}
@@ -0,0 +1,172 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestStagedOutputCommit(t *testing.T) {
+	outputDir := t.TempDir()
+	staging, err := main.NewStagedOutput(outputDir)
+	require.NoError(t, err)
+	defer staging.Cleanup()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(staging.Dir, "qcow2"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(staging.Dir, "qcow2", "disk.qcow2"), []byte("disk"), 0644))
+	// not committed in the output dir until the build is done
+	assert.NoFileExists(t, filepath.Join(outputDir, "qcow2", "disk.qcow2"))
+
+	require.NoError(t, staging.Commit([]string{"qcow2"}))
+	staging.Cleanup()
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "qcow2", "disk.qcow2"))
+	require.NoError(t, err)
+	assert.Equal(t, "disk", string(content))
+	// no leftovers of the staging dir
+	entries, err := os.ReadDir(outputDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "qcow2", entries[0].Name())
+}
+
+func TestStagedOutputCommitReplaces(t *testing.T) {
+	outputDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(outputDir, "qcow2"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "qcow2", "disk.qcow2"), []byte("old"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "qcow2", "stale"), []byte("old"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "manifest-qcow2.json"), []byte("old"), 0644))
+
+	staging, err := main.NewStagedOutput(outputDir)
+	require.NoError(t, err)
+	defer staging.Cleanup()
+	require.NoError(t, os.MkdirAll(filepath.Join(staging.Dir, "qcow2"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(staging.Dir, "qcow2", "disk.qcow2"), []byte("new"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(staging.Dir, "manifest-qcow2.json"), []byte("new"), 0644))
+
+	require.NoError(t, staging.Commit([]string{"manifest-qcow2.json", "qcow2"}))
+	staging.Cleanup()
+
+	for _, name := range []string{"manifest-qcow2.json", "qcow2/disk.qcow2"} {
+		content, err := os.ReadFile(filepath.Join(outputDir, name))
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(content))
+	}
+	// the old output is replaced as a whole
+	assert.NoFileExists(t, filepath.Join(outputDir, "qcow2", "stale"))
+	entries, err := os.ReadDir(outputDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestStagedOutputCommitRollback(t *testing.T) {
+	outputDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "manifest-qcow2.json"), []byte("old"), 0644))
+
+	staging, err := main.NewStagedOutput(outputDir)
+	require.NoError(t, err)
+	defer staging.Cleanup()
+	require.NoError(t, os.WriteFile(filepath.Join(staging.Dir, "manifest-qcow2.json"), []byte("new"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(staging.Dir, "SHA256SUMS"), []byte("new"), 0644))
+
+	// qcow2 was not built, nothing must be committed
+	err = staging.Commit([]string{"manifest-qcow2.json", "SHA256SUMS", "qcow2"})
+	assert.ErrorContains(t, err, "cannot move qcow2 into the output directory: ")
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "manifest-qcow2.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+	assert.NoFileExists(t, filepath.Join(outputDir, "SHA256SUMS"))
+	// the new outputs are back in the staging dir
+	assert.FileExists(t, filepath.Join(staging.Dir, "SHA256SUMS"))
+}
+
+func TestNotifyInterrupt(t *testing.T) {
+	for _, tc := range []struct {
+		sig      syscall.Signal
+		exitCode int
+	}{
+		{syscall.SIGINT, 130},
+		{syscall.SIGTERM, 143},
+	} {
+		t.Run(tc.sig.String(), func(t *testing.T) {
+			ctx, interrupted, stop := main.NotifyInterrupt()
+			defer stop()
+			assert.NoError(t, interrupted())
+
+			require.NoError(t, syscall.Kill(os.Getpid(), tc.sig))
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Second):
+				t.Fatalf("context not canceled after %s", tc.sig)
+			}
+			err := interrupted()
+			assert.EqualError(t, err, "build interrupted by "+tc.sig.String())
+			assert.Equal(t, tc.exitCode, main.InterruptExitCode(err))
+		})
+	}
+}
+
+func TestNotifyInterruptStopped(t *testing.T) {
+	ctx, interrupted, stop := main.NotifyInterrupt()
+	stop()
+	<-ctx.Done()
+	assert.NoError(t, interrupted())
+}
+
+func TestParseOutputOwner(t *testing.T) {
+	uid, gid, err := main.ParseOutputOwner("1000:1001")
+	require.NoError(t, err)
+	assert.Equal(t, 1000, uid)
+	assert.Equal(t, 1001, gid)
+
+	uid, gid, err = main.ParseOutputOwner("0:0")
+	require.NoError(t, err)
+	assert.Equal(t, 0, uid)
+	assert.Equal(t, 0, gid)
+}
+
+func TestParseOutputOwnerErrors(t *testing.T) {
+	for owner, expectedErr := range map[string]string{
+		"1000":         `invalid output owner "1000", must be uid:gid`,
+		"user:1000":    `invalid uid in output owner "user:1000": "user" is not a numeric id`,
+		"1000:group":   `invalid gid in output owner "1000:group": "group" is not a numeric id`,
+		"-1:1000":      `invalid uid in output owner "-1:1000": "-1" is not a numeric id`,
+		"1000:":        `invalid gid in output owner "1000:": "" is not a numeric id`,
+		"4294967295:0": `invalid uid in output owner "4294967295:0": "4294967295" is not a numeric id`,
+	} {
+		t.Run(owner, func(t *testing.T) {
+			_, _, err := main.ParseOutputOwner(owner)
+			assert.EqualError(t, err, expectedErr)
+		})
+	}
+}
+
+func TestChownTree(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of files needs root")
+	}
+
+	outputDir := t.TempDir()
+	qcow2Dir := filepath.Join(outputDir, "qcow2")
+	require.NoError(t, os.MkdirAll(qcow2Dir, 0755))
+	for _, name := range []string{"disk.qcow2", "disk.qcow2.sha256"} {
+		require.NoError(t, os.WriteFile(filepath.Join(qcow2Dir, name), []byte("data"), 0644))
+	}
+
+	require.NoError(t, main.ChownTree(qcow2Dir, 1234, 5678))
+	for _, p := range []string{qcow2Dir, filepath.Join(qcow2Dir, "disk.qcow2"), filepath.Join(qcow2Dir, "disk.qcow2.sha256")} {
+		info, err := os.Lstat(p)
+		require.NoError(t, err)
+		st := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(1234), st.Uid, p)
+		assert.Equal(t, uint32(5678), st.Gid, p)
+	}
+}
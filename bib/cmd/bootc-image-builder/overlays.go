@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/osbuild/images/pkg/container"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// Overlay copies paths from a supplementary container image into the
+// deployment, e.g. binaries that are built in a separate container.
+type Overlay struct {
+	// Image is the container image to copy from
+	Image string        `json:"image"`
+	Paths []OverlayPath `json:"paths"`
+}
+
+type OverlayPath struct {
+	// Source is the path in the overlay image
+	Source string `json:"source"`
+	// Destination is the path in the deployment, it defaults to
+	// Source
+	Destination string `json:"destination,omitempty"`
+}
+
+// overlayAllowedRoots are the directories that overlay content can be
+// copied to, the rest of /usr is read-only in the deployment and /var
+// belongs to the running system
+var overlayAllowedRoots = []string{"/etc", "/usr/local"}
+
+func (p *OverlayPath) destination() string {
+	if p.Destination == "" {
+		return p.Source
+	}
+	return p.Destination
+}
+
+// deploymentPath returns the path that the destination is written to.
+// On ostree systems /usr/local is a symlink to /var/usrlocal, the
+// symlink is resolved as the target does not exist before the first
+// boot.
+func (p *OverlayPath) deploymentPath() string {
+	dst := p.destination()
+	if dst == "/usr/local" || strings.HasPrefix(dst, "/usr/local/") {
+		return "/var/usrlocal" + strings.TrimPrefix(dst, "/usr/local")
+	}
+	return dst
+}
+
+func isCleanAbsPath(path string) bool {
+	return filepath.IsAbs(path) && filepath.Clean(path) == path
+}
+
+func validateOverlays(overlays []Overlay) error {
+	for _, overlay := range overlays {
+		if overlay.Image == "" {
+			return fmt.Errorf("overlay image must not be empty")
+		}
+		if len(overlay.Paths) == 0 {
+			return fmt.Errorf("overlay %q has no paths", overlay.Image)
+		}
+		for _, p := range overlay.Paths {
+			if !isCleanAbsPath(p.Source) || p.Source == "/" {
+				return fmt.Errorf("overlay source %q of %q must be a clean absolute path below /", p.Source, overlay.Image)
+			}
+			dst := p.destination()
+			if !isCleanAbsPath(dst) {
+				return fmt.Errorf("overlay destination %q must be a clean absolute path", dst)
+			}
+			allowed := false
+			for _, root := range overlayAllowedRoots {
+				if strings.HasPrefix(dst, root+"/") {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return fmt.Errorf("overlay destination %q is not allowed, it must be below %s", dst, strings.Join(overlayAllowedRoots, " or "))
+			}
+		}
+	}
+	return nil
+}
+
+func overlayPipelineName(idx int) string {
+	return fmt.Sprintf("overlay-%d", idx)
+}
+
+// overlaySourceSpecs returns the container sources of the overlay
+// images.
+func overlaySourceSpecs(overlays []Overlay, tlsVerify bool) []container.SourceSpec {
+	var specs []container.SourceSpec
+	for _, overlay := range overlays {
+		specs = append(specs, container.SourceSpec{
+			Source:    overlay.Image,
+			Name:      overlay.Image,
+			TLSVerify: &tlsVerify,
+		})
+	}
+	return specs
+}
+
+// addOverlays extracts every overlay image (resolved to containers, in
+// the same order) in its own pipeline and copies the paths from there
+// into the deployment, before it is relabeled.
+//
+// TODO: osbuild/images cannot add content from other containers to
+// the bootc disk image
+func addOverlays(m *serializedManifest, overlays []Overlay, containers []container.Spec) error {
+	if len(overlays) != len(containers) {
+		return fmt.Errorf("cannot add overlays: got %d containers for %d overlays", len(containers), len(overlays))
+	}
+
+	err := m.updateSource("org.osbuild.skopeo", func(source json.RawMessage) (json.RawMessage, error) {
+		skopeo := osbuild.NewSkopeoSource()
+		if source != nil {
+			if err := json.Unmarshal(source, skopeo); err != nil {
+				return nil, err
+			}
+		}
+		for _, c := range containers {
+			skopeo.AddItem(c.Source, c.Digest, c.ImageID, c.TLSVerify, c.ContainersTransport, c.StoragePath)
+		}
+		return json.Marshal(skopeo)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add overlays: %w", err)
+	}
+
+	for idx, c := range containers {
+		stage, err := osbuild.NewContainerDeployStage(osbuild.NewContainersInputForSources([]container.Spec{c}), &osbuild.ContainerDeployOptions{Exclude: []string{}})
+		if err != nil {
+			return err
+		}
+		pipeline := &osbuild.Pipeline{
+			Name:  overlayPipelineName(idx),
+			Build: "name:build",
+		}
+		pipeline.AddStage(stage)
+		if err := m.appendPipeline(pipeline); err != nil {
+			return err
+		}
+	}
+
+	err = m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(options json.RawMessage) ([]*osbuild.Stage, error) {
+		var selinuxOpts osbuild.OSTreeSelinuxStageOptions
+		if err := json.Unmarshal(options, &selinuxOpts); err != nil {
+			return nil, err
+		}
+		deployment := selinuxOpts.Deployment
+
+		var stages []*osbuild.Stage
+		for idx, overlay := range overlays {
+			var mkdirPaths []osbuild.MkdirStagePath
+			var copyPaths []osbuild.CopyStagePath
+			inputName := overlayPipelineName(idx)
+			for _, p := range overlay.Paths {
+				mkdirPaths = append(mkdirPaths, osbuild.MkdirStagePath{
+					Path:    filepath.Dir(p.deploymentPath()),
+					Parents: true,
+					ExistOk: true,
+				})
+				copyPaths = append(copyPaths, osbuild.CopyStagePath{
+					From: fmt.Sprintf("input://%s%s", inputName, p.Source),
+					To:   "tree://" + p.deploymentPath(),
+				})
+			}
+			mkdir := osbuild.NewMkdirStage(&osbuild.MkdirStageOptions{Paths: mkdirPaths})
+			mkdir.MountOSTree(deployment.OSName, deployment.Ref, 0)
+			cp := osbuild.NewCopyStageSimple(&osbuild.CopyStageOptions{Paths: copyPaths}, osbuild.NewPipelineTreeInputs(inputName, inputName))
+			cp.MountOSTree(deployment.OSName, deployment.Ref, 0)
+			stages = append(stages, mkdir, cp)
+		}
+		return stages, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add overlays: %w", err)
+	}
+	return nil
+}
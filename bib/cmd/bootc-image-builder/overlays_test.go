@@ -0,0 +1,139 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+var testOverlayContainerSpec = container.Spec{
+	Source:    "quay.io/example/tools",
+	Digest:    "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+	ImageID:   "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+	LocalName: "quay.io/example/tools:latest",
+}
+
+func getOverlaysConfig(overlays []main.Overlay) *main.ManifestConfig {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Overlays: overlays,
+	}
+	return config
+}
+
+func TestManifestOverlays(t *testing.T) {
+	overlays := []main.Overlay{
+		{
+			Image: "quay.io/example/tools:latest",
+			Paths: []main.OverlayPath{
+				{Source: "/usr/bin/tool", Destination: "/usr/local/bin/tool"},
+				{Source: "/etc/tool.d"},
+			},
+		},
+	}
+	config := getOverlaysConfig(overlays)
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddOverlays(manifestJson, overlays, []container.Spec{testOverlayContainerSpec})
+	require.NoError(t, err)
+
+	// the overlay image is extracted in its own pipeline
+	deployStages, err := findStages(manifestJson, "overlay-0", "org.osbuild.container-deploy")
+	require.NoError(t, err)
+	require.Len(t, deployStages, 1)
+
+	// and fetched with the bootc image
+	var sources struct {
+		Sources struct {
+			Skopeo struct {
+				Items map[string]struct {
+					Image struct {
+						Name   string `json:"name"`
+						Digest string `json:"digest"`
+					} `json:"image"`
+				} `json:"items"`
+			} `json:"org.osbuild.skopeo"`
+		} `json:"sources"`
+	}
+	require.NoError(t, json.Unmarshal(manifestJson, &sources))
+	assert.Len(t, sources.Sources.Skopeo.Items, 2)
+	item := sources.Sources.Skopeo.Items[testOverlayContainerSpec.ImageID]
+	assert.Equal(t, "quay.io/example/tools", item.Image.Name)
+	assert.Equal(t, testOverlayContainerSpec.Digest, item.Image.Digest)
+
+	// the content is copied into the deployment before it is relabeled
+	var mfs testManifest
+	require.NoError(t, json.Unmarshal(manifestJson, &mfs))
+	var stageTypes []string
+	var copyOptions json.RawMessage
+	for _, pl := range mfs.Pipelines {
+		if pl.Name != "ostree-deployment" {
+			continue
+		}
+		for _, st := range pl.Stages {
+			stageTypes = append(stageTypes, st.Type)
+			if st.Type == "org.osbuild.copy" {
+				var opts struct {
+					Paths []struct {
+						From string `json:"from"`
+					} `json:"paths"`
+				}
+				require.NoError(t, json.Unmarshal(st.Options, &opts))
+				if len(opts.Paths) > 0 && opts.Paths[0].From == "input://overlay-0/usr/bin/tool" {
+					copyOptions = st.Options
+				}
+			}
+		}
+	}
+	require.GreaterOrEqual(t, len(stageTypes), 3)
+	assert.Equal(t, []string{"org.osbuild.mkdir", "org.osbuild.copy", "org.osbuild.ostree.selinux"}, stageTypes[len(stageTypes)-3:])
+	assert.JSONEq(t, `{
+		"paths": [
+			{"from": "input://overlay-0/usr/bin/tool", "to": "tree:///var/usrlocal/bin/tool"},
+			{"from": "input://overlay-0/etc/tool.d", "to": "tree:///etc/tool.d"}
+		]
+	}`, string(copyOptions))
+}
+
+func TestManifestOverlaysErrors(t *testing.T) {
+	for _, tc := range []struct {
+		overlay main.Overlay
+		expErr  string
+	}{
+		{
+			main.Overlay{Paths: []main.OverlayPath{{Source: "/etc/foo"}}},
+			"overlay image must not be empty",
+		},
+		{
+			main.Overlay{Image: "quay.io/example/tools"},
+			`overlay "quay.io/example/tools" has no paths`,
+		},
+		{
+			main.Overlay{Image: "quay.io/example/tools", Paths: []main.OverlayPath{{Source: "etc/foo"}}},
+			`overlay source "etc/foo" of "quay.io/example/tools" must be a clean absolute path below /`,
+		},
+		{
+			main.Overlay{Image: "quay.io/example/tools", Paths: []main.OverlayPath{{Source: "/usr/bin/tool"}}},
+			`overlay destination "/usr/bin/tool" is not allowed, it must be below /etc or /usr/local`,
+		},
+		{
+			main.Overlay{Image: "quay.io/example/tools", Paths: []main.OverlayPath{{Source: "/tool", Destination: "/usr/local/../bin/tool"}}},
+			`overlay destination "/usr/local/../bin/tool" must be a clean absolute path`,
+		},
+		{
+			main.Overlay{Image: "quay.io/example/tools", Paths: []main.OverlayPath{{Source: "/tool", Destination: "/usr/local"}}},
+			`overlay destination "/usr/local" is not allowed, it must be below /etc or /usr/local`,
+		},
+	} {
+		_, err := main.Manifest(getOverlaysConfig([]main.Overlay{tc.overlay}))
+		assert.EqualError(t, err, tc.expErr)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/osbuild/images/pkg/disk"
+)
+
+// PartitionLayout is the partition table of a disk image as written
+// by --export-partition-table. All offsets and sizes are in bytes.
+//
+// UUIDs are not part of the layout, they are generated randomly for
+// every build.
+type PartitionLayout struct {
+	Type       string           `json:"type"`
+	Size       uint64           `json:"size"`
+	Partitions []PartitionEntry `json:"partitions"`
+}
+
+type PartitionEntry struct {
+	Start      uint64               `json:"start"`
+	Size       uint64               `json:"size"`
+	Type       string               `json:"type,omitempty"`
+	Bootable   bool                 `json:"bootable,omitempty"`
+	Filesystem *PartitionFilesystem `json:"filesystem,omitempty"`
+}
+
+type PartitionFilesystem struct {
+	Type         string `json:"type"`
+	Mountpoint   string `json:"mountpoint,omitempty"`
+	Label        string `json:"label,omitempty"`
+	FSTabOptions string `json:"fstab_options,omitempty"`
+}
+
+// genPartitionLayout creates the manifest of the disk image described
+// by the given config and returns the layout of its partition table.
+// Nothing is resolved, the manifest is not serialized.
+func genPartitionLayout(c *ManifestConfig) (*PartitionLayout, error) {
+	c.PartitionTable = nil
+	if _, err := Manifest(c); err != nil {
+		return nil, err
+	}
+	pt := c.PartitionTable
+	if pt == nil {
+		return nil, fmt.Errorf("cannot export the partition table of image type %q, only disk images have one", c.ImgType)
+	}
+
+	layout := &PartitionLayout{
+		Type: pt.Type,
+		Size: pt.Size,
+	}
+	for _, part := range pt.Partitions {
+		entry := PartitionEntry{
+			Start:    part.Start,
+			Size:     part.Size,
+			Type:     part.Type,
+			Bootable: part.Bootable,
+		}
+		if fs, ok := part.Payload.(*disk.Filesystem); ok {
+			entry.Filesystem = &PartitionFilesystem{
+				Type:         fs.Type,
+				Mountpoint:   fs.Mountpoint,
+				Label:        fs.Label,
+				FSTabOptions: fs.FSTabOptions,
+			}
+		}
+		layout.Partitions = append(layout.Partitions, entry)
+	}
+	return layout, nil
+}
+
+// exportPartitionLayout writes the partition layout of the disk image
+// described by the given config as JSON to path.
+func exportPartitionLayout(c *ManifestConfig, path string) error {
+	layout, err := genPartitionLayout(c)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(layout, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
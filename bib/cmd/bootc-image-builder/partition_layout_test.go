@@ -0,0 +1,130 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestGenPartitionLayoutSeparateVar(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "raw"
+	config.Architecture = arch.ARCH_X86_64
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Filesystem: []blueprint.FilesystemCustomization{
+					{Mountpoint: "/var", MinSize: 5 * main.GibiByte},
+				},
+			},
+		},
+	}
+	layout, err := main.GenPartitionLayout(config)
+	require.NoError(t, err)
+
+	expected := &main.PartitionLayout{
+		Type: "gpt",
+		Size: 10 * main.GibiByte,
+		Partitions: []main.PartitionEntry{
+			{
+				Start:    1 * main.MebiByte,
+				Size:     1 * main.MebiByte,
+				Type:     "21686148-6449-6E6F-744E-656564454649",
+				Bootable: true,
+			},
+			{
+				Start: 2 * main.MebiByte,
+				Size:  501 * main.MebiByte,
+				Type:  "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+				Filesystem: &main.PartitionFilesystem{
+					Type:         "vfat",
+					Mountpoint:   "/boot/efi",
+					Label:        "EFI-SYSTEM",
+					FSTabOptions: "umask=0077,shortname=winnt",
+				},
+			},
+			{
+				Start: 503 * main.MebiByte,
+				Size:  1 * main.GibiByte,
+				Type:  "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+				Filesystem: &main.PartitionFilesystem{
+					Type:         "ext4",
+					Mountpoint:   "/boot",
+					Label:        "boot",
+					FSTabOptions: "ro",
+				},
+			},
+			{
+				// the root partition is grown to fill the disk up to
+				// the 33 sectors of the secondary GPT header
+				Start: 1527*main.MebiByte + 5*main.GibiByte,
+				Size:  10*main.GibiByte - 1527*main.MebiByte - 5*main.GibiByte - 33*512,
+				Type:  "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+				Filesystem: &main.PartitionFilesystem{
+					Type:         "ext4",
+					Mountpoint:   "/",
+					Label:        "root",
+					FSTabOptions: "defaults",
+				},
+			},
+			{
+				Start: 1527 * main.MebiByte,
+				Size:  5 * main.GibiByte,
+				Type:  "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+				Filesystem: &main.PartitionFilesystem{
+					Type:         "xfs",
+					Mountpoint:   "/var",
+					FSTabOptions: "defaults",
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, layout)
+}
+
+func TestGenPartitionLayoutErrors(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	_, err := main.GenPartitionLayout(config)
+	assert.EqualError(t, err, `cannot export the partition table of image type "iso", only disk images have one`)
+
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Filesystem: []blueprint.FilesystemCustomization{
+					{Mountpoint: "/var/home", MinSize: main.GibiByte},
+				},
+			},
+		},
+	}
+	_, err = main.GenPartitionLayout(config)
+	assert.EqualError(t, err, `The following custom mountpoints are not supported ["/var/home"]`)
+}
+
+func TestExportPartitionTableCLI(t *testing.T) {
+	ptPath := filepath.Join(t.TempDir(), "pt.json")
+	rootCmd, err := main.NewRootCmd()
+	require.NoError(t, err)
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	// the image does not exist, nothing must be resolved
+	rootCmd.SetArgs([]string{"manifest", "--type", "raw", "--export-partition-table", ptPath, "testempty"})
+	require.NoError(t, rootCmd.Execute())
+
+	data, err := os.ReadFile(ptPath)
+	require.NoError(t, err)
+	var layout main.PartitionLayout
+	require.NoError(t, json.Unmarshal(data, &layout))
+	assert.Equal(t, "gpt", layout.Type)
+	assert.Equal(t, uint64(10*main.GibiByte), layout.Size)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/images/pkg/disk"
+)
+
+// PartitionUUIDs sets the GUIDs of the GPT partition table of disk
+// images
+type PartitionUUIDs struct {
+	// Disk is the GUID of the partition table
+	Disk string `json:"disk,omitempty"`
+	// Partitions maps the mountpoints of the partitions to their GUIDs
+	Partitions map[string]string `json:"partitions,omitempty"`
+}
+
+// partUUIDNamespace is the namespace of the partition GUIDs that are
+// derived from SOURCE_DATE_EPOCH
+var partUUIDNamespace = uuid.MustParse("5d3c0d5e-53a6-4a6c-9a4b-4f3c56e0f0a1")
+
+// parseGUID returns the GUID in the uppercase form of the partition
+// tables, the lowercase form is accepted too
+func parseGUID(guid string) (string, error) {
+	parsed, err := uuid.Parse(guid)
+	if err != nil || len(guid) != 36 {
+		return "", fmt.Errorf("invalid GUID %q, expected the form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", guid)
+	}
+	if parsed == uuid.Nil {
+		return "", fmt.Errorf("GUID must not be the nil GUID")
+	}
+	return strings.ToUpper(parsed.String()), nil
+}
+
+// partitionMountpoint returns the mountpoint of the filesystem of the
+// partition, partitions without one (e.g. the BIOS boot partition)
+// return ""
+func partitionMountpoint(part *disk.Partition) string {
+	if mnt, ok := part.Payload.(disk.Mountable); ok {
+		return mnt.GetMountpoint()
+	}
+	return ""
+}
+
+// applyPartitionUUIDs sets the configured GUIDs of the partition
+// table. With a SOURCE_DATE_EPOCH the partitions that would get a
+// random GUID, i.e. the ones that are not in the base partition table,
+// get one that is derived from it and the mountpoint instead, so
+// rebuilds have the same GUIDs. Partitions without a mountpoint use
+// their index in the partition table instead.
+func applyPartitionUUIDs(pt *disk.PartitionTable, basept *disk.PartitionTable, uuids *PartitionUUIDs, epoch string) error {
+	if pt.Type != "gpt" {
+		if uuids != nil {
+			return fmt.Errorf("partition_uuids are only supported for GPT partition tables")
+		}
+		return nil
+	}
+
+	if epoch != "" {
+		fixed := make(map[string]bool, len(basept.Partitions))
+		for _, part := range basept.Partitions {
+			fixed[part.UUID] = true
+		}
+		for idx := range pt.Partitions {
+			part := &pt.Partitions[idx]
+			if fixed[part.UUID] {
+				continue
+			}
+			mountpoint := partitionMountpoint(part)
+			name := fmt.Sprintf("%s:%s", epoch, mountpoint)
+			if mountpoint == "" {
+				name = fmt.Sprintf("%s:#%d", epoch, idx)
+			}
+			part.UUID = strings.ToUpper(uuid.NewSHA1(partUUIDNamespace, []byte(name)).String())
+		}
+	}
+
+	if uuids == nil {
+		return nil
+	}
+	if uuids.Disk != "" {
+		guid, err := parseGUID(uuids.Disk)
+		if err != nil {
+			return fmt.Errorf("cannot set the disk GUID: %w", err)
+		}
+		pt.UUID = guid
+	}
+	for mountpoint, guid := range uuids.Partitions {
+		guid, err := parseGUID(guid)
+		if err != nil {
+			return fmt.Errorf("cannot set the GUID of the partition of %q: %w", mountpoint, err)
+		}
+		found := false
+		for idx := range pt.Partitions {
+			if partitionMountpoint(&pt.Partitions[idx]) == mountpoint {
+				pt.Partitions[idx].UUID = guid
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("cannot set the GUID of the partition of %q: no partition with this mountpoint", mountpoint)
+		}
+	}
+
+	seen := map[string]string{pt.UUID: "the disk"}
+	for idx := range pt.Partitions {
+		part := &pt.Partitions[idx]
+		what := fmt.Sprintf("the partition of %q", partitionMountpoint(part))
+		if other, ok := seen[part.UUID]; ok {
+			return fmt.Errorf("GUID %s is used by both %s and %s", part.UUID, other, what)
+		}
+		seen[part.UUID] = what
+	}
+	return nil
+}
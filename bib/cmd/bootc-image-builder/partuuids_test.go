@@ -0,0 +1,131 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/osbuild/images/pkg/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+type sfdiskOptions struct {
+	UUID       string `json:"uuid"`
+	Partitions []struct {
+		UUID string `json:"uuid"`
+	} `json:"partitions"`
+}
+
+func getPartUUIDsConfig(imgArch arch.Arch, uuids *main.PartitionUUIDs) *main.ManifestConfig {
+	config := getBaseConfig()
+	config.ImgType = "raw"
+	config.Architecture = imgArch
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Filesystem: []blueprint.FilesystemCustomization{
+					{Mountpoint: "/var/log", MinSize: main.GibiByte},
+				},
+			},
+		},
+		PartitionUUIDs: uuids,
+	}
+	return config
+}
+
+func manifestSfdiskOptions(t *testing.T, config *main.ManifestConfig) sfdiskOptions {
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	stages, err := findStages(manifestJson, "image", "org.osbuild.sfdisk")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var opts sfdiskOptions
+	require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+	return opts
+}
+
+func TestManifestPartitionUUIDs(t *testing.T) {
+	varLogGUID := "0f5c3e1a-2b7d-4c8e-9f10-a1b2c3d4e5f6"
+	config := getPartUUIDsConfig(arch.ARCH_X86_64, &main.PartitionUUIDs{
+		Disk: "8D2C6E5A-0B1F-4E3C-9A7D-5F6E7D8C9B0A",
+		Partitions: map[string]string{
+			"/var/log": varLogGUID,
+		},
+	})
+	opts := manifestSfdiskOptions(t, config)
+	assert.Equal(t, "8D2C6E5A-0B1F-4E3C-9A7D-5F6E7D8C9B0A", opts.UUID)
+	var guids []string
+	for _, part := range opts.Partitions {
+		guids = append(guids, part.UUID)
+	}
+	assert.Contains(t, guids, "0F5C3E1A-2B7D-4C8E-9F10-A1B2C3D4E5F6")
+}
+
+func TestManifestPartitionUUIDsSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	first := manifestSfdiskOptions(t, getPartUUIDsConfig(arch.ARCH_X86_64, nil))
+	second := manifestSfdiskOptions(t, getPartUUIDsConfig(arch.ARCH_X86_64, nil))
+	assert.Equal(t, first, second)
+
+	// a different epoch gives the /var/log partition a different GUID
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000001")
+	third := manifestSfdiskOptions(t, getPartUUIDsConfig(arch.ARCH_X86_64, nil))
+	assert.Equal(t, first.UUID, third.UUID)
+	assert.NotEqual(t, first.Partitions, third.Partitions)
+}
+
+func TestApplyPartitionUUIDsUnmounted(t *testing.T) {
+	basept := &disk.PartitionTable{Type: "gpt"}
+	pt := &disk.PartitionTable{
+		Type: "gpt",
+		Partitions: []disk.Partition{
+			{Size: main.MebiByte, Type: disk.BIOSBootPartitionGUID},
+			{Size: main.GibiByte, Type: disk.FilesystemDataGUID},
+			{Size: main.GibiByte, Type: disk.FilesystemDataGUID},
+			{Size: main.GibiByte, Type: disk.FilesystemDataGUID, Payload: &disk.Filesystem{Type: "xfs", Mountpoint: "/"}},
+		},
+	}
+	require.NoError(t, main.ApplyPartitionUUIDs(pt, basept, nil, "1700000000"))
+
+	// the partitions without a mountpoint get GUIDs of their own
+	seen := map[string]bool{}
+	for _, part := range pt.Partitions {
+		assert.NotEmpty(t, part.UUID)
+		assert.False(t, seen[part.UUID], "duplicate GUID %s", part.UUID)
+		seen[part.UUID] = true
+	}
+}
+
+func TestManifestPartitionUUIDsErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		arch  arch.Arch
+		uuids main.PartitionUUIDs
+		err   string
+	}{
+		"bad-disk":     {arch.ARCH_X86_64, main.PartitionUUIDs{Disk: "not-a-guid"}, `cannot set the disk GUID: invalid GUID "not-a-guid", expected the form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx`},
+		"nil-disk":     {arch.ARCH_X86_64, main.PartitionUUIDs{Disk: "00000000-0000-0000-0000-000000000000"}, "cannot set the disk GUID: GUID must not be the nil GUID"},
+		"braces":       {arch.ARCH_X86_64, main.PartitionUUIDs{Partitions: map[string]string{"/": "{0f5c3e1a-2b7d-4c8e-9f10-a1b2c3d4e5f6}"}}, `cannot set the GUID of the partition of "/": invalid GUID "{0f5c3e1a-2b7d-4c8e-9f10-a1b2c3d4e5f6}", expected the form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx`},
+		"no-partition": {arch.ARCH_X86_64, main.PartitionUUIDs{Partitions: map[string]string{"/srv": "0f5c3e1a-2b7d-4c8e-9f10-a1b2c3d4e5f6"}}, `cannot set the GUID of the partition of "/srv": no partition with this mountpoint`},
+		"duplicate":    {arch.ARCH_X86_64, main.PartitionUUIDs{Disk: "0f5c3e1a-2b7d-4c8e-9f10-a1b2c3d4e5f6", Partitions: map[string]string{"/": "0F5C3E1A-2B7D-4C8E-9F10-A1B2C3D4E5F6"}}, `GUID 0F5C3E1A-2B7D-4C8E-9F10-A1B2C3D4E5F6 is used by both the disk and the partition of "/"`},
+		"mbr":          {arch.ARCH_AARCH64, main.PartitionUUIDs{Disk: "0f5c3e1a-2b7d-4c8e-9f10-a1b2c3d4e5f6"}, "partition_uuids are only supported for GPT partition tables"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := main.Manifest(getPartUUIDsConfig(tc.arch, &tc.uuids))
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestManifestPartitionUUIDsISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{PartitionUUIDs: &main.PartitionUUIDs{Disk: "0f5c3e1a-2b7d-4c8e-9f10-a1b2c3d4e5f6"}}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "partition_uuids not supported for the iso image type")
+}
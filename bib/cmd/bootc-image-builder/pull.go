@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/container"
+)
+
+// containersSourceCache is the directory of the osbuild store that the
+// org.osbuild.skopeo source downloads container images to, each image
+// is stored in the "dir:" format in <image id>/image
+const containersSourceCache = "sources/org.osbuild.containers"
+
+var (
+	pullResolve = func(c *ManifestConfig, a arch.Arch) (container.Spec, error) {
+		resolver := container.NewResolver(a.String())
+		resolver.Add(c.containerSource())
+		specs, err := resolver.Finish()
+		if err != nil {
+			return container.Spec{}, err
+		}
+		return specs[0], nil
+	}
+	pullFetch = fetchContainer
+)
+
+// pullArchs returns the architectures that a build resolves the image
+// for, the build pipeline runs the image of the host architecture
+func pullArchs(targetArch arch.Arch) []arch.Arch {
+	archs := []arch.Arch{targetArch}
+	if hostArch := arch.Current(); hostArch != targetArch {
+		archs = append(archs, hostArch)
+	}
+	return archs
+}
+
+// fetchContainer copies the image with the given digest into the
+// given directory in the "dir:" format, the way the skopeo source of
+// osbuild does
+func fetchContainer(spec container.Spec, dest string) error {
+	named, err := reference.ParseNormalizedNamed(spec.Source)
+	if err != nil {
+		return err
+	}
+	canonical, err := reference.WithDigest(reference.TrimNamed(named), digest.Digest(spec.Digest))
+	if err != nil {
+		return err
+	}
+	srcRef, err := docker.NewReference(canonical)
+	if err != nil {
+		return err
+	}
+	destRef, err := directory.NewReference(dest)
+	if err != nil {
+		return err
+	}
+
+	// the signature is checked by "verify", the store only caches
+	policyCtx, err := signature.NewPolicyContext(&signature.Policy{
+		Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()},
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = policyCtx.Destroy() }()
+
+	tlsVerify := spec.TLSVerify == nil || *spec.TLSVerify
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.NewOptionalBool(!tlsVerify),
+	}
+	_, err = copy.Image(context.Background(), policyCtx, destRef, srcRef, &copy.Options{
+		SourceCtx:        sys,
+		RemoveSignatures: true,
+	})
+	return err
+}
+
+// pullImage resolves the image of the given config the way a build
+// does and downloads it into the container cache of the osbuild store,
+// so a later build with the same store does not download it again.
+// Images that are in the cache already are not downloaded.
+func pullImage(w io.Writer, c *ManifestConfig, storeDir string) error {
+	for _, a := range pullArchs(c.Architecture) {
+		spec, err := pullResolve(c, a)
+		if err != nil {
+			return fmt.Errorf("cannot resolve %s: %w", c.Imgref, err)
+		}
+
+		cacheDir := filepath.Join(storeDir, containersSourceCache)
+		imageDir := filepath.Join(cacheDir, spec.ImageID)
+		if _, err := os.Stat(imageDir); err == nil {
+			fmt.Fprintf(w, "%s@%s is in the store already\n", c.Imgref, spec.Digest)
+			continue
+		}
+
+		// downloaded next to the cache and renamed once complete so
+		// that an interrupted pull leaves nothing behind that osbuild
+		// would take for the image
+		if err := os.MkdirAll(cacheDir, 0755); err != nil {
+			return err
+		}
+		tmpDir, err := os.MkdirTemp(cacheDir, "tmp-download-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+		if err := os.Chmod(tmpDir, 0755); err != nil {
+			return err
+		}
+		if err := pullFetch(spec, filepath.Join(tmpDir, "image")); err != nil {
+			return fmt.Errorf("cannot pull %s: %w", c.Imgref, err)
+		}
+		if err := os.Rename(tmpDir, imageDir); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s@%s\n", c.Imgref, spec.Digest)
+	}
+	return nil
+}
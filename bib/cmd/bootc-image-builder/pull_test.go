@@ -0,0 +1,91 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+const testImageID = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+
+type pullCalls struct {
+	resolved []*main.ManifestConfig
+	fetched  []string
+}
+
+func mockPull(t *testing.T, fetchErr error) *pullCalls {
+	calls := &pullCalls{}
+	restore := main.MockPullResolve(func(c *main.ManifestConfig, a arch.Arch) (container.Spec, error) {
+		calls.resolved = append(calls.resolved, c)
+		return container.Spec{Source: c.Imgref, Digest: testDigest, ImageID: testImageID}, nil
+	})
+	t.Cleanup(restore)
+	restore = main.MockPullFetch(func(spec container.Spec, dest string) error {
+		calls.fetched = append(calls.fetched, dest)
+		if fetchErr != nil {
+			return fetchErr
+		}
+		require.NoError(t, os.MkdirAll(dest, 0755))
+		return os.WriteFile(filepath.Join(dest, "manifest.json"), []byte("{}"), 0644)
+	})
+	t.Cleanup(restore)
+	return calls
+}
+
+func runPullCmd(t *testing.T, args ...string) (string, error) {
+	rootCmd, err := main.NewRootCmd()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	rootCmd.SetErr(&bytes.Buffer{})
+	rootCmd.SetArgs(append([]string{"pull", "--target-arch", arch.Current().String()}, args...))
+	err = rootCmd.Execute()
+	return buf.String(), err
+}
+
+func TestPull(t *testing.T) {
+	calls := mockPull(t, nil)
+	store := t.TempDir()
+
+	out, err := runPullCmd(t, "--store", store, "--tls-verify=false", "quay.io/example/example:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "quay.io/example/example:latest@"+testDigest+"\n", out)
+	require.Len(t, calls.resolved, 1)
+	assert.Equal(t, "quay.io/example/example:latest", calls.resolved[0].Imgref)
+	assert.False(t, calls.resolved[0].TLSVerify)
+	require.Len(t, calls.fetched, 1)
+
+	// the image is where the osbuild skopeo source looks for it and
+	// nothing else is left in the cache
+	assert.FileExists(t, filepath.Join(store, "sources/org.osbuild.containers", testImageID, "image/manifest.json"))
+	entries, err := os.ReadDir(filepath.Join(store, "sources/org.osbuild.containers"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// a second pull only resolves
+	out, err = runPullCmd(t, "--store", store, "quay.io/example/example:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "quay.io/example/example:latest@"+testDigest+" is in the store already\n", out)
+	assert.Len(t, calls.resolved, 2)
+	assert.Len(t, calls.fetched, 1)
+}
+
+func TestPullFetchError(t *testing.T) {
+	mockPull(t, fmt.Errorf("connection refused"))
+	store := t.TempDir()
+
+	_, err := runPullCmd(t, "--store", store, "quay.io/example/example:latest")
+	assert.EqualError(t, err, "cannot pull quay.io/example/example:latest: connection refused")
+	entries, err := os.ReadDir(filepath.Join(store, "sources/org.osbuild.containers"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
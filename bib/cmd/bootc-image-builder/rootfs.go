@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/images/pkg/disk"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// the label ends up in /dev/disk/by-label/ so keep it to characters
+// that need no escaping
+var rootfsLabelRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// applyRootfsCustomizations sets the label and the UUID of the root
+// filesystem that the mkfs stage uses, the UUID also ends up in the
+// fstab.
+func applyRootfsCustomizations(pt *disk.PartitionTable, label, fsUUID string) error {
+	if label == "" && fsUUID == "" {
+		return nil
+	}
+	rootfs, ok := pt.FindMountable("/").(*disk.Filesystem)
+	if !ok {
+		return fmt.Errorf("cannot customize the root filesystem: no root filesystem in the partition table")
+	}
+
+	if label != "" {
+		maxLen, ok := filesystemLabelMaxLen[rootfs.Type]
+		if !ok {
+			return fmt.Errorf("cannot set the label of a %s root filesystem", rootfs.Type)
+		}
+		if len(label) > maxLen {
+			return fmt.Errorf("root filesystem label %q is too long for %s, the maximum is %d characters", label, rootfs.Type, maxLen)
+		}
+		if !rootfsLabelRegex.MatchString(label) {
+			return fmt.Errorf("root filesystem label %q must only contain ASCII letters, digits, '-', '.' and '_'", label)
+		}
+		rootfs.Label = label
+	}
+
+	if fsUUID != "" {
+		parsed, err := uuid.Parse(fsUUID)
+		if err != nil || parsed.String() != fsUUID {
+			return fmt.Errorf("invalid root filesystem UUID %q, expected the lowercase form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", fsUUID)
+		}
+		if parsed == uuid.Nil {
+			return fmt.Errorf("root filesystem UUID must not be the nil UUID")
+		}
+		rootfs.UUID = fsUUID
+	}
+	return nil
+}
+
+// setDeploymentRootfsLabel changes the label that the deployment uses
+// to find the root filesystem (root=LABEL=...) to the given one.
+//
+// TODO: osbuild/images always uses the "root" label
+func setDeploymentRootfsLabel(m *serializedManifest, label string) error {
+	return m.updateStageOptions("ostree-deployment", "org.osbuild.ostree.deploy.container", func(options json.RawMessage) (json.RawMessage, error) {
+		var opts map[string]json.RawMessage
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+		rootfs, err := json.Marshal(osbuild.Rootfs{Label: label})
+		if err != nil {
+			return nil, err
+		}
+		opts["rootfs"] = rootfs
+		return json.Marshal(opts)
+	})
+}
@@ -0,0 +1,94 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestRootfsLabelUUID(t *testing.T) {
+	rootUUID := "6e4ff95f-f662-45ee-a82a-bdf44a2d0b75"
+	config := getBaseConfig()
+	config.ImgType = "raw"
+	config.Architecture = arch.ARCH_X86_64
+	config.Config = &main.BuildConfig{
+		RootfsLabel: "appliance-root",
+		RootfsUUID:  rootUUID,
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	manifestJson, err = main.SetDeploymentRootfsLabel(manifestJson, "appliance-root")
+	require.NoError(t, err)
+
+	stages, err := findStages(manifestJson, "image", "org.osbuild.mkfs.ext4")
+	require.NoError(t, err)
+	labels := make(map[string]string)
+	for _, st := range stages {
+		var opts struct {
+			UUID  string `json:"uuid"`
+			Label string `json:"label"`
+		}
+		require.NoError(t, json.Unmarshal(st.Options, &opts))
+		labels[opts.Label] = opts.UUID
+	}
+	assert.Equal(t, rootUUID, labels["appliance-root"])
+	assert.Contains(t, labels, "boot")
+	assert.NotContains(t, labels, "root")
+
+	// the deployment finds the root filesystem by the new label
+	deployStages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.ostree.deploy.container")
+	require.NoError(t, err)
+	require.Len(t, deployStages, 1)
+	var deployOpts struct {
+		Rootfs map[string]string `json:"rootfs"`
+	}
+	require.NoError(t, json.Unmarshal(deployStages[0].Options, &deployOpts))
+	assert.Equal(t, map[string]string{"label": "appliance-root"}, deployOpts.Rootfs)
+
+	// and mounts it by UUID
+	fstabStages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.fstab")
+	require.NoError(t, err)
+	require.Len(t, fstabStages, 1)
+	assert.Contains(t, string(fstabStages[0].Options), rootUUID)
+}
+
+func TestManifestRootfsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		label, uuid string
+		expErr      string
+	}{
+		{"much-too-long-label", "", `root filesystem label "much-too-long-label" is too long for ext4, the maximum is 16 characters`},
+		{"my root", "", `root filesystem label "my root" must only contain ASCII letters, digits, '-', '.' and '_'`},
+		{"", "not-a-uuid", `invalid root filesystem UUID "not-a-uuid", expected the lowercase form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx`},
+		{"", "6E4FF95F-F662-45EE-A82A-BDF44A2D0B75", `invalid root filesystem UUID "6E4FF95F-F662-45EE-A82A-BDF44A2D0B75", expected the lowercase form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx`},
+		{"", "00000000-0000-0000-0000-000000000000", "root filesystem UUID must not be the nil UUID"},
+	} {
+		config := getBaseConfig()
+		config.ImgType = "qcow2"
+		config.Config = &main.BuildConfig{
+			RootfsLabel: tc.label,
+			RootfsUUID:  tc.uuid,
+		}
+		_, err := main.Manifest(config)
+		assert.EqualError(t, err, tc.expErr)
+	}
+}
+
+func TestManifestRootfsISO(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{
+		RootfsLabel: "root",
+		RootfsUUID:  "6e4ff95f-f662-45ee-a82a-bdf44a2d0b75",
+	}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, "rootfs_label, rootfs_uuid not supported for the iso image type")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+	"github.com/osbuild/images/pkg/customizations/users"
+)
+
+// sshd uses the first value it reads and includes the drop-ins in
+// lexical order, this one sorts before the ones of the distributions
+const rootSSHLoginDropInPath = "/etc/ssh/sshd_config.d/01-bootc-image-builder-root.conf"
+
+// rootSSHKeys returns the root user with the given authorized keys and
+// the sshd drop-in that allows root to log in with them. Password
+// logins of root are not allowed by the drop-in, so a locked or unset
+// root password keeps working as before.
+func rootSSHKeys(keys []string, bpUsers []users.User) (*users.User, *fsnode.File, error) {
+	for _, user := range bpUsers {
+		if user.Name == "root" {
+			return nil, nil, fmt.Errorf("root_ssh_keys cannot be combined with a root user in the blueprint, set the key of the root user there instead")
+		}
+	}
+	for _, key := range keys {
+		if strings.ContainsAny(key, "\n\r") {
+			return nil, nil, fmt.Errorf("invalid root ssh key %q, must be a single line", key)
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+			return nil, nil, fmt.Errorf("invalid root ssh key %q: %w", key, err)
+		}
+	}
+
+	// the users stage writes the keys to ~/.ssh/authorized_keys with
+	// the ownership and the modes that sshd requires
+	authorizedKeys := strings.Join(keys, "\n")
+	root := &users.User{Name: "root", Key: &authorizedKeys}
+
+	f, err := fsnode.NewFile(rootSSHLoginDropInPath, nil, nil, nil, []byte("PermitRootLogin prohibit-password\n"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, f, nil
+}
@@ -0,0 +1,89 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+const (
+	testRootKey1 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJdVgGfMXvM5z3mRh9QyD7r8u5dMYfOgTkAEr2kX3Qm1 provisioner@example.com"
+	testRootKey2 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBC1Jp1mVYsm3Fq8Yc0uXQ6H2f7f2mS6xWcR3hKp5vTb backup@example.com"
+)
+
+func TestManifestRootSSHKeys(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		RootSSHKeys: []string{testRootKey1, testRootKey2},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.users")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	var opts struct {
+		Users map[string]struct {
+			Key string `json:"key"`
+		} `json:"users"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+	assert.Equal(t, testRootKey1+"\n"+testRootKey2, opts.Users["root"].Key)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/ssh/sshd_config.d/01-bootc-image-builder-root.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "PermitRootLogin prohibit-password\n", content)
+}
+
+func TestManifestRootSSHKeysErrors(t *testing.T) {
+	rootKey := testRootKey1
+	for name, tc := range map[string]struct {
+		imgType string
+		config  *main.BuildConfig
+		err     string
+	}{
+		"bad-key": {
+			imgType: "qcow2",
+			config:  &main.BuildConfig{RootSSHKeys: []string{"ssh-ed25519 not-a-key"}},
+			err:     `invalid root ssh key "ssh-ed25519 not-a-key": ssh: no key found`,
+		},
+		"multiline": {
+			imgType: "qcow2",
+			config:  &main.BuildConfig{RootSSHKeys: []string{testRootKey1 + "\n" + testRootKey2}},
+			err:     `invalid root ssh key "` + testRootKey1 + `\n` + testRootKey2 + `", must be a single line`,
+		},
+		"blueprint-root": {
+			imgType: "qcow2",
+			config: &main.BuildConfig{
+				RootSSHKeys: []string{testRootKey1},
+				Blueprint: &blueprint.Blueprint{
+					Customizations: &blueprint.Customizations{
+						User: []blueprint.UserCustomization{{Name: "root", Key: &rootKey}},
+					},
+				},
+			},
+			err: "root_ssh_keys cannot be combined with a root user in the blueprint, set the key of the root user there instead",
+		},
+		"iso": {
+			imgType: "iso",
+			config:  &main.BuildConfig{RootSSHKeys: []string{testRootKey1}},
+			err:     "root_ssh_keys not supported for the iso image type",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = tc.config
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/osbuild/images/pkg/disk"
+)
+
+// sectorSizes are the supported logical sector sizes of disk images,
+// 4096 is for 4K native (4Kn) disks
+var sectorSizes = []uint64{disk.DefaultSectorSize, 4096}
+
+func validateSectorSize(size uint64) error {
+	for _, s := range sectorSizes {
+		if size == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported sector_size %d, valid values are %v", size, sectorSizes)
+}
+
+// setLoopbackSectorSize sets the sector size of all loopback devices of
+// the disk image pipeline. The partition table already counts the
+// start and size of the partitions in sectors of that size, the
+// loopback devices make the partitioning and mkfs stages see it as the
+// logical sector size of the disk.
+//
+// TODO: osbuild/images never sets the sector size of loopback devices
+func setLoopbackSectorSize(m *serializedManifest, size uint64) error {
+	found := false
+	for _, pl := range m.Pipelines {
+		if pl.Name != espDiskPipelineName {
+			continue
+		}
+		for _, st := range pl.Stages {
+			if st["devices"] == nil {
+				continue
+			}
+			var devices map[string]json.RawMessage
+			if err := json.Unmarshal(st["devices"], &devices); err != nil {
+				return err
+			}
+			for name, data := range devices {
+				var dev rawLoopbackDevice
+				if err := json.Unmarshal(data, &dev); err != nil {
+					return err
+				}
+				if dev.Type != "org.osbuild.loopback" {
+					continue
+				}
+				dev.Options.SectorSize = &size
+				var err error
+				if devices[name], err = json.Marshal(dev); err != nil {
+					return err
+				}
+				found = true
+			}
+			var err error
+			if st["devices"], err = json.Marshal(devices); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("cannot set sector size: no loopback device found in pipeline %q", espDiskPipelineName)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/manifest"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+type sectorTestPartition struct {
+	Start uint64 `json:"start"`
+	Size  uint64 `json:"size"`
+}
+
+// partitionByteOffsets returns the start and size in bytes of the
+// partitions of the sfdisk or sgdisk stage of the disk image
+func partitionByteOffsets(t *testing.T, mf manifest.OSBuildManifest, sectorSize uint64) []sectorTestPartition {
+	var stages []stage
+	for _, typ := range []string{"org.osbuild.sfdisk", "org.osbuild.sgdisk"} {
+		found, err := findStages(mf, "image", typ)
+		require.NoError(t, err)
+		stages = append(stages, found...)
+	}
+	require.Len(t, stages, 1)
+	var opts struct {
+		Partitions []sectorTestPartition `json:"partitions"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+	for idx := range opts.Partitions {
+		opts.Partitions[idx].Start *= sectorSize
+		opts.Partitions[idx].Size *= sectorSize
+	}
+	return opts.Partitions
+}
+
+// loopbackSectorSizes returns the sector-size option of all loopback
+// devices of the disk image, 0 if it is not set
+func loopbackSectorSizes(t *testing.T, mf manifest.OSBuildManifest) []uint64 {
+	var raw struct {
+		Pipelines []struct {
+			Name   string `json:"name"`
+			Stages []struct {
+				Devices map[string]struct {
+					Type    string `json:"type"`
+					Options struct {
+						SectorSize uint64 `json:"sector-size"`
+					} `json:"options"`
+				} `json:"devices"`
+			} `json:"stages"`
+		} `json:"pipelines"`
+	}
+	require.NoError(t, json.Unmarshal(mf, &raw))
+	var sizes []uint64
+	for _, pl := range raw.Pipelines {
+		if pl.Name != "image" {
+			continue
+		}
+		for _, st := range pl.Stages {
+			for _, dev := range st.Devices {
+				if dev.Type == "org.osbuild.loopback" {
+					sizes = append(sizes, dev.Options.SectorSize)
+				}
+			}
+		}
+	}
+	return sizes
+}
+
+func TestManifestSectorSize(t *testing.T) {
+	for _, a := range []arch.Arch{arch.ARCH_X86_64, arch.ARCH_AARCH64} {
+		var defaultOffsets []sectorTestPartition
+		for _, sectorSize := range []uint64{512, 4096} {
+			t.Run(fmt.Sprintf("%s-%d", a, sectorSize), func(t *testing.T) {
+				config := getBaseConfig()
+				config.ImgType = "qcow2"
+				config.Architecture = a
+				config.Config = &main.BuildConfig{SectorSize: sectorSize}
+				mf, err := main.Manifest(config)
+				require.NoError(t, err)
+				manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+				require.NoError(t, err)
+				manifestJson, err = main.SetLoopbackSectorSize(manifestJson, sectorSize)
+				require.NoError(t, err)
+
+				offsets := partitionByteOffsets(t, manifestJson, sectorSize)
+				require.NotEmpty(t, offsets)
+				for _, p := range offsets {
+					assert.Zero(t, p.Start%sectorSize, "start %d is not aligned to %d", p.Start, sectorSize)
+					assert.Zero(t, p.Size%sectorSize, "size %d is not aligned to %d", p.Size, sectorSize)
+					// the partitions are aligned to 1 MiB either way
+					assert.Zero(t, p.Start%main.MebiByte)
+				}
+				// only the unit of the sectors changes, not where the
+				// partitions start, the backup GPT at the end of the
+				// disk is larger with 4096 byte sectors though
+				if defaultOffsets == nil {
+					defaultOffsets = offsets
+				} else {
+					require.Len(t, offsets, len(defaultOffsets))
+					for idx := range offsets {
+						assert.Equal(t, defaultOffsets[idx].Start, offsets[idx].Start)
+					}
+				}
+
+				sizes := loopbackSectorSizes(t, manifestJson)
+				require.NotEmpty(t, sizes)
+				for _, size := range sizes {
+					assert.Equal(t, sectorSize, size)
+				}
+			})
+		}
+	}
+}
+
+func TestManifestSectorSizeErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType    string
+		sectorSize uint64
+		err        string
+	}{
+		"invalid": {"qcow2", 1024, "unsupported sector_size 1024, valid values are [512 4096]"},
+		"iso":     {"anaconda-iso", 4096, "sector_size not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{SectorSize: tc.sectorSize}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
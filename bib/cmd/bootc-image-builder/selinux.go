@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// selinux_contexts only supports container images with the "targeted"
+// policy, the policy of Fedora, CentOS and RHEL. The policy of the
+// image is only known when it is built, the labeling stage of
+// addSELinuxLabels fails the build for images without it.
+const (
+	fileContextsPath      = "/etc/selinux/targeted/contexts/files/file_contexts"
+	fileContextsLocalPath = fileContextsPath + ".local"
+)
+
+// user:role:type with an optional MLS/MCS range, e.g.
+// system_u:object_r:httpd_config_t:s0
+var selinuxContextRegex = regexp.MustCompile(`^[a-z0-9_]+_u:[a-z0-9_]+_r:[a-z0-9_]+_t(:s[0-9]+(-s[0-9]+)?(:c[0-9]+([.,]c[0-9]+)*)?)?$`)
+
+func validateSELinuxContext(context string) error {
+	if !selinuxContextRegex.MatchString(context) {
+		return fmt.Errorf("invalid selinux context %q, expected user_u:role_r:type_t[:range]", context)
+	}
+	return nil
+}
+
+// selinuxContextsFile creates the file_contexts.local file that labels
+// the given paths with their context on any later relabeling of the
+// system. Only paths created by the file and directory customizations
+// can be labeled.
+func selinuxContextsFile(contexts map[string]string, dirs []*fsnode.Directory, files []*fsnode.File) (*fsnode.File, error) {
+	customized := make(map[string]bool)
+	for _, dir := range dirs {
+		customized[dir.Path()] = true
+	}
+	for _, file := range files {
+		customized[file.Path()] = true
+	}
+
+	paths := make([]string, 0, len(contexts))
+	for path := range contexts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var content strings.Builder
+	for _, path := range paths {
+		if !customized[path] {
+			return nil, fmt.Errorf("cannot set selinux context of %q: not a customized file or directory", path)
+		}
+		if err := validateSELinuxContext(contexts[path]); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&content, "%s %s\n", regexp.QuoteMeta(path), contexts[path])
+	}
+	return fsnode.NewFile(fileContextsLocalPath, nil, nil, nil, []byte(content.String()))
+}
+
+// addSELinuxLabels labels the customized paths with their context after
+// the deployment is relabeled. setfiles runs with the file contexts of
+// the targeted policy of the deployment, so the build fails for images
+// with another policy instead of labeling them with the wrong one.
+//
+// TODO: osbuild/images cannot label the files of the deployment
+func addSELinuxLabels(m *serializedManifest, contexts map[string]string) error {
+	err := m.appendStages("ostree-deployment", func(stages []map[string]json.RawMessage) ([]*osbuild.Stage, error) {
+		var deployment *osbuild.OSTreeDeployment
+		for _, stage := range stages {
+			var stageType string
+			if err := json.Unmarshal(stage["type"], &stageType); err != nil {
+				return nil, err
+			}
+			if stageType != "org.osbuild.ostree.selinux" {
+				continue
+			}
+			var opts osbuild.OSTreeSelinuxStageOptions
+			if err := json.Unmarshal(stage["options"], &opts); err != nil {
+				return nil, err
+			}
+			deployment = &opts.Deployment
+		}
+		if deployment == nil {
+			return nil, fmt.Errorf("no org.osbuild.ostree.selinux stage found")
+		}
+
+		opts := osbuild.NewSELinuxStageOptions(strings.TrimPrefix(fileContextsPath, "/"))
+		opts.Labels = contexts
+		stage := osbuild.NewSELinuxStage(opts)
+		stage.MountOSTree(deployment.OSName, deployment.Ref, 0)
+		return []*osbuild.Stage{stage}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot add selinux_contexts: %w", err)
+	}
+	return nil
+}
+
+const (
+	SELinuxModeEnforcing  = "enforcing"
+	SELinuxModePermissive = "permissive"
+	SELinuxModeDisabled   = "disabled"
+)
+
+// selinuxMode returns the kernel argument that selects the given mode.
+// The config file alone cannot disable SELinux on current systems,
+// setSELinuxConfig sets the mode in /etc/selinux/config as well.
+func selinuxMode(mode string) (string, error) {
+	switch mode {
+	case SELinuxModeEnforcing:
+		return "enforcing=1", nil
+	case SELinuxModePermissive:
+		return "enforcing=0", nil
+	case SELinuxModeDisabled:
+		return "selinux=0", nil
+	default:
+		return "", fmt.Errorf("unsupported selinux_mode %q, valid values are %q, %q and %q", mode, SELinuxModeEnforcing, SELinuxModePermissive, SELinuxModeDisabled)
+	}
+}
+
+// setSELinuxConfig sets the SELINUX= line of /etc/selinux/config of the
+// deployment to the given mode. The stage only changes the state, the
+// SELINUXTYPE and the other lines of the container image are kept.
+//
+// TODO: osbuild/images cannot add stages to the deployment
+func setSELinuxConfig(m *serializedManifest, mode string) error {
+	err := m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(options json.RawMessage) ([]*osbuild.Stage, error) {
+		var selinuxOpts osbuild.OSTreeSelinuxStageOptions
+		if err := json.Unmarshal(options, &selinuxOpts); err != nil {
+			return nil, err
+		}
+		deployment := selinuxOpts.Deployment
+
+		stage := osbuild.NewSELinuxConfigStage(&osbuild.SELinuxConfigStageOptions{
+			State: osbuild.SELinuxPolicyState(mode),
+		})
+		stage.MountOSTree(deployment.OSName, deployment.Ref, 0)
+		return []*osbuild.Stage{stage}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set selinux_mode: %w", err)
+	}
+	return nil
+}
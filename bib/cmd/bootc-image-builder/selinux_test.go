@@ -0,0 +1,171 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func getSELinuxConfig(contexts map[string]string) *main.ManifestConfig {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Directories: []blueprint.DirectoryCustomization{
+					{Path: "/etc/httpd/conf.d", EnsureParents: true},
+				},
+				Files: []blueprint.FileCustomization{
+					{Path: "/etc/httpd/conf.d/app.conf", Data: "Listen 8080\n"},
+				},
+			},
+		},
+		SELinuxContexts: contexts,
+	}
+	return config
+}
+
+func TestManifestSELinuxContexts(t *testing.T) {
+	config := getSELinuxConfig(map[string]string{
+		"/etc/httpd/conf.d/app.conf": "system_u:object_r:httpd_config_t:s0",
+		"/etc/httpd/conf.d":          "system_u:object_r:httpd_config_t",
+	})
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/httpd/conf.d/app.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "Listen 8080\n", content)
+
+	content, err = findFileContent(manifestJson, "ostree-deployment", "/etc/selinux/targeted/contexts/files/file_contexts.local")
+	require.NoError(t, err)
+	assert.Equal(t, `/etc/httpd/conf\.d system_u:object_r:httpd_config_t
+/etc/httpd/conf\.d/app\.conf system_u:object_r:httpd_config_t:s0
+`, content)
+
+	// the contexts are applied by a labeling stage after the relabeling
+	// of the deployment
+	manifestJson, err = main.AddSELinuxLabels(manifestJson, config.Config.SELinuxContexts)
+	require.NoError(t, err)
+	var mfst testManifest
+	require.NoError(t, json.Unmarshal(manifestJson, &mfst))
+	var stages []stage
+	for _, pl := range mfst.Pipelines {
+		if pl.Name == "ostree-deployment" {
+			stages = pl.Stages
+		}
+	}
+	require.True(t, len(stages) >= 2)
+	assert.Equal(t, "org.osbuild.ostree.selinux", stages[len(stages)-2].Type)
+	labels := stages[len(stages)-1]
+	assert.Equal(t, "org.osbuild.selinux", labels.Type)
+	assert.JSONEq(t, `{
+		"file_contexts": "etc/selinux/targeted/contexts/files/file_contexts",
+		"labels": {
+			"/etc/httpd/conf.d": "system_u:object_r:httpd_config_t",
+			"/etc/httpd/conf.d/app.conf": "system_u:object_r:httpd_config_t:s0"
+		}
+	}`, string(labels.Options))
+	var mounts []struct {
+		Type string `json:"type"`
+	}
+	require.NoError(t, json.Unmarshal(labels.Mounts, &mounts))
+	require.Len(t, mounts, 1)
+	assert.Equal(t, "org.osbuild.ostree.deployment", mounts[0].Type)
+}
+
+func TestManifestSELinuxContextsErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType  string
+		contexts map[string]string
+		err      string
+	}{
+		"bad-context":    {"qcow2", map[string]string{"/etc/httpd/conf.d/app.conf": "httpd_config_t"}, `invalid selinux context "httpd_config_t", expected user_u:role_r:type_t[:range]`},
+		"bad-range":      {"qcow2", map[string]string{"/etc/httpd/conf.d/app.conf": "system_u:object_r:httpd_config_t:high"}, `invalid selinux context "system_u:object_r:httpd_config_t:high", expected user_u:role_r:type_t[:range]`},
+		"not-customized": {"qcow2", map[string]string{"/etc/hosts": "system_u:object_r:net_conf_t:s0"}, `cannot set selinux context of "/etc/hosts": not a customized file or directory`},
+		"iso":            {"iso", map[string]string{"/etc/httpd/conf.d/app.conf": "system_u:object_r:httpd_config_t:s0"}, "selinux_contexts not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getSELinuxConfig(tc.contexts)
+			config.ImgType = tc.imgType
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestManifestSELinuxMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		karg string
+	}{
+		{"enforcing", "enforcing=1"},
+		{"permissive", "enforcing=0"},
+		{"disabled", "selinux=0"},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{SELinuxMode: tc.mode}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			manifestJson, err = main.SetSELinuxConfig(manifestJson, tc.mode)
+			require.NoError(t, err)
+
+			// only SELINUX= of the config is set, the policy type of
+			// the image is kept
+			stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.selinux.config")
+			require.NoError(t, err)
+			require.Len(t, stages, 1)
+			assert.JSONEq(t, `{"state": "`+tc.mode+`"}`, string(stages[0].Options))
+			assert.Contains(t, string(stages[0].Mounts), "org.osbuild.ostree.deployment")
+
+			stages, err = findStages(manifestJson, "ostree-deployment", "org.osbuild.ostree.deploy.container")
+			require.NoError(t, err)
+			require.Len(t, stages, 1)
+			var deploy struct {
+				KernelOpts []string `json:"kernel_opts"`
+			}
+			require.NoError(t, json.Unmarshal(stages[0].Options, &deploy))
+			assert.Contains(t, deploy.KernelOpts, tc.karg)
+		})
+	}
+}
+
+func TestManifestSELinuxModeDefault(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	// the mode of the container image is kept
+	_, err = findFileContent(manifestJson, "ostree-deployment", "/etc/selinux/config")
+	assert.ErrorContains(t, err, "not found")
+	assert.NotContains(t, string(manifestJson), "enforcing=")
+	assert.NotContains(t, string(manifestJson), "selinux=0")
+}
+
+func TestManifestSELinuxModeErrors(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{SELinuxMode: "off"}
+	_, err := main.Manifest(config)
+	assert.EqualError(t, err, `unsupported selinux_mode "off", valid values are "enforcing", "permissive" and "disabled"`)
+
+	config.ImgType = "iso"
+	config.Config = &main.BuildConfig{SELinuxMode: "permissive"}
+	_, err = main.Manifest(config)
+	assert.EqualError(t, err, "selinux_mode not supported for the iso image type")
+}
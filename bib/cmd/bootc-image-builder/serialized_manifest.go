@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/osbuild/images/pkg/manifest"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// The helpers in this file change the serialized manifest for the
+// customizations that osbuild/images v0.38 cannot express: stages on
+// the physical root, the ISO tree or the disk image pipeline, symlinks
+// and stages in the deployment, and extra pipelines and sources.
+// Customizations that only add files, directories or services to the
+// deployment use img.Files, img.Directories and ServicesWorkload
+// instead. Every function that uses these helpers has a TODO that
+// names what osbuild/images is missing, the function can be replaced
+// once it is there.
+//
+// The manifest is decoded once by parseManifest, the helpers change it
+// in place and serialize encodes it again.
+
+type rawManifest struct {
+	Version   string            `json:"version"`
+	Pipelines []json.RawMessage `json:"pipelines"`
+	Sources   json.RawMessage   `json:"sources"`
+}
+
+type rawPipeline struct {
+	Name   string                       `json:"name"`
+	Build  string                       `json:"build,omitempty"`
+	Runner string                       `json:"runner,omitempty"`
+	Stages []map[string]json.RawMessage `json:"stages,omitempty"`
+}
+
+// serializedManifest is a decoded serialized manifest, the stages and
+// sources are kept as raw JSON
+type serializedManifest struct {
+	Version   string                     `json:"version"`
+	Pipelines []*rawPipeline             `json:"pipelines"`
+	Sources   map[string]json.RawMessage `json:"sources"`
+}
+
+func parseManifest(mf manifest.OSBuildManifest) (*serializedManifest, error) {
+	var m serializedManifest
+	if err := json.Unmarshal(mf, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *serializedManifest) serialize() (manifest.OSBuildManifest, error) {
+	return json.Marshal(m)
+}
+
+func stageTypeOf(st map[string]json.RawMessage) (string, error) {
+	var typ string
+	if err := json.Unmarshal(st["type"], &typ); err != nil {
+		return "", err
+	}
+	return typ, nil
+}
+
+func rawStages(stages []*osbuild.Stage) ([]map[string]json.RawMessage, error) {
+	var raw []map[string]json.RawMessage
+	for _, stage := range stages {
+		data, err := json.Marshal(stage)
+		if err != nil {
+			return nil, err
+		}
+		var rawStage map[string]json.RawMessage
+		if err := json.Unmarshal(data, &rawStage); err != nil {
+			return nil, err
+		}
+		raw = append(raw, rawStage)
+	}
+	return raw, nil
+}
+
+// updateStageOptions calls update with the options of every stage of
+// the given type in the given pipeline and replaces the options with
+// the result. It is an error if no such stage exists.
+func (m *serializedManifest) updateStageOptions(plName, stageType string, update func(options json.RawMessage) (json.RawMessage, error)) error {
+	found := false
+	for _, pl := range m.Pipelines {
+		if pl.Name != plName {
+			continue
+		}
+		for _, st := range pl.Stages {
+			typ, err := stageTypeOf(st)
+			if err != nil {
+				return err
+			}
+			if typ != stageType {
+				continue
+			}
+			options, err := update(st["options"])
+			if err != nil {
+				return err
+			}
+			st["options"] = options
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no %s stage found in pipeline %q", stageType, plName)
+	}
+	return nil
+}
+
+// removeStages removes all stages of the given type from the given
+// pipeline. It is an error if no such stage exists.
+func (m *serializedManifest) removeStages(plName, stageType string) error {
+	found := false
+	for _, pl := range m.Pipelines {
+		if pl.Name != plName {
+			continue
+		}
+		var stages []map[string]json.RawMessage
+		for _, st := range pl.Stages {
+			typ, err := stageTypeOf(st)
+			if err != nil {
+				return err
+			}
+			if typ == stageType {
+				found = true
+				continue
+			}
+			stages = append(stages, st)
+		}
+		pl.Stages = stages
+	}
+	if !found {
+		return fmt.Errorf("no %s stage found in pipeline %q", stageType, plName)
+	}
+	return nil
+}
+
+// appendPipeline adds the given pipeline to the end of the manifest
+func (m *serializedManifest) appendPipeline(pipeline interface{}) error {
+	data, err := json.Marshal(pipeline)
+	if err != nil {
+		return err
+	}
+	var pl rawPipeline
+	if err := json.Unmarshal(data, &pl); err != nil {
+		return err
+	}
+	m.Pipelines = append(m.Pipelines, &pl)
+	return nil
+}
+
+// insertStagesBefore inserts the stages that stages returns before the
+// first stage of the given type in the given pipeline. The options of
+// that stage are passed to stages.
+func (m *serializedManifest) insertStagesBefore(plName, stageType string, stages func(options json.RawMessage) ([]*osbuild.Stage, error)) error {
+	for _, pl := range m.Pipelines {
+		if pl.Name != plName {
+			continue
+		}
+		for stageIdx, st := range pl.Stages {
+			typ, err := stageTypeOf(st)
+			if err != nil {
+				return err
+			}
+			if typ != stageType {
+				continue
+			}
+			newStages, err := stages(st["options"])
+			if err != nil {
+				return err
+			}
+			inserted, err := rawStages(newStages)
+			if err != nil {
+				return err
+			}
+			pl.Stages = append(pl.Stages[:stageIdx], append(inserted, pl.Stages[stageIdx:]...)...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no %s stage found in pipeline %q", stageType, plName)
+}
+
+// updateSource calls update with the given source of the manifest (nil
+// if there is none yet) and replaces the source with the result.
+func (m *serializedManifest) updateSource(sourceType string, update func(source json.RawMessage) (json.RawMessage, error)) error {
+	source, err := update(m.Sources[sourceType])
+	if err != nil {
+		return err
+	}
+	if m.Sources == nil {
+		m.Sources = make(map[string]json.RawMessage)
+	}
+	m.Sources[sourceType] = source
+	return nil
+}
+
+// appendStages appends the stages that stages returns to the given
+// pipeline. The existing stages are passed to stages.
+func (m *serializedManifest) appendStages(plName string, stages func(existing []map[string]json.RawMessage) ([]*osbuild.Stage, error)) error {
+	for _, pl := range m.Pipelines {
+		if pl.Name != plName {
+			continue
+		}
+		newStages, err := stages(pl.Stages)
+		if err != nil {
+			return err
+		}
+		appended, err := rawStages(newStages)
+		if err != nil {
+			return err
+		}
+		pl.Stages = append(pl.Stages, appended...)
+		return nil
+	}
+	return fmt.Errorf("pipeline %q not found", plName)
+}
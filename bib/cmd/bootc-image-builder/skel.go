@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const skelDir = "/etc/skel"
+
+// Skel populates /etc/skel, the home directories of users that are
+// created on the installed system start as a copy of it
+type Skel struct {
+	Directories []SkelDirectory `json:"directories,omitempty"`
+	Files       []SkelFile      `json:"files,omitempty"`
+}
+
+// SkelDirectory is a directory below /etc/skel
+type SkelDirectory struct {
+	// Path is relative to /etc/skel, e.g. ".config/nvim"
+	Path string `json:"path"`
+	// Mode is the octal mode, e.g. "0700"
+	Mode string `json:"mode,omitempty"`
+}
+
+// SkelFile is a file below /etc/skel
+type SkelFile struct {
+	// Path is relative to /etc/skel, e.g. ".bashrc"
+	Path string `json:"path"`
+	// Mode is the octal mode, e.g. "0644"
+	Mode string `json:"mode,omitempty"`
+	Data string `json:"data"`
+}
+
+func skelPath(p string) (string, error) {
+	if p == "" || path.IsAbs(p) || path.Clean(p) != p || p == "." || p == ".." || (len(p) > 3 && p[:3] == "../") {
+		return "", fmt.Errorf("invalid skel path %q, expected a clean path relative to %s", p, skelDir)
+	}
+	return path.Join(skelDir, p), nil
+}
+
+func skelMode(p, mode string) (*os.FileMode, error) {
+	if mode == "" {
+		return nil, nil
+	}
+	modeNum, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || modeNum > 07777 {
+		return nil, fmt.Errorf("invalid skel mode %q for %s, expected an octal mode like \"0644\"", mode, p)
+	}
+	fileMode := os.FileMode(modeNum)
+	return &fileMode, nil
+}
+
+// skelNodes returns the directories and files of the given skel. The
+// parent directories of the files are created when they are not listed.
+func skelNodes(skel *Skel) ([]*fsnode.Directory, []*fsnode.File, error) {
+	if len(skel.Directories) == 0 && len(skel.Files) == 0 {
+		return nil, nil, fmt.Errorf("skel needs directories or files")
+	}
+
+	seen := make(map[string]bool)
+	// directories that exist once the listed ones are created
+	created := make(map[string]bool)
+	addCreated := func(p string) {
+		for ; p != skelDir && p != "/"; p = path.Dir(p) {
+			created[p] = true
+		}
+	}
+
+	var dirs []*fsnode.Directory
+	for _, d := range skel.Directories {
+		p, err := skelPath(d.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if seen[p] {
+			return nil, nil, fmt.Errorf("duplicate skel path %q", d.Path)
+		}
+		seen[p] = true
+		mode, err := skelMode(d.Path, d.Mode)
+		if err != nil {
+			return nil, nil, err
+		}
+		dir, err := fsnode.NewDirectory(p, mode, nil, nil, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		dirs = append(dirs, dir)
+		addCreated(p)
+	}
+
+	var files []*fsnode.File
+	var parents []string
+	for _, f := range skel.Files {
+		p, err := skelPath(f.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if seen[p] || created[p] {
+			return nil, nil, fmt.Errorf("duplicate skel path %q", f.Path)
+		}
+		seen[p] = true
+		mode, err := skelMode(f.Path, f.Mode)
+		if err != nil {
+			return nil, nil, err
+		}
+		file, err := fsnode.NewFile(p, mode, nil, nil, []byte(f.Data))
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+		if parent := path.Dir(p); parent != skelDir && !created[parent] {
+			parents = append(parents, parent)
+			addCreated(parent)
+		}
+	}
+	for _, p := range parents {
+		if seen[p] {
+			return nil, nil, fmt.Errorf("skel path %q is a file and a directory", p)
+		}
+	}
+
+	sort.Strings(parents)
+	for _, p := range parents {
+		dir, err := fsnode.NewDirectory(p, nil, nil, nil, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, files, nil
+}
@@ -0,0 +1,104 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestSkel(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Skel: &main.Skel{
+			Directories: []main.SkelDirectory{
+				{Path: ".ssh", Mode: "0700"},
+			},
+			Files: []main.SkelFile{
+				{Path: ".bashrc", Mode: "0644", Data: "export EDITOR=vim\n"},
+				{Path: ".ssh/config", Mode: "0600", Data: "Host *\n  ServerAliveInterval 60\n"},
+				{Path: ".config/nvim/init.vim", Data: "set number\n"},
+			},
+		},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	for path, expected := range map[string]string{
+		"/etc/skel/.bashrc":               "export EDITOR=vim\n",
+		"/etc/skel/.ssh/config":           "Host *\n  ServerAliveInterval 60\n",
+		"/etc/skel/.config/nvim/init.vim": "set number\n",
+	} {
+		content, err := findFileContent(manifestJson, "ostree-deployment", path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, content, path)
+	}
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.mkdir")
+	require.NoError(t, err)
+	// the first one creates the mountpoint of the ESP
+	require.Len(t, stages, 2)
+	var mkdirOpts struct {
+		Paths []struct {
+			Path    string `json:"path"`
+			Parents bool   `json:"parents"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(stages[1].Options, &mkdirOpts))
+	var dirs []string
+	for _, p := range mkdirOpts.Paths {
+		assert.True(t, p.Parents)
+		dirs = append(dirs, p.Path)
+	}
+	assert.Equal(t, []string{"/etc/skel/.ssh", "/etc/skel/.config/nvim"}, dirs)
+
+	stages, err = findStages(manifestJson, "ostree-deployment", "org.osbuild.chmod")
+	require.NoError(t, err)
+	modes := make(map[string]string)
+	for _, stage := range stages {
+		var chmodOpts struct {
+			Items map[string]struct {
+				Mode string `json:"mode"`
+			} `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(stage.Options, &chmodOpts))
+		for p, item := range chmodOpts.Items {
+			modes[p] = item.Mode
+		}
+	}
+	assert.Equal(t, "0700", modes["/etc/skel/.ssh"])
+	assert.Equal(t, "0644", modes["/etc/skel/.bashrc"])
+	assert.Equal(t, "0600", modes["/etc/skel/.ssh/config"])
+	assert.NotContains(t, modes, "/etc/skel/.config/nvim/init.vim")
+}
+
+func TestManifestSkelErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		skel    main.Skel
+		err     string
+	}{
+		"empty":     {"qcow2", main.Skel{}, "skel needs directories or files"},
+		"absolute":  {"qcow2", main.Skel{Files: []main.SkelFile{{Path: "/etc/skel/.bashrc"}}}, `invalid skel path "/etc/skel/.bashrc", expected a clean path relative to /etc/skel`},
+		"parent":    {"qcow2", main.Skel{Files: []main.SkelFile{{Path: "../profile"}}}, `invalid skel path "../profile", expected a clean path relative to /etc/skel`},
+		"unclean":   {"qcow2", main.Skel{Directories: []main.SkelDirectory{{Path: ".config/"}}}, `invalid skel path ".config/", expected a clean path relative to /etc/skel`},
+		"bad-mode":  {"qcow2", main.Skel{Files: []main.SkelFile{{Path: ".bashrc", Mode: "rw-r--r--"}}}, `invalid skel mode "rw-r--r--" for .bashrc, expected an octal mode like "0644"`},
+		"duplicate": {"qcow2", main.Skel{Directories: []main.SkelDirectory{{Path: ".config/nvim"}}, Files: []main.SkelFile{{Path: ".config"}}}, `duplicate skel path ".config"`},
+		"file-dir":  {"qcow2", main.Skel{Files: []main.SkelFile{{Path: ".vim"}, {Path: ".vim/vimrc"}}}, `skel path "/etc/skel/.vim" is a file and a directory`},
+		"iso":       {"iso", main.Skel{Files: []main.SkelFile{{Path: ".bashrc"}}}, "skel not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{Skel: &tc.skel}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
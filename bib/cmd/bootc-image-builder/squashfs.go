@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/osbuild/images/pkg/manifest"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+const (
+	// squashfsPipelineName is the name of the pipeline (and the
+	// export) with the squashfs of the deployed tree
+	squashfsPipelineName = "squashfs"
+	squashfsFilename     = "rootfs.squashfs"
+
+	squashfsDefaultCompression = "zstd"
+)
+
+var squashfsCompressions = []string{"gzip", "lz4", "xz", "zstd"}
+
+func (c *ManifestConfig) squashfsCompression() string {
+	if c.Config != nil && c.Config.SquashfsCompression != "" {
+		return c.Config.SquashfsCompression
+	}
+	return squashfsDefaultCompression
+}
+
+// manifestForSquashfs returns the manifest of the raw disk image, the
+// squashfs of its ostree-deployment tree is added by addSquashfs once
+// the manifest is serialized. Only the pipelines that the squashfs
+// needs are built.
+func manifestForSquashfs(c *ManifestConfig, rng *rand.Rand) (*manifest.Manifest, error) {
+	if c.DiskSize != 0 {
+		return nil, fmt.Errorf("disk size is not supported for the squashfs image type")
+	}
+	if c.Config != nil && len(c.Config.DataDisks) > 0 {
+		return nil, fmt.Errorf("data_disks not supported for the squashfs image type")
+	}
+	valid := false
+	for _, compression := range squashfsCompressions {
+		if c.squashfsCompression() == compression {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("unsupported squashfs_compression %q, valid values are %q", c.squashfsCompression(), squashfsCompressions)
+	}
+	rawConfig := *c
+	rawConfig.ImgType = "raw"
+	return manifestForDiskImage(&rawConfig, rng)
+}
+
+// addSquashfs adds the pipeline that packs the deployed tree, i.e. the
+// physical root with the ostree repository and the deployment, into a
+// squashfs.
+//
+// TODO: osbuild/images has no squashfs image type for bootc
+func addSquashfs(m *serializedManifest, compression string, a arch.Arch) error {
+	opts := &osbuild.SquashfsStageOptions{
+		Filename: squashfsFilename,
+		Compression: osbuild.FSCompression{
+			Method: compression,
+		},
+	}
+	if compression == "xz" {
+		opts.Compression.Options = &osbuild.FSCompressionOptions{
+			BCJ: osbuild.BCJOption(a.String()),
+		}
+	}
+	pipeline := &osbuild.Pipeline{
+		Name:  squashfsPipelineName,
+		Build: "name:build",
+	}
+	pipeline.AddStage(osbuild.NewSquashfsStage(opts, "ostree-deployment"))
+	err := m.appendPipeline(pipeline)
+	if err != nil {
+		return fmt.Errorf("cannot add squashfs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/arch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestSquashfs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		compression string
+		expected    string
+	}{
+		"default": {"", `{"filename": "rootfs.squashfs", "compression": {"method": "zstd"}}`},
+		"lz4":     {"lz4", `{"filename": "rootfs.squashfs", "compression": {"method": "lz4"}}`},
+		"xz":      {"xz", `{"filename": "rootfs.squashfs", "compression": {"method": "xz", "options": {"bcj": "x86"}}}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "squashfs"
+			config.Architecture = arch.ARCH_X86_64
+			config.Config = &main.BuildConfig{SquashfsCompression: tc.compression}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+			compression := tc.compression
+			if compression == "" {
+				compression = "zstd"
+			}
+			manifestJson, err = main.AddSquashfs(manifestJson, compression, arch.ARCH_X86_64)
+			require.NoError(t, err)
+
+			stages, err := findStages(manifestJson, "squashfs", "org.osbuild.squashfs")
+			require.NoError(t, err)
+			require.Len(t, stages, 1)
+			assert.JSONEq(t, tc.expected, string(stages[0].Options))
+
+			// the deployed tree is packed
+			var mfs struct {
+				Pipelines []struct {
+					Name   string `json:"name"`
+					Stages []struct {
+						Inputs json.RawMessage `json:"inputs"`
+					} `json:"stages"`
+				} `json:"pipelines"`
+			}
+			require.NoError(t, json.Unmarshal(manifestJson, &mfs))
+			last := mfs.Pipelines[len(mfs.Pipelines)-1]
+			assert.Equal(t, "squashfs", last.Name)
+			assert.JSONEq(t, `{"tree": {"type": "org.osbuild.tree", "origin": "org.osbuild.pipeline", "references": ["name:ostree-deployment"]}}`, string(last.Stages[0].Inputs))
+		})
+	}
+}
+
+func TestManifestSquashfsErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType  string
+		diskSize uint64
+		config   main.BuildConfig
+		err      string
+	}{
+		"bad-compression": {"squashfs", 0, main.BuildConfig{SquashfsCompression: "bzip2"}, `unsupported squashfs_compression "bzip2", valid values are ["gzip" "lz4" "xz" "zstd"]`},
+		"disk-size":       {"squashfs", 20 * main.GibiByte, main.BuildConfig{}, "disk size is not supported for the squashfs image type"},
+		"data-disks":      {"squashfs", 0, main.BuildConfig{DataDisks: []main.DataDisk{{Size: "10GiB", Mountpoint: "/data"}}}, "data_disks not supported for the squashfs image type"},
+		"other-type":      {"qcow2", 0, main.BuildConfig{SquashfsCompression: "xz"}, "squashfs_compression only supported for the squashfs image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.DiskSize = tc.diskSize
+			config.Config = &tc.config
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// SSHHostKey is a host key pair that is installed instead of keys that
+// are generated on the first boot
+type SSHHostKey struct {
+	// Type is one of "rsa", "ecdsa" or "ed25519"
+	Type       string `json:"type"`
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+// the key types of the host keys that sshd loads by default and the
+// algorithm of their public keys
+var sshHostKeyAlgorithms = map[string][]string{
+	"rsa":     {ssh.KeyAlgoRSA},
+	"ecdsa":   {ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521},
+	"ed25519": {ssh.KeyAlgoED25519},
+}
+
+// cloud-init deletes the host keys and generates new ones on the first
+// boot of an instance
+const sshHostKeysCloudInitPath = "/etc/cloud/cloud.cfg.d/90-bootc-image-builder-ssh-host-keys.cfg"
+
+// validateSSHHostKey ensures both keys parse and belong together. The
+// errors never contain the private key.
+func validateSSHHostKey(key *SSHHostKey) error {
+	algos, ok := sshHostKeyAlgorithms[key.Type]
+	if !ok {
+		return fmt.Errorf("unsupported ssh host key type %q, valid values are \"rsa\", \"ecdsa\" and \"ed25519\"", key.Type)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(key.PrivateKey))
+	if err != nil {
+		return fmt.Errorf("cannot parse the private %s ssh host key", key.Type)
+	}
+	public, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+	if err != nil {
+		return fmt.Errorf("cannot parse the public %s ssh host key: %w", key.Type, err)
+	}
+	validType := false
+	for _, algo := range algos {
+		if public.Type() == algo {
+			validType = true
+			break
+		}
+	}
+	if !validType {
+		return fmt.Errorf("public ssh host key is a %s key, not a %s key", public.Type(), key.Type)
+	}
+	if !bytes.Equal(signer.PublicKey().Marshal(), public.Marshal()) {
+		return fmt.Errorf("private and public %s ssh host keys do not belong together", key.Type)
+	}
+	return nil
+}
+
+// sshHostKeyFiles returns the files of the given host keys and the
+// cloud-init config that keeps them.
+func sshHostKeyFiles(keys []SSHHostKey) ([]*fsnode.File, error) {
+	var files []*fsnode.File
+	seen := make(map[string]bool)
+	privateMode := os.FileMode(0600)
+	publicMode := os.FileMode(0644)
+	for i := range keys {
+		key := &keys[i]
+		if err := validateSSHHostKey(key); err != nil {
+			return nil, err
+		}
+		if seen[key.Type] {
+			return nil, fmt.Errorf("duplicate %s ssh host key", key.Type)
+		}
+		seen[key.Type] = true
+
+		path := fmt.Sprintf("/etc/ssh/ssh_host_%s_key", key.Type)
+		private, err := fsnode.NewFile(path, &privateMode, nil, nil, []byte(key.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		public, err := fsnode.NewFile(path+".pub", &publicMode, nil, nil, []byte(key.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, private, public)
+	}
+	cloudInit, err := fsnode.NewFile(sshHostKeysCloudInitPath, nil, nil, nil, []byte("ssh_deletekeys: false\nssh_genkeytypes: []\n"))
+	if err != nil {
+		return nil, err
+	}
+	return append(files, cloudInit), nil
+}
@@ -0,0 +1,97 @@
+package main_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func makeSSHHostKey(t *testing.T) main.SSHHostKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	public, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return main.SSHHostKey{
+		Type:       "ed25519",
+		PrivateKey: string(pem.EncodeToMemory(block)),
+		PublicKey:  string(ssh.MarshalAuthorizedKey(public)),
+	}
+}
+
+func TestManifestSSHHostKeys(t *testing.T) {
+	key := makeSSHHostKey(t)
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{SSHHostKeys: []main.SSHHostKey{key}}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/ssh/ssh_host_ed25519_key")
+	require.NoError(t, err)
+	assert.Equal(t, key.PrivateKey, content)
+	content, err = findFileContent(manifestJson, "ostree-deployment", "/etc/ssh/ssh_host_ed25519_key.pub")
+	require.NoError(t, err)
+	assert.Equal(t, key.PublicKey, content)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.chmod")
+	require.NoError(t, err)
+	modes := make(map[string]string)
+	for _, st := range stages {
+		var chmod struct {
+			Items map[string]struct {
+				Mode string `json:"mode"`
+			} `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(st.Options, &chmod))
+		for path, item := range chmod.Items {
+			modes[path] = item.Mode
+		}
+	}
+	assert.Equal(t, "0600", modes["/etc/ssh/ssh_host_ed25519_key"])
+	assert.Equal(t, "0644", modes["/etc/ssh/ssh_host_ed25519_key.pub"])
+
+	// cloud-init would replace the keys on the first boot
+	content, err = findFileContent(manifestJson, "ostree-deployment", "/etc/cloud/cloud.cfg.d/90-bootc-image-builder-ssh-host-keys.cfg")
+	require.NoError(t, err)
+	assert.Equal(t, "ssh_deletekeys: false\nssh_genkeytypes: []\n", content)
+}
+
+func TestManifestSSHHostKeysErrors(t *testing.T) {
+	key := makeSSHHostKey(t)
+	other := makeSSHHostKey(t)
+	for name, tc := range map[string]struct {
+		imgType string
+		keys    []main.SSHHostKey
+		err     string
+	}{
+		"bad-type":    {"qcow2", []main.SSHHostKey{{Type: "dsa", PrivateKey: key.PrivateKey, PublicKey: key.PublicKey}}, `unsupported ssh host key type "dsa", valid values are "rsa", "ecdsa" and "ed25519"`},
+		"bad-private": {"qcow2", []main.SSHHostKey{{Type: "ed25519", PrivateKey: "secret", PublicKey: key.PublicKey}}, "cannot parse the private ed25519 ssh host key"},
+		"bad-public":  {"qcow2", []main.SSHHostKey{{Type: "ed25519", PrivateKey: key.PrivateKey, PublicKey: "ssh-ed25519"}}, "cannot parse the public ed25519 ssh host key: ssh: no key found"},
+		"wrong-type":  {"qcow2", []main.SSHHostKey{{Type: "rsa", PrivateKey: key.PrivateKey, PublicKey: key.PublicKey}}, "public ssh host key is a ssh-ed25519 key, not a rsa key"},
+		"mismatch":    {"qcow2", []main.SSHHostKey{{Type: "ed25519", PrivateKey: key.PrivateKey, PublicKey: other.PublicKey}}, "private and public ed25519 ssh host keys do not belong together"},
+		"duplicate":   {"qcow2", []main.SSHHostKey{key, other}, "duplicate ed25519 ssh host key"},
+		"iso":         {"iso", []main.SSHHostKey{key}, "ssh_host_keys not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{SSHHostKeys: tc.keys}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+			// the private key is never part of an error
+			assert.NotContains(t, err.Error(), "PRIVATE KEY")
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports/alltransports"
+
+	"github.com/osbuild/images/pkg/container"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// storageResolver resolves the containers of a local containers
+// storage itself and all other containers with the resolver of
+// osbuild/images. That resolver looks up local images by their name
+// without the tag, so e.g. "localhost/my-image:dev" would resolve to
+// "localhost/my-image:latest".
+type storageResolver struct {
+	registry containerResolver
+
+	local []container.SourceSpec
+}
+
+func newStorageResolver(arch string) *storageResolver {
+	return &storageResolver{registry: container.NewResolver(arch)}
+}
+
+func isContainersStorage(spec container.SourceSpec) bool {
+	return spec.ContainersTransport != nil && *spec.ContainersTransport == osbuild.ContainersStorageTransport
+}
+
+func (r *storageResolver) Add(spec container.SourceSpec) {
+	if isContainersStorage(spec) {
+		r.local = append(r.local, spec)
+		return
+	}
+	r.registry.Add(spec)
+}
+
+func (r *storageResolver) Finish() ([]container.Spec, error) {
+	local := r.local
+	r.local = nil
+
+	specs, err := r.registry.Finish()
+	if err != nil {
+		return nil, err
+	}
+	for _, src := range local {
+		spec, err := resolveContainersStorage(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve container: '%s': %w", src.Source, err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// containersStorageImageName returns the name of the given image in
+// the containers storage at storagePath (the default storage if nil)
+// for alltransports.ParseImageName(). The image is either a name with
+// an optional tag or the ID of an image.
+func containersStorageImageName(image string, storagePath *string) string {
+	var storage string
+	if storagePath != nil {
+		storage = fmt.Sprintf("[overlay@%s]", *storagePath)
+	}
+	return fmt.Sprintf("%s:%s%s", osbuild.ContainersStorageTransport, storage, image)
+}
+
+// storageSourceName returns the name of the given local image with
+// its tag, "latest" if there is none
+func storageSourceName(src container.SourceSpec) (reference.Named, error) {
+	named, err := reference.ParseNormalizedNamed(src.Source)
+	if err != nil {
+		return nil, err
+	}
+	return reference.TagNameOnly(named), nil
+}
+
+// resolveContainersStorage resolves the given image of a local
+// containers storage
+func resolveContainersStorage(src container.SourceSpec) (container.Spec, error) {
+	named, err := storageSourceName(src)
+	if err != nil {
+		return container.Spec{}, err
+	}
+	ref, err := alltransports.ParseImageName(containersStorageImageName(named.String(), src.StoragePath))
+	if err != nil {
+		return container.Spec{}, err
+	}
+
+	ctx := context.Background()
+	img, err := ref.NewImage(ctx, nil)
+	if err != nil {
+		return container.Spec{}, err
+	}
+	defer img.Close()
+	raw, _, err := img.Manifest(ctx)
+	if err != nil {
+		return container.Spec{}, err
+	}
+	manifestDigest, err := manifest.Digest(raw)
+	if err != nil {
+		return container.Spec{}, err
+	}
+
+	localName := src.Name
+	if localName == "" {
+		localName = named.String()
+	}
+	return container.Spec{
+		Source:              named.Name(),
+		Digest:              manifestDigest.String(),
+		TLSVerify:           src.TLSVerify,
+		ImageID:             img.ConfigInfo().Digest.String(),
+		LocalName:           localName,
+		ContainersTransport: src.ContainersTransport,
+		StoragePath:         src.StoragePath,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	syslogForwardConfPath = "/etc/rsyslog.d/90-bootc-image-builder-forward.conf"
+	rsyslogService        = "rsyslog.service"
+
+	syslogDefaultPort     = 514
+	syslogDefaultProtocol = "udp"
+)
+
+// SyslogForward forwards the syslog messages of the system to a remote
+// syslog server with rsyslog
+type SyslogForward struct {
+	// Server is the host name or the IP address of the syslog server
+	Server string `json:"server"`
+	// Port defaults to 514
+	Port int `json:"port,omitempty"`
+	// Protocol is "udp" (the default) or "tcp"
+	Protocol string `json:"protocol,omitempty"`
+}
+
+var syslogProtocols = []string{"udp", "tcp"}
+
+func (sf *SyslogForward) port() int {
+	if sf.Port == 0 {
+		return syslogDefaultPort
+	}
+	return sf.Port
+}
+
+func (sf *SyslogForward) protocol() string {
+	if sf.Protocol == "" {
+		return syslogDefaultProtocol
+	}
+	return sf.Protocol
+}
+
+// syslogForwardConfig returns the rsyslog drop-in that forwards all
+// messages to the configured server. rsyslog itself comes from the
+// container image.
+func syslogForwardConfig(sf *SyslogForward) (*fsnode.File, error) {
+	if net.ParseIP(sf.Server) == nil && !hostnameRegex.MatchString(sf.Server) {
+		return nil, fmt.Errorf("invalid syslog_forward server %q, expected a host name or an IP address", sf.Server)
+	}
+	if sf.Port < 0 || sf.Port > 65535 {
+		return nil, fmt.Errorf("invalid syslog_forward port %d", sf.Port)
+	}
+	valid := false
+	for _, p := range syslogProtocols {
+		if sf.protocol() == p {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("unsupported syslog_forward protocol %q, valid values are %q", sf.protocol(), syslogProtocols)
+	}
+
+	content := fmt.Sprintf("# written by bootc-image-builder\n*.* action(type=\"omfwd\" target=\"%s\" port=\"%d\" protocol=\"%s\"", sf.Server, sf.port(), sf.protocol())
+	if sf.protocol() == "tcp" {
+		// keep the messages while the server is unreachable instead
+		// of dropping them
+		content += " queue.type=\"LinkedList\" queue.filename=\"bootc-image-builder-forward\" queue.saveOnShutdown=\"on\" action.resumeRetryCount=\"-1\""
+	}
+	content += ")\n"
+	return fsnode.NewFile(syslogForwardConfPath, nil, nil, nil, []byte(content))
+}
@@ -0,0 +1,72 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestSyslogForward(t *testing.T) {
+	for name, tc := range map[string]struct {
+		forward  main.SyslogForward
+		expected string
+	}{
+		"defaults": {
+			main.SyslogForward{Server: "logs.example.com"},
+			"# written by bootc-image-builder\n*.* action(type=\"omfwd\" target=\"logs.example.com\" port=\"514\" protocol=\"udp\")\n",
+		},
+		"tcp": {
+			main.SyslogForward{Server: "192.0.2.10", Port: 6514, Protocol: "tcp"},
+			"# written by bootc-image-builder\n*.* action(type=\"omfwd\" target=\"192.0.2.10\" port=\"6514\" protocol=\"tcp\" queue.type=\"LinkedList\" queue.filename=\"bootc-image-builder-forward\" queue.saveOnShutdown=\"on\" action.resumeRetryCount=\"-1\")\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{SyslogForward: &tc.forward}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/rsyslog.d/90-bootc-image-builder-forward.conf")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, content)
+
+			stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.systemd")
+			require.NoError(t, err)
+			require.Len(t, stages, 1)
+			var opts struct {
+				EnabledServices []string `json:"enabled_services"`
+			}
+			require.NoError(t, json.Unmarshal(stages[0].Options, &opts))
+			assert.Equal(t, []string{"rsyslog.service"}, opts.EnabledServices)
+		})
+	}
+}
+
+func TestManifestSyslogForwardErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		forward main.SyslogForward
+		err     string
+	}{
+		"no-server":    {"qcow2", main.SyslogForward{}, `invalid syslog_forward server "", expected a host name or an IP address`},
+		"bad-server":   {"qcow2", main.SyslogForward{Server: `logs" port="1`}, `invalid syslog_forward server "logs\" port=\"1", expected a host name or an IP address`},
+		"bad-port":     {"qcow2", main.SyslogForward{Server: "logs.example.com", Port: 70000}, "invalid syslog_forward port 70000"},
+		"bad-protocol": {"qcow2", main.SyslogForward{Server: "logs.example.com", Protocol: "relp"}, `unsupported syslog_forward protocol "relp", valid values are ["udp" "tcp"]`},
+		"iso":          {"iso", main.SyslogForward{Server: "logs.example.com"}, "syslog_forward not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{SyslogForward: &tc.forward}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
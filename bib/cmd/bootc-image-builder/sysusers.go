@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	sysusersDropInPath = "/etc/sysusers.d/50-bootc-image-builder.conf"
+	tmpfilesDropInPath = "/etc/tmpfiles.d/50-bootc-image-builder.conf"
+)
+
+var (
+	// the user and group names that systemd-sysusers accepts by default
+	sysusersNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]{0,30}$`)
+	// "-", a number, "uid:gid", "uid:group" or the path of a file
+	// whose owner is used
+	sysusersIDRegex      = regexp.MustCompile(`^(-|[0-9]+(:([0-9]+|[a-zA-Z_][a-zA-Z0-9_-]*))?|/.*)$`)
+	sysusersGroupIDRegex = regexp.MustCompile(`^(-|[0-9]+|/.*)$`)
+	sysusersRangeRegex   = regexp.MustCompile(`^[0-9]+-[0-9]+$`)
+
+	// the line types of tmpfiles.d(5), followed by the modifiers
+	tmpfilesTypeRegex = regexp.MustCompile(`^[fFwdDevqQpLcbCxXrRzZtThHaA][-+!=~^]*$`)
+	tmpfilesModeRegex = regexp.MustCompile(`^(-|[~:]*[0-7]{3,4})$`)
+)
+
+// splitConfigFields splits a line of a sysusers.d or tmpfiles.d file at
+// whitespace, quoted fields may contain whitespace
+func splitConfigFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField := false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// isConfigComment returns true for the lines that are skipped by
+// systemd-sysusers and systemd-tmpfiles
+func isConfigComment(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "#")
+}
+
+// validateSysusersLine checks a line of sysusers.d(5), e.g.
+// "u myservice - "My Service" /var/lib/myservice"
+func validateSysusersLine(line string) error {
+	fields, err := splitConfigFields(line)
+	if err != nil {
+		return fmt.Errorf("invalid sysusers line %q: %w", line, err)
+	}
+	if len(fields) < 2 {
+		return fmt.Errorf("invalid sysusers line %q, expected at least a type and a name", line)
+	}
+	typ, name := fields[0], fields[1]
+	var id string
+	if len(fields) > 2 {
+		id = fields[2]
+	}
+	switch typ {
+	case "u", "u!":
+		if len(fields) > 6 {
+			return fmt.Errorf("invalid sysusers line %q, expected at most 6 fields", line)
+		}
+		if id != "" && !sysusersIDRegex.MatchString(id) {
+			return fmt.Errorf("invalid sysusers line %q, bad id %q", line, id)
+		}
+	case "g":
+		if len(fields) > 3 {
+			return fmt.Errorf("invalid sysusers line %q, groups only have a name and an id", line)
+		}
+		if id != "" && !sysusersGroupIDRegex.MatchString(id) {
+			return fmt.Errorf("invalid sysusers line %q, bad id %q", line, id)
+		}
+	case "m":
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid sysusers line %q, expected a user and a group", line)
+		}
+		if !sysusersNameRegex.MatchString(id) {
+			return fmt.Errorf("invalid sysusers line %q, bad group name %q", line, id)
+		}
+	case "r":
+		if len(fields) != 3 || name != "-" || !sysusersRangeRegex.MatchString(id) {
+			return fmt.Errorf("invalid sysusers line %q, expected \"r - <from>-<to>\"", line)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid sysusers line %q, unsupported type %q, valid values are \"u\", \"u!\", \"g\", \"m\" and \"r\"", line, typ)
+	}
+	if !sysusersNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid sysusers line %q, bad name %q", line, name)
+	}
+	return nil
+}
+
+// validateTmpfilesLine checks a line of tmpfiles.d(5), e.g.
+// "d /run/myservice 0750 myservice myservice -"
+func validateTmpfilesLine(line string) error {
+	fields, err := splitConfigFields(line)
+	if err != nil {
+		return fmt.Errorf("invalid tmpfiles line %q: %w", line, err)
+	}
+	if len(fields) < 2 {
+		return fmt.Errorf("invalid tmpfiles line %q, expected at least a type and a path", line)
+	}
+	if !tmpfilesTypeRegex.MatchString(fields[0]) {
+		return fmt.Errorf("invalid tmpfiles line %q, unsupported type %q", line, fields[0])
+	}
+	// specifiers like %t expand to absolute paths
+	if !strings.HasPrefix(fields[1], "/") && !strings.HasPrefix(fields[1], "%") {
+		return fmt.Errorf("invalid tmpfiles line %q, path %q is not absolute", line, fields[1])
+	}
+	if len(fields) > 2 && !tmpfilesModeRegex.MatchString(fields[2]) {
+		return fmt.Errorf("invalid tmpfiles line %q, bad mode %q", line, fields[2])
+	}
+	return nil
+}
+
+// configDropIn returns the file with the given lines after validating
+// each line that is not a comment
+func configDropIn(path string, lines []string, validate func(string) error) (*fsnode.File, error) {
+	var content strings.Builder
+	for _, line := range lines {
+		if strings.Contains(line, "\n") {
+			return nil, fmt.Errorf("line %q of %s must not contain a newline", line, path)
+		}
+		if !isConfigComment(line) {
+			if err := validate(line); err != nil {
+				return nil, err
+			}
+		}
+		content.WriteString(line + "\n")
+	}
+	return fsnode.NewFile(path, nil, nil, nil, []byte(content.String()))
+}
+
+// sysusersDropIn returns the sysusers.d drop-in with the given lines,
+// systemd-sysusers creates the users and groups on boot
+func sysusersDropIn(lines []string) (*fsnode.File, error) {
+	return configDropIn(sysusersDropInPath, lines, validateSysusersLine)
+}
+
+// tmpfilesDropIn returns the tmpfiles.d drop-in with the given lines,
+// systemd-tmpfiles applies them on every boot
+func tmpfilesDropIn(lines []string) (*fsnode.File, error) {
+	return configDropIn(tmpfilesDropInPath, lines, validateTmpfilesLine)
+}
@@ -0,0 +1,82 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestSysusersTmpfiles(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Sysusers: []string{
+			"# the user of the service",
+			`u myservice - "My Service" /var/lib/myservice /sbin/nologin`,
+			"g mygroup 1234",
+			"m myservice mygroup",
+			"r - 500-900",
+		},
+		Tmpfiles: []string{
+			"d /run/myservice 0750 myservice myservice -",
+			"L+ /etc/myservice.conf - - - - /usr/share/myservice/default.conf",
+			"f %t/myservice/ready",
+		},
+	}
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/sysusers.d/50-bootc-image-builder.conf")
+	require.NoError(t, err)
+	assert.Equal(t, `# the user of the service
+u myservice - "My Service" /var/lib/myservice /sbin/nologin
+g mygroup 1234
+m myservice mygroup
+r - 500-900
+`, content)
+
+	content, err = findFileContent(manifestJson, "ostree-deployment", "/etc/tmpfiles.d/50-bootc-image-builder.conf")
+	require.NoError(t, err)
+	assert.Equal(t, `d /run/myservice 0750 myservice myservice -
+L+ /etc/myservice.conf - - - - /usr/share/myservice/default.conf
+f %t/myservice/ready
+`, content)
+}
+
+func TestManifestSysusersTmpfilesErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType  string
+		sysusers []string
+		tmpfiles []string
+		err      string
+	}{
+		"sysusers-type":     {"qcow2", []string{"x myservice"}, nil, `invalid sysusers line "x myservice", unsupported type "x", valid values are "u", "u!", "g", "m" and "r"`},
+		"sysusers-short":    {"qcow2", []string{"u"}, nil, `invalid sysusers line "u", expected at least a type and a name`},
+		"sysusers-name":     {"qcow2", []string{"u 1service"}, nil, `invalid sysusers line "u 1service", bad name "1service"`},
+		"sysusers-id":       {"qcow2", []string{"u myservice abc"}, nil, `invalid sysusers line "u myservice abc", bad id "abc"`},
+		"sysusers-group-id": {"qcow2", []string{"g mygroup 1:2"}, nil, `invalid sysusers line "g mygroup 1:2", bad id "1:2"`},
+		"sysusers-member":   {"qcow2", []string{"m myservice"}, nil, `invalid sysusers line "m myservice", expected a user and a group`},
+		"sysusers-range":    {"qcow2", []string{"r - 500"}, nil, `invalid sysusers line "r - 500", expected "r - <from>-<to>"`},
+		"sysusers-quote":    {"qcow2", []string{`u myservice - "My Service`}, nil, `invalid sysusers line "u myservice - \"My Service": unterminated quote`},
+		"sysusers-newline":  {"qcow2", []string{"u a\nu b"}, nil, `line "u a\nu b" of /etc/sysusers.d/50-bootc-image-builder.conf must not contain a newline`},
+		"tmpfiles-type":     {"qcow2", nil, []string{"y /run/myservice"}, `invalid tmpfiles line "y /run/myservice", unsupported type "y"`},
+		"tmpfiles-short":    {"qcow2", nil, []string{"d"}, `invalid tmpfiles line "d", expected at least a type and a path`},
+		"tmpfiles-relative": {"qcow2", nil, []string{"d run/myservice"}, `invalid tmpfiles line "d run/myservice", path "run/myservice" is not absolute`},
+		"tmpfiles-mode":     {"qcow2", nil, []string{"d /run/myservice 750x"}, `invalid tmpfiles line "d /run/myservice 750x", bad mode "750x"`},
+		"sysusers-iso":      {"iso", []string{"g mygroup"}, nil, "sysusers not supported for the iso image type"},
+		"tmpfiles-iso":      {"iso", nil, []string{"d /run/myservice"}, "tmpfiles not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{Sysusers: tc.sysusers, Tmpfiles: tc.tmpfiles}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
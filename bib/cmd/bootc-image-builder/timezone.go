@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/osbuild/images/pkg/osbuild"
+)
+
+// zone names of the tz database, e.g. "Europe/Berlin" or "Etc/GMT+5"
+var timezoneRegex = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+
+var hostTimezone = detectHostTimezone
+
+func validateTimezone(tz string) error {
+	if !timezoneRegex.MatchString(tz) {
+		return fmt.Errorf("invalid timezone %q, expected a zone name like \"Europe/Berlin\"", tz)
+	}
+	return nil
+}
+
+// detectHostTimezone returns the zone name of the host. bib runs in a
+// container, so TZ (set by "podman run --tz") is checked before
+// /etc/timezone and the /etc/localtime symlink.
+func detectHostTimezone() (string, error) {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" && !filepath.IsAbs(tz) {
+		return tz, nil
+	}
+	if b, err := os.ReadFile("/etc/timezone"); err == nil {
+		if tz := strings.TrimSpace(string(b)); tz != "" {
+			return tz, nil
+		}
+	}
+	target, err := os.Readlink("/etc/localtime")
+	if err != nil {
+		return "", fmt.Errorf("cannot detect the host timezone, set TZ: %w", err)
+	}
+	_, tz, ok := strings.Cut(target, "zoneinfo/")
+	if !ok {
+		return "", fmt.Errorf("cannot detect the host timezone from /etc/localtime pointing to %q, set TZ", target)
+	}
+	return tz, nil
+}
+
+// inheritHostTimezone sets the timezone of the host for images without
+// a timezone customization
+func (c *ManifestConfig) inheritHostTimezone() error {
+	var customizations *blueprint.Customizations
+	if c.Config != nil && c.Config.Blueprint != nil {
+		customizations = c.Config.Blueprint.Customizations
+	}
+	if tz, _ := customizations.GetTimezoneSettings(); tz != nil {
+		return nil
+	}
+	tz, err := hostTimezone()
+	if err != nil {
+		return err
+	}
+	if err := validateTimezone(tz); err != nil {
+		return fmt.Errorf("cannot inherit the host timezone: %w", err)
+	}
+	c.HostTimezone = tz
+	return nil
+}
+
+// timezone returns the timezone of the image, the one of the blueprint
+// wins over the one of the host
+func (c *ManifestConfig) timezone() string {
+	if c.Config != nil && c.Config.Blueprint != nil {
+		if tz, _ := c.Config.Blueprint.Customizations.GetTimezoneSettings(); tz != nil {
+			return *tz
+		}
+	}
+	return c.HostTimezone
+}
+
+// addTimezone sets the timezone of the deployment.
+//
+// TODO: osbuild/images does not set the timezone of bootc images
+func addTimezone(m *serializedManifest, tz string) error {
+	err := m.insertStagesBefore("ostree-deployment", "org.osbuild.ostree.selinux", func(options json.RawMessage) ([]*osbuild.Stage, error) {
+		var selinuxOpts osbuild.OSTreeSelinuxStageOptions
+		if err := json.Unmarshal(options, &selinuxOpts); err != nil {
+			return nil, err
+		}
+		deployment := selinuxOpts.Deployment
+
+		stage := osbuild.NewTimezoneStage(&osbuild.TimezoneStageOptions{Zone: tz})
+		stage.MountOSTree(deployment.OSName, deployment.Ref, 0)
+		return []*osbuild.Stage{stage}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set timezone: %w", err)
+	}
+	return nil
+}
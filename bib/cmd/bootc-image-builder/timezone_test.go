@@ -0,0 +1,78 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func mockHostTimezone(t *testing.T, tz string) {
+	restore := main.MockHostTimezone(func() (string, error) {
+		return tz, nil
+	})
+	t.Cleanup(restore)
+}
+
+func TestManifestInheritHostTimezone(t *testing.T) {
+	mockHostTimezone(t, "Europe/Berlin")
+
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	require.NoError(t, config.InheritHostTimezone())
+	assert.Equal(t, "Europe/Berlin", config.Timezone())
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+	manifestJson, err = main.AddTimezone(manifestJson, config.Timezone())
+	require.NoError(t, err)
+
+	stages, err := findStages(manifestJson, "ostree-deployment", "org.osbuild.timezone")
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	assert.JSONEq(t, `{"zone": "Europe/Berlin"}`, string(stages[0].Options))
+	// set in the deployment, not in the physical root
+	var mounts []struct {
+		Type string `json:"type"`
+	}
+	require.NoError(t, json.Unmarshal(stages[0].Mounts, &mounts))
+	require.Len(t, mounts, 1)
+	assert.Equal(t, "org.osbuild.ostree.deployment", mounts[0].Type)
+}
+
+func TestManifestInheritHostTimezoneCustomized(t *testing.T) {
+	mockHostTimezone(t, "Europe/Berlin")
+
+	tz := "America/New_York"
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				Timezone: &blueprint.TimezoneCustomization{Timezone: &tz},
+			},
+		},
+	}
+	require.NoError(t, config.InheritHostTimezone())
+	assert.Equal(t, "", config.HostTimezone)
+	assert.Equal(t, "America/New_York", config.Timezone())
+}
+
+func TestManifestInheritHostTimezoneErrors(t *testing.T) {
+	mockHostTimezone(t, "../../etc/shadow")
+	config := getBaseConfig()
+	config.ImgType = "qcow2"
+	err := config.InheritHostTimezone()
+	assert.EqualError(t, err, `cannot inherit the host timezone: invalid timezone "../../etc/shadow", expected a zone name like "Europe/Berlin"`)
+
+	config = getBaseConfig()
+	config.ImgType = "iso"
+	config.HostTimezone = "Europe/Berlin"
+	_, err = main.Manifest(config)
+	assert.EqualError(t, err, "inheriting the host timezone is not supported for the iso image type")
+}
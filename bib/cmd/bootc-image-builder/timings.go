@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// StageTiming is the wall-clock duration of a single osbuild stage
+type StageTiming struct {
+	Pipeline string        `json:"pipeline"`
+	Stage    string        `json:"stage"`
+	Duration time.Duration `json:"-"`
+}
+
+func (st StageTiming) MarshalJSON() ([]byte, error) {
+	type timing StageTiming
+	return json.Marshal(struct {
+		timing
+		Seconds float64 `json:"duration_seconds"`
+	}{timing(st), st.Duration.Seconds()})
+}
+
+// BuildTimings are the durations of all stages that were run, stages
+// that osbuild took from its store are not included
+type BuildTimings struct {
+	Stages []StageTiming `json:"stages"`
+	Total  time.Duration `json:"-"`
+}
+
+func (bt BuildTimings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Stages       []StageTiming `json:"stages"`
+		TotalSeconds float64       `json:"total_seconds"`
+	}{bt.Stages, bt.Total.Seconds()})
+}
+
+// monitorEntry is the part of a record of the osbuild JSONSeqMonitor
+// that is needed for the timings
+type monitorEntry struct {
+	Message   string  `json:"message"`
+	Timestamp float64 `json:"timestamp"`
+}
+
+const (
+	monitorPipelineStart = "Starting pipeline "
+	monitorStageStart    = "Starting module "
+)
+
+// timingRecorder turns the records of the osbuild monitor into stage
+// timings. osbuild only reports when a stage starts, a stage ends when
+// the next stage or pipeline starts or when the build is done.
+type timingRecorder struct {
+	timings  BuildTimings
+	pipeline string
+	current  *StageTiming
+	started  time.Time
+	first    time.Time
+	last     time.Time
+}
+
+func (r *timingRecorder) finishStage(at time.Time) {
+	if r.current == nil {
+		return
+	}
+	r.current.Duration = at.Sub(r.started)
+	r.timings.Stages = append(r.timings.Stages, *r.current)
+	r.current = nil
+}
+
+func (r *timingRecorder) record(entry monitorEntry) {
+	at := time.Now()
+	if entry.Timestamp > 0 {
+		at = time.Unix(0, int64(entry.Timestamp*float64(time.Second)))
+	}
+	if r.first.IsZero() {
+		r.first = at
+	}
+	r.last = at
+
+	switch {
+	case strings.HasPrefix(entry.Message, monitorPipelineStart):
+		r.finishStage(at)
+		r.pipeline = strings.TrimSpace(strings.TrimPrefix(entry.Message, monitorPipelineStart))
+	case strings.HasPrefix(entry.Message, monitorStageStart):
+		r.finishStage(at)
+		r.current = &StageTiming{
+			Pipeline: r.pipeline,
+			Stage:    strings.TrimSpace(strings.TrimPrefix(entry.Message, monitorStageStart)),
+		}
+		r.started = at
+	}
+}
+
+// done finishes the running stage at the given time and returns the
+// timings
+func (r *timingRecorder) done(at time.Time) BuildTimings {
+	if at.Before(r.last) {
+		at = r.last
+	}
+	r.finishStage(at)
+	if !r.first.IsZero() {
+		r.timings.Total = at.Sub(r.first)
+	}
+	return r.timings
+}
+
+// readMonitor reads the RFC 7464 JSON text sequence written by the
+// osbuild JSONSeqMonitor, the log messages are passed on to the given
+// writer as the monitor replaces the regular osbuild output.
+func (r *timingRecorder) readMonitor(rd io.Reader, log io.Writer) error {
+	scanner := bufio.NewScanner(rd)
+	// stage output can be long
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexByte(data[1:], '\x1e'); i >= 0 {
+			return i + 1, data[:i+1], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		record := bytes.TrimSpace(bytes.TrimPrefix(scanner.Bytes(), []byte{'\x1e'}))
+		if len(record) == 0 {
+			continue
+		}
+		var entry monitorEntry
+		if err := json.Unmarshal(record, &entry); err != nil {
+			return fmt.Errorf("cannot parse osbuild monitor output: %w", err)
+		}
+		r.record(entry)
+		if entry.Message != "" {
+			fmt.Fprintln(log, strings.TrimRight(entry.Message, "\n"))
+		}
+	}
+	return scanner.Err()
+}
+
+// timingsMonitorArgs make osbuild report its progress to the first
+// extra file, which is fd 3
+var timingsMonitorArgs = []string{"--monitor", "JSONSeqMonitor", "--monitor-fd", "3"}
+
+// runOSBuildWithTimings runs osbuild like runOSBuild but with the
+// JSONSeqMonitor on an extra fd to record the stage timings
+func runOSBuildWithTimings(ctx context.Context, manifest []byte, store, outputDirectory string, exports, extraEnv []string, stdout, stderr io.Writer) (BuildTimings, error) {
+	monitorR, monitorW, err := os.Pipe()
+	if err != nil {
+		return BuildTimings{}, err
+	}
+	defer monitorR.Close()
+
+	cmd := osbuildCmd(manifest, osbuildArgs(store, outputDirectory, exports, timingsMonitorArgs), extraEnv, stdout, stderr)
+	cmd.ExtraFiles = []*os.File{monitorW}
+
+	wait, err := startOSBuild(ctx, cmd)
+	if err != nil {
+		monitorW.Close()
+		return BuildTimings{}, fmt.Errorf("error starting osbuild: %w", err)
+	}
+	// only osbuild writes to the pipe now, reading ends when it exits
+	monitorW.Close()
+
+	var recorder timingRecorder
+	if err := recorder.readMonitor(monitorR, stdout); err != nil {
+		// the timings are only informational, do not fail the build
+		fmt.Fprintf(stderr, "WARNING: timings are incomplete: %s\n", err)
+		// keep the pipe drained so that osbuild does not block
+		_, _ = io.Copy(io.Discard, monitorR)
+	}
+	if err := wait(); err != nil {
+		return BuildTimings{}, fmt.Errorf("running osbuild failed: %w", err)
+	}
+	return recorder.done(time.Now()), nil
+}
+
+// printTimings prints the stage timings as a table
+func printTimings(w io.Writer, timings BuildTimings) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PIPELINE\tSTAGE\tDURATION")
+	for _, st := range timings.Stages {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", st.Pipeline, st.Stage, st.Duration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(tw, "total\t\t%s\n", timings.Total.Round(time.Millisecond))
+	return tw.Flush()
+}
+
+// writeTimingsJSON writes the stage timings as JSON to the given path
+func writeTimingsJSON(path string, timings BuildTimings) error {
+	data, err := json.MarshalIndent(timings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
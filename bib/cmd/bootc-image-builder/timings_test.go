@@ -0,0 +1,101 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+// fakeMonitorOutput is the JSONSeqMonitor output of a build with two
+// pipelines with two stages each
+var fakeMonitorOutput = strings.Join([]string{
+	`{"message": "Starting pipeline build", "timestamp": 1000.0}`,
+	`{"message": "Starting module org.osbuild.rpm", "timestamp": 1000.5}`,
+	`{"message": "installing packages\n", "timestamp": 1001.0}`,
+	`{"message": "Starting module org.osbuild.selinux", "timestamp": 1010.5}`,
+	`{"message": "Starting pipeline image", "timestamp": 1012.5}`,
+	`{"message": "Starting module org.osbuild.truncate", "timestamp": 1013.0}`,
+	`{"message": "Starting module org.osbuild.bootupd", "timestamp": 1013.25}`,
+}, "\n\x1e")
+
+func TestRecordTimings(t *testing.T) {
+	var log bytes.Buffer
+	monitor := strings.NewReader("\x1e" + fakeMonitorOutput + "\n")
+	end := time.Unix(1020, 0)
+	timings, err := main.RecordTimings(monitor, &log, end)
+	require.NoError(t, err)
+
+	assert.Equal(t, []main.StageTiming{
+		{Pipeline: "build", Stage: "org.osbuild.rpm", Duration: 10 * time.Second},
+		{Pipeline: "build", Stage: "org.osbuild.selinux", Duration: 2 * time.Second},
+		{Pipeline: "image", Stage: "org.osbuild.truncate", Duration: 250 * time.Millisecond},
+		{Pipeline: "image", Stage: "org.osbuild.bootupd", Duration: 6750 * time.Millisecond},
+	}, timings.Stages)
+	assert.Equal(t, 20*time.Second, timings.Total)
+	// the monitor replaces the regular output, the log is passed on
+	assert.Contains(t, log.String(), "installing packages\nStarting module org.osbuild.selinux\n")
+}
+
+func TestRecordTimingsEmpty(t *testing.T) {
+	timings, err := main.RecordTimings(strings.NewReader(""), &bytes.Buffer{}, time.Now())
+	require.NoError(t, err)
+	assert.Len(t, timings.Stages, 0)
+	assert.Equal(t, time.Duration(0), timings.Total)
+}
+
+func TestRecordTimingsBadRecord(t *testing.T) {
+	_, err := main.RecordTimings(strings.NewReader("\x1e{not json}\n"), &bytes.Buffer{}, time.Now())
+	assert.ErrorContains(t, err, "cannot parse osbuild monitor output: ")
+}
+
+func TestPrintTimings(t *testing.T) {
+	timings := main.BuildTimings{
+		Stages: []main.StageTiming{
+			{Pipeline: "build", Stage: "org.osbuild.rpm", Duration: 10 * time.Second},
+			{Pipeline: "image", Stage: "org.osbuild.bootupd", Duration: 1500 * time.Millisecond},
+		},
+		Total: 12 * time.Second,
+	}
+	var buf bytes.Buffer
+	require.NoError(t, main.PrintTimings(&buf, timings))
+	assert.Equal(t, `PIPELINE  STAGE                DURATION
+build     org.osbuild.rpm      10s
+image     org.osbuild.bootupd  1.5s
+total                          12s
+`, buf.String())
+}
+
+func TestWriteTimingsJSON(t *testing.T) {
+	timings := main.BuildTimings{
+		Stages: []main.StageTiming{
+			{Pipeline: "build", Stage: "org.osbuild.rpm", Duration: 1500 * time.Millisecond},
+		},
+		Total: 2 * time.Second,
+	}
+	path := filepath.Join(t.TempDir(), "timings.json")
+	require.NoError(t, main.WriteTimingsJSON(path, timings))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, map[string]interface{}{
+		"stages": []interface{}{
+			map[string]interface{}{
+				"pipeline":         "build",
+				"stage":            "org.osbuild.rpm",
+				"duration_seconds": 1.5,
+			},
+		},
+		"total_seconds": 2.0,
+	}, decoded)
+}
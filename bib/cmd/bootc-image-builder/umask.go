@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+const (
+	umaskProfilePath = "/etc/profile.d/90-bootc-image-builder-umask.sh"
+	// drop-ins in service.d apply to all services
+	umaskServiceDropInPath = "/etc/systemd/system/service.d/90-bootc-image-builder-umask.conf"
+)
+
+// umaskFiles returns the files that set the given octal umask, e.g.
+// "0077", for login shells and for all systemd services
+func umaskFiles(umask string) ([]*fsnode.File, error) {
+	value, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || value > 0777 {
+		return nil, fmt.Errorf("invalid umask %q, must be an octal value between 0000 and 0777", umask)
+	}
+	normalized := fmt.Sprintf("%04o", value)
+
+	profile, err := fsnode.NewFile(umaskProfilePath, nil, nil, nil, []byte(fmt.Sprintf("umask %s\n", normalized)))
+	if err != nil {
+		return nil, err
+	}
+	dropIn, err := fsnode.NewFile(umaskServiceDropInPath, nil, nil, nil, []byte(fmt.Sprintf("[Service]\nUMask=%s\n", normalized)))
+	if err != nil {
+		return nil, err
+	}
+	return []*fsnode.File{profile, dropIn}, nil
+}
@@ -0,0 +1,60 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestUmask(t *testing.T) {
+	for name, tc := range map[string]struct {
+		umask    string
+		expected string
+	}{
+		"four-digits":  {"0077", "0077"},
+		"three-digits": {"027", "0027"},
+		"zero":         {"0", "0000"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{Umask: tc.umask}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/profile.d/90-bootc-image-builder-umask.sh")
+			require.NoError(t, err)
+			assert.Equal(t, "umask "+tc.expected+"\n", content)
+			content, err = findFileContent(manifestJson, "ostree-deployment", "/etc/systemd/system/service.d/90-bootc-image-builder-umask.conf")
+			require.NoError(t, err)
+			assert.Equal(t, "[Service]\nUMask="+tc.expected+"\n", content)
+		})
+	}
+}
+
+func TestManifestUmaskErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType string
+		umask   string
+		err     string
+	}{
+		"not-octal":    {"qcow2", "0089", `invalid umask "0089", must be an octal value between 0000 and 0777`},
+		"out-of-range": {"qcow2", "1777", `invalid umask "1777", must be an octal value between 0000 and 0777`},
+		"negative":     {"qcow2", "-077", `invalid umask "-077", must be an octal value between 0000 and 0777`},
+		"symbolic":     {"qcow2", "u=rwx,go=", `invalid umask "u=rwx,go=", must be an octal value between 0000 and 0777`},
+		"iso":          {"iso", "0077", "umask not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{Umask: tc.umask}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// uploadJob uploads a single artifact to a cloud
+type uploadJob struct {
+	// Name is shown in errors, e.g. "ami"
+	Name   string
+	Upload func() error
+}
+
+// runUploads runs the given uploads with at most parallel of them at
+// the same time. All uploads are run even if some fail, the error of
+// the first failed one in the order of the jobs is returned.
+func runUploads(jobs []uploadJob, parallel int) error {
+	if parallel < 1 {
+		return fmt.Errorf("invalid number of parallel uploads %d, must be at least 1", parallel)
+	}
+
+	errs := make([]error, len(jobs))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, job uploadJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := job.Upload(); err != nil {
+				errs[i] = fmt.Errorf("cannot upload %s: %w", job.Name, err)
+			}
+		}(i, job)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main_test
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+// stubUploader records how many uploads run at the same time
+type stubUploader struct {
+	mu      sync.Mutex
+	running int
+	max     int
+	done    []string
+}
+
+func (s *stubUploader) job(name string, err error) main.UploadJob {
+	return main.UploadJob{
+		Name: name,
+		Upload: func() error {
+			s.mu.Lock()
+			s.running++
+			if s.running > s.max {
+				s.max = s.running
+			}
+			s.mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			s.mu.Lock()
+			s.running--
+			s.done = append(s.done, name)
+			s.mu.Unlock()
+			return err
+		},
+	}
+}
+
+func TestRunUploadsCapsConcurrency(t *testing.T) {
+	for _, parallel := range []int{1, 2, 3, 8} {
+		t.Run(fmt.Sprintf("parallel-%d", parallel), func(t *testing.T) {
+			stub := &stubUploader{}
+			var jobs []main.UploadJob
+			for i := 0; i < 6; i++ {
+				jobs = append(jobs, stub.job(fmt.Sprintf("artifact-%d", i), nil))
+			}
+			require.NoError(t, main.RunUploads(jobs, parallel))
+			assert.Len(t, stub.done, 6)
+			assert.LessOrEqual(t, stub.max, parallel)
+			if parallel > 1 {
+				// the sleep makes the uploads overlap
+				assert.Greater(t, stub.max, 1)
+			} else {
+				assert.Equal(t, 1, stub.max)
+			}
+		})
+	}
+}
+
+func TestRunUploadsSingleArtifact(t *testing.T) {
+	// a build has one artifact to upload today, which runs alone
+	// with any limit
+	for _, parallel := range []int{1, 4} {
+		t.Run(fmt.Sprintf("parallel-%d", parallel), func(t *testing.T) {
+			stub := &stubUploader{}
+			require.NoError(t, main.RunUploads([]main.UploadJob{stub.job("ami", nil)}, parallel))
+			assert.Equal(t, []string{"ami"}, stub.done)
+			assert.Equal(t, 1, stub.max)
+		})
+	}
+}
+
+func TestRunUploadsErrors(t *testing.T) {
+	stub := &stubUploader{}
+	jobs := []main.UploadJob{
+		stub.job("ami", nil),
+		stub.job("gce", fmt.Errorf("quota exceeded")),
+		stub.job("azure", fmt.Errorf("unauthorized")),
+	}
+	err := main.RunUploads(jobs, 2)
+	assert.EqualError(t, err, "cannot upload gce: quota exceeded")
+	// the other uploads are not aborted
+	assert.Len(t, stub.done, 3)
+
+	err = main.RunUploads(jobs, 0)
+	assert.EqualError(t, err, "invalid number of parallel uploads 0, must be at least 1")
+}
+
+func TestBuildParallelUploadsValidation(t *testing.T) {
+	rootCmd, err := main.NewRootCmd()
+	require.NoError(t, err)
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	rootCmd.SetArgs([]string{"build", "--parallel-uploads", "0", "testempty"})
+	err = rootCmd.Execute()
+	assert.EqualError(t, err, "--parallel-uploads must be at least 1, got 0")
+}
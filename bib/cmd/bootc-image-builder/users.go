@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/osbuild/images/pkg/blueprint"
+)
+
+// unsupportedCryptPrefixes are crypt(5) hash prefixes that are not
+// recognized as hashed by osbuild/images (only "$2b$", "$5$" and "$6$"
+// are). Such a password would be hashed again with SHA-512 and the
+// user could not log in with it.
+var unsupportedCryptPrefixes = []string{"$y$", "$gy$", "$7$", "$2a$", "$2y$", "$1$", "$md5", "$sha1$"}
+
+// validateUserPasswords ensures that hashed user passwords use an
+// algorithm that is passed on as-is. The password is not part of the
+// error so it does not end up in logs.
+func validateUserPasswords(users []blueprint.UserCustomization) error {
+	for _, user := range users {
+		if user.Password == nil {
+			continue
+		}
+		for _, prefix := range unsupportedCryptPrefixes {
+			if strings.HasPrefix(*user.Password, prefix) {
+				return fmt.Errorf("password of user %q is hashed with an unsupported algorithm (%q), use a SHA-512 (\"$6$\") hash or a plain text password", user.Name, prefix)
+			}
+		}
+	}
+	return nil
+}
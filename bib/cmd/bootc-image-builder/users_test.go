@@ -0,0 +1,48 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/osbuild/images/pkg/blueprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func getPasswordConfig(imgType, password string) *main.ManifestConfig {
+	config := getBaseConfig()
+	config.ImgType = imgType
+	config.Config = &main.BuildConfig{
+		Blueprint: &blueprint.Blueprint{
+			Customizations: &blueprint.Customizations{
+				User: []blueprint.UserCustomization{
+					{Name: "alice", Password: &password},
+				},
+			},
+		},
+	}
+	return config
+}
+
+func TestManifestUserPasswordHashes(t *testing.T) {
+	for _, password := range []string{
+		"plain-text-password",
+		"$6$rounds=5000$saltsaltsaltsalt$hash",
+		"$5$saltsaltsaltsalt$hash",
+	} {
+		for _, imgType := range []string{"qcow2", "iso"} {
+			_, err := main.Manifest(getPasswordConfig(imgType, password))
+			require.NoError(t, err)
+		}
+	}
+}
+
+func TestManifestUserPasswordHashesUnsupported(t *testing.T) {
+	for _, imgType := range []string{"qcow2", "iso"} {
+		// yescrypt, the default of current Fedora
+		_, err := main.Manifest(getPasswordConfig(imgType, "$y$j9T$saltsaltsaltsaltsalt$hash"))
+		assert.EqualError(t, err, `password of user "alice" is hashed with an unsupported algorithm ("$y$"), use a SHA-512 ("$6$") hash or a plain text password`)
+		assert.NotContains(t, err.Error(), "saltsalt")
+	}
+}
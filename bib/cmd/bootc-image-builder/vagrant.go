@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// vagrantBoxFilename is the name of the box in the "vagrant-libvirt"
+// output directory
+const vagrantBoxFilename = "disk.box"
+
+// VagrantBoxMetadata is the metadata.json of a vagrant-libvirt box, the
+// virtual size is in GiB.
+type VagrantBoxMetadata struct {
+	Provider    string `json:"provider"`
+	Format      string `json:"format"`
+	VirtualSize uint64 `json:"virtual_size"`
+}
+
+const vagrantfileLibvirt = `Vagrant.configure("2") do |config|
+  config.vm.provider :libvirt do |libvirt|
+    libvirt.driver = "kvm"
+  end
+end
+`
+
+func addTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+func addTarBytes(tw *tar.Writer, name string, data []byte) error {
+	return addTarFile(tw, name, int64(len(data)), bytes.NewReader(data))
+}
+
+// writeVagrantBox packages the qcow2 image as a vagrant-libvirt box,
+// a tarball with the image as box.img, metadata.json and a Vagrantfile.
+// The box is not compressed, the qcow2 image already is compact.
+func writeVagrantBox(boxPath, qcow2Path string, virtualSize uint64) error {
+	img, err := os.Open(qcow2Path)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+	st, err := img.Stat()
+	if err != nil {
+		return err
+	}
+
+	metadata, err := json.Marshal(VagrantBoxMetadata{
+		Provider: "libvirt",
+		Format:   "qcow2",
+		// vagrant expects whole GiB
+		VirtualSize: (virtualSize + GibiByte - 1) / GibiByte,
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(boxPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	if err := addTarBytes(tw, "metadata.json", metadata); err != nil {
+		return fmt.Errorf("cannot write vagrant box: %w", err)
+	}
+	if err := addTarBytes(tw, "Vagrantfile", []byte(vagrantfileLibvirt)); err != nil {
+		return fmt.Errorf("cannot write vagrant box: %w", err)
+	}
+	if err := addTarFile(tw, "box.img", st.Size(), img); err != nil {
+		return fmt.Errorf("cannot write vagrant box: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("cannot write vagrant box: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,64 @@
+package main_test
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestWriteVagrantBox(t *testing.T) {
+	tmpdir := t.TempDir()
+	qcow2Path := filepath.Join(tmpdir, "disk.qcow2")
+	require.NoError(t, os.WriteFile(qcow2Path, []byte("qcow2 content"), 0644))
+	boxPath := filepath.Join(tmpdir, "disk.box")
+
+	err := main.WriteVagrantBox(boxPath, qcow2Path, 10*main.GibiByte+1)
+	require.NoError(t, err)
+
+	f, err := os.Open(boxPath)
+	require.NoError(t, err)
+	defer f.Close()
+	content := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		content[hdr.Name] = data
+	}
+	require.Len(t, content, 3)
+
+	assert.Equal(t, []byte("qcow2 content"), content["box.img"])
+	assert.Contains(t, string(content["Vagrantfile"]), "config.vm.provider :libvirt")
+	var metadata main.VagrantBoxMetadata
+	require.NoError(t, json.Unmarshal(content["metadata.json"], &metadata))
+	// the virtual size is rounded up to whole GiB
+	assert.Equal(t, main.VagrantBoxMetadata{Provider: "libvirt", Format: "qcow2", VirtualSize: 11}, metadata)
+}
+
+func TestManifestVagrantLibvirt(t *testing.T) {
+	config := getBaseConfig()
+	config.ImgType = "vagrant-libvirt"
+	mf, err := main.Manifest(config)
+	require.NoError(t, err)
+	manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+	require.NoError(t, err)
+
+	// the box is packaged from the qcow2 export
+	stages, err := findStages(manifestJson, "qcow2", "org.osbuild.qemu")
+	require.NoError(t, err)
+	assert.Len(t, stages, 1)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/osbuild/images/pkg/customizations/fsnode"
+)
+
+// VConsoleCustomization configures the virtual console of the system
+type VConsoleCustomization struct {
+	// Keymap is the console keymap, e.g. "de-nodeadkeys"
+	Keymap string `json:"keymap,omitempty"`
+	// Font is the console font, e.g. "eurlatgr"
+	Font string `json:"font,omitempty"`
+}
+
+// keymaps and fonts are file names below /usr/lib/kbd without the
+// extension
+var vconsoleNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+-]*$`)
+
+// vconsoleConfig returns the /etc/vconsole.conf for the given
+// customization. It replaces the file of the container image, unset
+// settings use the defaults of systemd.
+func vconsoleConfig(vc *VConsoleCustomization) (*fsnode.File, error) {
+	if vc.Keymap == "" && vc.Font == "" {
+		return nil, fmt.Errorf("vconsole needs a keymap or a font")
+	}
+	var content strings.Builder
+	if vc.Keymap != "" {
+		if !vconsoleNameRegex.MatchString(vc.Keymap) {
+			return nil, fmt.Errorf("invalid vconsole keymap %q", vc.Keymap)
+		}
+		fmt.Fprintf(&content, "KEYMAP=%s\n", vc.Keymap)
+	}
+	if vc.Font != "" {
+		if !vconsoleNameRegex.MatchString(vc.Font) {
+			return nil, fmt.Errorf("invalid vconsole font %q", vc.Font)
+		}
+		fmt.Fprintf(&content, "FONT=%s\n", vc.Font)
+	}
+	return fsnode.NewFile("/etc/vconsole.conf", nil, nil, nil, []byte(content.String()))
+}
@@ -0,0 +1,58 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+func TestManifestVConsole(t *testing.T) {
+	for _, tc := range []struct {
+		vconsole main.VConsoleCustomization
+		expected string
+	}{
+		{main.VConsoleCustomization{Keymap: "de-nodeadkeys", Font: "eurlatgr"}, "KEYMAP=de-nodeadkeys\nFONT=eurlatgr\n"},
+		{main.VConsoleCustomization{Keymap: "fr"}, "KEYMAP=fr\n"},
+		{main.VConsoleCustomization{Font: "ter-v16n"}, "FONT=ter-v16n\n"},
+	} {
+		t.Run(tc.vconsole.Keymap+"/"+tc.vconsole.Font, func(t *testing.T) {
+			vconsole := tc.vconsole
+			config := getBaseConfig()
+			config.ImgType = "qcow2"
+			config.Config = &main.BuildConfig{VConsole: &vconsole}
+			mf, err := main.Manifest(config)
+			require.NoError(t, err)
+			manifestJson, err := mf.Serialize(nil, getDiskContainers(), nil)
+			require.NoError(t, err)
+
+			content, err := findFileContent(manifestJson, "ostree-deployment", "/etc/vconsole.conf")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, content)
+		})
+	}
+}
+
+func TestManifestVConsoleErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		imgType  string
+		vconsole main.VConsoleCustomization
+		err      string
+	}{
+		"empty":      {"qcow2", main.VConsoleCustomization{}, "vconsole needs a keymap or a font"},
+		"bad-keymap": {"qcow2", main.VConsoleCustomization{Keymap: "us\nFONT=x"}, `invalid vconsole keymap "us\nFONT=x"`},
+		"bad-font":   {"qcow2", main.VConsoleCustomization{Font: "../font"}, `invalid vconsole font "../font"`},
+		"iso":        {"iso", main.VConsoleCustomization{Keymap: "us"}, "vconsole not supported for the iso image type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			vconsole := tc.vconsole
+			config := getBaseConfig()
+			config.ImgType = tc.imgType
+			config.Config = &main.BuildConfig{VConsole: &vconsole}
+			_, err := main.Manifest(config)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
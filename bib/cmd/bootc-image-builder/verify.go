@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultPolicyPath is the system wide containers signature policy
+const defaultPolicyPath = "/etc/containers/policy.json"
+
+// imageVerifier checks the signature of the image with the given
+// reference and digest
+type imageVerifier interface {
+	Verify(imgref, digest string) error
+}
+
+var (
+	verifyResolveDigest = resolveBaseDigest
+	newImageVerifier    = func(policyPath string, tlsVerify bool) (imageVerifier, error) {
+		return newPolicyVerifier(policyPath, tlsVerify)
+	}
+)
+
+// resolveBaseDigest resolves the base container of the given config
+// without building it
+func resolveBaseDigest(c *ManifestConfig) (string, error) {
+	resolver := c.newResolver(c.Architecture.String())
+	resolver.Add(c.containerSource())
+	specs, err := resolver.Finish()
+	if err != nil {
+		return "", err
+	}
+	return specs[0].Digest, nil
+}
+
+// policyVerifier verifies images against a containers-policy.json(5)
+type policyVerifier struct {
+	policy    *signature.Policy
+	tlsVerify bool
+}
+
+func newPolicyVerifier(policyPath string, tlsVerify bool) (*policyVerifier, error) {
+	policy, err := signature.NewPolicyFromFile(policyPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load signature policy: %w", err)
+	}
+	return &policyVerifier{policy: policy, tlsVerify: tlsVerify}, nil
+}
+
+func (v *policyVerifier) Verify(imgref, imgDigest string) error {
+	named, err := reference.ParseNormalizedNamed(imgref)
+	if err != nil {
+		return err
+	}
+	// pin the reference to the resolved digest so that the verified
+	// image is the one a build would use
+	canonical, err := reference.WithDigest(reference.TrimNamed(named), digest.Digest(imgDigest))
+	if err != nil {
+		return err
+	}
+	ref, err := docker.NewReference(canonical)
+	if err != nil {
+		return err
+	}
+
+	policyCtx, err := signature.NewPolicyContext(v.policy)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = policyCtx.Destroy() }()
+
+	ctx := context.Background()
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.NewOptionalBool(!v.tlsVerify),
+	}
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	allowed, err := policyCtx.IsRunningImageAllowed(ctx, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("image %s is rejected by the signature policy", imgref)
+	}
+	return nil
+}
+
+// verifyImage resolves the image of the given config the way a build
+// does and prints its digest. With a policy the signature of the
+// resolved image is verified as well.
+func verifyImage(w io.Writer, c *ManifestConfig, policyPath string) error {
+	imgDigest, err := verifyResolveDigest(c)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %w", c.Imgref, err)
+	}
+	fmt.Fprintf(w, "%s@%s\n", c.Imgref, imgDigest)
+	if policyPath == "" {
+		fmt.Fprintf(w, "signature not verified, no policy given\n")
+		return nil
+	}
+
+	verifier, err := newImageVerifier(policyPath, c.TLSVerify)
+	if err != nil {
+		return err
+	}
+	if err := verifier.Verify(c.Imgref, imgDigest); err != nil {
+		return fmt.Errorf("cannot verify signature of %s: %w", c.Imgref, err)
+	}
+	fmt.Fprintf(w, "signature verified\n")
+	return nil
+}
@@ -0,0 +1,90 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	main "github.com/osbuild/bootc-image-builder/bib/cmd/bootc-image-builder"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+type stubVerifier struct {
+	err error
+
+	imgref string
+	digest string
+}
+
+func (v *stubVerifier) Verify(imgref, digest string) error {
+	v.imgref = imgref
+	v.digest = digest
+	return v.err
+}
+
+func mockVerify(t *testing.T, verifier *stubVerifier) {
+	restore := main.MockVerifyResolveDigest(func(c *main.ManifestConfig) (string, error) {
+		return testDigest, nil
+	})
+	t.Cleanup(restore)
+	restore = main.MockNewImageVerifier(func(policyPath string, tlsVerify bool) (main.ImageVerifier, error) {
+		assert.Equal(t, "/some/policy.json", policyPath)
+		return verifier, nil
+	})
+	t.Cleanup(restore)
+}
+
+func runVerifyCmd(t *testing.T, args ...string) (string, error) {
+	rootCmd, err := main.NewRootCmd()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	rootCmd.SetErr(&bytes.Buffer{})
+	rootCmd.SetArgs(append([]string{"verify"}, args...))
+	err = rootCmd.Execute()
+	return buf.String(), err
+}
+
+func TestVerifyVerified(t *testing.T) {
+	verifier := &stubVerifier{}
+	mockVerify(t, verifier)
+
+	out, err := runVerifyCmd(t, "--policy", "/some/policy.json", "quay.io/example/example:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "quay.io/example/example:latest@"+testDigest+"\nsignature verified\n", out)
+	assert.Equal(t, "quay.io/example/example:latest", verifier.imgref)
+	assert.Equal(t, testDigest, verifier.digest)
+}
+
+func TestVerifyUnverified(t *testing.T) {
+	verifier := &stubVerifier{err: fmt.Errorf("no signature")}
+	mockVerify(t, verifier)
+
+	out, err := runVerifyCmd(t, "--policy", "/some/policy.json", "quay.io/example/example:latest")
+	assert.EqualError(t, err, "cannot verify signature of quay.io/example/example:latest: no signature")
+	assert.Equal(t, "quay.io/example/example:latest@"+testDigest+"\n", out)
+}
+
+func TestVerifyNoPolicy(t *testing.T) {
+	verifier := &stubVerifier{err: fmt.Errorf("must not be called")}
+	mockVerify(t, verifier)
+
+	out, err := runVerifyCmd(t, "quay.io/example/example:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "quay.io/example/example:latest@"+testDigest+"\nsignature not verified, no policy given\n", out)
+	assert.Equal(t, "", verifier.imgref)
+}
+
+func TestVerifyResolveError(t *testing.T) {
+	restore := main.MockVerifyResolveDigest(func(c *main.ManifestConfig) (string, error) {
+		return "", fmt.Errorf("manifest unknown")
+	})
+	defer restore()
+
+	_, err := runVerifyCmd(t, "quay.io/example/example:latest")
+	assert.EqualError(t, err, "cannot resolve quay.io/example/example:latest: manifest unknown")
+}
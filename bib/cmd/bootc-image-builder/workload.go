@@ -22,3 +22,20 @@ func (p *NullWorkload) GetServices() []string {
 func (p *NullWorkload) GetDisabledServices() []string {
 	return nil
 }
+
+// ServicesWorkload implements the images Workload interface and only
+// holds the services that get enabled or disabled in the deployment.
+type ServicesWorkload struct {
+	NullWorkload
+
+	EnabledServices  []string
+	DisabledServices []string
+}
+
+func (p *ServicesWorkload) GetServices() []string {
+	return p.EnabledServices
+}
+
+func (p *ServicesWorkload) GetDisabledServices() []string {
+	return p.DisabledServices
+}
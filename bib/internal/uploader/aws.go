@@ -11,6 +11,15 @@ import (
 	"github.com/osbuild/images/pkg/cloud/awscloud"
 )
 
+// BootMode returns the boot mode of AMIs of the given architecture.
+// The x86_64 disk can boot via BIOS and UEFI, aarch64 only via UEFI.
+func BootMode(a arch.Arch) string {
+	if a == arch.ARCH_AARCH64 {
+		return ec2.BootModeValuesUefi
+	}
+	return ec2.BootModeValuesUefiPreferred
+}
+
 func UploadAndRegister(a *awscloud.AWS, filename, bucketName, imageName, targetArch string) error {
 	keyName := fmt.Sprintf("%s-%s", uuid.New().String(), filepath.Base(filename))
 
@@ -24,7 +33,7 @@ func UploadAndRegister(a *awscloud.AWS, filename, bucketName, imageName, targetA
 	if targetArch == "" {
 		targetArch = arch.Current().String()
 	}
-	bootMode := ec2.BootModeValuesUefiPreferred
+	bootMode := BootMode(arch.FromString(targetArch))
 	fmt.Printf("Registering AMI %s\n", imageName)
 	ami, snapshot, err := a.Register(imageName, bucketName, keyName, nil, targetArch, &bootMode)
 	fmt.Printf("Deleted S3 object %s:%s\n", bucketName, keyName)
@@ -1,12 +1,16 @@
 package util
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 // IsMountpoint checks if the target path is a mount point
@@ -26,3 +30,60 @@ func RunCmdSync(cmdName string, args ...string) error {
 	}
 	return nil
 }
+
+// CopyFileSparse copies src to dst but only writes the data regions
+// of src, holes are skipped over (via SEEK_DATA/SEEK_HOLE) so that
+// dst has the same apparent size but stays sparse on disk.
+func CopyFileSparse(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := copyDataRegions(out, in, st.Size()); err != nil {
+		out.Close()
+		return fmt.Errorf("cannot copy %q to %q: %w", src, dst, err)
+	}
+	return out.Close()
+}
+
+// copyDataRegions copies the data regions of the first size bytes of
+// in to the same offsets in out and truncates out to size
+func copyDataRegions(out, in *os.File, size int64) error {
+	var offset int64
+	for offset < size {
+		dataStart, err := in.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, syscall.ENXIO) {
+			// no more data, the rest of the file is a hole
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot find data: %w", err)
+		}
+		dataEnd, err := in.Seek(dataStart, unix.SEEK_HOLE)
+		if err != nil {
+			return fmt.Errorf("cannot find hole: %w", err)
+		}
+		if _, err := in.Seek(dataStart, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := out.Seek(dataStart, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(out, in, dataEnd-dataStart); err != nil {
+			return err
+		}
+		offset = dataEnd
+	}
+	// extend out to the full size in case in ends with a hole
+	return out.Truncate(size)
+}
@@ -0,0 +1,74 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/osbuild/bootc-image-builder/bib/internal/util"
+)
+
+func diskUsage(t *testing.T, path string) int64 {
+	st, err := os.Stat(path)
+	require.NoError(t, err)
+	return st.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestCopyFileSparse(t *testing.T) {
+	tmpdir := t.TempDir()
+	src := filepath.Join(tmpdir, "src.raw")
+	dst := filepath.Join(tmpdir, "dst.raw")
+
+	const size = 64 * 1024 * 1024
+	fp, err := os.Create(src)
+	require.NoError(t, err)
+	require.NoError(t, fp.Truncate(size))
+	_, err = fp.WriteAt([]byte("bootc"), 8*1024*1024)
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+	if diskUsage(t, src) >= size {
+		t.Skip("filesystem does not support sparse files")
+	}
+
+	err = util.CopyFileSparse(dst, src)
+	require.NoError(t, err)
+
+	st, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), st.Size())
+	// the hole was not written out
+	assert.Less(t, diskUsage(t, dst), int64(1024*1024))
+
+	buf := make([]byte, 5)
+	fp, err = os.Open(dst)
+	require.NoError(t, err)
+	defer fp.Close()
+	_, err = fp.ReadAt(buf, 8*1024*1024)
+	require.NoError(t, err)
+	assert.Equal(t, "bootc", string(buf))
+}
+
+func TestCopyFileSparseTrailingData(t *testing.T) {
+	tmpdir := t.TempDir()
+	src := filepath.Join(tmpdir, "src.raw")
+	dst := filepath.Join(tmpdir, "dst.raw")
+
+	fp, err := os.Create(src)
+	require.NoError(t, err)
+	_, err = fp.WriteAt([]byte("end"), 4*1024*1024-3)
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+
+	err = util.CopyFileSparse(dst, src)
+	require.NoError(t, err)
+
+	srcContent, err := os.ReadFile(src)
+	require.NoError(t, err)
+	dstContent, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, srcContent, dstContent)
+}
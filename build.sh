@@ -3,9 +3,13 @@
 set -euo pipefail
 # Keep this in sync with e.g. https://github.com/containers/podman/blob/2981262215f563461d449b9841741339f4d9a894/Makefile#L51
 # It turns off the esoteric containers-storage backends that add dependencies
-# on things like btrfs that we don't need.
-CONTAINERS_STORAGE_THIN_TAGS="containers_image_openpgp exclude_graphdriver_btrfs exclude_graphdriver_devicemapper exclude_graphdriver_overlay"
+# on things like btrfs that we don't need. The overlay backend is kept, it is
+# needed to read the storage of --containers-storage and --local.
+# The unit tests in .github/workflows/tests.yml use the same tags.
+CONTAINERS_STORAGE_THIN_TAGS="containers_image_openpgp exclude_graphdriver_btrfs exclude_graphdriver_devicemapper"
+# The version that is recorded in the images, see bibVersion
+VERSION="${VERSION:-$(git rev-parse --short HEAD 2>/dev/null || echo devel)}"
 
 cd bib
 set -x
-go build -tags "${CONTAINERS_STORAGE_THIN_TAGS}" -o ../bin/bootc-image-builder ./cmd/bootc-image-builder
+go build -tags "${CONTAINERS_STORAGE_THIN_TAGS}" -ldflags "-X main.bibVersion=${VERSION}" -o ../bin/bootc-image-builder ./cmd/bootc-image-builder